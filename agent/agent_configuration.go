@@ -4,6 +4,7 @@ import (
 	"regexp"
 	"time"
 
+	"github.com/buildkite/agent/v3/kubernetes"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 )
 
@@ -15,6 +16,7 @@ type AgentConfiguration struct {
 	BuildPath                   string
 	HooksPath                   string
 	SocketsPath                 string
+	JobAPIListen                string
 	GitMirrorsPath              string
 	GitMirrorsLockTimeout       int
 	GitMirrorsSkipUpdate        bool
@@ -37,6 +39,10 @@ type AgentConfiguration struct {
 	RunInPty                    bool
 	KubernetesExec              bool
 
+	KubernetesHeartbeatTimeout   time.Duration
+	KubernetesExitAggregation    kubernetes.ExitAggregation
+	KubernetesPrimaryContainerID int // negative means the container with the highest ID
+
 	SigningJWKSFile  string // Where to find the key to sign pipeline uploads with (passed through to jobs, they might be uploading pipelines)
 	SigningJWKSKeyID string // The key ID to sign pipeline uploads with
 	DebugSigning     bool   // Whether to print step payloads when signing them
@@ -58,8 +64,12 @@ type AgentConfiguration struct {
 	Shell                      string
 	Profile                    string
 	RedactedVars               []string
+	SecretsProviders           []string
+	SecretsEnv                 []string
 	AcquireJob                 string
 	TracingBackend             string
 	TracingServiceName         string
+	TracingFileFormat          string
+	TracingMetaDataContext     bool
 	DisableWarningsFor         []string
 }
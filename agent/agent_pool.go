@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"sync"
 
+	"github.com/buildkite/agent/v3/internal/agentapi"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/status"
 )
@@ -101,10 +102,57 @@ func (r *AgentPool) Stop(graceful bool) {
 	}
 }
 
+// SetConfiguration changes the configuration of all the workers in the pool.
+// Running jobs carry on with the configuration they started with.
+func (ap *AgentPool) SetConfiguration(conf AgentConfiguration) {
+	for _, worker := range ap.workers {
+		worker.SetConfiguration(conf)
+	}
+}
+
+// SetTags changes the tags of all the workers in the pool. Each worker is
+// re-registered with the new tags once it's idle, if they've changed.
+func (ap *AgentPool) SetTags(tags []string) {
+	for _, worker := range ap.workers {
+		worker.SetTags(tags)
+	}
+}
+
+// Workers describes the workers in the pool. It implements
+// agentapi.WorkerController.
+func (ap *AgentPool) Workers() []agentapi.WorkerInfo {
+	infos := make([]agentapi.WorkerInfo, 0, len(ap.workers))
+	for _, worker := range ap.workers {
+		infos = append(infos, worker.info())
+	}
+	return infos
+}
+
+// CancelJob cancels the job running on the worker with the given ID or name,
+// or the worker running the job with the given ID (or both). It implements
+// agentapi.WorkerController.
+func (ap *AgentPool) CancelJob(worker, jobID string) (string, error) {
+	for _, w := range ap.workers {
+		info := w.info()
+		if worker != "" && worker != info.ID && worker != info.Name {
+			continue
+		}
+		if worker == "" && jobID != info.JobID {
+			continue
+		}
+		return w.cancelJob(jobID)
+	}
+	if worker != "" {
+		return "", fmt.Errorf("%w: %q", agentapi.ErrWorkerNotFound, worker)
+	}
+	return "", fmt.Errorf("%w: %q", agentapi.ErrJobNotRunning, jobID)
+}
+
 func (ap *AgentPool) statusJSONHandler(l logger.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		type agentWorkerStatus struct {
 			Status       agentWorkerState `json:"status"`
+			Reason       string           `json:"reason,omitempty"`
 			CurrentJobID string           `json:"current_job_id,omitempty"`
 			ID           string           `json:"id"`
 			SpawnIndex   int              `json:"spawn_index"`
@@ -113,15 +161,21 @@ func (ap *AgentPool) statusJSONHandler(l logger.Logger) http.HandlerFunc {
 		aggregateState := agentWorkerStateIdle
 		statuses := make([]agentWorkerStatus, 0, len(ap.workers))
 		for _, worker := range ap.workers {
-			// If any worker is busy, the aggregate state is busy
-			workerState := worker.getState()
-			if workerState == agentWorkerStateBusy {
+			// If any worker is busy, the aggregate state is busy. Otherwise,
+			// if any worker is refusing jobs, it's refusing.
+			info := worker.info()
+			workerState := agentWorkerState(info.State)
+			switch {
+			case workerState == agentWorkerStateBusy:
 				aggregateState = agentWorkerStateBusy
+			case workerState == agentWorkerStateRefusing && aggregateState == agentWorkerStateIdle:
+				aggregateState = agentWorkerStateRefusing
 			}
 			statuses = append(statuses, agentWorkerStatus{
-				ID:           worker.agent.UUID,
+				ID:           info.ID,
 				Status:       workerState,
-				CurrentJobID: worker.getCurrentJobID(),
+				Reason:       info.Reason,
+				CurrentJobID: info.JobID,
 				SpawnIndex:   worker.spawnIndex,
 			})
 		}
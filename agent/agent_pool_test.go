@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/internal/agentapi"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeJobRunner is a jobRunner that records cancellation.
+type fakeJobRunner struct {
+	cancelled bool
+}
+
+func (f *fakeJobRunner) Run(context.Context) error { return nil }
+func (f *fakeJobRunner) Cancel() error             { f.cancelled = true; return nil }
+func (f *fakeJobRunner) CancelAndStop() error      { return f.Cancel() }
+
+func TestAgentPoolWorkersAndCancelJob(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeJobRunner{}
+	busy := NewAgentWorker(logger.Discard, &api.AgentRegisterResponse{UUID: "uuid-1", Name: "agent-1"}, nil, &api.Client{}, AgentWorkerConfig{SpawnIndex: 1})
+	busy.setBusy("job-1")
+	busy.jobRunner = runner
+	idle := NewAgentWorker(logger.Discard, &api.AgentRegisterResponse{UUID: "uuid-2", Name: "agent-2"}, nil, &api.Client{}, AgentWorkerConfig{SpawnIndex: 2})
+	idle.Stop(true)
+
+	pool := NewAgentPool([]*AgentWorker{busy, idle})
+
+	want := []agentapi.WorkerInfo{
+		{ID: "uuid-1", Name: "agent-1", SpawnIndex: 1, State: "busy", JobID: "job-1"},
+		{ID: "uuid-2", Name: "agent-2", SpawnIndex: 2, State: "idle", Stopping: true},
+	}
+	if diff := cmp.Diff(pool.Workers(), want); diff != "" {
+		t.Errorf("pool.Workers() diff (-got +want):\n%s", diff)
+	}
+
+	errTests := []struct {
+		worker, jobID string
+		want          error
+	}{
+		{worker: "agent-3", want: agentapi.ErrWorkerNotFound},
+		{worker: "agent-2", want: agentapi.ErrJobNotRunning},
+		{worker: "agent-1", jobID: "job-2", want: agentapi.ErrJobNotRunning},
+		{jobID: "job-2", want: agentapi.ErrJobNotRunning},
+	}
+	for _, test := range errTests {
+		if _, err := pool.CancelJob(test.worker, test.jobID); !errors.Is(err, test.want) {
+			t.Errorf("pool.CancelJob(%q, %q) error = %v, want %v", test.worker, test.jobID, err, test.want)
+		}
+	}
+	if runner.cancelled {
+		t.Fatalf("job runner was cancelled after failed CancelJob calls")
+	}
+
+	got, err := pool.CancelJob("", "job-1")
+	if err != nil || got != "job-1" {
+		t.Errorf(`pool.CancelJob("", "job-1") = (%q, %v), want ("job-1", nil)`, got, err)
+	}
+	if !runner.cancelled {
+		t.Errorf("job runner was not cancelled")
+	}
+}
@@ -7,14 +7,18 @@ import (
 	"io"
 	"math/rand/v2"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/internal/agentapi"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/metrics"
 	"github.com/buildkite/agent/v3/process"
 	"github.com/buildkite/agent/v3/status"
+	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/buildkite/roko"
 )
 
@@ -38,8 +42,16 @@ type AgentWorkerConfig struct {
 	// The configuration of the agent from the CLI
 	AgentConfiguration AgentConfiguration
 
+	// The request the agent was registered with, used to re-register it if
+	// its tags change
+	RegisterRequest api.AgentRegisterRequest
+
 	// Stdout of the parent agent process. Used for job log stdout writing arg, for simpler containerized log collection.
 	AgentStdout io.Writer
+
+	// Checks that must pass before the worker pings for work. While one
+	// fails, the worker refuses jobs.
+	ReadinessChecks []ReadinessCheck
 }
 
 type agentStats struct {
@@ -61,8 +73,23 @@ type AgentWorker struct {
 	// The logger instance to use
 	logger logger.Logger
 
-	// The configuration of the agent from the CLI
+	// The configuration of the agent from the CLI. It can be changed while the
+	// worker is running (see SetConfiguration), so it's read with
+	// configuration().
 	agentConfiguration AgentConfiguration
+	confMtx            sync.Mutex
+
+	// The client the agent was registered with, and the request it was
+	// registered with, for re-registering it when its tags change. The tags
+	// to re-register with are held in pendingTags (guarded by confMtx) until
+	// the worker is idle.
+	registerClient  APIClient
+	registerRequest api.AgentRegisterRequest
+	pendingTags     []string
+
+	// Held while heartbeating and while re-registering, so heartbeats aren't
+	// sent for a disconnected agent
+	registrationMtx sync.Mutex
 
 	// The registered agent API record
 	agent *api.AgentRegisterResponse
@@ -102,8 +129,17 @@ type AgentWorker struct {
 	// Stdout of the parent agent process. Used for job log stdout writing arg, for simpler containerized log collection.
 	agentStdout io.Writer
 
-	// Are we doing something right now?
+	// Checks that must pass before pinging for work
+	readinessChecks []ReadinessCheck
+
+	// How often to ping while a readiness check fails, so the worker still
+	// gets actions (such as disconnect) from Buildkite
+	notReadyPingInterval time.Duration
+
+	// Are we doing something right now? When refusing jobs, stateReason
+	// says why.
 	state        agentWorkerState
+	stateReason  string
 	currentJobID string
 	stateMtx     sync.Mutex
 }
@@ -111,8 +147,9 @@ type AgentWorker struct {
 type agentWorkerState string
 
 const (
-	agentWorkerStateIdle agentWorkerState = "idle"
-	agentWorkerStateBusy agentWorkerState = "busy"
+	agentWorkerStateIdle     agentWorkerState = "idle"
+	agentWorkerStateBusy     agentWorkerState = "busy"
+	agentWorkerStateRefusing agentWorkerState = "refusing"
 )
 
 func (a *AgentWorker) setBusy(jobID string) {
@@ -126,19 +163,178 @@ func (a *AgentWorker) setIdle() {
 	a.stateMtx.Lock()
 	defer a.stateMtx.Unlock()
 	a.state = agentWorkerStateIdle
+	a.stateReason = ""
 	a.currentJobID = ""
 }
 
-func (a *AgentWorker) getState() agentWorkerState {
+// setRefusing marks the worker as refusing jobs, for the given reason. It
+// reports whether the worker wasn't already refusing jobs.
+func (a *AgentWorker) setRefusing(reason string) bool {
 	a.stateMtx.Lock()
 	defer a.stateMtx.Unlock()
-	return a.state
+	changed := a.state != agentWorkerStateRefusing
+	a.state = agentWorkerStateRefusing
+	a.stateReason = reason
+	return changed
 }
 
-func (a *AgentWorker) getCurrentJobID() string {
+// setAccepting marks a worker that was refusing jobs as idle. It reports
+// whether the worker was refusing jobs.
+func (a *AgentWorker) setAccepting() bool {
 	a.stateMtx.Lock()
 	defer a.stateMtx.Unlock()
-	return a.currentJobID
+	if a.state != agentWorkerStateRefusing {
+		return false
+	}
+	a.state = agentWorkerStateIdle
+	a.stateReason = ""
+	return true
+}
+
+// notReadyReason returns why the worker is refusing jobs, or "" if it isn't.
+func (a *AgentWorker) notReadyReason() string {
+	a.stateMtx.Lock()
+	defer a.stateMtx.Unlock()
+	if a.state != agentWorkerStateRefusing {
+		return ""
+	}
+	return a.stateReason
+}
+
+// pingInterval returns how often to ping for work, as given when the agent
+// was last registered.
+func (a *AgentWorker) pingInterval() time.Duration {
+	a.stateMtx.Lock()
+	defer a.stateMtx.Unlock()
+	return time.Second * time.Duration(a.agent.PingInterval)
+}
+
+// heartbeatInterval returns how often to send heartbeats, as given when the
+// agent was last registered.
+func (a *AgentWorker) heartbeatInterval() time.Duration {
+	a.stateMtx.Lock()
+	defer a.stateMtx.Unlock()
+	return time.Second * time.Duration(a.agent.HeartbeatInterval)
+}
+
+// info describes the worker for the Agent API.
+func (a *AgentWorker) info() agentapi.WorkerInfo {
+	a.stopMutex.Lock()
+	stopping := a.stopping
+	a.stopMutex.Unlock()
+
+	a.stateMtx.Lock()
+	defer a.stateMtx.Unlock()
+	return agentapi.WorkerInfo{
+		ID:         a.agent.UUID,
+		Name:       a.agent.Name,
+		SpawnIndex: a.spawnIndex,
+		State:      string(a.state),
+		Reason:     a.stateReason,
+		JobID:      a.currentJobID,
+		Stopping:   stopping,
+	}
+}
+
+// cancelJob cancels the job the worker is running, provided it is jobID (if
+// jobID is not empty). The worker carries on accepting jobs afterwards. It
+// returns the ID of the job being cancelled.
+func (a *AgentWorker) cancelJob(jobID string) (string, error) {
+	a.stateMtx.Lock()
+	jr, current, name := a.jobRunner, a.currentJobID, a.agent.Name
+	a.stateMtx.Unlock()
+
+	if jr == nil {
+		return "", fmt.Errorf("%w: agent %s is not running a job", agentapi.ErrJobNotRunning, name)
+	}
+	if jobID != "" && jobID != current {
+		return "", fmt.Errorf("%w: agent %s is running job %s", agentapi.ErrJobNotRunning, name, current)
+	}
+	a.logger.Info("Canceling job %s at the request of the Agent API", current)
+	if err := jr.Cancel(); err != nil {
+		return "", fmt.Errorf("canceling job %s: %w", current, err)
+	}
+	return current, nil
+}
+
+// configuration returns the worker's current configuration.
+func (a *AgentWorker) configuration() AgentConfiguration {
+	a.confMtx.Lock()
+	defer a.confMtx.Unlock()
+	return a.agentConfiguration
+}
+
+// SetConfiguration changes the configuration of the worker. Jobs started after
+// this use the new configuration, while a job that's already running carries
+// on with the configuration it started with.
+func (a *AgentWorker) SetConfiguration(conf AgentConfiguration) {
+	a.confMtx.Lock()
+	defer a.confMtx.Unlock()
+	a.agentConfiguration = conf
+}
+
+// SetTags changes the tags of the agent. Since that requires registering the
+// agent again, it happens the next time the worker is idle, and only if the
+// tags are different to the ones it was registered with.
+func (a *AgentWorker) SetTags(tags []string) {
+	a.confMtx.Lock()
+	defer a.confMtx.Unlock()
+	if slices.Equal(tags, a.registerRequest.Tags) {
+		a.pendingTags = nil
+		return
+	}
+	a.pendingTags = slices.Clone(tags)
+}
+
+// takePendingTags returns the tags the agent needs to be re-registered with,
+// if any, and clears them.
+func (a *AgentWorker) takePendingTags() []string {
+	a.confMtx.Lock()
+	defer a.confMtx.Unlock()
+	tags := a.pendingTags
+	a.pendingTags = nil
+	return tags
+}
+
+// reregister disconnects the agent, then registers and connects it again with
+// new tags. It must only be called while the worker is idle. If the agent
+// couldn't be disconnected, it carries on with its old tags, but if it was
+// disconnected and couldn't be registered again, the worker is stopped.
+func (a *AgentWorker) reregister(ctx context.Context, tags []string) error {
+	a.registrationMtx.Lock()
+	defer a.registrationMtx.Unlock()
+
+	a.logger.Info("Re-registering agent with tags [%s]", strings.Join(tags, ", "))
+
+	if err := a.Disconnect(ctx); err != nil {
+		return fmt.Errorf("couldn't disconnect the agent to re-register it, so it's keeping its old tags: %w", err)
+	}
+
+	a.confMtx.Lock()
+	req := a.registerRequest
+	a.confMtx.Unlock()
+	req.Tags = tags
+
+	registered, err := Register(ctx, a.logger, a.registerClient, req)
+	if err != nil {
+		a.Stop(false)
+		return fmt.Errorf("couldn't re-register the agent: %w", err)
+	}
+
+	a.stateMtx.Lock()
+	a.agent = registered
+	a.apiClient = a.registerClient.FromAgentRegisterResponse(registered)
+	a.stateMtx.Unlock()
+
+	a.confMtx.Lock()
+	a.registerRequest = req
+	a.confMtx.Unlock()
+
+	if err := a.Connect(ctx); err != nil {
+		a.Stop(false)
+		return fmt.Errorf("couldn't connect the re-registered agent: %w", err)
+	}
+	return nil
 }
 
 type errUnrecoverable struct {
@@ -166,12 +362,18 @@ func (e *errUnrecoverable) Unwrap() error {
 }
 
 // Creates the agent worker and initializes its API Client
+// defaultNotReadyPingInterval is how often a worker pings while a readiness
+// check fails.
+const defaultNotReadyPingInterval = time.Minute
+
 func NewAgentWorker(l logger.Logger, a *api.AgentRegisterResponse, m *metrics.Collector, apiClient APIClient, c AgentWorkerConfig) *AgentWorker {
 	return &AgentWorker{
 		logger:             l,
 		agent:              a,
 		metricsCollector:   m,
 		apiClient:          apiClient.FromAgentRegisterResponse(a),
+		registerClient:     apiClient,
+		registerRequest:    c.RegisterRequest,
 		debug:              c.Debug,
 		debugHTTP:          c.DebugHTTP,
 		agentConfiguration: c.AgentConfiguration,
@@ -180,15 +382,21 @@ func NewAgentWorker(l logger.Logger, a *api.AgentRegisterResponse, m *metrics.Co
 		spawnIndex:         c.SpawnIndex,
 		retrySleepFunc:     time.Sleep, // https://github.com/buildkite/roko/issues/2
 		agentStdout:        c.AgentStdout,
+		readinessChecks:    c.ReadinessChecks,
 		state:              agentWorkerStateIdle,
+
+		notReadyPingInterval: defaultNotReadyPingInterval,
 	}
 }
 
 const workerStatusPart = `{{if le .LastPing.Seconds 2.0}}✅{{else}}❌{{end}} Last ping: {{.LastPing}} ago <br/>
 {{if le .LastHeartbeat.Seconds 60.0}}✅{{else}}❌{{end}} Last heartbeat: {{.LastHeartbeat}} ago<br/>
-{{if .LastHeartbeatError}}❌{{else}}✅{{end}} Last heartbeat error: {{printf "%v" .LastHeartbeatError}}`
+{{if .LastHeartbeatError}}❌{{else}}✅{{end}} Last heartbeat error: {{printf "%v" .LastHeartbeatError}}<br/>
+{{if .NotReadyReason}}❌ Not accepting jobs, since {{.NotReadyReason}}{{else}}✅ Accepting jobs{{end}}`
 
 func (a *AgentWorker) statusCallback(context.Context) (any, error) {
+	notReadyReason := a.notReadyReason()
+
 	a.stats.Lock()
 	defer a.stats.Unlock()
 
@@ -197,11 +405,13 @@ func (a *AgentWorker) statusCallback(context.Context) (any, error) {
 		LastHeartbeat      time.Duration
 		LastHeartbeatError error
 		LastPing           time.Duration
+		NotReadyReason     string
 	}{
 		SpawnIndex:         a.spawnIndex,
 		LastHeartbeat:      time.Since(a.stats.lastHeartbeat),
 		LastHeartbeatError: a.stats.lastHeartbeatError,
 		LastPing:           time.Since(a.stats.lastPing),
+		NotReadyReason:     notReadyReason,
 	}, nil
 }
 
@@ -214,12 +424,6 @@ func (a *AgentWorker) Start(ctx context.Context, idleMonitor *IdleMonitor) error
 	ctx, done := status.AddItem(ctx, fmt.Sprintf("Worker %d", a.spawnIndex), workerStatusPart, a.statusCallback)
 	defer done()
 
-	// Start running our metrics collector
-	if err := a.metricsCollector.Start(); err != nil {
-		return err
-	}
-	defer a.metricsCollector.Stop()
-
 	// Use a context to run heartbeats for as long as the ping loop or job runs
 	heartbeatCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -228,14 +432,14 @@ func (a *AgentWorker) Start(ctx context.Context, idleMonitor *IdleMonitor) error
 
 	// If the agent is booted in acquisition mode, then we don't need to
 	// bother about starting the ping loop.
-	if a.agentConfiguration.AcquireJob != "" {
+	if a.configuration().AcquireJob != "" {
 		// When in acquisition mode, there can't be any agents, so
 		// there's really no point in letting the idle monitor know
 		// we're busy, but it's probably a good thing to do for good
 		// measure.
 		idleMonitor.MarkBusy(a.agent.UUID)
 
-		return a.AcquireAndRunJob(ctx, a.agentConfiguration.AcquireJob)
+		return a.AcquireAndRunJob(ctx, a.configuration().AcquireJob)
 	}
 
 	return a.runPingLoop(ctx, idleMonitor)
@@ -246,15 +450,24 @@ func (a *AgentWorker) runHeartbeatLoop(ctx context.Context) {
 	defer done()
 	setStat("🏃 Starting...")
 
-	heartbeatInterval := time.Second * time.Duration(a.agent.HeartbeatInterval)
+	heartbeatInterval := a.heartbeatInterval()
 	heartbeatTicker := time.NewTicker(heartbeatInterval)
 	defer heartbeatTicker.Stop()
 	for {
 		setStat("😴 Sleeping for a bit")
 		select {
 		case <-heartbeatTicker.C:
+			// The interval can change when the agent re-registers
+			if interval := a.heartbeatInterval(); interval != heartbeatInterval {
+				heartbeatInterval = interval
+				heartbeatTicker.Reset(heartbeatInterval)
+			}
+
 			setStat("❤️ Sending heartbeat")
-			if err := a.Heartbeat(ctx); err != nil {
+			a.registrationMtx.Lock()
+			err := a.Heartbeat(ctx)
+			a.registrationMtx.Unlock()
+			if err != nil {
 				if errors.Is(err, &errUnrecoverable{}) {
 					a.logger.Error("%s", err)
 					return
@@ -285,18 +498,63 @@ func (a *AgentWorker) runPingLoop(ctx context.Context, idleMonitor *IdleMonitor)
 	setStat("🏃 Starting...")
 
 	// Create the ticker
-	pingInterval := time.Second * time.Duration(a.agent.PingInterval)
+	pingInterval := a.pingInterval()
 	pingTicker := time.NewTicker(pingInterval)
 	defer pingTicker.Stop()
 
+	// A worker refusing jobs isn't doing anything, so it counts as idle: with
+	// disconnect-after-idle-timeout, a host that stays unhealthy disconnects,
+	// and can be replaced.
 	lastActionTime := time.Now()
+	lastPingTime := time.Now()
 	a.logger.Info("Waiting for work...")
 
 	// Continue this loop until the closing of the stop channel signals termination
 	for {
 		if !a.stopping {
-			setStat("📡 Pinging Buildkite for work")
-			job, err := a.Ping(ctx)
+			// The worker is idle, so now is the time to re-register it if
+			// its tags have changed
+			if tags := a.takePendingTags(); tags != nil {
+				setStat("🏷️ Re-registering with new tags")
+				// The agent gets a new UUID, so the idle monitor can forget
+				// the old one
+				idleMonitor.MarkBusy(a.agent.UUID)
+				if err := a.reregister(ctx, tags); err != nil {
+					a.logger.Error("%v", err)
+				}
+				if interval := a.pingInterval(); interval != pingInterval {
+					pingInterval = interval
+					pingTicker.Reset(pingInterval)
+				}
+				lastActionTime = time.Now()
+				if a.stopping {
+					continue
+				}
+			}
+
+			// While a readiness check fails, only ping every so often, so the
+			// worker still gets actions such as disconnect. Buildkite can't be
+			// told the worker isn't accepting jobs, so any ping can be given
+			// one. Heartbeats keep the agent connected meanwhile.
+			var job *api.Job
+			var err error
+			switch {
+			case a.acceptingJobs(ctx, setStat):
+				setStat("📡 Pinging Buildkite for work")
+				lastPingTime = time.Now()
+				job, err = a.Ping(ctx)
+
+			case time.Since(lastPingTime) >= max(a.notReadyPingInterval, pingInterval):
+				setStat("📡 Pinging Buildkite for actions")
+				lastPingTime = time.Now()
+				job, err = a.Ping(ctx)
+				if job != nil {
+					// The job is assigned to this agent now, and there's no
+					// way to give it back, so it's run anyway.
+					a.logger.Warn("Buildkite assigned job %s to this agent while it isn't accepting jobs, since %s. Running it anyway",
+						job.ID, a.notReadyReason())
+				}
+			}
 			if err != nil {
 				if errors.Is(err, &errUnrecoverable{}) {
 					a.logger.Error("%v", err)
@@ -314,7 +572,7 @@ func (a *AgentWorker) runPingLoop(ctx context.Context, idleMonitor *IdleMonitor)
 				if runErr := a.AcceptAndRunJob(ctx, job); runErr != nil {
 					a.logger.Error("%v", runErr)
 				} else {
-					if a.agentConfiguration.DisconnectAfterJob {
+					if a.configuration().DisconnectAfterJob {
 						a.logger.Info("Job finished. Disconnecting...")
 						return nil
 					}
@@ -336,9 +594,8 @@ func (a *AgentWorker) runPingLoop(ctx context.Context, idleMonitor *IdleMonitor)
 			}
 
 			// Handle disconnect after idle timeout (and deprecated disconnect-after-job-timeout)
-			if a.agentConfiguration.DisconnectAfterIdleTimeout > 0 {
-				idleDeadline := lastActionTime.Add(time.Second *
-					time.Duration(a.agentConfiguration.DisconnectAfterIdleTimeout))
+			if idleTimeout := a.configuration().DisconnectAfterIdleTimeout; idleTimeout > 0 {
+				idleDeadline := lastActionTime.Add(time.Second * time.Duration(idleTimeout))
 
 				if time.Now().After(idleDeadline) {
 					// Let other agents know this agent is now idle and termination
@@ -347,8 +604,7 @@ func (a *AgentWorker) runPingLoop(ctx context.Context, idleMonitor *IdleMonitor)
 
 					// But only terminate if everyone else is also idle
 					if idleMonitor.Idle() {
-						a.logger.Info("All agents have been idle for %d seconds. Disconnecting...",
-							a.agentConfiguration.DisconnectAfterIdleTimeout)
+						a.logger.Info("All agents have been idle for %d seconds. Disconnecting...", idleTimeout)
 						return nil
 					} else {
 						a.logger.Debug("Agent has been idle for %.f seconds, but other agents haven't",
@@ -369,6 +625,29 @@ func (a *AgentWorker) runPingLoop(ctx context.Context, idleMonitor *IdleMonitor)
 	}
 }
 
+// acceptingJobs runs the checks that must pass before the worker pings for
+// work. While they fail, the worker stays connected, but is marked as refusing
+// jobs, and only pings every notReadyPingInterval.
+func (a *AgentWorker) acceptingJobs(ctx context.Context, setStat func(string)) bool {
+	conf := a.configuration()
+	for _, check := range a.readinessChecks {
+		setStat("🩺 Running the " + check.Name + " check")
+		if err := check.Check(ctx, conf); err != nil {
+			reason := fmt.Sprintf("the %s check failed: %v", check.Name, err)
+			if a.setRefusing(reason) {
+				a.logger.Warn("Not accepting jobs, since %s", reason)
+			}
+			setStat("🚫 Not accepting jobs, since " + reason)
+			return false
+		}
+	}
+
+	if a.setAccepting() {
+		a.logger.Info("Accepting jobs again")
+	}
+	return true
+}
+
 // Stops the agent from accepting new work and cancels any current work it's
 // running
 func (a *AgentWorker) Stop(graceful bool) {
@@ -479,7 +758,10 @@ func (a *AgentWorker) Heartbeat(ctx context.Context) error {
 
 // Performs a ping that checks Buildkite for a job or action to take
 // Returns a job, or nil if none is found
-func (a *AgentWorker) Ping(ctx context.Context) (*api.Job, error) {
+func (a *AgentWorker) Ping(ctx context.Context) (job *api.Job, err error) {
+	span, ctx := tracetools.StartSpanFromContext(ctx, "ping", a.configuration().TracingBackend)
+	defer func() { span.FinishWithError(err) }()
+
 	ping, resp, pingErr := a.apiClient.Ping(ctx)
 	// wait a minute, where's my if err != nil block? TL;DR look for pingErr ~20 lines down
 	// the api client returns an error if the response code isn't a 2xx, but there's still information in resp and ping
@@ -548,6 +830,7 @@ func (a *AgentWorker) Ping(ctx context.Context) (*api.Job, error) {
 		return nil, nil
 	}
 
+	span.AddAttributes(map[string]string{"buildkite.job_id": ping.Job.ID})
 	return ping.Job, nil
 }
 
@@ -557,9 +840,14 @@ var ErrJobAcquisitionFailure = errors.New("failed to acquire job")
 // server determined interval (from the Retry-After response header) if the job is in the waiting
 // state. If the job is in an unassignable state, it will return an error immediately.
 // Otherwise, it will retry every 3s for 30 s. The whole operation will timeout after 5 min.
-func (a *AgentWorker) AcquireAndRunJob(ctx context.Context, jobId string) error {
+func (a *AgentWorker) AcquireAndRunJob(ctx context.Context, jobId string) (err error) {
 	a.logger.Info("Attempting to acquire job %s...", jobId)
 
+	span, ctx := tracetools.StartSpanFromContext(ctx, "job", a.configuration().TracingBackend)
+	span.AddAttributes(map[string]string{"buildkite.job_id": jobId})
+	defer func() { span.FinishWithError(err) }()
+	acquireSpan, _ := tracetools.StartSpanFromContext(ctx, "acquire-job", a.configuration().TracingBackend)
+
 	// Timeout the context to prevent the exponentital backoff from growing too
 	// large if the job is in the waiting state.
 	//
@@ -621,6 +909,8 @@ func (a *AgentWorker) AcquireAndRunJob(ctx context.Context, jobId string) error
 		return aj, nil
 	})
 
+	acquireSpan.FinishWithError(err)
+
 	// If `acquiredJob` is nil, then the job was never acquired
 	if acquiredJob == nil {
 		return fmt.Errorf("failed to acquire job: %w", err)
@@ -631,9 +921,23 @@ func (a *AgentWorker) AcquireAndRunJob(ctx context.Context, jobId string) error
 }
 
 // Accepts a job and runs it, only returns an error if something goes wrong
-func (a *AgentWorker) AcceptAndRunJob(ctx context.Context, job *api.Job) error {
+func (a *AgentWorker) AcceptAndRunJob(ctx context.Context, job *api.Job) (err error) {
 	a.logger.Info("Assigned job %s. Accepting...", job.ID)
 
+	// The job span covers everything the agent does for the job, and is the
+	// parent of the bootstrap's spans. It continues the trace that scheduled
+	// the job, if there is one, either in the job env or (for example, for
+	// triggered builds, and only if enabled) in the build meta-data.
+	traceEnv := job.Env
+	conf := a.configuration()
+	if conf.TracingBackend == tracetools.BackendOpenTelemetry && conf.TracingMetaDataContext && !tracetools.HasTraceContext(traceEnv) {
+		traceEnv = a.traceContextFromMetaData(ctx, job.ID)
+	}
+	span, ctx := tracetools.StartSpanFromTraceContext(ctx, "job", a.configuration().TracingBackend, traceEnv)
+	span.AddAttributes(map[string]string{"buildkite.job_id": job.ID})
+	defer func() { span.FinishWithError(err) }()
+	acceptSpan, _ := tracetools.StartSpanFromContext(ctx, "accept-job", a.configuration().TracingBackend)
+
 	// Accept the job. We'll retry on connection related issues, but if
 	// Buildkite returns a 422 or 500 for example, we'll just bail out,
 	// re-ping, and try the whole process again.
@@ -655,6 +959,8 @@ func (a *AgentWorker) AcceptAndRunJob(ctx context.Context, job *api.Job) error {
 		return accepted, err
 	})
 
+	acceptSpan.FinishWithError(err)
+
 	// If `accepted` is nil, then the job was never accepted
 	if accepted == nil {
 		return fmt.Errorf("Failed to accept job: %w", err)
@@ -664,6 +970,30 @@ func (a *AgentWorker) AcceptAndRunJob(ctx context.Context, job *api.Job) error {
 	return a.RunJob(ctx, accepted)
 }
 
+// traceContextFromMetaData returns the W3C trace context stored in the
+// "traceparent" and "tracestate" meta-data keys of the job's build, as env
+// vars. Missing keys or errors are not a problem, so they're only logged.
+func (a *AgentWorker) traceContextFromMetaData(ctx context.Context, jobID string) map[string]string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	env := make(map[string]string)
+	for key, envVar := range map[string]string{
+		"traceparent": tracetools.EnvVarTraceParentKey,
+		"tracestate":  tracetools.EnvVarTraceStateKey,
+	} {
+		md, resp, err := a.apiClient.GetMetaData(ctx, "job", jobID, key)
+		if err != nil {
+			if resp == nil || resp.StatusCode != http.StatusNotFound {
+				a.logger.Debug("Couldn't get %q meta-data for the trace context: %v", key, err)
+			}
+			continue
+		}
+		env[envVar] = md.Value
+	}
+	return env
+}
+
 func (a *AgentWorker) RunJob(ctx context.Context, acceptResponse *api.Job) error {
 	a.setBusy(acceptResponse.ID)
 	defer a.setIdle()
@@ -676,26 +1006,34 @@ func (a *AgentWorker) RunJob(ctx context.Context, acceptResponse *api.Job) error
 		"queue":    acceptResponse.Env["BUILDKITE_AGENT_META_DATA_QUEUE"],
 	})
 
+	// The job runs with the configuration as it is now, even if it's changed
+	// while the job is running
+	conf := a.configuration()
+
 	// Now that we've got a job to do, we can start it.
 	jr, err := NewJobRunner(ctx, a.logger, a.apiClient, JobRunnerConfig{
 		Job:                acceptResponse,
-		JWKS:               a.agentConfiguration.VerificationJWKS,
+		JWKS:               conf.VerificationJWKS,
 		Debug:              a.debug,
 		DebugHTTP:          a.debugHTTP,
 		CancelSignal:       a.cancelSig,
 		MetricsScope:       jobMetricsScope,
 		JobStatusInterval:  time.Duration(a.agent.JobStatusInterval) * time.Second,
-		AgentConfiguration: a.agentConfiguration,
+		AgentConfiguration: conf,
 		AgentStdout:        a.agentStdout,
-		KubernetesExec:     a.agentConfiguration.KubernetesExec,
+		KubernetesExec:     conf.KubernetesExec,
 	})
 	if err != nil {
 		return fmt.Errorf("Failed to initialize job: %w", err)
 	}
+	a.stateMtx.Lock()
 	a.jobRunner = jr
+	a.stateMtx.Unlock()
 	defer func() {
 		// No more job, no more runner.
+		a.stateMtx.Lock()
 		a.jobRunner = nil
+		a.stateMtx.Unlock()
 	}()
 
 	// Start running the job
@@ -738,12 +1076,17 @@ func (a *AgentWorker) Disconnect(ctx context.Context) error {
 
 func (a *AgentWorker) healthHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		notReadyReason := a.notReadyReason()
+
 		a.stats.Lock()
 		defer a.stats.Unlock()
 
 		if a.stats.lastHeartbeatError != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "ERROR: last heartbeat failed: %v. last successful was %v ago", a.stats.lastHeartbeatError, time.Since(a.stats.lastHeartbeat))
+		} else if notReadyReason != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "NOT READY: not accepting jobs, since %s", notReadyReason)
 		} else {
 			if a.stats.lastHeartbeat.IsZero() {
 				fmt.Fprintf(w, "OK: no heartbeat yet")
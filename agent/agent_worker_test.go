@@ -2,6 +2,7 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -9,11 +10,15 @@ import (
 	"net/http/httptest"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/internal/builddir"
 	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -205,3 +210,238 @@ func TestAcquireAndRunJobWaiting(t *testing.T) {
 	}
 	assert.Equal(t, exptectedSleeps, retrySleeps)
 }
+
+func TestAgentWorkerReregister(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls = append(calls, req.Header.Get("Authorization")+" "+req.URL.Path)
+		switch req.URL.Path {
+		case "/register":
+			var reg api.AgentRegisterRequest
+			if err := json.NewDecoder(req.Body).Decode(&reg); err != nil {
+				t.Errorf("decoding register request: %v", err)
+			}
+			assert.Equal(t, "agent-1", reg.Name)
+			assert.Equal(t, []string{"queue=deploy"}, reg.Tags)
+			fmt.Fprintf(rw, `{"id": "uuid-2", "name": "agent-1", "access_token": "alpacas", "meta_data": ["queue=deploy"], "ping_interval": 10, "heartbeat_interval": 120}`)
+		case "/connect", "/disconnect":
+			fmt.Fprintf(rw, `{}`)
+		default:
+			t.Errorf("Unknown endpoint %s %s", req.Method, req.URL.Path)
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := api.NewClient(logger.Discard, api.Config{Endpoint: server.URL, Token: "registration"})
+	worker := NewAgentWorker(
+		logger.Discard,
+		&api.AgentRegisterResponse{UUID: "uuid-1", Name: "agent-1", AccessToken: "llamas", PingInterval: 5, HeartbeatInterval: 60},
+		nil,
+		client,
+		AgentWorkerConfig{
+			SpawnIndex:      1,
+			RegisterRequest: api.AgentRegisterRequest{Name: "agent-1", Tags: []string{"queue=default"}},
+		},
+	)
+
+	// The same tags don't need re-registering
+	worker.SetTags([]string{"queue=default"})
+	if tags := worker.takePendingTags(); tags != nil {
+		t.Errorf("after SetTags with the same tags, worker.takePendingTags() = %q, want nil", tags)
+	}
+
+	worker.SetTags([]string{"queue=deploy"})
+	tags := worker.takePendingTags()
+	if diff := cmp.Diff(tags, []string{"queue=deploy"}); diff != "" {
+		t.Errorf("worker.takePendingTags() diff (-got +want):\n%s", diff)
+	}
+
+	if err := worker.reregister(context.Background(), tags); err != nil {
+		t.Fatalf("worker.reregister(%q) error = %v", tags, err)
+	}
+
+	wantCalls := []string{"Token llamas /disconnect", "Token registration /register", "Token alpacas /connect"}
+	if diff := cmp.Diff(calls, wantCalls); diff != "" {
+		t.Errorf("API calls diff (-got +want):\n%s", diff)
+	}
+	if got, want := worker.info().ID, "uuid-2"; got != want {
+		t.Errorf("worker.info().ID = %q, want %q", got, want)
+	}
+	if got, want := worker.pingInterval(), 10*time.Second; got != want {
+		t.Errorf("worker.pingInterval() = %v, want %v", got, want)
+	}
+	if got, want := worker.heartbeatInterval(), 2*time.Minute; got != want {
+		t.Errorf("worker.heartbeatInterval() = %v, want %v", got, want)
+	}
+
+	// Now that it's registered with them, the new tags don't need
+	// re-registering again
+	worker.SetTags([]string{"queue=deploy"})
+	if tags := worker.takePendingTags(); tags != nil {
+		t.Errorf("after re-registering, worker.takePendingTags() = %q, want nil", tags)
+	}
+}
+
+func TestAgentWorkerSetConfiguration(t *testing.T) {
+	t.Parallel()
+
+	worker := NewAgentWorker(logger.Discard, &api.AgentRegisterResponse{}, nil, &api.Client{}, AgentWorkerConfig{
+		AgentConfiguration: AgentConfiguration{HooksPath: "/etc/hooks", RedactedVars: []string{"*_TOKEN"}},
+	})
+
+	worker.SetConfiguration(AgentConfiguration{HooksPath: "/opt/hooks", RedactedVars: []string{"*_SECRET"}})
+
+	want := AgentConfiguration{HooksPath: "/opt/hooks", RedactedVars: []string{"*_SECRET"}}
+	if diff := cmp.Diff(worker.configuration(), want); diff != "" {
+		t.Errorf("worker.configuration() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestAgentWorkerRefusesJobsWhileNotReady(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	gc := &builddir.Collector{
+		BuildPath: t.TempDir(),
+		// No disk has this much free
+		MinFreeSpace: math.MaxUint64,
+		Logger:       logger.Discard,
+	}
+	worker := NewAgentWorker(logger.Discard, &api.AgentRegisterResponse{}, nil, &api.Client{}, AgentWorkerConfig{
+		ReadinessChecks: []ReadinessCheck{DiskSpaceReadinessCheck(logger.Discard, gc)},
+	})
+	setStat := func(string) {}
+
+	if worker.acceptingJobs(ctx, setStat) {
+		t.Errorf("worker.acceptingJobs(ctx) = true, want false")
+	}
+	info := worker.info()
+	if got, want := info.State, string(agentWorkerStateRefusing); got != want {
+		t.Errorf("worker.info().State = %q, want %q", got, want)
+	}
+	if !strings.Contains(info.Reason, "the disk-space check failed: insufficient disk space") {
+		t.Errorf("worker.info().Reason = %q, want it to say the disk-space check failed", info.Reason)
+	}
+
+	rec := httptest.NewRecorder()
+	worker.healthHandler()(rec, httptest.NewRequest(http.MethodGet, "/agent/1", nil))
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("healthHandler() status = %d, want %d", got, want)
+	}
+	if got, want := rec.Body.String(), "NOT READY: not accepting jobs, since "+info.Reason; got != want {
+		t.Errorf("healthHandler() body = %q, want %q", got, want)
+	}
+
+	gc.MinFreeSpace = 1
+	if !worker.acceptingJobs(ctx, setStat) {
+		t.Errorf("worker.acceptingJobs(ctx) = false, want true")
+	}
+	info = worker.info()
+	if got, want := info.State, string(agentWorkerStateIdle); got != want {
+		t.Errorf("worker.info().State = %q, want %q", got, want)
+	}
+	if info.Reason != "" {
+		t.Errorf("worker.info().Reason = %q, want it empty", info.Reason)
+	}
+
+	rec = httptest.NewRecorder()
+	worker.healthHandler()(rec, httptest.NewRequest(http.MethodGet, "/agent/1", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("healthHandler() status = %d, want %d", got, want)
+	}
+}
+
+func TestAgentWorkerDoesNotPingWhileNotReady(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var heartbeats atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/heartbeat":
+			heartbeats.Add(1)
+			fmt.Fprintf(rw, `{}`)
+		case "/ping":
+			// Any ping could be given a job, so there are none until the
+			// (much longer) not ready ping interval
+			t.Errorf("Unexpected ping while a readiness check fails")
+			fmt.Fprintf(rw, `{"job": {"id": "job-1"}}`)
+		default:
+			t.Errorf("Unexpected request %s %s", req.Method, req.URL.Path)
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := api.NewClient(logger.Discard, api.Config{Endpoint: server.URL, Token: "llamas"})
+	notReady := ReadinessCheck{
+		Name:  "always-failing",
+		Check: func(context.Context, AgentConfiguration) error { return errors.New("not today") },
+	}
+	worker := NewAgentWorker(logger.Discard, &api.AgentRegisterResponse{PingInterval: 1, HeartbeatInterval: 1}, nil, client, AgentWorkerConfig{
+		AgentConfiguration: AgentConfiguration{DisconnectAfterIdleTimeout: 3},
+		ReadinessChecks:    []ReadinessCheck{notReady},
+	})
+	worker.apiClient = client
+
+	// A worker refusing jobs counts as idle, so it stops when it has been
+	// refusing for the idle timeout
+	if err := worker.Start(ctx, NewIdleMonitor(1)); err != nil {
+		t.Fatalf("worker.Start(ctx) error = %v", err)
+	}
+	if heartbeats.Load() == 0 {
+		t.Errorf("Buildkite got no heartbeats, want the agent kept connected")
+	}
+	if got, want := worker.info().State, string(agentWorkerStateRefusing); got != want {
+		t.Errorf("worker.info().State = %q, want %q", got, want)
+	}
+}
+
+func TestAgentWorkerPingsForActionsWhileNotReady(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var pings atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/heartbeat":
+			fmt.Fprintf(rw, `{}`)
+		case "/ping":
+			pings.Add(1)
+			fmt.Fprintf(rw, `{"action": "disconnect"}`)
+		default:
+			t.Errorf("Unexpected request %s %s", req.Method, req.URL.Path)
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := api.NewClient(logger.Discard, api.Config{Endpoint: server.URL, Token: "llamas"})
+	notReady := ReadinessCheck{
+		Name:  "always-failing",
+		Check: func(context.Context, AgentConfiguration) error { return errors.New("not today") },
+	}
+	worker := NewAgentWorker(logger.Discard, &api.AgentRegisterResponse{PingInterval: 1, HeartbeatInterval: 1}, nil, client, AgentWorkerConfig{
+		ReadinessChecks: []ReadinessCheck{notReady},
+	})
+	worker.apiClient = client
+	worker.notReadyPingInterval = 2 * time.Second
+
+	// Without an idle timeout, only the disconnect action stops the worker
+	if err := worker.Start(ctx, NewIdleMonitor(1)); err != nil {
+		t.Fatalf("worker.Start(ctx) error = %v", err)
+	}
+	if got := pings.Load(); got != 1 {
+		t.Errorf("Buildkite got %d pings, want 1", got)
+	}
+	if got, want := worker.info().State, string(agentWorkerStateRefusing); got != want {
+		t.Errorf("worker.info().State = %q, want %q", got, want)
+	}
+}
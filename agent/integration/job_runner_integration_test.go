@@ -2,11 +2,13 @@ package integration
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
@@ -14,6 +16,7 @@ import (
 	"github.com/buildkite/agent/v3/agent"
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/bintest/v3"
+	"github.com/google/go-cmp/cmp"
 	"gotest.tools/v3/assert"
 )
 
@@ -246,8 +249,8 @@ func TestJobRunner_WhenJobHasToken_ItOverridesAccessToken(t *testing.T) {
 	defer mb.CheckAndClose(t)
 
 	mb.Expect().Once().AndExitWith(0).AndCallFunc(func(c *bintest.Call) {
-		if got, want := c.GetEnv("BUILDKITE_AGENT_ACCESS_TOKEN"), jobToken; got != want {
-			t.Errorf("c.GetEnv(BUILDKITE_AGENT_ACCESS_TOKEN) = %q, want %q", got, want)
+		if got, want := c.GetEnv("BUILDKITE_BOOTSTRAP_ACCESS_TOKEN"), jobToken; got != want {
+			t.Errorf("c.GetEnv(BUILDKITE_BOOTSTRAP_ACCESS_TOKEN) = %q, want %q", got, want)
 		}
 		c.Exit(0)
 	})
@@ -286,8 +289,8 @@ func TestJobRunnerPassesAccessTokenToBootstrap(t *testing.T) {
 	defer mb.CheckAndClose(t)
 
 	mb.Expect().Once().AndExitWith(0).AndCallFunc(func(c *bintest.Call) {
-		if got, want := c.GetEnv("BUILDKITE_AGENT_ACCESS_TOKEN"), "llamasrock"; got != want {
-			t.Errorf("c.GetEnv(BUILDKITE_AGENT_ACCESS_TOKEN) = %q, want %q", got, want)
+		if got, want := c.GetEnv("BUILDKITE_BOOTSTRAP_ACCESS_TOKEN"), "llamasrock"; got != want {
+			t.Errorf("c.GetEnv(BUILDKITE_BOOTSTRAP_ACCESS_TOKEN) = %q, want %q", got, want)
 		}
 		c.Exit(0)
 	})
@@ -343,3 +346,172 @@ func TestJobRunnerIgnoresPipelineChangesToProtectedVars(t *testing.T) {
 		mockBootstrap: mb,
 	})
 }
+
+func TestJobRunnerLeavesSecretsEnvOutOfEnvFile(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	j := &api.Job{
+		ID:                 "my-job-id",
+		ChunksMaxSizeBytes: 1024,
+		Env: map[string]string{
+			"BUILDKITE_COMMAND":     "echo hello world",
+			"BUILDKITE_SECRETS_ENV": "DB_PASSWORD=prod/db",
+			"DB_PASSWORD":           "llamas",
+			"NOT_A_SECRET":          "alpacas",
+		},
+	}
+
+	mb := mockBootstrap(t)
+	defer mb.CheckAndClose(t)
+
+	mb.Expect().Once().AndExitWith(0).AndCallFunc(func(c *bintest.Call) {
+		// c.GetEnv doesn't handle values containing "="
+		if want := "BUILDKITE_SECRETS_ENV=DB_PASSWORD=prod/db,API_KEY=prod/api"; !slices.Contains(c.Env, want) {
+			t.Errorf("c.Env doesn't contain %q", want)
+		}
+
+		envFile, err := os.ReadFile(c.GetEnv("BUILDKITE_ENV_FILE"))
+		if err != nil {
+			t.Errorf("os.ReadFile(BUILDKITE_ENV_FILE) error = %v", err)
+		}
+		if strings.Contains(string(envFile), "\nDB_PASSWORD=") || strings.HasPrefix(string(envFile), "DB_PASSWORD=") {
+			t.Errorf("env file contains DB_PASSWORD:\n%s", envFile)
+		}
+		if !strings.Contains(string(envFile), `NOT_A_SECRET="alpacas"`) {
+			t.Errorf("env file doesn't contain NOT_A_SECRET:\n%s", envFile)
+		}
+		c.Exit(0)
+	})
+
+	// create a mock agent API
+	e := createTestAgentEndpoint()
+	server := e.server()
+	defer server.Close()
+
+	err := runJob(t, ctx, testRunJobConfig{
+		job:           j,
+		server:        server,
+		agentCfg:      agent.AgentConfiguration{SecretsEnv: []string{"API_KEY=prod/api"}},
+		mockBootstrap: mb,
+	})
+	if err != nil {
+		t.Fatalf("runJob() error = %v", err)
+	}
+}
+
+func TestJobRunnerSendsTheJobResultToTheAPI(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	j := &api.Job{
+		ID:                 "my-job-id",
+		ChunksMaxSizeBytes: 1024,
+		Env: map[string]string{
+			"BUILDKITE_COMMAND": "echo hello world",
+		},
+	}
+
+	mb := mockBootstrap(t)
+	defer mb.CheckAndClose(t)
+
+	var resultPath string
+	mb.Expect().Once().AndExitWith(1).AndCallFunc(func(c *bintest.Call) {
+		// The job executor writes the result set through the Job API here
+		// (see TestJobResultIsOnlySetThroughJobAPI in internal/job).
+		resultPath = c.GetEnv("BUILDKITE_BOOTSTRAP_JOB_RESULT_FILE")
+		data, err := json.Marshal(&api.JobResult{Category: "infra", Reason: "registry unavailable", SoftFail: true})
+		if err != nil {
+			t.Errorf("json.Marshal(result) error = %v", err)
+		}
+		if err := os.WriteFile(resultPath, data, 0o600); err != nil {
+			t.Errorf("os.WriteFile(%q, result) error = %v", resultPath, err)
+		}
+		c.Exit(1)
+	})
+
+	e := createTestAgentEndpoint()
+	server := e.server()
+	defer server.Close()
+
+	err := runJob(t, ctx, testRunJobConfig{
+		job:           j,
+		server:        server,
+		agentCfg:      agent.AgentConfiguration{},
+		mockBootstrap: mb,
+	})
+	if err != nil {
+		t.Fatalf("runJob() error = %v", err)
+	}
+
+	// The result is only part of the finish request, not api.Job.
+	e.mtx.Lock()
+	body := e.calls["/jobs/my-job-id/finish"][0]
+	e.mtx.Unlock()
+	var finish struct {
+		Result *api.JobResult `json:"result"`
+	}
+	if err := json.Unmarshal(body, &finish); err != nil {
+		t.Fatalf("json.Unmarshal(finish request) error = %v", err)
+	}
+	want := &api.JobResult{Category: "infra", Reason: "registry unavailable", SoftFail: true}
+	if diff := cmp.Diff(finish.Result, want); diff != "" {
+		t.Errorf("finish.Result diff (-got +want):\n%s", diff)
+	}
+
+	if _, err := os.Stat(resultPath); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) error = %v, want the result file to have been removed", resultPath, err)
+	}
+}
+
+func TestJobRunnerIgnoresAnInvalidJobResult(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	j := &api.Job{
+		ID:                 "my-job-id",
+		ChunksMaxSizeBytes: 1024,
+		Env: map[string]string{
+			"BUILDKITE_COMMAND": "echo hello world",
+		},
+	}
+
+	mb := mockBootstrap(t)
+	defer mb.CheckAndClose(t)
+
+	mb.Expect().Once().AndExitWith(1).AndCallFunc(func(c *bintest.Call) {
+		// A result the Job API wouldn't have accepted.
+		resultPath := c.GetEnv("BUILDKITE_BOOTSTRAP_JOB_RESULT_FILE")
+		if err := os.WriteFile(resultPath, []byte(`{"category":"llamas","reason":"line one\nline two","soft_fail":true}`), 0o600); err != nil {
+			t.Errorf("os.WriteFile(%q, result) error = %v", resultPath, err)
+		}
+		c.Exit(1)
+	})
+
+	e := createTestAgentEndpoint()
+	server := e.server()
+	defer server.Close()
+
+	err := runJob(t, ctx, testRunJobConfig{
+		job:           j,
+		server:        server,
+		agentCfg:      agent.AgentConfiguration{},
+		mockBootstrap: mb,
+	})
+	if err != nil {
+		t.Fatalf("runJob() error = %v", err)
+	}
+
+	e.mtx.Lock()
+	body := e.calls["/jobs/my-job-id/finish"][0]
+	e.mtx.Unlock()
+	var finish struct {
+		Result *api.JobResult `json:"result"`
+	}
+	if err := json.Unmarshal(body, &finish); err != nil {
+		t.Fatalf("json.Unmarshal(finish request) error = %v", err)
+	}
+	if finish.Result != nil {
+		t.Errorf("finish.Result = %+v, want nil", finish.Result)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,11 +15,13 @@ import (
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/internal/experiments"
 	"github.com/buildkite/agent/v3/internal/job/shell"
+	"github.com/buildkite/agent/v3/internal/secrets"
 	"github.com/buildkite/agent/v3/kubernetes"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/metrics"
 	"github.com/buildkite/agent/v3/process"
 	"github.com/buildkite/agent/v3/status"
+	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/buildkite/roko"
 	"github.com/buildkite/shellwords"
 	"github.com/lestrrat-go/jwx/v2/jwk"
@@ -50,30 +53,33 @@ const (
 // Certain env can only be set by agent configuration.
 // We show the user a warning in the bootstrap if they use any of these at a job level.
 var ProtectedEnv = map[string]struct{}{
-	"BUILDKITE_AGENT_ACCESS_TOKEN":       {},
-	"BUILDKITE_AGENT_DEBUG":              {},
-	"BUILDKITE_AGENT_ENDPOINT":           {},
-	"BUILDKITE_AGENT_PID":                {},
-	"BUILDKITE_BIN_PATH":                 {},
-	"BUILDKITE_BUILD_PATH":               {},
-	"BUILDKITE_COMMAND_EVAL":             {},
-	"BUILDKITE_CONFIG_PATH":              {},
-	"BUILDKITE_CONTAINER_COUNT":          {},
-	"BUILDKITE_GIT_CLEAN_FLAGS":          {},
-	"BUILDKITE_GIT_CLONE_FLAGS":          {},
-	"BUILDKITE_GIT_CLONE_MIRROR_FLAGS":   {},
-	"BUILDKITE_GIT_FETCH_FLAGS":          {},
-	"BUILDKITE_GIT_MIRRORS_LOCK_TIMEOUT": {},
-	"BUILDKITE_GIT_MIRRORS_PATH":         {},
-	"BUILDKITE_GIT_MIRRORS_SKIP_UPDATE":  {},
-	"BUILDKITE_GIT_SUBMODULES":           {},
-	"BUILDKITE_HOOKS_PATH":               {},
-	"BUILDKITE_KUBERNETES_EXEC":          {},
-	"BUILDKITE_LOCAL_HOOKS_ENABLED":      {},
-	"BUILDKITE_PLUGINS_ENABLED":          {},
-	"BUILDKITE_PLUGINS_PATH":             {},
-	"BUILDKITE_SHELL":                    {},
-	"BUILDKITE_SSH_KEYSCAN":              {},
+	"BUILDKITE_AGENT_ACCESS_TOKEN":        {},
+	"BUILDKITE_AGENT_DEBUG":               {},
+	"BUILDKITE_AGENT_ENDPOINT":            {},
+	"BUILDKITE_AGENT_PID":                 {},
+	"BUILDKITE_BIN_PATH":                  {},
+	"BUILDKITE_BOOTSTRAP_ACCESS_TOKEN":    {},
+	"BUILDKITE_BOOTSTRAP_JOB_RESULT_FILE": {},
+	"BUILDKITE_BUILD_PATH":                {},
+	"BUILDKITE_COMMAND_EVAL":              {},
+	"BUILDKITE_CONFIG_PATH":               {},
+	"BUILDKITE_CONTAINER_COUNT":           {},
+	"BUILDKITE_GIT_CLEAN_FLAGS":           {},
+	"BUILDKITE_GIT_CLONE_FLAGS":           {},
+	"BUILDKITE_GIT_CLONE_MIRROR_FLAGS":    {},
+	"BUILDKITE_GIT_FETCH_FLAGS":           {},
+	"BUILDKITE_GIT_MIRRORS_LOCK_TIMEOUT":  {},
+	"BUILDKITE_GIT_MIRRORS_PATH":          {},
+	"BUILDKITE_GIT_MIRRORS_SKIP_UPDATE":   {},
+	"BUILDKITE_GIT_SUBMODULES":            {},
+	"BUILDKITE_HOOKS_PATH":                {},
+	"BUILDKITE_KUBERNETES_EXEC":           {},
+	"BUILDKITE_LOCAL_HOOKS_ENABLED":       {},
+	"BUILDKITE_PLUGINS_ENABLED":           {},
+	"BUILDKITE_PLUGINS_PATH":              {},
+	"BUILDKITE_SECRETS_PROVIDERS":         {},
+	"BUILDKITE_SHELL":                     {},
+	"BUILDKITE_SSH_KEYSCAN":               {},
 }
 
 type JobRunnerConfig struct {
@@ -110,6 +116,7 @@ type JobRunnerConfig struct {
 
 type jobRunner interface {
 	Run(ctx context.Context) error
+	Cancel() error
 	CancelAndStop() error
 }
 
@@ -155,6 +162,13 @@ type JobRunner struct {
 
 	// File containing a copy of the job env
 	envFile *os.File
+
+	// Path to a file the job executor writes the job's result to, if the job
+	// sets one
+	resultPath string
+
+	// The result the job set, read from the result file once it finishes
+	result *api.JobResult
 }
 
 type jobAPI interface {
@@ -222,6 +236,19 @@ func NewJobRunner(ctx context.Context, l logger.Logger, apiClient APIClient, con
 		r.envFile = file
 	}
 
+	// Prepare a file to receive the job result, if the job sets one. Under
+	// Kubernetes the executor runs in other containers, so can't write it.
+	if !r.conf.KubernetesExec {
+		file, err := os.CreateTemp(tempDir, fmt.Sprintf("job-result-%s", r.conf.Job.ID))
+		if err != nil {
+			return r, err
+		}
+		r.resultPath = file.Name()
+		if err := file.Close(); err != nil {
+			return r, err
+		}
+	}
+
 	env, err := r.createEnvironment(ctx)
 	if err != nil {
 		return nil, err
@@ -346,11 +373,20 @@ func NewJobRunner(ctx context.Context, l logger.Logger, apiClient APIClient, con
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse BUILDKITE_CONTAINER_COUNT: %w", err)
 		}
+		// A negative primary container ID means the one with the highest ID.
+		var primaryID *int
+		if id := conf.AgentConfiguration.KubernetesPrimaryContainerID; id >= 0 {
+			primaryID = &id
+		}
 		r.process = kubernetes.New(r.agentLogger, kubernetes.Config{
 			Stdout:      r.jobLogs,
 			Stderr:      r.jobLogs,
 			ClientCount: containerCount,
 			Env:         processEnv,
+
+			HeartbeatTimeout: conf.AgentConfiguration.KubernetesHeartbeatTimeout,
+			ExitAggregation:  conf.AgentConfiguration.KubernetesExitAggregation,
+			PrimaryClientID:  primaryID,
 		})
 	} else { // not Kubernetes
 		// The bootstrap-script gets parsed based on the operating system
@@ -400,7 +436,10 @@ func (r *JobRunner) normalizeVerificationBehavior(behavior string) (string, erro
 }
 
 // Creates the environment variables that will be used in the process and writes a flat environment file
-func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
+func (r *JobRunner) createEnvironment(ctx context.Context) (_ []string, err error) {
+	span, _ := tracetools.StartSpanFromContext(ctx, "environment-setup", r.conf.AgentConfiguration.TracingBackend)
+	defer func() { span.FinishWithError(err) }()
+
 	// Create a clone of our jobs environment. We'll then set the
 	// environment variables provided by the agent, which will override any
 	// sent by Buildkite. The variables below should always take
@@ -413,11 +452,25 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 	// The agent registration token should never make it into the job environment
 	delete(env, "BUILDKITE_AGENT_TOKEN")
 
+	// Secrets to set in the environment can come from the pipeline and the
+	// agent configuration. The executor resolves them in order, so the agent
+	// configuration takes precedence.
+	secretsEnv := append(secrets.SplitList(env["BUILDKITE_SECRETS_ENV"]), r.conf.AgentConfiguration.SecretsEnv...)
+	envSecrets, err := secrets.ParseEnv(secretsEnv)
+	if err != nil {
+		return nil, err
+	}
+
 	// Write out the job environment to a file, in k="v" format, with newlines escaped
 	// We present only the clean environment - i.e only variables configured
 	// on the job upstream - and expose the path in another environment variable.
+	// Variables that will be set from secrets are left out, so that the file
+	// never contains them.
 	if r.envFile != nil {
 		for key, value := range env {
+			if slices.ContainsFunc(envSecrets, func(s secrets.EnvSecret) bool { return s.Name == key }) {
+				continue
+			}
 			if _, err := r.envFile.WriteString(fmt.Sprintf("%s=%q\n", key, value)); err != nil {
 				return nil, err
 			}
@@ -428,6 +481,12 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 		env["BUILDKITE_ENV_FILE"] = r.envFile.Name()
 	}
 
+	// The result file is for the executor, which removes it from the job
+	// environment, so that the result can only be set through the Job API.
+	if r.resultPath != "" {
+		env["BUILDKITE_BOOTSTRAP_JOB_RESULT_FILE"] = r.resultPath
+	}
+
 	var ignoredEnv []string
 
 	// Check if the user has defined any protected env
@@ -447,10 +506,12 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 		env["BUILDKITE_IGNORED_ENV"] = strings.Join(ignoredEnv, ",")
 	}
 
-	// Add the API configuration
+	// Add the API configuration. The access token is only for the bootstrap,
+	// which removes it from the job environment: commands in the job reach
+	// Buildkite through the Job API instead.
 	apiConfig := r.apiClient.Config()
 	env["BUILDKITE_AGENT_ENDPOINT"] = apiConfig.Endpoint
-	env["BUILDKITE_AGENT_ACCESS_TOKEN"] = apiConfig.Token
+	env["BUILDKITE_BOOTSTRAP_ACCESS_TOKEN"] = apiConfig.Token
 
 	// Add agent environment variables
 	env["BUILDKITE_AGENT_DEBUG"] = fmt.Sprintf("%t", r.conf.Debug)
@@ -473,6 +534,9 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 	env["BUILDKITE_CONFIG_PATH"] = r.conf.AgentConfiguration.ConfigPath
 	env["BUILDKITE_BUILD_PATH"] = r.conf.AgentConfiguration.BuildPath
 	env["BUILDKITE_SOCKETS_PATH"] = r.conf.AgentConfiguration.SocketsPath
+	if r.conf.AgentConfiguration.JobAPIListen != "" {
+		env["BUILDKITE_AGENT_JOB_API_LISTEN"] = r.conf.AgentConfiguration.JobAPIListen
+	}
 	env["BUILDKITE_GIT_MIRRORS_PATH"] = r.conf.AgentConfiguration.GitMirrorsPath
 	env["BUILDKITE_GIT_MIRRORS_SKIP_UPDATE"] = fmt.Sprintf("%t", r.conf.AgentConfiguration.GitMirrorsSkipUpdate)
 	env["BUILDKITE_HOOKS_PATH"] = r.conf.AgentConfiguration.HooksPath
@@ -491,6 +555,10 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 	env["BUILDKITE_SHELL"] = r.conf.AgentConfiguration.Shell
 	env["BUILDKITE_AGENT_EXPERIMENT"] = strings.Join(experiments.Enabled(ctx), ",")
 	env["BUILDKITE_REDACTED_VARS"] = strings.Join(r.conf.AgentConfiguration.RedactedVars, ",")
+	env["BUILDKITE_SECRETS_PROVIDERS"] = strings.Join(r.conf.AgentConfiguration.SecretsProviders, ",")
+	if len(secretsEnv) > 0 {
+		env["BUILDKITE_SECRETS_ENV"] = strings.Join(secretsEnv, ",")
+	}
 	env["BUILDKITE_STRICT_SINGLE_HOOKS"] = fmt.Sprintf("%t", r.conf.AgentConfiguration.StrictSingleHooks)
 	env["BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS"] = fmt.Sprintf("%d", int(r.conf.AgentConfiguration.SignalGracePeriod/time.Second))
 
@@ -541,6 +609,15 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 	if r.conf.AgentConfiguration.TracingBackend != "" {
 		env["BUILDKITE_TRACING_BACKEND"] = r.conf.AgentConfiguration.TracingBackend
 		env["BUILDKITE_TRACING_SERVICE_NAME"] = r.conf.AgentConfiguration.TracingServiceName
+		if r.conf.AgentConfiguration.TracingBackend == tracetools.BackendFile {
+			env["BUILDKITE_TRACING_FILE_FORMAT"] = r.conf.AgentConfiguration.TracingFileFormat
+		}
+
+		// Pass on the context of the agent's job span, so the bootstrap's
+		// spans are part of the same trace.
+		if err := tracetools.InjectTraceContext(ctx, r.conf.AgentConfiguration.TracingBackend, env); err != nil {
+			r.agentLogger.Warn("Couldn't pass the trace context to the job: %v", err)
+		}
 	}
 
 	env["BUILDKITE_AGENT_DISABLE_WARNINGS_FOR"] = strings.Join(r.conf.AgentConfiguration.DisableWarningsFor, ",")
@@ -589,9 +666,12 @@ func (w LogWriter) Write(bytes []byte) (int, error) {
 	return len(bytes), nil
 }
 
-func (r *JobRunner) executePreBootstrapHook(ctx context.Context, hook string) (bool, error) {
+func (r *JobRunner) executePreBootstrapHook(ctx context.Context, hook string) (_ bool, err error) {
 	r.agentLogger.Info("Running pre-bootstrap hook %q", hook)
 
+	span, ctx := tracetools.StartSpanFromContext(ctx, "pre-bootstrap", r.conf.AgentConfiguration.TracingBackend)
+	defer func() { span.FinishWithError(err) }()
+
 	sh, err := shell.New()
 	if err != nil {
 		return false, err
@@ -618,9 +698,12 @@ func (r *JobRunner) executePreBootstrapHook(ctx context.Context, hook string) (b
 // issues, but if a connection succeeds and we get an client error response back from
 // Buildkite, we won't bother retrying. For example, a "no such host" will
 // retry, but an HTTP response from Buildkite that isn't retryable won't.
-func (r *JobRunner) startJob(ctx context.Context, startedAt time.Time) error {
+func (r *JobRunner) startJob(ctx context.Context, startedAt time.Time) (err error) {
 	r.conf.Job.StartedAt = startedAt.UTC().Format(time.RFC3339Nano)
 
+	span, ctx := tracetools.StartSpanFromContext(ctx, "start-job", r.conf.AgentConfiguration.TracingBackend)
+	defer func() { span.FinishWithError(err) }()
+
 	return roko.NewRetrier(
 		roko.WithMaxAttempts(7),
 		roko.WithStrategy(roko.Exponential(2*time.Second, 0)),
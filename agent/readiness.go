@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/buildkite/agent/v3/internal/builddir"
+	"github.com/buildkite/agent/v3/internal/system"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/dustin/go-humanize"
+)
+
+// ReadinessCheck is a check that must pass before an agent worker asks
+// Buildkite for a job. While a check fails, the worker stays connected, but
+// refuses jobs. The same checks are shared by all the workers in a pool, so
+// they must be safe to run concurrently.
+type ReadinessCheck struct {
+	// The name of the check, used when reporting why the worker isn't
+	// accepting jobs
+	Name string
+
+	// Check returns an error saying why the host isn't ready to run a job,
+	// or nil if it is. conf is the worker's current configuration, which can
+	// change when the agent's config is reloaded.
+	Check func(ctx context.Context, conf AgentConfiguration) error
+}
+
+// DiskSpaceReadinessCheck removes the least recently used checkouts from the
+// build path when it's low on space, and fails if it can't free enough. If the
+// free space can't be determined, it logs a warning and passes.
+func DiskSpaceReadinessCheck(l logger.Logger, gc *builddir.Collector) ReadinessCheck {
+	return ReadinessCheck{
+		Name: "disk-space",
+		Check: func(ctx context.Context, _ AgentConfiguration) error {
+			err := gc.Collect(ctx)
+			if err != nil && !errors.Is(err, builddir.ErrInsufficientDiskSpace) {
+				l.Warn("Couldn't free up disk space in the build path: %v", err)
+				return nil
+			}
+			return err
+		},
+	}
+}
+
+// LoadAverageReadinessCheck fails while the host's load average over the last
+// minute is above max.
+func LoadAverageReadinessCheck(max float64) ReadinessCheck {
+	return ReadinessCheck{
+		Name: "load-average",
+		Check: func(context.Context, AgentConfiguration) error {
+			load, err := system.GetLoadAverage()
+			if err != nil {
+				return err
+			}
+			if load > max {
+				return fmt.Errorf("the load average is %.2f, above the maximum of %.2f", load, max)
+			}
+			return nil
+		},
+	}
+}
+
+// MemoryReadinessCheck fails while the host has less than min bytes of memory
+// available.
+func MemoryReadinessCheck(min uint64) ReadinessCheck {
+	return ReadinessCheck{
+		Name: "memory",
+		Check: func(context.Context, AgentConfiguration) error {
+			available, err := system.GetAvailableMemory()
+			if err != nil {
+				return err
+			}
+			if available < min {
+				return fmt.Errorf("%s of memory is available, but %s is required",
+					humanize.IBytes(available), humanize.IBytes(min))
+			}
+			return nil
+		},
+	}
+}
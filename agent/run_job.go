@@ -19,6 +19,7 @@ import (
 	"github.com/buildkite/agent/v3/metrics"
 	"github.com/buildkite/agent/v3/process"
 	"github.com/buildkite/agent/v3/status"
+	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/buildkite/go-pipeline"
 	"github.com/buildkite/roko"
 )
@@ -330,6 +331,8 @@ func (r *JobRunner) runJob(ctx context.Context) processExit {
 func (r *JobRunner) cleanup(ctx context.Context, wg *sync.WaitGroup, exit processExit) {
 	finishedAt := time.Now()
 
+	drainSpan, _ := tracetools.StartSpanFromContext(ctx, "log-drain", r.conf.AgentConfiguration.TracingBackend)
+
 	// Flush the job logs. If the process is never started, then logs from prior to the attempt to
 	// start the process will still be buffered. Also, there may still be logs in the buffer that
 	// were left behind because the uploader goroutine exited before it could flush them.
@@ -341,6 +344,8 @@ func (r *JobRunner) cleanup(ctx context.Context, wg *sync.WaitGroup, exit proces
 	// Stop the header time streamer. This will block until all the chunks have been uploaded
 	r.headerTimesStreamer.Stop()
 
+	drainSpan.FinishWithError(nil)
+
 	// Warn about failed chunks
 	if count := r.logStreamer.FailedChunks(); count > 0 {
 		r.agentLogger.Warn("%d chunks failed to upload for this job", count)
@@ -358,6 +363,14 @@ func (r *JobRunner) cleanup(ctx context.Context, wg *sync.WaitGroup, exit proces
 		r.agentLogger.Debug("[JobRunner] Deleted env file: %s", r.envFile.Name())
 	}
 
+	// Read and remove the result file, if any
+	if r.resultPath != "" {
+		r.result = r.readResult()
+		if err := os.Remove(r.resultPath); err != nil {
+			r.agentLogger.Warn("[JobRunner] Error cleaning up result file: %s", err)
+		}
+	}
+
 	// Write some metrics about the job run
 	jobMetrics := r.conf.MetricsScope.With(metrics.Tags{"exit_code": strconv.Itoa(exit.Status)})
 
@@ -376,9 +389,37 @@ func (r *JobRunner) cleanup(ctx context.Context, wg *sync.WaitGroup, exit proces
 	r.agentLogger.Info("Finished job %s", r.conf.Job.ID)
 }
 
+// readResult reads the result the job executor wrote to the result file.
+// It returns nil if the job didn't set a result.
+func (r *JobRunner) readResult() *api.JobResult {
+	data, err := os.ReadFile(r.resultPath)
+	if err != nil {
+		r.agentLogger.Warn("[JobRunner] Error reading result file: %s", err)
+		return nil
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	var res api.JobResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		r.agentLogger.Warn("[JobRunner] Error decoding result file: %s", err)
+		return nil
+	}
+	// The executor only writes results the Job API accepted, but check again
+	// in case something else in the job wrote to the file.
+	if err := res.Validate(); err != nil {
+		r.agentLogger.Warn("[JobRunner] Ignoring the result in the result file: %s", err)
+		return nil
+	}
+	return &res
+}
+
 // finishJob finishes the job in the Buildkite Agent API. If the FinishJob call
 // cannot return successfully, this will retry for a long time.
-func (r *JobRunner) finishJob(ctx context.Context, finishedAt time.Time, exit processExit, failedChunkCount int) error {
+func (r *JobRunner) finishJob(ctx context.Context, finishedAt time.Time, exit processExit, failedChunkCount int) (err error) {
+	span, ctx := tracetools.StartSpanFromContext(ctx, "finish-job", r.conf.AgentConfiguration.TracingBackend)
+	defer func() { span.FinishWithError(err) }()
+
 	r.conf.Job.FinishedAt = finishedAt.UTC().Format(time.RFC3339Nano)
 	r.conf.Job.ExitStatus = strconv.Itoa(exit.Status)
 	r.conf.Job.Signal = exit.Signal
@@ -387,6 +428,10 @@ func (r *JobRunner) finishJob(ctx context.Context, finishedAt time.Time, exit pr
 
 	r.agentLogger.Debug("[JobRunner] Finishing job with exit_status=%s, signal=%s and signal_reason=%s",
 		r.conf.Job.ExitStatus, r.conf.Job.Signal, r.conf.Job.SignalReason)
+	if res := r.result; res != nil {
+		r.agentLogger.Debug("[JobRunner] Job result: category=%s, reason=%q, soft_fail=%t",
+			res.Category, res.Reason, res.SoftFail)
+	}
 
 	ctx, cancel := context.WithTimeout(ctx, 48*time.Hour)
 	defer cancel()
@@ -397,7 +442,7 @@ func (r *JobRunner) finishJob(ctx context.Context, finishedAt time.Time, exit pr
 		roko.WithMaxAttempts(20),
 		roko.WithJitter(),
 	).DoWithContext(ctx, func(retrier *roko.Retrier) error {
-		response, err := r.apiClient.FinishJob(ctx, r.conf.Job)
+		response, err := r.apiClient.FinishJob(ctx, r.conf.Job, r.result)
 		if err != nil {
 			// If the API returns with a 422, that means that we
 			// successfully tried to finish the job, but Buildkite
@@ -2,7 +2,10 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/buildkite/go-pipeline"
 )
@@ -27,6 +30,56 @@ type Job struct {
 	ChunksFailedCount  int                        `json:"chunks_failed_count,omitempty"`
 }
 
+// Categories of job failure, for JobResult.Category.
+const (
+	JobResultCategoryInfra = "infra"
+	JobResultCategoryTest  = "test"
+	JobResultCategoryLint  = "lint"
+	JobResultCategoryBuild = "build"
+	JobResultCategoryOther = "other"
+)
+
+// JobResultCategories are the valid values of JobResult.Category.
+var JobResultCategories = []string{
+	JobResultCategoryInfra,
+	JobResultCategoryTest,
+	JobResultCategoryLint,
+	JobResultCategoryBuild,
+	JobResultCategoryOther,
+}
+
+// JobResult is a structured result set by the job itself, reported alongside
+// its exit status.
+type JobResult struct {
+	// Category is the kind of failure, one of JobResultCategories.
+	Category string `json:"category,omitempty"`
+
+	// Reason is a one-line description of the result.
+	Reason string `json:"reason,omitempty"`
+
+	// SoftFail requests that a failure of the job be treated as a soft
+	// failure.
+	SoftFail bool `json:"soft_fail,omitempty"`
+}
+
+// maxJobResultReasonLength limits the length of JobResult.Reason.
+const maxJobResultReasonLength = 1024
+
+// Validate checks that the category is known and the reason is a single line
+// of reasonable length.
+func (r *JobResult) Validate() error {
+	if r.Category != "" && !slices.Contains(JobResultCategories, r.Category) {
+		return fmt.Errorf("invalid category %q: must be one of %s", r.Category, strings.Join(JobResultCategories, ", "))
+	}
+	if strings.ContainsAny(r.Reason, "\r\n") {
+		return errors.New("invalid reason: must be a single line")
+	}
+	if len(r.Reason) > maxJobResultReasonLength {
+		return fmt.Errorf("invalid reason: must be at most %d bytes long", maxJobResultReasonLength)
+	}
+	return nil
+}
+
 type JobState struct {
 	State string `json:"state,omitempty"`
 }
@@ -36,11 +89,12 @@ type jobStartRequest struct {
 }
 
 type jobFinishRequest struct {
-	ExitStatus        string `json:"exit_status,omitempty"`
-	Signal            string `json:"signal,omitempty"`
-	SignalReason      string `json:"signal_reason,omitempty"`
-	FinishedAt        string `json:"finished_at,omitempty"`
-	ChunksFailedCount int    `json:"chunks_failed_count"`
+	ExitStatus        string     `json:"exit_status,omitempty"`
+	Signal            string     `json:"signal,omitempty"`
+	SignalReason      string     `json:"signal_reason,omitempty"`
+	FinishedAt        string     `json:"finished_at,omitempty"`
+	ChunksFailedCount int        `json:"chunks_failed_count"`
+	Result            *JobResult `json:"result,omitempty"`
 }
 
 // GetJobState returns the state of a given job
@@ -113,8 +167,9 @@ func (c *Client) StartJob(ctx context.Context, job *Job) (*Response, error) {
 	return c.doRequest(req, nil)
 }
 
-// FinishJob finishes the passed in job
-func (c *Client) FinishJob(ctx context.Context, job *Job) (*Response, error) {
+// FinishJob finishes the passed in job, with the structured result the job
+// set, if any
+func (c *Client) FinishJob(ctx context.Context, job *Job, result *JobResult) (*Response, error) {
 	u := fmt.Sprintf("jobs/%s/finish", railsPathEscape(job.ID))
 
 	req, err := c.newRequest(ctx, "PUT", u, &jobFinishRequest{
@@ -123,6 +178,7 @@ func (c *Client) FinishJob(ctx context.Context, job *Job) (*Response, error) {
 		Signal:            job.Signal,
 		SignalReason:      job.SignalReason,
 		ChunksFailedCount: job.ChunksFailedCount,
+		Result:            result,
 	})
 	if err != nil {
 		return nil, err
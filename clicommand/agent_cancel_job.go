@@ -0,0 +1,105 @@
+package clicommand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/urfave/cli"
+)
+
+const agentCancelJobHelpDescription = `Usage:
+
+    buildkite-agent agent cancel-job [job-id] [options...]
+
+Description:
+
+Cancels a job running on an agent on this host, identified either by the job
+ID, or by the name or ID of the agent running it (with ′--worker′). If both
+are given, the job is only cancelled if that agent is still running it.
+
+The job is cancelled as if it had been cancelled in Buildkite: its process is
+sent the cancel signal, and killed if it doesn't exit within the grace period.
+The agent then carries on accepting jobs.
+
+If the agents were started with ′--agent-api-token′, the same token must be
+provided with ′--agent-api-token′ (or $BUILDKITE_AGENT_API_TOKEN).
+
+Note that this subcommand is only available when agents have been started
+with the ′agent-api′ experiment enabled.
+
+Examples:
+
+    $ buildkite-agent agent cancel-job 0190c5a6-7b2c-4e5d-9f1a-2b3c4d5e6f70
+    $ buildkite-agent agent cancel-job --worker my-agent-1`
+
+type AgentCancelJobConfig struct {
+	Worker string `cli:"worker"`
+
+	// Common config options
+	SocketsPath   string `cli:"sockets-path" normalize:"filepath"`
+	AgentAPIToken string `cli:"agent-api-token"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var AgentCancelJobCommand = cli.Command{
+	Name:        "cancel-job",
+	Usage:       "Cancels a job running on an agent on this host",
+	Description: agentCancelJobHelpDescription,
+	Flags: append(append(globalFlags(), agentAPIClientFlags...),
+		cli.StringFlag{
+			Name:  "worker",
+			Value: "",
+			Usage: "The name or ID of the agent running the job",
+		},
+	),
+	Action: agentCancelJobAction,
+}
+
+func agentCancelJobAction(c *cli.Context) error {
+	if c.NArg() > 1 {
+		fmt.Fprint(c.App.ErrWriter, agentCancelJobHelpDescription)
+		return &SilentExitError{code: 1}
+	}
+	jobID := c.Args().First()
+
+	ctx, cfg, l, _, done := setupLoggerAndConfig[AgentCancelJobConfig](context.Background(), c)
+	defer done()
+
+	if jobID == "" && cfg.Worker == "" {
+		fmt.Fprint(c.App.ErrWriter, agentCancelJobHelpDescription)
+		return &SilentExitError{code: 1}
+	}
+
+	clients, err := agentAPIClients(ctx, l, cfg.SocketsPath, cfg.AgentAPIToken)
+	if err != nil {
+		return err
+	}
+
+	// Each agent only knows about its own workers, so ask each in turn.
+	var notFound error
+	for _, cl := range clients {
+		cancelled, err := cl.CancelJob(ctx, cfg.Worker, jobID)
+		var apiErr socket.APIErr
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusConflict) {
+			if notFound == nil || apiErr.StatusCode == http.StatusConflict {
+				notFound = errors.New(apiErr.Msg)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't cancel job: %w", err)
+		}
+		l.Info("Cancelling job %s", cancelled)
+		return nil
+	}
+	return fmt.Errorf("couldn't cancel job: %w", notFound)
+}
@@ -0,0 +1,152 @@
+package clicommand
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/internal/builddir"
+	"github.com/buildkite/agent/v3/internal/job/hook"
+	"github.com/buildkite/agent/v3/internal/job/shell"
+	"github.com/buildkite/agent/v3/internal/system"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// How long the readiness hook can run before it's cancelled, and counted
+	// as failing.
+	readinessHookTimeout = 30 * time.Second
+
+	// How long the result of the readiness hook is reused for, so that
+	// spawned workers pinging at around the same time don't each run it.
+	readinessHookReuse = 5 * time.Second
+)
+
+// readinessChecks returns the checks that must pass before a worker asks for a
+// job, as configured. They're shared by all the workers.
+func readinessChecks(l logger.Logger, cfg AgentStartConfig) ([]agent.ReadinessCheck, error) {
+	var checks []agent.ReadinessCheck
+
+	// Old checkouts are removed from the build path when it runs low on
+	// space.
+	if cfg.BuildPathMinFreeSpace != "" {
+		minFreeSpace, err := humanize.ParseBytes(cfg.BuildPathMinFreeSpace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid build-path-min-free-space %q: %w", cfg.BuildPathMinFreeSpace, err)
+		}
+		checks = append(checks, agent.DiskSpaceReadinessCheck(l, &builddir.Collector{
+			BuildPath:    cfg.BuildPath,
+			MinFreeSpace: minFreeSpace,
+			Logger:       l,
+		}))
+	}
+
+	if cfg.ReadinessMaxLoadAverage != "" {
+		maxLoad, err := strconv.ParseFloat(cfg.ReadinessMaxLoadAverage, 64)
+		if err != nil || maxLoad <= 0 {
+			return nil, fmt.Errorf("invalid readiness-max-load-average %q: it must be a positive number", cfg.ReadinessMaxLoadAverage)
+		}
+		if _, err := system.GetLoadAverage(); err != nil {
+			return nil, fmt.Errorf("readiness-max-load-average can't be used: %w", err)
+		}
+		checks = append(checks, agent.LoadAverageReadinessCheck(maxLoad))
+	}
+
+	if cfg.ReadinessMinAvailableMemory != "" {
+		minMemory, err := humanize.ParseBytes(cfg.ReadinessMinAvailableMemory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid readiness-min-available-memory %q: %w", cfg.ReadinessMinAvailableMemory, err)
+		}
+		if _, err := system.GetAvailableMemory(); err != nil {
+			return nil, fmt.Errorf("readiness-min-available-memory can't be used: %w", err)
+		}
+		checks = append(checks, agent.MemoryReadinessCheck(minMemory))
+	}
+
+	// The hooks path can be changed by reloading the config, so the hook is
+	// always checked for.
+	checks = append(checks, readinessHookCheck(l))
+
+	return checks, nil
+}
+
+// readinessHookCheck runs the readiness hook from the worker's current hooks
+// path, if there is one. The hook is looked for each time, so it can be added
+// (or the hooks path changed) without restarting the agent. If it exits with
+// an error, the last line it printed is the reason the host isn't ready.
+func readinessHookCheck(l logger.Logger) agent.ReadinessCheck {
+	var (
+		mu       sync.Mutex
+		lastPath string
+		lastRun  time.Time
+		lastErr  error
+	)
+
+	return agent.ReadinessCheck{
+		Name: "readiness-hook",
+		Check: func(ctx context.Context, conf agent.AgentConfiguration) error {
+			if conf.HooksPath == "" {
+				return nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if conf.HooksPath == lastPath && time.Since(lastRun) < readinessHookReuse {
+				return lastErr
+			}
+			lastErr = runReadinessHook(ctx, l, conf.HooksPath)
+			lastPath = conf.HooksPath
+			lastRun = time.Now()
+			return lastErr
+		},
+	}
+}
+
+// runReadinessHook runs the readiness hook, if there is one.
+func runReadinessHook(ctx context.Context, l logger.Logger, hooksPath string) error {
+	p, err := hook.Find(hooksPath, "readiness")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("finding the readiness hook: %w", err)
+	}
+
+	sh, err := shell.New()
+	if err != nil {
+		return fmt.Errorf("creating shell for the readiness hook: %w", err)
+	}
+	var out bytes.Buffer
+	sh.Logger = shell.DiscardLogger
+	sh.Writer = &out
+
+	ctx, cancel := context.WithTimeout(ctx, readinessHookTimeout)
+	defer cancel()
+
+	err = sh.RunScript(ctx, p, nil)
+	if out.Len() > 0 {
+		l.Debug("Readiness hook output:\n%s", out.String())
+	}
+	if err == nil {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("the readiness hook didn't finish within %v", readinessHookTimeout)
+	}
+
+	// The last thing the hook printed is most likely why it failed
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return errors.New(last)
+	}
+	return fmt.Errorf("the readiness hook failed: %w", err)
+}
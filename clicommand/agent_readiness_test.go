@@ -0,0 +1,96 @@
+package clicommand
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessChecks(t *testing.T) {
+	t.Parallel()
+
+	l := logger.Discard
+	checkNames := func(cfg AgentStartConfig) []string {
+		t.Helper()
+		checks, err := readinessChecks(l, cfg)
+		if err != nil {
+			t.Fatalf("readinessChecks(%+v) error = %v", cfg, err)
+		}
+		names := make([]string, 0, len(checks))
+		for _, check := range checks {
+			names = append(names, check.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"readiness-hook"}, checkNames(AgentStartConfig{BuildPath: "/builds"}))
+	assert.Equal(t, []string{"disk-space", "readiness-hook"}, checkNames(AgentStartConfig{
+		BuildPath:             "/builds",
+		BuildPathMinFreeSpace: "10GiB",
+		HooksPath:             "/hooks",
+	}))
+
+	for _, cfg := range []AgentStartConfig{
+		{BuildPathMinFreeSpace: "lots"},
+		{ReadinessMaxLoadAverage: "high"},
+		{ReadinessMaxLoadAverage: "-1"},
+		{ReadinessMinAvailableMemory: "2 llamas"},
+	} {
+		if _, err := readinessChecks(l, cfg); err == nil {
+			t.Errorf("readinessChecks(%+v) error = nil, want an error", cfg)
+		}
+	}
+}
+
+func TestReadinessHookCheck(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is a shell script")
+	}
+
+	ctx := context.Background()
+	hooksPath := t.TempDir()
+	conf := agent.AgentConfiguration{HooksPath: hooksPath}
+	check := readinessHookCheck(logger.Discard)
+
+	// Without a hooks path, there's nothing to run
+	if err := check.Check(ctx, agent.AgentConfiguration{}); err != nil {
+		t.Errorf("check.Check(ctx, no hooks path) error = %v, want nil", err)
+	}
+
+	// There's no hook yet
+	if err := check.Check(ctx, conf); err != nil {
+		t.Errorf("check.Check(ctx, conf) error = %v, want nil", err)
+	}
+
+	hookPath := filepath.Join(hooksPath, "readiness")
+	hook := "#!/bin/sh\necho 'Checking the scratch disk...'\necho 'The scratch disk is read-only' >&2\nexit 1\n"
+	if err := os.WriteFile(hookPath, []byte(hook), 0o755); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", hookPath, err)
+	}
+
+	// The result of the first run is reused for a moment
+	if err := check.Check(ctx, conf); err != nil {
+		t.Errorf("check.Check(ctx, conf) error = %v, want nil", err)
+	}
+
+	// ...unless the hooks path changes, say when the config is reloaded
+	otherPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(otherPath, "readiness"), []byte(hook), 0o755); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", filepath.Join(otherPath, "readiness"), err)
+	}
+	if err := check.Check(ctx, agent.AgentConfiguration{HooksPath: otherPath}); err == nil {
+		t.Errorf("check.Check(ctx, other hooks path) error = nil, want the hook's error")
+	}
+
+	if err := runReadinessHook(ctx, logger.Discard, hooksPath); err == nil || err.Error() != "The scratch disk is read-only" {
+		t.Errorf("runReadinessHook(ctx, l, %q) error = %v, want %q", hooksPath, err, "The scratch disk is read-only")
+	}
+}
@@ -0,0 +1,162 @@
+package clicommand
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/cliconfig"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/oleiade/reflections"
+	"github.com/urfave/cli"
+)
+
+// reloadableConfigOptions are the agent start options that can be changed
+// without restarting the agent, by sending it SIGHUP.
+var reloadableConfigOptions = []string{
+	"redacted-vars",
+	"allowed-repositories",
+	"allowed-plugins",
+	"allowed-environment-variables",
+	"enable-environment-variable-allowlist",
+	"hooks-path",
+	"disable-warnings-for",
+	"log-level",
+	"tags",
+	"queue",
+}
+
+// agentPoolConfigurer is the part of agent.AgentPool that reloading the
+// config changes.
+type agentPoolConfigurer interface {
+	SetConfiguration(agent.AgentConfiguration)
+	SetTags([]string)
+}
+
+// configReloader reloads the config of a running agent, applying the options
+// that can be changed without restarting it, and reporting the ones that
+// can't.
+type configReloader struct {
+	c    *cli.Context
+	l    logger.Logger
+	pool agentPoolConfigurer
+
+	// The config as it was loaded, before agent start made any changes to
+	// it, and the agent configuration and tags made from it
+	cfg       AgentStartConfig
+	agentConf agent.AgentConfiguration
+	tags      []string
+}
+
+// reload loads the config again, from the same flags, environment variables
+// and config files as when the agent started. Only the config files can have
+// changed, since flags and environment variables are fixed when the agent
+// starts. If the new config is invalid, nothing is changed.
+func (r *configReloader) reload(ctx context.Context) error {
+	r.l.Info("Reloading configuration...")
+
+	var cfg AgentStartConfig
+	loader := cliconfig.Loader{
+		CLI:                    r.c,
+		Config:                 &cfg,
+		DefaultConfigFilePaths: defaultConfigFilePaths(),
+	}
+	warnings, err := loader.Load()
+	if err != nil {
+		return fmt.Errorf("loading configuration: %w", err)
+	}
+	for _, warning := range warnings {
+		r.l.Warn("%s", warning)
+	}
+
+	reloaded, ignored := changedConfigOptions(r.cfg, cfg)
+	for _, name := range ignored {
+		r.l.Warn("The %s option has changed, but it can't be reloaded. Restart the agent to apply it", name)
+	}
+	if len(reloaded) == 0 {
+		r.l.Info("No configuration options that can be reloaded have changed")
+		return nil
+	}
+
+	// Check everything before changing anything, so an invalid config
+	// doesn't get half applied
+	agentConf := r.agentConf
+	if err := applyReloadableConfig(&agentConf, cfg); err != nil {
+		return err
+	}
+	level, err := logger.LevelFromString(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	tags := r.tags
+	if slices.Contains(reloaded, "tags") || slices.Contains(reloaded, "queue") {
+		tags, err = fetchAgentTags(ctx, r.l, cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !cfg.Debug {
+		r.l.SetLevel(level)
+	}
+	r.pool.SetConfiguration(agentConf)
+	if !slices.Equal(tags, r.tags) {
+		r.l.Info("Agent tags have changed to [%s]. Agents will re-register with them once they're idle", strings.Join(tags, ", "))
+		r.pool.SetTags(tags)
+	}
+
+	// Keep the old values of the options that weren't applied, so they're
+	// reported again the next time the config is reloaded
+	for _, name := range ignored {
+		field := configFieldByCLIName(r.cfg, name)
+		value, _ := reflections.GetField(r.cfg, field)
+		if err := reflections.SetField(&cfg, field, value); err != nil {
+			return err
+		}
+	}
+
+	r.cfg, r.agentConf, r.tags = cfg, agentConf, tags
+	r.l.Info("Reloaded configuration options: %s", strings.Join(reloaded, ", "))
+	return nil
+}
+
+// changedConfigOptions returns the names of the options that are different
+// between two configs, split into those that can be reloaded and those that
+// can't.
+func changedConfigOptions(old, new AgentStartConfig) (reloaded, ignored []string) {
+	fields, _ := reflections.Fields(old)
+	for _, field := range fields {
+		cliName, _ := reflections.GetFieldTag(old, field, "cli")
+		if cliName == "" {
+			continue
+		}
+
+		oldValue, _ := reflections.GetField(old, field)
+		newValue, _ := reflections.GetField(new, field)
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+
+		if slices.Contains(reloadableConfigOptions, cliName) {
+			reloaded = append(reloaded, cliName)
+		} else {
+			ignored = append(ignored, cliName)
+		}
+	}
+	return reloaded, ignored
+}
+
+// configFieldByCLIName returns the name of the field in cfg for the option
+// with the given name.
+func configFieldByCLIName(cfg AgentStartConfig, cliName string) string {
+	fields, _ := reflections.Fields(cfg)
+	for _, field := range fields {
+		if tag, _ := reflections.GetFieldTag(cfg, field, "cli"); tag == cliName {
+			return field
+		}
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package clicommand
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+// fakeAgentPool records the configuration and tags it's given.
+type fakeAgentPool struct {
+	conf *agent.AgentConfiguration
+	tags []string
+}
+
+func (f *fakeAgentPool) SetConfiguration(conf agent.AgentConfiguration) { f.conf = &conf }
+func (f *fakeAgentPool) SetTags(tags []string)                          { f.tags = tags }
+
+func TestConfigReloader(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "buildkite-agent.yml")
+	writeConfig := func(content string) {
+		t.Helper()
+		content = "token: llamas\nbuild-path: " + dir + "\n" + content
+		if err := os.WriteFile(config, []byte(content), 0o644); err != nil {
+			t.Fatalf("os.WriteFile(%q) error = %v", config, err)
+		}
+	}
+	writeConfig("name: agent-1\nhooks-path: /etc/hooks\ntags: [queue=default]\n")
+
+	l := logger.NewBuffer()
+	pool := &fakeAgentPool{}
+
+	var reloads []error
+	app := cli.NewApp()
+	app.Name = "buildkite-agent"
+	app.Commands = []cli.Command{{
+		Name:  "start",
+		Flags: AgentStartCommand.Flags,
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			_, cfg, _, _, done := setupLoggerAndConfig[AgentStartConfig](ctx, c)
+			defer done()
+
+			var agentConf agent.AgentConfiguration
+			if err := applyReloadableConfig(&agentConf, cfg); err != nil {
+				t.Fatalf("applyReloadableConfig() error = %v", err)
+			}
+			tags, err := fetchAgentTags(ctx, l, cfg)
+			if err != nil {
+				t.Fatalf("fetchAgentTags() error = %v", err)
+			}
+			r := &configReloader{c: c, l: l, pool: pool, cfg: cfg, agentConf: agentConf, tags: tags}
+
+			writeConfig("name: agent-2\nhooks-path: /opt/hooks\nallowed-plugins: [docker]\ntags: [queue=deploy]\n")
+			reloads = append(reloads, r.reload(ctx))
+
+			// Only the options that can't be reloaded are still different
+			l.Messages = nil
+			reloads = append(reloads, r.reload(ctx))
+
+			writeConfig("name: agent-2\nhooks-path: /opt/hooks\nallowed-plugins: ['(']\ntags: [queue=deploy]\n")
+			reloads = append(reloads, r.reload(ctx))
+			return nil
+		},
+	}}
+	if err := app.Run([]string{"buildkite-agent", "start", "--config", config}); err != nil {
+		t.Fatalf("app.Run() error = %v", err)
+	}
+
+	if assert.Len(t, reloads, 3) {
+		assert.NoError(t, reloads[0])
+		assert.NoError(t, reloads[1])
+		assert.ErrorContains(t, reloads[2], "allowed-plugins failed to compile")
+	}
+
+	if assert.NotNil(t, pool.conf) {
+		assert.Equal(t, "/opt/hooks", pool.conf.HooksPath)
+		if assert.Len(t, pool.conf.AllowedPlugins, 1) {
+			assert.Equal(t, "docker", pool.conf.AllowedPlugins[0].String())
+		}
+	}
+	assert.Equal(t, []string{"queue=deploy"}, pool.tags)
+
+	assert.True(t, slices.Contains(l.Messages, "[warn] The name option has changed, but it can't be reloaded. Restart the agent to apply it"), "messages: %q", l.Messages)
+	assert.True(t, slices.Contains(l.Messages, "[info] No configuration options that can be reloaded have changed"), "messages: %q", l.Messages)
+}
@@ -21,10 +21,13 @@ import (
 	"github.com/buildkite/agent/v3/agent"
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/internal/agentapi"
+	"github.com/buildkite/agent/v3/internal/clusterlock"
 	"github.com/buildkite/agent/v3/internal/experiments"
 	"github.com/buildkite/agent/v3/internal/job/hook"
 	"github.com/buildkite/agent/v3/internal/job/shell"
+	"github.com/buildkite/agent/v3/internal/secrets"
 	"github.com/buildkite/agent/v3/internal/utils"
+	"github.com/buildkite/agent/v3/kubernetes"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/metrics"
 	"github.com/buildkite/agent/v3/process"
@@ -51,6 +54,24 @@ actual build script defined in the pipeline.
 
 The agent will run any jobs within a PTY (pseudo terminal) if available.
 
+Before asking for a job, the agent runs its readiness checks: the options
+build-path-min-free-space, readiness-max-load-average and
+readiness-min-available-memory, and the "readiness" hook in the hooks path, if
+there is one. While a check fails (or the hook exits non-zero), the agent stays
+connected with heartbeats, but doesn't ask for jobs, and its /agent/N health
+check (see health-check-addr) reports why. It still pings Buildkite once a
+minute, so it can be told to disconnect, but a job Buildkite assigns it
+then is run anyway, since it can't be given back. While it isn't accepting
+jobs, the agent counts as idle for disconnect-after-idle-timeout.
+
+Sending the agent SIGHUP reloads its config files, without stopping running
+jobs. These options take effect for the jobs that start afterwards:
+redacted-vars, allowed-repositories, allowed-plugins,
+allowed-environment-variables, enable-environment-variable-allowlist,
+hooks-path, disable-warnings-for and log-level. If the tags (or queue) change,
+each agent re-registers with the new tags once it's idle. Changes to any other
+options are reported, and need the agent to be restarted.
+
 Example:
 
     $ buildkite-agent start --token xxx`
@@ -82,6 +103,9 @@ type AgentStartConfig struct {
 	RedactedVars      []string `cli:"redacted-vars" normalize:"list"`
 	CancelSignal      string   `cli:"cancel-signal"`
 
+	SecretsProviders []string `cli:"secrets-providers" normalize:"list"`
+	SecretsEnv       []string `cli:"secrets-env" normalize:"list"`
+
 	SigningJWKSFile  string `cli:"signing-jwks-file" normalize:"filepath"`
 	SigningJWKSKeyID string `cli:"signing-jwks-key-id"`
 	DebugSigning     bool   `cli:"debug-signing"`
@@ -102,10 +126,20 @@ type AgentStartConfig struct {
 	WriteJobLogsToStdout bool     `cli:"write-job-logs-to-stdout"`
 	DisableWarningsFor   []string `cli:"disable-warnings-for" normalize:"list"`
 
-	BuildPath   string `cli:"build-path" normalize:"filepath" validate:"required"`
-	HooksPath   string `cli:"hooks-path" normalize:"filepath"`
-	SocketsPath string `cli:"sockets-path" normalize:"filepath"`
-	PluginsPath string `cli:"plugins-path" normalize:"filepath"`
+	BuildPath             string `cli:"build-path" normalize:"filepath" validate:"required"`
+	BuildPathMinFreeSpace string `cli:"build-path-min-free-space"`
+	HooksPath             string `cli:"hooks-path" normalize:"filepath"`
+	SocketsPath           string `cli:"sockets-path" normalize:"filepath"`
+	PluginsPath           string `cli:"plugins-path" normalize:"filepath"`
+
+	ReadinessMaxLoadAverage     string `cli:"readiness-max-load-average"`
+	ReadinessMinAvailableMemory string `cli:"readiness-min-available-memory"`
+
+	JobAPIListen string `cli:"job-api-listen"`
+
+	AgentAPIPersistState bool   `cli:"agent-api-persist-state"`
+	LockClusterBackend   string `cli:"lock-cluster-backend"`
+	AgentAPIToken        string `cli:"agent-api-token"`
 
 	Shell           string `cli:"shell"`
 	BootstrapScript string `cli:"bootstrap-script" normalize:"commandpath"`
@@ -156,8 +190,11 @@ type AgentStartConfig struct {
 	MetricsDatadog              bool   `cli:"metrics-datadog"`
 	MetricsDatadogHost          string `cli:"metrics-datadog-host"`
 	MetricsDatadogDistributions bool   `cli:"metrics-datadog-distributions"`
+	MetricsOTLP                 bool   `cli:"metrics-otlp"`
 	TracingBackend              string `cli:"tracing-backend"`
 	TracingServiceName          string `cli:"tracing-service-name"`
+	TracingFileFormat           string `cli:"tracing-file-format"`
+	TracingMetaDataContext      bool   `cli:"tracing-meta-data-context"`
 
 	// Global flags
 	Debug             bool     `cli:"debug"`
@@ -168,6 +205,11 @@ type AgentStartConfig struct {
 	StrictSingleHooks bool     `cli:"strict-single-hooks"`
 	KubernetesExec    bool     `cli:"kubernetes-exec"`
 
+	// Kubernetes config
+	KubernetesHeartbeatTimeout   time.Duration `cli:"kubernetes-heartbeat-timeout"`
+	KubernetesExitAggregation    string        `cli:"kubernetes-exit-aggregation"`
+	KubernetesPrimaryContainerID int           `cli:"kubernetes-primary-container-id"`
+
 	// API config
 	DebugHTTP bool   `cli:"debug-http"`
 	Token     string `cli:"token" validate:"required"`
@@ -208,6 +250,10 @@ func (asc AgentStartConfig) Features(ctx context.Context) []string {
 		features = append(features, "opentelemetry-tracing")
 	}
 
+	if asc.TracingBackend == tracetools.BackendFile {
+		features = append(features, "file-tracing")
+	}
+
 	if asc.DisconnectAfterJob {
 		features = append(features, "disconnect-after-job")
 	}
@@ -285,7 +331,14 @@ func defaultConfigFilePaths() (paths []string) {
 		}
 	}
 
-	return paths
+	// A YAML config file can be used in any of the same places, but the
+	// key=value file takes precedence.
+	withYAML := make([]string, 0, 2*len(paths))
+	for _, path := range paths {
+		withYAML = append(withYAML, path, strings.TrimSuffix(path, ".cfg")+".yml")
+	}
+
+	return withYAML
 }
 
 var AgentStartCommand = cli.Command{
@@ -296,7 +349,7 @@ var AgentStartCommand = cli.Command{
 		cli.StringFlag{
 			Name:   "config",
 			Value:  "",
-			Usage:  "Path to a configuration file",
+			Usage:  "Path to a configuration file, either in the key=value format, or YAML if it ends in .yml or .yaml. YAML files in a conf.d directory next to the configuration file are also loaded",
 			EnvVar: "BUILDKITE_AGENT_CONFIG",
 		},
 		cli.StringFlag{
@@ -492,6 +545,24 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Path to where the builds will run from",
 			EnvVar: "BUILDKITE_BUILD_PATH",
 		},
+		cli.StringFlag{
+			Name:   "build-path-min-free-space",
+			Value:  "",
+			Usage:  "The disk space to keep free in the build path, such as \"10GiB\". When there's less free, the least recently used checkouts are removed before asking for a job, and if that doesn't free enough, the agent doesn't accept jobs until there is. Disabled if empty",
+			EnvVar: "BUILDKITE_BUILD_PATH_MIN_FREE_SPACE",
+		},
+		cli.StringFlag{
+			Name:   "readiness-max-load-average",
+			Value:  "",
+			Usage:  "Don't accept jobs while the host's load average over the last minute is above this, such as \"8\". Not supported on Windows. Disabled if empty",
+			EnvVar: "BUILDKITE_READINESS_MAX_LOAD_AVERAGE",
+		},
+		cli.StringFlag{
+			Name:   "readiness-min-available-memory",
+			Value:  "",
+			Usage:  "Don't accept jobs while the host has less memory than this available, such as \"2GiB\". Only supported on Linux and Windows. Disabled if empty",
+			EnvVar: "BUILDKITE_READINESS_MIN_AVAILABLE_MEMORY",
+		},
 		cli.StringFlag{
 			Name:   "hooks-path",
 			Value:  "",
@@ -504,6 +575,29 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Directory where the agent will place sockets",
 			EnvVar: "BUILDKITE_SOCKETS_PATH",
 		},
+		cli.StringFlag{
+			Name:   "job-api-listen",
+			Value:  "",
+			Usage:  "A TCP address (such as 127.0.0.1:0, or the address of a container network bridge) on which each job's Job API also listens, for commands that can't reach its socket",
+			EnvVar: "BUILDKITE_AGENT_JOB_API_LISTEN",
+		},
+		cli.BoolFlag{
+			Name:   "agent-api-persist-state",
+			Usage:  "Persist Agent API lock state in a file in the sockets path, so that locks (including ′lock do′/′lock done′) survive agent restarts. Requires the ′agent-api′ experiment",
+			EnvVar: "BUILDKITE_AGENT_API_PERSIST_STATE",
+		},
+		cli.StringFlag{
+			Name:   "lock-cluster-backend",
+			Value:  "",
+			Usage:  "Where to store ′--lock-scope cluster′ locks, shared with agents on other hosts: a directory on a shared filesystem (file:///path) or a Redis server (redis://[[user]:password@]host[:port][/db], or rediss:// for TLS). Requires the ′agent-api′ experiment",
+			EnvVar: "BUILDKITE_LOCK_CLUSTER_BACKEND",
+		},
+		cli.StringFlag{
+			Name:   "agent-api-token",
+			Value:  "",
+			Usage:  "If set, Agent API requests to inspect workers or cancel jobs (′agent workers′, ′agent cancel-job′) must provide this token. Requires the ′agent-api′ experiment",
+			EnvVar: "BUILDKITE_AGENT_API_TOKEN",
+		},
 		cli.StringFlag{
 			Name:   "plugins-path",
 			Value:  "",
@@ -604,6 +698,11 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Use Datadog Distributions for Timing metrics",
 			EnvVar: "BUILDKITE_METRICS_DATADOG_DISTRIBUTIONS",
 		},
+		cli.BoolFlag{
+			Name:   "metrics-otlp",
+			Usage:  "Export metrics with OTLP, configured with the standard OTEL_EXPORTER_OTLP_* environment variables",
+			EnvVar: "BUILDKITE_METRICS_OTLP",
+		},
 		cli.StringFlag{
 			Name:   "log-format",
 			Usage:  "The format to use for the logger output",
@@ -631,7 +730,7 @@ var AgentStartCommand = cli.Command{
 		signalGracePeriodSecondsFlag,
 		cli.StringFlag{
 			Name:   "tracing-backend",
-			Usage:  `Enable tracing for build jobs by specifying a backend, "datadog" or "opentelemetry"`,
+			Usage:  `Enable tracing for build jobs by specifying a backend, "datadog", "opentelemetry", or "file" (to upload a trace of each job as an artifact)`,
 			EnvVar: "BUILDKITE_TRACING_BACKEND",
 			Value:  "",
 		},
@@ -641,6 +740,17 @@ var AgentStartCommand = cli.Command{
 			EnvVar: "BUILDKITE_TRACING_SERVICE_NAME",
 			Value:  "buildkite-agent",
 		},
+		cli.StringFlag{
+			Name:   "tracing-file-format",
+			Usage:  `The format of the trace file uploaded by the "file" tracing backend, "chrome" (which can be opened in Perfetto) or "otlp-json"`,
+			EnvVar: "BUILDKITE_TRACING_FILE_FORMAT",
+			Value:  tracetools.FileFormatChrome,
+		},
+		cli.BoolFlag{
+			Name:   "tracing-meta-data-context",
+			Usage:  "With the ′opentelemetry′ tracing backend, continue the trace in the ′traceparent′ and ′tracestate′ build meta-data when the job env doesn't have one. The meta-data is read before each job is accepted, so this needs an agent token that can read it",
+			EnvVar: "BUILDKITE_TRACING_META_DATA_CONTEXT",
+		},
 		cli.StringFlag{
 			Name:   "verification-jwks-file",
 			Usage:  "Path to a file containing a JSON Web Key Set (JWKS), used to verify job signatures. ",
@@ -686,8 +796,33 @@ var AgentStartCommand = cli.Command{
 		ExperimentsFlag,
 		ProfileFlag,
 		RedactedVars,
+		SecretsProvidersFlag,
+		cli.StringSliceFlag{
+			Name:   "secrets-env",
+			Value:  &cli.StringSlice{},
+			Usage:  "Environment variables to set from secrets before the job's hooks run, as a list of ′NAME=KEY′. These are added to any set by the pipeline in ′BUILDKITE_SECRETS_ENV′, and take precedence over them",
+			EnvVar: "BUILDKITE_AGENT_SECRETS_ENV",
+		},
 		StrictSingleHooksFlag,
 		KubernetesExecFlag,
+		cli.DurationFlag{
+			Name:   "kubernetes-heartbeat-timeout",
+			Usage:  "How long a container can go without sending a heartbeat before it is considered lost, when using ′kubernetes-exec′. 0 disables heartbeats",
+			EnvVar: "BUILDKITE_KUBERNETES_HEARTBEAT_TIMEOUT",
+			Value:  time.Minute,
+		},
+		cli.StringFlag{
+			Name:   "kubernetes-exit-aggregation",
+			Value:  string(kubernetes.ExitAggregationFirstFailure),
+			Usage:  fmt.Sprintf("How the exit statuses of containers are combined into the job's exit status, when using ′kubernetes-exec′. One of: %v", kubernetes.ExitAggregations),
+			EnvVar: "BUILDKITE_KUBERNETES_EXIT_AGGREGATION",
+		},
+		cli.IntFlag{
+			Name:   "kubernetes-primary-container-id",
+			Value:  -1,
+			Usage:  "The ID of the container that runs the job's command, when using ′kubernetes-exec′. The others are sidecars. Defaults to the container with the highest ID",
+			EnvVar: "BUILDKITE_KUBERNETES_PRIMARY_CONTAINER_ID",
+		},
 
 		// Deprecated flags which will be removed in v4
 		cli.StringSliceFlag{
@@ -740,6 +875,10 @@ var AgentStartCommand = cli.Command{
 		))
 		defer done()
 
+		// Keep the config as it was loaded, before any changes are made to it
+		// below, for comparing with when it's reloaded
+		loadedCfg := cfg
+
 		// Remove any config env from the environment to prevent them propagating to bootstrap
 		if err := UnsetConfigFromEnvironment(c); err != nil {
 			return fmt.Errorf("failed to unset config from environment: %w", err)
@@ -755,6 +894,19 @@ var AgentStartCommand = cli.Command{
 			}
 		}
 
+		if cfg.KubernetesExec {
+			if !slices.Contains(kubernetes.ExitAggregations, kubernetes.ExitAggregation(cfg.KubernetesExitAggregation)) {
+				return fmt.Errorf(
+					"invalid kubernetes exit aggregation %q. Must be one of: %v",
+					cfg.KubernetesExitAggregation,
+					kubernetes.ExitAggregations,
+				)
+			}
+			if t := cfg.KubernetesHeartbeatTimeout; t < 0 || (t > 0 && t < kubernetes.MinHeartbeatTimeout) {
+				return fmt.Errorf("invalid kubernetes-heartbeat-timeout %v. Must be 0, or at least %v", t, kubernetes.MinHeartbeatTimeout)
+			}
+		}
+
 		// Force some settings if on Windows (these aren't supported yet)
 		if runtime.GOOS == "windows" {
 			cfg.NoPTY = true
@@ -807,42 +959,6 @@ var AgentStartCommand = cli.Command{
 			cfg.DisconnectAfterIdleTimeout = cfg.DisconnectAfterJobTimeout
 		}
 
-		var ec2TagTimeout time.Duration
-		if t := cfg.WaitForEC2TagsTimeout; t != "" {
-			var err error
-			ec2TagTimeout, err = time.ParseDuration(t)
-			if err != nil {
-				return fmt.Errorf("failed to parse ec2 tag timeout: %w", err)
-			}
-		}
-
-		var ec2MetaDataTimeout time.Duration
-		if t := cfg.WaitForEC2MetaDataTimeout; t != "" {
-			var err error
-			ec2MetaDataTimeout, err = time.ParseDuration(t)
-			if err != nil {
-				return fmt.Errorf("failed to parse ec2 meta-data timeout: %w", err)
-			}
-		}
-
-		var ecsMetaDataTimeout time.Duration
-		if t := cfg.WaitForECSMetaDataTimeout; t != "" {
-			var err error
-			ecsMetaDataTimeout, err = time.ParseDuration(t)
-			if err != nil {
-				return fmt.Errorf("failed to parse ecs meta-data timeout: %w", err)
-			}
-		}
-
-		var gcpLabelsTimeout time.Duration
-		if t := cfg.WaitForGCPLabelsTimeout; t != "" {
-			var err error
-			gcpLabelsTimeout, err = time.ParseDuration(t)
-			if err != nil {
-				return fmt.Errorf("failed to parse gcp labels timeout: %w", err)
-			}
-		}
-
 		// Treat a negative signal grace period as relative to the cancel grace period
 		if cfg.SignalGracePeriodSeconds < 0 {
 			if cfg.CancelGracePeriod < -cfg.SignalGracePeriodSeconds {
@@ -869,6 +985,9 @@ var AgentStartCommand = cli.Command{
 			Datadog:              cfg.MetricsDatadog,
 			DatadogHost:          cfg.MetricsDatadogHost,
 			DatadogDistributions: cfg.MetricsDatadogDistributions,
+
+			OTLP:                   cfg.MetricsOTLP,
+			OTLPResourceAttributes: tagsToMap(cfg.Tags),
 		})
 
 		// Sense check supported tracing backends, we don't want bootstrapped jobs to silently have no tracing
@@ -879,13 +998,24 @@ var AgentStartCommand = cli.Command{
 				maps.Keys(tracetools.ValidTracingBackends),
 			)
 		}
+		if !slices.Contains(tracetools.FileFormats, cfg.TracingFileFormat) {
+			return fmt.Errorf("invalid tracing file format %q. Must be one of: %v", cfg.TracingFileFormat, tracetools.FileFormats)
+		}
+		stopTracing := startAgentTracing(ctx, l, cfg.TracingBackend, cfg.TracingServiceName)
+		defer stopTracing()
 
+		var agentAPI *agentapi.Server
 		if experiments.IsEnabled(ctx, experiments.AgentAPI) {
-			shutdown, err := runAgentAPI(ctx, l, cfg.SocketsPath)
+			svr, shutdown, err := runAgentAPI(ctx, l, cfg.SocketsPath, agentAPIOptions{
+				persistState:   cfg.AgentAPIPersistState,
+				clusterBackend: cfg.LockClusterBackend,
+				token:          cfg.AgentAPIToken,
+			})
 			if err != nil {
 				return err
 			}
 			defer shutdown()
+			agentAPI = svr
 		}
 
 		var verificationJWKS jwk.Set
@@ -905,22 +1035,13 @@ var AgentStartCommand = cli.Command{
 			}
 		}
 
-		if len(cfg.AllowedEnvironmentVariables) > 0 && !cfg.EnableEnvironmentVariableAllowList {
-			l.Fatal("allowed-environment-variables is set, but enable-environment-variable-allowlist is not set")
+		// The providers aren't used until `buildkite-agent secret get` is
+		// called, but validate them here anyway
+		if _, err := secrets.ParseRouter(cfg.SecretsProviders, &secrets.BuildkiteProvider{}, os.Getenv); err != nil {
+			l.Fatal("Secrets providers failed validation: %v", err)
 		}
-
-		var allowedEnvironmentVariables []*regexp.Regexp
-		if cfg.EnableEnvironmentVariableAllowList {
-			allowedEnvironmentVariables = append(allowedEnvironmentVariables, buildkiteSetEnvironmentVariables...)
-
-			for _, v := range cfg.AllowedEnvironmentVariables {
-				re, err := regexp.Compile(v)
-				if err != nil {
-					l.Fatal("Regex %s in allowed-environment-variables failed to compile: %v", v, err)
-				}
-
-				allowedEnvironmentVariables = append(allowedEnvironmentVariables, re)
-			}
+		if _, err := secrets.ParseEnv(cfg.SecretsEnv); err != nil {
+			l.Fatal("Secrets env failed validation: %v", err)
 		}
 
 		// AgentConfiguration is the runtime configuration for an agent
@@ -928,10 +1049,10 @@ var AgentStartCommand = cli.Command{
 			BootstrapScript:              cfg.BootstrapScript,
 			BuildPath:                    cfg.BuildPath,
 			SocketsPath:                  cfg.SocketsPath,
+			JobAPIListen:                 cfg.JobAPIListen,
 			GitMirrorsPath:               cfg.GitMirrorsPath,
 			GitMirrorsLockTimeout:        cfg.GitMirrorsLockTimeout,
 			GitMirrorsSkipUpdate:         cfg.GitMirrorsSkipUpdate,
-			HooksPath:                    cfg.HooksPath,
 			PluginsPath:                  cfg.PluginsPath,
 			GitCheckoutFlags:             cfg.GitCheckoutFlags,
 			GitCloneFlags:                cfg.GitCloneFlags,
@@ -944,7 +1065,6 @@ var AgentStartCommand = cli.Command{
 			PluginsEnabled:               !cfg.NoPlugins,
 			PluginValidation:             !cfg.NoPluginValidation,
 			LocalHooksEnabled:            !cfg.NoLocalHooks,
-			AllowedEnvironmentVariables:  allowedEnvironmentVariables,
 			StrictSingleHooks:            cfg.StrictSingleHooks,
 			RunInPty:                     !cfg.NoPTY,
 			ANSITimestamps:               !cfg.NoANSITimestamps,
@@ -958,20 +1078,29 @@ var AgentStartCommand = cli.Command{
 			WriteJobLogsToStdout:         cfg.WriteJobLogsToStdout,
 			LogFormat:                    cfg.LogFormat,
 			Shell:                        cfg.Shell,
-			RedactedVars:                 cfg.RedactedVars,
+			SecretsProviders:             cfg.SecretsProviders,
+			SecretsEnv:                   cfg.SecretsEnv,
 			AcquireJob:                   cfg.AcquireJob,
 			TracingBackend:               cfg.TracingBackend,
 			TracingServiceName:           cfg.TracingServiceName,
+			TracingFileFormat:            cfg.TracingFileFormat,
+			TracingMetaDataContext:       cfg.TracingMetaDataContext,
 			VerificationFailureBehaviour: cfg.VerificationFailureBehavior,
 			KubernetesExec:               cfg.KubernetesExec,
+			KubernetesHeartbeatTimeout:   cfg.KubernetesHeartbeatTimeout,
+			KubernetesExitAggregation:    kubernetes.ExitAggregation(cfg.KubernetesExitAggregation),
+			KubernetesPrimaryContainerID: cfg.KubernetesPrimaryContainerID,
 
 			SigningJWKSFile:  cfg.SigningJWKSFile,
 			SigningJWKSKeyID: cfg.SigningJWKSKeyID,
 			DebugSigning:     cfg.DebugSigning,
 
 			VerificationJWKS: verificationJWKS,
+		}
 
-			DisableWarningsFor: cfg.DisableWarningsFor,
+		// The options that can be changed by reloading the config
+		if err := applyReloadableConfig(&agentConf, cfg); err != nil {
+			return err
 		}
 
 		if configFile != nil {
@@ -1041,27 +1170,11 @@ var AgentStartCommand = cli.Command{
 			l.Info("Agents will disconnect after %d seconds of inactivity", agentConf.DisconnectAfterIdleTimeout)
 		}
 
-		if len(cfg.AllowedRepositories) > 0 {
-			agentConf.AllowedRepositories = make([]*regexp.Regexp, 0, len(cfg.AllowedRepositories))
-			for _, v := range cfg.AllowedRepositories {
-				r, err := regexp.Compile(v)
-				if err != nil {
-					l.Fatal("Regex %s is allowed-repositories failed to compile: %v", v, err)
-				}
-				agentConf.AllowedRepositories = append(agentConf.AllowedRepositories, r)
-			}
+		if len(agentConf.AllowedRepositories) > 0 {
 			l.Info("Allowed repositories patterns: %q", agentConf.AllowedRepositories)
 		}
 
-		if len(cfg.AllowedPlugins) > 0 {
-			agentConf.AllowedPlugins = make([]*regexp.Regexp, 0, len(cfg.AllowedPlugins))
-			for _, v := range cfg.AllowedPlugins {
-				r, err := regexp.Compile(v)
-				if err != nil {
-					l.Fatal("Regex %s in allowed-plugins failed to compile: %v", v, err)
-				}
-				agentConf.AllowedPlugins = append(agentConf.AllowedPlugins, r)
-			}
+		if len(agentConf.AllowedPlugins) > 0 {
 			l.Info("Allowed plugins patterns: %q", agentConf.AllowedPlugins)
 		}
 
@@ -1070,32 +1183,9 @@ var AgentStartCommand = cli.Command{
 			return fmt.Errorf("failed to parse cancel-signal: %w", err)
 		}
 
-		tags := agent.FetchTags(ctx, l, agent.FetchTagsConfig{
-			Tags:                      cfg.Tags,
-			TagsFromK8s:               cfg.KubernetesExec,
-			TagsFromEC2MetaData:       (cfg.TagsFromEC2MetaData || cfg.TagsFromEC2),
-			TagsFromEC2MetaDataPaths:  cfg.TagsFromEC2MetaDataPaths,
-			TagsFromEC2Tags:           cfg.TagsFromEC2Tags,
-			TagsFromECSMetaData:       cfg.TagsFromECSMetaData,
-			TagsFromGCPMetaData:       (cfg.TagsFromGCPMetaData || cfg.TagsFromGCP),
-			TagsFromGCPMetaDataPaths:  cfg.TagsFromGCPMetaDataPaths,
-			TagsFromGCPLabels:         cfg.TagsFromGCPLabels,
-			TagsFromHost:              cfg.TagsFromHost,
-			WaitForEC2TagsTimeout:     ec2TagTimeout,
-			WaitForEC2MetaDataTimeout: ec2MetaDataTimeout,
-			WaitForECSMetaDataTimeout: ecsMetaDataTimeout,
-			WaitForGCPLabelsTimeout:   gcpLabelsTimeout,
-		})
-
-		// Munge the value from --queue (if it exists) into the tags slice
-		if cfg.Queue != "" {
-			i := slices.IndexFunc(tags, func(s string) bool {
-				return strings.HasPrefix(strings.TrimSpace(s), "queue=")
-			})
-			if i != -1 {
-				l.Fatal("Queue must be present in only one of the --tags or the --queue flags")
-			}
-			tags = append(tags, "queue="+cfg.Queue)
+		tags, err := fetchAgentTags(ctx, l, cfg)
+		if err != nil {
+			l.Fatal("%v", err)
 		}
 
 		// confirm the BuildPath is exists. The bootstrap is going to write to it when a job executes,
@@ -1108,6 +1198,12 @@ var AgentStartCommand = cli.Command{
 			}
 		}
 
+		// The checks that must pass before a worker asks for a job
+		checks, err := readinessChecks(l, cfg)
+		if err != nil {
+			return err
+		}
+
 		// Create the API client
 		client := api.NewClient(l, loadAPIClientConfig(cfg, "Token"))
 
@@ -1137,6 +1233,13 @@ var AgentStartCommand = cli.Command{
 			return errors.New("You can't spawn multiple agents and acquire a job at the same time")
 		}
 
+		// Start running our metrics collector. It's shared by all the
+		// workers, so it's started and stopped once for the whole agent.
+		if err := mc.Start(); err != nil {
+			return err
+		}
+		defer mc.Stop()
+
 		var workers []*agent.AgentWorker
 
 		for i := 1; i <= cfg.Spawn; i++ {
@@ -1174,18 +1277,23 @@ var AgentStartCommand = cli.Command{
 				client,
 				agent.AgentWorkerConfig{
 					AgentConfiguration: agentConf,
+					RegisterRequest:    registerReq,
 					CancelSignal:       cancelSig,
 					SignalGracePeriod:  signalGracePeriod,
 					Debug:              cfg.Debug,
 					DebugHTTP:          cfg.DebugHTTP,
 					SpawnIndex:         i,
 					AgentStdout:        os.Stdout,
+					ReadinessChecks:    checks,
 				},
 			))
 		}
 
 		// Setup the agent pool that spawns agent workers
 		pool := agent.NewAgentPool(workers)
+		if agentAPI != nil {
+			agentAPI.SetWorkerController(pool)
+		}
 
 		// Agent-wide shutdown hook. Once per agent, for all workers on the agent.
 		defer agentShutdownHook(l, cfg)
@@ -1195,8 +1303,16 @@ var AgentStartCommand = cli.Command{
 			return fmt.Errorf("failed to run startup hook: %w", err)
 		}
 
-		// Handle process signals
-		signals := handlePoolSignals(ctx, l, pool)
+		// Handle process signals, reloading the config on SIGHUP
+		reloader := &configReloader{
+			c:         c,
+			l:         l,
+			pool:      pool,
+			cfg:       loadedCfg,
+			agentConf: agentConf,
+			tags:      tags,
+		}
+		signals := handlePoolSignals(ctx, l, pool, reloader)
 		defer close(signals)
 
 		l.Info("Starting %d Agent(s)", cfg.Spawn)
@@ -1220,6 +1336,137 @@ var AgentStartCommand = cli.Command{
 	},
 }
 
+// applyReloadableConfig sets the options in conf that can be changed by
+// reloading the config from cfg.
+func applyReloadableConfig(conf *agent.AgentConfiguration, cfg AgentStartConfig) error {
+	if len(cfg.AllowedEnvironmentVariables) > 0 && !cfg.EnableEnvironmentVariableAllowList {
+		return errors.New("allowed-environment-variables is set, but enable-environment-variable-allowlist is not set")
+	}
+
+	var allowedEnvironmentVariables []*regexp.Regexp
+	if cfg.EnableEnvironmentVariableAllowList {
+		allowedEnvironmentVariables = append(allowedEnvironmentVariables, buildkiteSetEnvironmentVariables...)
+
+		for _, v := range cfg.AllowedEnvironmentVariables {
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return fmt.Errorf("Regex %s in allowed-environment-variables failed to compile: %w", v, err)
+			}
+
+			allowedEnvironmentVariables = append(allowedEnvironmentVariables, re)
+		}
+	}
+
+	var allowedRepositories []*regexp.Regexp
+	for _, v := range cfg.AllowedRepositories {
+		r, err := regexp.Compile(v)
+		if err != nil {
+			return fmt.Errorf("Regex %s in allowed-repositories failed to compile: %w", v, err)
+		}
+		allowedRepositories = append(allowedRepositories, r)
+	}
+
+	var allowedPlugins []*regexp.Regexp
+	for _, v := range cfg.AllowedPlugins {
+		r, err := regexp.Compile(v)
+		if err != nil {
+			return fmt.Errorf("Regex %s in allowed-plugins failed to compile: %w", v, err)
+		}
+		allowedPlugins = append(allowedPlugins, r)
+	}
+
+	conf.HooksPath = cfg.HooksPath
+	conf.RedactedVars = cfg.RedactedVars
+	conf.DisableWarningsFor = cfg.DisableWarningsFor
+	conf.AllowedEnvironmentVariables = allowedEnvironmentVariables
+	conf.AllowedRepositories = allowedRepositories
+	conf.AllowedPlugins = allowedPlugins
+	return nil
+}
+
+// fetchAgentTags returns the tags to register the agent with: those given in
+// cfg, those fetched from the sources it enables, and the queue.
+func fetchAgentTags(ctx context.Context, l logger.Logger, cfg AgentStartConfig) ([]string, error) {
+	var ec2TagTimeout time.Duration
+	if t := cfg.WaitForEC2TagsTimeout; t != "" {
+		var err error
+		ec2TagTimeout, err = time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ec2 tag timeout: %w", err)
+		}
+	}
+
+	var ec2MetaDataTimeout time.Duration
+	if t := cfg.WaitForEC2MetaDataTimeout; t != "" {
+		var err error
+		ec2MetaDataTimeout, err = time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ec2 meta-data timeout: %w", err)
+		}
+	}
+
+	var ecsMetaDataTimeout time.Duration
+	if t := cfg.WaitForECSMetaDataTimeout; t != "" {
+		var err error
+		ecsMetaDataTimeout, err = time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ecs meta-data timeout: %w", err)
+		}
+	}
+
+	var gcpLabelsTimeout time.Duration
+	if t := cfg.WaitForGCPLabelsTimeout; t != "" {
+		var err error
+		gcpLabelsTimeout, err = time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse gcp labels timeout: %w", err)
+		}
+	}
+
+	tags := agent.FetchTags(ctx, l, agent.FetchTagsConfig{
+		Tags:                      cfg.Tags,
+		TagsFromK8s:               cfg.KubernetesExec,
+		TagsFromEC2MetaData:       (cfg.TagsFromEC2MetaData || cfg.TagsFromEC2),
+		TagsFromEC2MetaDataPaths:  cfg.TagsFromEC2MetaDataPaths,
+		TagsFromEC2Tags:           cfg.TagsFromEC2Tags,
+		TagsFromECSMetaData:       cfg.TagsFromECSMetaData,
+		TagsFromGCPMetaData:       (cfg.TagsFromGCPMetaData || cfg.TagsFromGCP),
+		TagsFromGCPMetaDataPaths:  cfg.TagsFromGCPMetaDataPaths,
+		TagsFromGCPLabels:         cfg.TagsFromGCPLabels,
+		TagsFromHost:              cfg.TagsFromHost,
+		WaitForEC2TagsTimeout:     ec2TagTimeout,
+		WaitForEC2MetaDataTimeout: ec2MetaDataTimeout,
+		WaitForECSMetaDataTimeout: ecsMetaDataTimeout,
+		WaitForGCPLabelsTimeout:   gcpLabelsTimeout,
+	})
+
+	// Munge the value from --queue (if it exists) into the tags slice
+	if cfg.Queue != "" {
+		i := slices.IndexFunc(tags, func(s string) bool {
+			return strings.HasPrefix(strings.TrimSpace(s), "queue=")
+		})
+		if i != -1 {
+			return nil, errors.New("Queue must be present in only one of the --tags or the --queue flags")
+		}
+		tags = append(tags, "queue="+cfg.Queue)
+	}
+	return tags, nil
+}
+
+// tagsToMap converts agent tags in key=value form to a map. Tags that aren't in
+// that form are skipped.
+func tagsToMap(tags []string) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		k, v, ok := strings.Cut(tag, "=")
+		if !ok {
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m
+}
+
 func parseAndValidateJWKS(ctx context.Context, keysetType, path string) (jwk.Set, error) {
 	jwksBytes, err := os.ReadFile(path)
 	if err != nil {
@@ -1251,7 +1498,7 @@ func parseAndValidateJWKS(ctx context.Context, keysetType, path string) (jwk.Set
 	return jwks, nil
 }
 
-func handlePoolSignals(ctx context.Context, l logger.Logger, pool *agent.AgentPool) chan os.Signal {
+func handlePoolSignals(ctx context.Context, l logger.Logger, pool *agent.AgentPool, reloader *configReloader) chan os.Signal {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt,
 		syscall.SIGHUP,
@@ -1284,6 +1531,10 @@ func handlePoolSignals(ctx context.Context, l logger.Logger, pool *agent.AgentPo
 					l.Info("Forcefully stopping running jobs and stopping the agent(s)")
 					pool.Stop(false)
 				}
+			case syscall.SIGHUP:
+				if err := reloader.reload(ctx); err != nil {
+					l.Error("Couldn't reload the configuration, so it hasn't changed: %v", err)
+				}
 			default:
 				l.Debug("Ignoring signal `%s`", sig.String())
 			}
@@ -1357,22 +1608,53 @@ func defaultSocketsPath() string {
 	return filepath.Join(home, ".buildkite-agent", "sockets")
 }
 
+// agentAPIOptions configures the Agent API server run by runAgentAPI.
+type agentAPIOptions struct {
+	// Whether to persist the lock state in the sockets directory.
+	persistState bool
+
+	// If not empty, cluster-scope locks are stored in the backend this
+	// describes.
+	clusterBackend string
+
+	// If not empty, worker introspection and job control requests must
+	// provide this token.
+	token string
+}
+
 // runAgentAPI runs an API socket that can be used to interact with this
-// (top-level) agent. It returns a shutdown function.
-func runAgentAPI(ctx context.Context, l logger.Logger, socketsPath string) (func(), error) {
+// (top-level) agent. It returns the server and a shutdown function.
+func runAgentAPI(ctx context.Context, l logger.Logger, socketsPath string, o agentAPIOptions) (*agentapi.Server, func(), error) {
 	path := agentapi.DefaultSocketPath(socketsPath)
 	// There should be only one Agent API socket per agent process.
 	// If a previous agent crashed and left behind a socket, we can
 	// remove it.
 	os.Remove(path)
 
-	svr, err := agentapi.NewServer(path, l)
+	var opts []agentapi.ServerOption
+	if o.persistState {
+		statePath := agentapi.StatePath(socketsPath)
+		l.Info("Agent API: Persisting state in %s", statePath)
+		opts = append(opts, agentapi.WithStatePath(statePath))
+	}
+	if o.clusterBackend != "" {
+		backend, err := clusterlock.Open(o.clusterBackend)
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't open cluster lock backend: %w", err)
+		}
+		opts = append(opts, agentapi.WithClusterLockBackend(backend))
+	}
+	if o.token != "" {
+		opts = append(opts, agentapi.WithToken(o.token))
+	}
+
+	svr, err := agentapi.NewServer(path, l, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't create Agent API server: %w", err)
+		return nil, nil, fmt.Errorf("couldn't create Agent API server: %w", err)
 	}
 
 	if err := svr.Start(); err != nil {
-		return nil, fmt.Errorf("couldn't start Agent API server: %w", err)
+		return nil, nil, fmt.Errorf("couldn't start Agent API server: %w", err)
 	}
 
 	// Try to be the leader - no worries if this fails.
@@ -1382,9 +1664,9 @@ func runAgentAPI(ctx context.Context, l logger.Logger, socketsPath string) (func
 	}
 
 	// Whoever the leader is, ping them every so often as a health-check.
-	go leaderPinger(ctx, l, path, leaderPath)
+	go leaderPinger(ctx, l, path, leaderPath, o.persistState)
 
-	return func() {
+	return svr, func() {
 		svr.Shutdown(ctx)
 		if d, err := os.Readlink(leaderPath); err == nil && d == path {
 			os.Remove(leaderPath)
@@ -1394,7 +1676,7 @@ func runAgentAPI(ctx context.Context, l logger.Logger, socketsPath string) (func
 
 // leaderPinger pings the leader socket for liveness, and takes over if it
 // fails.
-func leaderPinger(ctx context.Context, l logger.Logger, path, leaderPath string) {
+func leaderPinger(ctx context.Context, l logger.Logger, path, leaderPath string, persistState bool) {
 	pingLeader := func() error {
 		d, err := os.Readlink(leaderPath)
 		if err != nil {
@@ -1419,7 +1701,9 @@ func leaderPinger(ctx context.Context, l logger.Logger, path, leaderPath string)
 	for range time.Tick(100 * time.Millisecond) {
 		if err := pingLeader(); err != nil {
 			l.Warn("Agent API: Leader ping failed, staging coup: %v", err)
-			l.Warn("Agent API: Leader state (locks) has been lost!")
+			if !persistState {
+				l.Warn("Agent API: Leader state (locks) has been lost!")
+			}
 			os.Remove(leaderPath)
 			os.Symlink(path, leaderPath)
 		}
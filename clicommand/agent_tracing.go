@@ -0,0 +1,66 @@
+package clicommand
+
+import (
+	"context"
+	"os"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/agent/v3/tracetools"
+	"github.com/buildkite/agent/v3/version"
+	"github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/opentracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// startAgentTracing sets up the global tracer for the agent process, so that
+// agent workers and job runners can record spans for the parts of a job that
+// happen before and after the bootstrap. The trace is continued by the
+// bootstrap through the trace context in the job environment. The returned
+// function flushes and stops the tracer.
+func startAgentTracing(ctx context.Context, l logger.Logger, backend, serviceName string) func() {
+	switch backend {
+	case tracetools.BackendDatadog:
+		// Newer versions of the tracing libs print out diagnostic info which
+		// spams the agent logs. Disable it unless it's been explicitly set.
+		if _, has := os.LookupEnv("DD_TRACE_STARTUP_LOGS"); !has {
+			os.Setenv("DD_TRACE_STARTUP_LOGS", "false")
+		}
+		opentracing.SetGlobalTracer(opentracer.New(
+			tracer.WithService(serviceName),
+			tracer.WithSampler(tracer.NewAllSampler()),
+			tracer.WithAnalytics(true),
+		))
+		return tracer.Stop
+
+	case tracetools.BackendOpenTelemetry:
+		exporter, err := otlptrace.New(ctx, otlptracegrpc.NewClient())
+		if err != nil {
+			l.Error("Error creating OTLP trace exporter, agent spans won't be recorded: %v", err)
+			return func() {}
+		}
+		tracerProvider := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+				semconv.ServiceNameKey.String(serviceName),
+				semconv.ServiceVersionKey.String(version.Version()),
+				semconv.DeploymentEnvironmentKey.String("ci"),
+			)),
+		)
+		otel.SetTracerProvider(tracerProvider)
+		otel.SetTextMapPropagator(tracetools.OpenTelemetryPropagator())
+		return func() {
+			ctx := context.Background()
+			_ = tracerProvider.ForceFlush(ctx)
+			_ = tracerProvider.Shutdown(ctx)
+		}
+
+	default:
+		return func() {}
+	}
+}
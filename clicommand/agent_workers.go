@@ -0,0 +1,160 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/buildkite/agent/v3/internal/agentapi"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/urfave/cli"
+)
+
+const agentWorkersHelpDescription = `Usage:
+
+    buildkite-agent agent workers [options...]
+
+Description:
+
+Lists the agent workers run by each agent on this host that is using the same
+sockets path, with the job each one is running (if any) and whether it is
+stopping.
+
+If the agents were started with ′--agent-api-token′, the same token must be
+provided with ′--agent-api-token′ (or $BUILDKITE_AGENT_API_TOKEN).
+
+Note that this subcommand is only available when agents have been started
+with the ′agent-api′ experiment enabled.
+
+Examples:
+
+    $ buildkite-agent agent workers
+    PID   NAME          STATE  JOB                                   STOPPING
+    4242  my-agent-1    busy   0190c5a6-7b2c-4e5d-9f1a-2b3c4d5e6f70  false
+    4242  my-agent-2    idle   -                                     false
+
+    $ buildkite-agent agent workers --format json`
+
+type AgentWorkersConfig struct {
+	Format string `cli:"format"`
+
+	// Common config options
+	SocketsPath   string `cli:"sockets-path" normalize:"filepath"`
+	AgentAPIToken string `cli:"agent-api-token"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+// Flags used by all agent subcommands that use the Agent API.
+var agentAPIClientFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "sockets-path",
+		Value:  defaultSocketsPath(),
+		Usage:  "Directory where the agent will place sockets",
+		EnvVar: "BUILDKITE_SOCKETS_PATH",
+	},
+	cli.StringFlag{
+		Name:   "agent-api-token",
+		Value:  "",
+		Usage:  "The token required by the agents' Agent API (see ′agent start --agent-api-token′)",
+		EnvVar: "BUILDKITE_AGENT_API_TOKEN",
+	},
+}
+
+var AgentWorkersCommand = cli.Command{
+	Name:        "workers",
+	Usage:       "Lists the agent workers on this host, and the jobs they are running",
+	Description: agentWorkersHelpDescription,
+	Flags: append(append(globalFlags(), agentAPIClientFlags...),
+		cli.StringFlag{
+			Name:   "format",
+			Value:  "table",
+			Usage:  "The output format: ′table′ or ′json′",
+			EnvVar: "BUILDKITE_AGENT_WORKERS_FORMAT",
+		},
+	),
+	Action: agentWorkersAction,
+}
+
+func agentWorkersAction(c *cli.Context) error {
+	if c.NArg() != 0 {
+		fmt.Fprint(c.App.ErrWriter, agentWorkersHelpDescription)
+		return &SilentExitError{code: 1}
+	}
+
+	ctx, cfg, l, _, done := setupLoggerAndConfig[AgentWorkersConfig](context.Background(), c)
+	defer done()
+
+	if cfg.Format != "table" && cfg.Format != "json" {
+		return fmt.Errorf("invalid format %q (expected ′table′ or ′json′)", cfg.Format)
+	}
+
+	clients, err := agentAPIClients(ctx, l, cfg.SocketsPath, cfg.AgentAPIToken)
+	if err != nil {
+		return err
+	}
+
+	var agents []*agentapi.WorkersResponse
+	for _, cl := range clients {
+		resp, err := cl.Workers(ctx)
+		if err != nil {
+			return fmt.Errorf("couldn't list workers: %w", err)
+		}
+		agents = append(agents, resp)
+	}
+
+	if cfg.Format == "json" {
+		enc := json.NewEncoder(c.App.Writer)
+		enc.SetIndent("", "  ")
+		return enc.Encode(agents)
+	}
+	return writeWorkersTable(c.App.Writer, agents)
+}
+
+// writeWorkersTable writes a table describing the workers of each agent to w.
+func writeWorkersTable(w io.Writer, agents []*agentapi.WorkersResponse) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tNAME\tSTATE\tJOB\tSTOPPING")
+	for _, a := range agents {
+		for _, wk := range a.Workers {
+			job := wk.JobID
+			if job == "" {
+				job = "-"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%t\n", a.PID, wk.Name, wk.State, job, wk.Stopping)
+		}
+	}
+	return tw.Flush()
+}
+
+// agentAPIClients returns a client for the Agent API of each agent using the
+// sockets directory. Sockets that can't be dialled (usually because the agent
+// has exited) are skipped.
+func agentAPIClients(ctx context.Context, l logger.Logger, socketsPath, token string) ([]*agentapi.Client, error) {
+	paths, err := agentapi.AgentSocketPaths(socketsPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find Agent API sockets: %w", err)
+	}
+
+	var clients []*agentapi.Client
+	for _, path := range paths {
+		cl, err := agentapi.NewClient(ctx, path, agentapi.WithClientToken(token))
+		if err != nil {
+			l.Debug("Skipping Agent API socket %s: %v", path, err)
+			continue
+		}
+		clients = append(clients, cl)
+	}
+	if len(clients) == 0 {
+		return nil, fmt.Errorf(lockClientErrMessage, errors.New("no agents found in "+socketsPath))
+	}
+	return clients, nil
+}
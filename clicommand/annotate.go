@@ -70,7 +70,7 @@ type AnnotateConfig struct {
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	AgentAccessToken string `cli:"agent-access-token"` // required, unless the Job API is available
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
 }
@@ -125,6 +125,10 @@ var AnnotateCommand = cli.Command{
 		ctx, cfg, l, _, done := setupLoggerAndConfig[AnnotateConfig](ctx, c)
 		defer done()
 
+		if err := requireAgentAccessToken(cfg.AgentAccessToken); err != nil {
+			return err
+		}
+
 		if err := annotate(ctx, cfg, l); err != nil {
 			return err
 		}
@@ -155,7 +159,7 @@ func annotate(ctx context.Context, cfg AnnotateConfig, l logger.Logger) error {
 	}
 
 	// Create the API client
-	client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+	client := api.NewClient(l, loadJobAPIClientConfig(cfg, "AgentAccessToken"))
 
 	// Create the annotation we'll send to the Buildkite API
 	annotation := &api.Annotation{
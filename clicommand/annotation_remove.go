@@ -39,7 +39,7 @@ type AnnotationRemoveConfig struct {
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	AgentAccessToken string `cli:"agent-access-token"` // required, unless the Job API is available
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
 }
@@ -80,8 +80,12 @@ var AnnotationRemoveCommand = cli.Command{
 		ctx, cfg, l, _, done := setupLoggerAndConfig[AnnotationRemoveConfig](ctx, c)
 		defer done()
 
+		if err := requireAgentAccessToken(cfg.AgentAccessToken); err != nil {
+			return err
+		}
+
 		// Create the API client
-		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+		client := api.NewClient(l, loadJobAPIClientConfig(cfg, "AgentAccessToken"))
 
 		// Retry the removal a few times before giving up
 		if err := roko.NewRetrier(
@@ -65,7 +65,7 @@ type ArtifactShasumConfig struct {
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	AgentAccessToken string `cli:"agent-access-token"` // required, unless the Job API is available
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
 }
@@ -123,8 +123,12 @@ func searchAndPrintShaSum(
 	l logger.Logger,
 	stdout io.Writer,
 ) error {
+	if err := requireAgentAccessToken(cfg.AgentAccessToken); err != nil {
+		return err
+	}
+
 	// Create the API client
-	client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+	client := api.NewClient(l, loadJobAPIClientConfig(cfg, "AgentAccessToken"))
 
 	// Find the artifact we want to show the SHASUM for
 	searcher := agent.NewArtifactSearcher(l, client, cfg.Build)
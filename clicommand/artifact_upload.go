@@ -81,7 +81,7 @@ type ArtifactUploadConfig struct {
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	AgentAccessToken string `cli:"agent-access-token"` // required, unless the Job API is available
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
 
@@ -144,8 +144,12 @@ var ArtifactUploadCommand = cli.Command{
 		ctx, cfg, l, _, done := setupLoggerAndConfig[ArtifactUploadConfig](ctx, c)
 		defer done()
 
+		if err := requireAgentAccessToken(cfg.AgentAccessToken); err != nil {
+			return err
+		}
+
 		// Create the API client
-		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+		client := api.NewClient(l, loadJobAPIClientConfig(cfg, "AgentAccessToken"))
 
 		// Setup the uploader
 		uploader := agent.NewArtifactUploader(l, client, agent.ArtifactUploaderConfig{
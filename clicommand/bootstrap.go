@@ -12,6 +12,7 @@ import (
 
 	"github.com/buildkite/agent/v3/internal/job"
 	"github.com/buildkite/agent/v3/process"
+	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/urfave/cli"
 )
 
@@ -95,10 +96,16 @@ type BootstrapConfig struct {
 	RedactedVars                 []string `cli:"redacted-vars" normalize:"list"`
 	TracingBackend               string   `cli:"tracing-backend"`
 	TracingServiceName           string   `cli:"tracing-service-name"`
+	TracingFileFormat            string   `cli:"tracing-file-format"`
 	NoJobAPI                     bool     `cli:"no-job-api"`
+	JobAPIListen                 string   `cli:"job-api-listen"`
+	AgentAccessToken             string   `cli:"agent-access-token"`
+	Endpoint                     string   `cli:"endpoint"`
+	JobResultFile                string   `cli:"job-result-file" normalize:"filepath"`
 	DisableWarningsFor           []string `cli:"disable-warnings-for" normalize:"list"`
 	KubernetesExec               bool     `cli:"kubernetes-exec"`
 	KubernetesContainerID        int      `cli:"kubernetes-container-id"`
+	KubernetesContainerName      string   `cli:"kubernetes-container-name"`
 }
 
 var BootstrapCommand = cli.Command{
@@ -353,11 +360,27 @@ var BootstrapCommand = cli.Command{
 			EnvVar: "BUILDKITE_TRACING_SERVICE_NAME",
 			Value:  "buildkite-agent",
 		},
+		cli.StringFlag{
+			Name:   "tracing-file-format",
+			Usage:  `The format of the trace file written by the "file" tracing backend, "chrome" or "otlp-json"`,
+			EnvVar: "BUILDKITE_TRACING_FILE_FORMAT",
+			Value:  tracetools.FileFormatChrome,
+		},
 		cli.BoolFlag{
 			Name:   "no-job-api",
 			Usage:  "Disables the Job API, which gives commands in jobs some abilities to introspect and mutate the state of the job.",
 			EnvVar: "BUILDKITE_AGENT_NO_JOB_API",
 		},
+		cli.StringFlag{
+			Name:   "job-api-listen",
+			Usage:  "A TCP address (such as 127.0.0.1:0) on which the Job API also listens, for commands that can't reach its socket",
+			EnvVar: "BUILDKITE_AGENT_JOB_API_LISTEN",
+		},
+		cli.StringFlag{
+			Name:   "job-result-file",
+			Usage:  "Path to a file to write the result set by the job to, for the agent to report",
+			EnvVar: "BUILDKITE_BOOTSTRAP_JOB_RESULT_FILE",
+		},
 		cli.StringSliceFlag{
 			Name:   "disable-warnings-for",
 			Usage:  "A list of warning IDs to disable",
@@ -370,9 +393,24 @@ var BootstrapCommand = cli.Command{
 				"used to identify this container within the pod",
 			EnvVar: "BUILDKITE_CONTAINER_ID",
 		},
+		cli.StringFlag{
+			Name: "kubernetes-container-name",
+			Usage: "This is intended to be used only by the Buildkite k8s stack " +
+				"(github.com/buildkite/agent-stack-k8s); it sets the name of this " +
+				"container, which the agent uses in log messages about it",
+			EnvVar: "BUILDKITE_CONTAINER_NAME",
+		},
 		cancelSignalFlag,
 		signalGracePeriodSecondsFlag,
 
+		// API Flags
+		cli.StringFlag{
+			Name:   "agent-access-token",
+			Usage:  "The access token the executor uses on behalf of the job. It isn't passed on to the job's environment; commands in the job reach Buildkite through the Job API instead",
+			EnvVar: "BUILDKITE_BOOTSTRAP_ACCESS_TOKEN,BUILDKITE_AGENT_ACCESS_TOKEN",
+		},
+		EndpointFlag,
+
 		// Global flags
 		DebugFlag,
 		LogLevelFlag,
@@ -460,10 +498,16 @@ var BootstrapCommand = cli.Command{
 			Tag:                          cfg.Tag,
 			TracingBackend:               cfg.TracingBackend,
 			TracingServiceName:           cfg.TracingServiceName,
+			TracingFileFormat:            cfg.TracingFileFormat,
 			JobAPI:                       !cfg.NoJobAPI,
+			JobAPIListen:                 cfg.JobAPIListen,
+			AgentEndpoint:                cfg.Endpoint,
+			AgentAccessToken:             cfg.AgentAccessToken,
+			JobResultFile:                cfg.JobResultFile,
 			DisabledWarnings:             cfg.DisableWarningsFor,
 			KubernetesExec:               cfg.KubernetesExec,
 			KubernetesContainerID:        cfg.KubernetesContainerID,
+			KubernetesContainerName:      cfg.KubernetesContainerName,
 		})
 
 		cctx, cancel := context.WithCancel(ctx)
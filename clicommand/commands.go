@@ -9,6 +9,14 @@ var BuildkiteAgentCommands = []cli.Command{
 
 	// These are in alphabetical order
 	AcknowledgementsCommand,
+	{
+		Name:  "agent",
+		Usage: "Inspect and control the agents running on this host",
+		Subcommands: []cli.Command{
+			AgentCancelJobCommand,
+			AgentWorkersCommand,
+		},
+	},
 	AnnotateCommand,
 	{
 		Name:  "annotation",
@@ -27,6 +35,14 @@ var BuildkiteAgentCommands = []cli.Command{
 			ArtifactShasumCommand,
 		},
 	},
+	{
+		Name:  "config",
+		Usage: "Inspect the agent's configuration",
+		Subcommands: []cli.Command{
+			ConfigShowCommand,
+		},
+	},
+	DoctorCommand,
 	{
 		Name:  "env",
 		Usage: "Process environment subcommands",
@@ -38,6 +54,14 @@ var BuildkiteAgentCommands = []cli.Command{
 		},
 	},
 	GitCredentialsHelperCommand,
+	{
+		Name:  "job",
+		Usage: "Interact with the job currently running",
+		Subcommands: []cli.Command{
+			JobOnExitCommand,
+			JobResultCommand,
+		},
+	},
 	{
 		Name:  "lock",
 		Usage: "Process lock subcommands",
@@ -46,7 +70,10 @@ var BuildkiteAgentCommands = []cli.Command{
 			LockDoCommand,
 			LockDoneCommand,
 			LockGetCommand,
+			LockInspectCommand,
+			LockListCommand,
 			LockReleaseCommand,
+			LockRenewCommand,
 		},
 	},
 	{
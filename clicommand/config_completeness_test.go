@@ -15,7 +15,9 @@ type configCommandPair struct {
 
 var commandConfigPairs = []configCommandPair{
 	{Config: AcknowledgementsConfig{}, Command: AcknowledgementsCommand},
+	{Config: AgentCancelJobConfig{}, Command: AgentCancelJobCommand},
 	{Config: AgentStartConfig{}, Command: AgentStartCommand},
+	{Config: AgentWorkersConfig{}, Command: AgentWorkersCommand},
 	{Config: AnnotateConfig{}, Command: AnnotateCommand},
 	{Config: AnnotationRemoveConfig{}, Command: AnnotationRemoveCommand},
 	{Config: ArtifactDownloadConfig{}, Command: ArtifactDownloadCommand},
@@ -23,16 +25,23 @@ var commandConfigPairs = []configCommandPair{
 	{Config: ArtifactShasumConfig{}, Command: ArtifactShasumCommand},
 	{Config: ArtifactUploadConfig{}, Command: ArtifactUploadCommand},
 	{Config: BootstrapConfig{}, Command: BootstrapCommand},
+	{Config: ConfigShowConfig{}, Command: ConfigShowCommand},
+	{Config: DoctorConfig{}, Command: DoctorCommand},
 	{Config: EnvDumpConfig{}, Command: EnvDumpCommand},
 	{Config: EnvGetConfig{}, Command: EnvGetCommand},
 	{Config: EnvSetConfig{}, Command: EnvSetCommand},
 	{Config: EnvUnsetConfig{}, Command: EnvUnsetCommand},
 	{Config: GitCredentialsHelperConfig{}, Command: GitCredentialsHelperCommand},
+	{Config: JobOnExitConfig{}, Command: JobOnExitCommand},
+	{Config: JobResultConfig{}, Command: JobResultCommand},
 	{Config: LockAcquireConfig{}, Command: LockAcquireCommand},
 	{Config: LockDoConfig{}, Command: LockDoCommand},
 	{Config: LockDoneConfig{}, Command: LockDoneCommand},
 	{Config: LockGetConfig{}, Command: LockGetCommand},
+	{Config: LockInspectConfig{}, Command: LockInspectCommand},
+	{Config: LockListConfig{}, Command: LockListCommand},
 	{Config: LockReleaseConfig{}, Command: LockReleaseCommand},
+	{Config: LockRenewConfig{}, Command: LockRenewCommand},
 	{Config: MetaDataExistsConfig{}, Command: MetaDataExistsCommand},
 	{Config: MetaDataGetConfig{}, Command: MetaDataGetCommand},
 	{Config: MetaDataKeysConfig{}, Command: MetaDataKeysCommand},
@@ -56,7 +65,7 @@ func TestAllCommandConfigStructsHaveCorrespondingCLIFlags(t *testing.T) {
 			flagNames[flag.GetName()] = struct{}{}
 		}
 
-		fields, err := reflections.Fields(pair.Config)
+		fields, err := reflections.FieldsDeep(pair.Config)
 		if err != nil {
 			t.Fatalf("getting fields for type %T: %v", pair.Config, err)
 		}
@@ -0,0 +1,601 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/cliconfig"
+	"github.com/buildkite/agent/v3/internal/job/hook"
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/buildkite/agent/v3/internal/system"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/shellwords"
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli"
+)
+
+const doctorHelpDescription = `Usage:
+
+    buildkite-agent doctor [options...]
+
+Description:
+
+Checks that this host is set up to run ′buildkite-agent start′, given the same
+options, environment variables and config files, and reports whether each
+check passed, and how to fix the ones that didn't.
+
+The checks are:
+
+  - config: the config is valid
+  - api: the Buildkite API can be reached (the agent isn't registered)
+  - clock: the system clock agrees with the Buildkite API's
+  - git, shell, ssh-keyscan: the programs jobs need are installed
+  - build-path, plugins-path, git-mirrors-path: the directories the agent
+    writes to are writable
+  - hooks-path: the agent hooks can be run
+  - sockets-path: the sockets the agent creates aren't too long for this OS
+  - disk-space: there's enough free space for checkouts
+
+If any check fails, the command exits with status 1. Warnings don't change the
+exit status.
+
+Examples:
+
+    $ buildkite-agent doctor
+    STATUS  CHECK         RESULT
+    pass    config        Loaded /etc/buildkite-agent/buildkite-agent.cfg
+    pass    api           https://agent.buildkite.com/v3 responded (401 Unauthorized)
+    fail    git           git wasn't found in the PATH
+    ...
+
+    Hints:
+      git: Install git, and make sure it's in the PATH of the agent
+
+    $ buildkite-agent doctor --format json`
+
+type DoctorConfig struct {
+	// All the options of ′agent start′, so that they're checked the same way
+	// they're loaded
+	AgentStartConfig
+
+	Format string `cli:"format"`
+}
+
+var DoctorCommand = cli.Command{
+	Name:        "doctor",
+	Usage:       "Checks that this host is set up to run the agent",
+	Description: doctorHelpDescription,
+	Flags: append(slices.Clone(AgentStartCommand.Flags),
+		cli.StringFlag{
+			Name:   "format",
+			Value:  "table",
+			Usage:  "The output format: ′table′ or ′json′",
+			EnvVar: "BUILDKITE_DOCTOR_FORMAT",
+		},
+	),
+	Action: doctorAction,
+}
+
+// The results of a doctor check.
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+// Thresholds for the doctor checks.
+const (
+	doctorAPITimeout = 10 * time.Second
+
+	// The Date header the clock is compared with has a resolution of a
+	// second, and the request takes some time, so small differences are fine
+	doctorClockSkewWarn = 10 * time.Second
+	doctorClockSkewFail = time.Minute
+
+	doctorDiskSpaceFail = 1 << 30 // 1 GiB
+	doctorDiskSpaceWarn = 5 << 30 // 5 GiB
+)
+
+// doctorHooks are the agent hooks that are looked for in the hooks path.
+var doctorHooks = []string{
+	"agent-startup",
+	"agent-shutdown",
+	"pre-bootstrap",
+	"environment",
+	"pre-checkout",
+	"checkout",
+	"post-checkout",
+	"pre-command",
+	"command",
+	"post-command",
+	"pre-artifact",
+	"post-artifact",
+	"pre-exit",
+}
+
+// doctorCheck is the result of a doctor check.
+type doctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+func doctorAction(c *cli.Context) error {
+	if c.NArg() != 0 {
+		fmt.Fprint(c.App.ErrWriter, doctorHelpDescription)
+		return &SilentExitError{code: 1}
+	}
+
+	var cfg DoctorConfig
+	loader := cliconfig.Loader{
+		CLI:                       c,
+		Config:                    &cfg,
+		DefaultConfigFilePaths:    defaultConfigFilePaths(),
+		ContinueOnValidationError: true,
+	}
+	warnings, err := loader.Load()
+	if err != nil {
+		return err
+	}
+
+	if cfg.Format != "table" && cfg.Format != "json" {
+		return fmt.Errorf("invalid format %q (expected ′table′ or ′json′)", cfg.Format)
+	}
+
+	l := CreateLogger(&cfg)
+	ctx := context.Background()
+
+	checks := []doctorCheck{doctorCheckConfig(loader, warnings)}
+	checks = append(checks, doctorCheckAPI(ctx, l, cfg.AgentStartConfig)...)
+	checks = append(checks,
+		doctorCheckCommand("git", "git", "Install git, and make sure it's in the PATH of the agent"),
+		doctorCheckShell(cfg.AgentStartConfig),
+		doctorCheckSSHKeyscan(cfg.AgentStartConfig),
+		doctorCheckWritableDir("build-path", cfg.BuildPath),
+		doctorCheckWritableDir("plugins-path", cfg.PluginsPath),
+		doctorCheckWritableDir("git-mirrors-path", cfg.GitMirrorsPath),
+		doctorCheckHooks(cfg.HooksPath),
+		doctorCheckSocketsPath(cfg.SocketsPath),
+		doctorCheckDiskSpace(cfg.BuildPath),
+	)
+
+	if cfg.Format == "json" {
+		enc := json.NewEncoder(c.App.Writer)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(checks)
+	} else {
+		err = writeDoctorTable(c.App.Writer, checks)
+	}
+	if err != nil {
+		return err
+	}
+
+	if slices.ContainsFunc(checks, func(check doctorCheck) bool { return check.Status == doctorFail }) {
+		return &SilentExitError{code: 1}
+	}
+	return nil
+}
+
+// doctorCheckConfig checks that the config loaded, and is valid.
+func doctorCheckConfig(loader cliconfig.Loader, warnings []string) doctorCheck {
+	check := doctorCheck{Name: "config", Status: doctorPass}
+
+	switch {
+	case len(loader.ValidationErrors) > 0:
+		errs := make([]string, 0, len(loader.ValidationErrors))
+		for _, err := range loader.ValidationErrors {
+			errs = append(errs, err.Error())
+		}
+		check.Status = doctorFail
+		check.Message = strings.Join(errs, "; ")
+		check.Hint = "Fix the config. ′buildkite-agent config show′ shows where each value came from"
+
+	case len(warnings) > 0:
+		check.Status = doctorWarn
+		check.Message = strings.Join(warnings, "; ")
+		check.Hint = "Replace the deprecated options, since they'll be removed in a future version"
+
+	case loader.File != nil:
+		path, _ := loader.File.AbsolutePath()
+		check.Message = "Loaded " + path
+
+	default:
+		check.Message = "No config file was found, so only flags, environment variables and defaults are used"
+	}
+	return check
+}
+
+// doctorCheckAPI checks that the Buildkite API can be reached, and that the
+// system clock agrees with the API's. It connects to the API without
+// registering an agent, so any response means it can be reached, but one
+// rejecting the token means the agent won't be able to start.
+func doctorCheckAPI(ctx context.Context, l logger.Logger, cfg AgentStartConfig) []doctorCheck {
+	if cfg.Token == "" {
+		return []doctorCheck{
+			{Name: "api", Status: doctorWarn, Message: "Couldn't be checked, since the token isn't set"},
+			{Name: "clock", Status: doctorWarn, Message: "Couldn't be checked, since the token isn't set"},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, doctorAPITimeout)
+	defer cancel()
+
+	conf := loadAPIClientConfig(cfg, "Token")
+	client := api.NewClient(l, conf)
+	resp, err := client.Connect(ctx)
+	if resp == nil {
+		return []doctorCheck{
+			{
+				Name:    "api",
+				Status:  doctorFail,
+				Message: fmt.Sprintf("Couldn't reach %s: %v", conf.Endpoint, err),
+				Hint:    fmt.Sprintf("Check that this host can make HTTPS requests to %s, including through any proxy or firewall", conf.Endpoint),
+			},
+			{
+				Name:    "clock",
+				Status:  doctorWarn,
+				Message: "Couldn't be checked, since the Buildkite API couldn't be reached",
+			},
+		}
+	}
+
+	apiCheck := doctorCheck{
+		Name:    "api",
+		Status:  doctorPass,
+		Message: fmt.Sprintf("%s responded (%s)", conf.Endpoint, resp.Status),
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		apiCheck.Status = doctorFail
+		apiCheck.Message = fmt.Sprintf("%s rejected the token (%s)", conf.Endpoint, resp.Status)
+		apiCheck.Hint = "Check that the agent token (--token or BUILDKITE_AGENT_TOKEN) is correct, and hasn't been revoked"
+	case resp.StatusCode >= 500:
+		apiCheck.Status = doctorWarn
+		apiCheck.Hint = "The Buildkite API is having problems. Check https://www.buildkitestatus.com"
+	}
+
+	return []doctorCheck{apiCheck, doctorCheckClock(resp.Header.Get("Date"), time.Now())}
+}
+
+// doctorCheckClock compares the system clock (now) with the time in a Date
+// header from the Buildkite API.
+func doctorCheckClock(date string, now time.Time) doctorCheck {
+	check := doctorCheck{Name: "clock", Status: doctorPass}
+
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		check.Status = doctorWarn
+		check.Message = fmt.Sprintf("Couldn't be checked, since the Buildkite API's Date header %q is invalid", date)
+		return check
+	}
+
+	skew := now.Sub(serverTime).Round(time.Second)
+	if skew < 0 {
+		skew = -skew
+		check.Message = fmt.Sprintf("The system clock is %v behind the Buildkite API's", skew)
+	} else {
+		check.Message = fmt.Sprintf("The system clock is %v ahead of the Buildkite API's", skew)
+	}
+
+	switch {
+	case skew >= doctorClockSkewFail:
+		check.Status = doctorFail
+	case skew >= doctorClockSkewWarn:
+		check.Status = doctorWarn
+	default:
+		check.Message = "The system clock agrees with the Buildkite API's"
+		return check
+	}
+	check.Hint = "Sync the system clock, for example with NTP. Tokens and signatures used by jobs are only valid for a limited time"
+	return check
+}
+
+// doctorCheckCommand checks that the program is in the PATH, and runs it
+// with --version.
+func doctorCheckCommand(name, program, hint string) doctorCheck {
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return doctorCheck{
+			Name:    name,
+			Status:  doctorFail,
+			Message: fmt.Sprintf("%s wasn't found in the PATH", program),
+			Hint:    hint,
+		}
+	}
+
+	out, err := exec.Command(path, "--version").CombinedOutput()
+	if err != nil {
+		return doctorCheck{
+			Name:    name,
+			Status:  doctorFail,
+			Message: fmt.Sprintf("%s --version failed: %v", path, err),
+			Hint:    hint,
+		}
+	}
+
+	version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return doctorCheck{Name: name, Status: doctorPass, Message: fmt.Sprintf("%s (%s)", path, version)}
+}
+
+// doctorCheckShell checks that the shell jobs are run with is installed.
+func doctorCheckShell(cfg AgentStartConfig) doctorCheck {
+	check := doctorCheck{Name: "shell", Status: doctorFail}
+
+	shell := cfg.Shell
+	if shell == "" {
+		shell = DefaultShell()
+	}
+	args, err := shellwords.Split(shell)
+	if err != nil || len(args) == 0 {
+		check.Message = fmt.Sprintf("The shell %q couldn't be parsed: %v", shell, err)
+		check.Hint = "Fix the shell option"
+		return check
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		check.Message = fmt.Sprintf("%s wasn't found", args[0])
+		check.Hint = "Install it, or change the shell option to a shell that's installed"
+		return check
+	}
+
+	check.Status = doctorPass
+	check.Message = path
+	return check
+}
+
+// doctorCheckSSHKeyscan checks that ssh-keyscan is installed, if it's used.
+func doctorCheckSSHKeyscan(cfg AgentStartConfig) doctorCheck {
+	if cfg.NoSSHKeyscan {
+		return doctorCheck{Name: "ssh-keyscan", Status: doctorPass, Message: "Not used, since no-ssh-keyscan is set"}
+	}
+
+	path, err := exec.LookPath("ssh-keyscan")
+	if err != nil {
+		return doctorCheck{
+			Name:    "ssh-keyscan",
+			Status:  doctorWarn,
+			Message: "ssh-keyscan wasn't found in the PATH, so the host keys of repositories checked out over SSH can't be added",
+			Hint:    "Install the OpenSSH client, or set no-ssh-keyscan if repositories are added to known_hosts some other way",
+		}
+	}
+	return doctorCheck{Name: "ssh-keyscan", Status: doctorPass, Message: path}
+}
+
+// doctorCheckWritableDir checks that a directory the agent writes to is
+// writable, or can be created if it doesn't exist yet.
+func doctorCheckWritableDir(name, dir string) doctorCheck {
+	check := doctorCheck{Name: name, Status: doctorPass}
+	if dir == "" {
+		check.Message = "Not set"
+		return check
+	}
+
+	info, err := os.Stat(dir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// The agent creates it when it's needed, so the nearest directory
+		// that exists has to be writable instead
+		parent := existingParent(dir)
+		if err := checkWritable(parent); err != nil {
+			check.Status = doctorFail
+			check.Message = fmt.Sprintf("%s doesn't exist, and can't be created: %v", dir, err)
+			check.Hint = fmt.Sprintf("Create %s, and make it writable by the user the agent runs as", dir)
+			return check
+		}
+		check.Status = doctorWarn
+		check.Message = fmt.Sprintf("%s doesn't exist yet, but can be created", dir)
+		return check
+
+	case err != nil:
+		check.Status = doctorFail
+		check.Message = err.Error()
+		check.Hint = fmt.Sprintf("Make %s accessible to the user the agent runs as", dir)
+		return check
+
+	case !info.IsDir():
+		check.Status = doctorFail
+		check.Message = fmt.Sprintf("%s isn't a directory", dir)
+		check.Hint = fmt.Sprintf("Remove %s, or change the %s option", dir, name)
+		return check
+	}
+
+	if err := checkWritable(dir); err != nil {
+		check.Status = doctorFail
+		check.Message = fmt.Sprintf("%s isn't writable: %v", dir, err)
+		check.Hint = fmt.Sprintf("Make %s writable by the user the agent runs as", dir)
+		return check
+	}
+
+	check.Message = dir + " is writable"
+	return check
+}
+
+// doctorCheckHooks checks that the agent hooks can be run: their type can be
+// determined, and hooks that are run directly (rather than sourced by the
+// shell) are executable.
+func doctorCheckHooks(dir string) doctorCheck {
+	check := doctorCheck{Name: "hooks-path", Status: doctorPass}
+	if dir == "" {
+		check.Message = "Not set"
+		return check
+	}
+	if _, err := os.Stat(dir); err != nil {
+		check.Status = doctorWarn
+		check.Message = fmt.Sprintf("%s can't be read, so no agent hooks will be run: %v", dir, err)
+		check.Hint = "Create it, or change the hooks-path option, if there are agent hooks to run"
+		return check
+	}
+
+	var found, problems []string
+	for _, name := range doctorHooks {
+		path, err := hook.Find(dir, name)
+		if err != nil {
+			continue
+		}
+		found = append(found, name)
+
+		if problem := hookProblem(path); problem != "" {
+			problems = append(problems, fmt.Sprintf("%s %s", name, problem))
+		}
+	}
+
+	switch {
+	case len(problems) > 0:
+		check.Status = doctorFail
+		check.Message = fmt.Sprintf("Some hooks can't be run: %s", strings.Join(problems, "; "))
+		check.Hint = fmt.Sprintf("Make the hooks in %s executable (chmod +x) and readable by the user the agent runs as", dir)
+	case len(found) == 0:
+		check.Message = fmt.Sprintf("No hooks found in %s", dir)
+	default:
+		check.Message = fmt.Sprintf("Found %s", strings.Join(found, ", "))
+	}
+	return check
+}
+
+// hookProblem returns why the hook at path can't be run, or "" if it can.
+func hookProblem(path string) string {
+	hookType, err := hook.Type(path)
+	if err != nil {
+		return err.Error()
+	}
+
+	switch hookType {
+	case hook.TypeShell:
+		// Shell hooks are sourced, so they don't need to be executable
+		return ""
+
+	case hook.TypeScript:
+		if runtime.GOOS == "windows" {
+			return "has a shebang line, and scripts with shebangs can't be run on Windows"
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return ""
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err.Error()
+	}
+	if info.Mode()&0o111 == 0 {
+		return fmt.Sprintf("is a %s, but isn't executable", hookType)
+	}
+	return ""
+}
+
+// doctorCheckSocketsPath checks that the sockets the agent creates in the
+// sockets path aren't too long for this OS.
+func doctorCheckSocketsPath(dir string) doctorCheck {
+	check := doctorCheck{Name: "sockets-path", Status: doctorPass}
+	if dir == "" {
+		check.Message = "Not set"
+		return check
+	}
+
+	// The longest socket path is the Job API's (see jobapi.NewSocketPath),
+	// with the largest PID Linux allows
+	longest := filepath.Join(dir, "job-api", "4194304-99999.sock")
+	if err := socket.CheckPathLength(longest); err != nil {
+		check.Status = doctorFail
+		check.Message = err.Error()
+		check.Hint = "Change the sockets-path option to a shorter path"
+		return check
+	}
+
+	check.Message = fmt.Sprintf("Sockets in %s are short enough", dir)
+	return check
+}
+
+// doctorCheckDiskSpace checks that there's enough free space for checkouts
+// in the build path.
+func doctorCheckDiskSpace(buildPath string) doctorCheck {
+	check := doctorCheck{Name: "disk-space", Status: doctorPass}
+	if buildPath == "" {
+		check.Status = doctorWarn
+		check.Message = "Couldn't be checked, since build-path isn't set"
+		return check
+	}
+
+	space, err := system.GetDiskSpace(existingParent(buildPath))
+	if err != nil {
+		check.Status = doctorWarn
+		check.Message = fmt.Sprintf("Couldn't be checked: %v", err)
+		return check
+	}
+
+	check.Message = fmt.Sprintf("%s free of %s", humanize.IBytes(space.Available), humanize.IBytes(space.Total))
+	switch {
+	case space.Available < doctorDiskSpaceFail:
+		check.Status = doctorFail
+	case space.Available < doctorDiskSpaceWarn:
+		check.Status = doctorWarn
+	default:
+		return check
+	}
+	check.Hint = fmt.Sprintf("Free up space on the disk with %s, for example by removing old checkouts from it", buildPath)
+	return check
+}
+
+// existingParent returns path, or the nearest directory above it, that exists.
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// checkWritable checks that a file can be created in dir.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".buildkite-agent-doctor-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// writeDoctorTable writes the result of each check as a table, followed by
+// the hints for the checks that didn't pass, to w.
+func writeDoctorTable(w io.Writer, checks []doctorCheck) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCHECK\tRESULT")
+	for _, check := range checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Status, check.Name, check.Message)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	var hints []string
+	for _, check := range checks {
+		if check.Hint != "" && check.Status != doctorPass {
+			hints = append(hints, fmt.Sprintf("  %s: %s", check.Name, check.Hint))
+		}
+	}
+	if len(hints) > 0 {
+		fmt.Fprintf(w, "\nHints:\n%s\n", strings.Join(hints, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package clicommand
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+// runDoctor runs `buildkite-agent doctor` with the given arguments, and returns
+// its output.
+func runDoctor(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	app := cli.NewApp()
+	app.Name = "buildkite-agent"
+	app.Writer = &out
+	app.ErrWriter = &out
+	app.Commands = []cli.Command{DoctorCommand}
+	err := app.Run(append([]string{"buildkite-agent", "doctor"}, args...))
+	return out.String(), err
+}
+
+func TestDoctor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks don't need to be executable on Windows")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/connect" {
+			t.Errorf("Unexpected request %s %s", req.Method, req.URL.Path)
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	hooksPath := filepath.Join(dir, "hooks")
+	if err := os.MkdirAll(hooksPath, 0o755); err != nil {
+		t.Fatalf("os.MkdirAll(%q) error = %v", hooksPath, err)
+	}
+	for name, content := range map[string]string{
+		"environment": "export LLAMAS=1\n",
+		"pre-command": "#!/usr/bin/env python3\nprint('alpacas')\n",
+	} {
+		if err := os.WriteFile(filepath.Join(hooksPath, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("os.WriteFile(%q) error = %v", name, err)
+		}
+	}
+
+	output, err := runDoctor(t,
+		"--format", "json",
+		"--token", "llamas",
+		"--endpoint", server.URL,
+		"--build-path", filepath.Join(dir, "builds"),
+		"--hooks-path", hooksPath,
+		"--sockets-path", filepath.Join(dir, "sockets"),
+		"--shell", "/bin/sh -e -c",
+	)
+
+	var exitErr *SilentExitError
+	if !errors.As(err, &exitErr) || exitErr.Code() != 1 {
+		t.Errorf("runDoctor() error = %v, want a SilentExitError with code 1", err)
+	}
+
+	var checks []doctorCheck
+	if err := json.Unmarshal([]byte(output), &checks); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v, output:\n%s", err, output)
+	}
+	statuses := make(map[string]string)
+	for _, check := range checks {
+		statuses[check.Name] = check.Status
+	}
+
+	assert.Equal(t, doctorPass, statuses["config"])
+	assert.Equal(t, doctorPass, statuses["api"])
+	assert.Equal(t, doctorPass, statuses["clock"])
+	assert.Equal(t, doctorPass, statuses["shell"])
+	assert.Equal(t, doctorWarn, statuses["build-path"], "it doesn't exist yet")
+	assert.Equal(t, doctorPass, statuses["plugins-path"])
+	assert.Equal(t, doctorFail, statuses["hooks-path"], "pre-command isn't executable")
+	assert.Equal(t, doctorPass, statuses["sockets-path"])
+	assert.Contains(t, output, "pre-command is a script, but isn't executable")
+}
+
+func TestDoctorCheckAPI(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		status     int
+		wantStatus string
+	}{
+		{status: http.StatusOK, wantStatus: doctorPass},
+		{status: http.StatusUnauthorized, wantStatus: doctorFail},
+		{status: http.StatusForbidden, wantStatus: doctorFail},
+		{status: http.StatusServiceUnavailable, wantStatus: doctorWarn},
+	}
+
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				http.Error(rw, `{"message": "llamas"}`, test.status)
+			}))
+			defer server.Close()
+
+			checks := doctorCheckAPI(context.Background(), logger.Discard, AgentStartConfig{
+				Token:    "llamas",
+				Endpoint: server.URL,
+			})
+			if got := checks[0]; got.Name != "api" || got.Status != test.wantStatus {
+				t.Errorf("doctorCheckAPI() api check = %+v, want status %q", got, test.wantStatus)
+			}
+			if test.wantStatus == doctorFail {
+				assert.Contains(t, checks[0].Hint, "agent token")
+			}
+		})
+	}
+}
+
+func TestDoctorCheckClock(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		date       string
+		wantStatus string
+	}{
+		{date: "Sat, 01 Jun 2024 12:00:01 GMT", wantStatus: doctorPass},
+		{date: "Sat, 01 Jun 2024 12:00:30 GMT", wantStatus: doctorWarn},
+		{date: "Sat, 01 Jun 2024 11:55:00 GMT", wantStatus: doctorFail},
+		{date: "", wantStatus: doctorWarn},
+	}
+
+	for _, test := range tests {
+		if got := doctorCheckClock(test.date, now); got.Status != test.wantStatus {
+			t.Errorf("doctorCheckClock(%q, %v) = %+v, want status %q", test.date, now, got, test.wantStatus)
+		}
+	}
+}
+
+func TestDoctorCheckSocketsPath(t *testing.T) {
+	t.Parallel()
+
+	if got := doctorCheckSocketsPath("/tmp/sockets"); got.Status != doctorPass {
+		t.Errorf(`doctorCheckSocketsPath("/tmp/sockets") = %+v, want status %q`, got, doctorPass)
+	}
+
+	long := "/" + strings.Repeat("a", 100)
+	if got := doctorCheckSocketsPath(long); got.Status != doctorFail {
+		t.Errorf("doctorCheckSocketsPath(%q) = %+v, want status %q", long, got, doctorFail)
+	}
+}
+
+func TestDoctorCheckWritableDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", file, err)
+	}
+
+	tests := []struct {
+		dir        string
+		wantStatus string
+	}{
+		{dir: "", wantStatus: doctorPass},
+		{dir: dir, wantStatus: doctorPass},
+		{dir: filepath.Join(dir, "missing", "nested"), wantStatus: doctorWarn},
+		{dir: file, wantStatus: doctorFail},
+	}
+
+	for _, test := range tests {
+		if got := doctorCheckWritableDir("build-path", test.dir); got.Status != test.wantStatus {
+			t.Errorf("doctorCheckWritableDir(build-path, %q) = %+v, want status %q", test.dir, got, test.wantStatus)
+		}
+	}
+}
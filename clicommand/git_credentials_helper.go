@@ -43,7 +43,7 @@ type GitCredentialsHelperConfig struct {
 
 	// API config
 	// DebugHTTP bool // Not present due to the possibility of leaking code access tokens to logs
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	AgentAccessToken string `cli:"agent-access-token"` // required, unless the Job API is available
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
 }
@@ -108,7 +108,11 @@ var GitCredentialsHelperCommand = cli.Command{
 			return handleAuthError(c, l, fmt.Errorf("failed to parse git URL from stdin: %w", err))
 		}
 
-		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+		if err := requireAgentAccessToken(cfg.AgentAccessToken); err != nil {
+			return err
+		}
+
+		client := api.NewClient(l, loadJobAPIClientConfig(cfg, "AgentAccessToken"))
 		tok, _, err := client.GenerateGithubCodeAccessToken(ctx, repo, cfg.JobID)
 		if err != nil {
 			return handleAuthError(c, l, fmt.Errorf("failed to get github app credentials: %w", err))
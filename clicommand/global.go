@@ -11,6 +11,7 @@ import (
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/cliconfig"
 	"github.com/buildkite/agent/v3/internal/experiments"
+	"github.com/buildkite/agent/v3/jobapi"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/version"
 	"github.com/oleiade/reflections"
@@ -117,6 +118,13 @@ var (
 			"*_CONNECTION_STRING",
 		},
 	}
+
+	SecretsProvidersFlag = cli.StringSliceFlag{
+		Name:   "secrets-providers",
+		Value:  &cli.StringSlice{},
+		Usage:  "Where to get secrets from, by key prefix, as a list of ′PREFIX=URL′. The URL scheme is one of ′file′, ′command′, ′vault+http′, ′vault+https′ or ′buildkite′. Keys that don't match a prefix are read from Buildkite secrets",
+		EnvVar: "BUILDKITE_SECRETS_PROVIDERS",
+	}
 )
 
 func globalFlags() []cli.Flag {
@@ -288,6 +296,38 @@ func loadAPIClientConfig(cfg any, tokenField string) api.Config {
 	return conf
 }
 
+// loadJobAPIClientConfig is like loadAPIClientConfig, but if the command is
+// running within a job that has the Job API available, and wasn't given a
+// token itself, the client is configured to make its requests through the Job
+// API, which makes them with the agent's access token and endpoint. This only
+// supports the requests proxied by the Job API (see jobapi.BuildkiteAPI).
+func loadJobAPIClientConfig(cfg any, tokenField string) api.Config {
+	conf := loadAPIClientConfig(cfg, tokenField)
+	if conf.Token != "" {
+		return conf
+	}
+
+	jobConf, err := jobapi.DefaultAPIClientConfig()
+	if err != nil {
+		return conf
+	}
+	conf.Endpoint = jobConf.Endpoint
+	conf.HTTPClient = jobConf.HTTPClient
+	return conf
+}
+
+// requireAgentAccessToken returns an error if token is empty, unless requests
+// can be made through the Job API instead (see loadJobAPIClientConfig).
+func requireAgentAccessToken(token string) error {
+	if token != "" {
+		return nil
+	}
+	if _, err := jobapi.DefaultAPIClientConfig(); err == nil {
+		return nil
+	}
+	return errors.New("missing agent-access-token parameter. Usually this is set in the environment for a Buildkite job via BUILDKITE_AGENT_ACCESS_TOKEN.")
+}
+
 type configOpts func(*cliconfig.Loader)
 
 func withConfigFilePaths(paths []string) func(*cliconfig.Loader) {
@@ -0,0 +1,125 @@
+package clicommand
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/internal/job/shell"
+	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/buildkite/agent/v3/jobapi"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/urfave/cli"
+)
+
+// fakeMetaDataSet is a stand-in for Buildkite that accepts meta-data for
+// job-1 made with the agent access token "llamas". It counts the requests.
+func fakeMetaDataSet(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.URL.Path != "/jobs/job-1/data/set" {
+			t.Errorf("Unexpected request %s %s", req.Method, req.URL.Path)
+			http.Error(rw, "Not found", http.StatusNotFound)
+			return
+		}
+		if got, want := req.Header.Get("Authorization"), "Token llamas"; got != want {
+			t.Errorf("Authorization header = %q, want %q", got, want)
+		}
+		calls.Add(1)
+		rw.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// runMetaDataSet runs `buildkite-agent meta-data set` with the given
+// arguments.
+func runMetaDataSet(args ...string) error {
+	app := cli.NewApp()
+	app.Name = "buildkite-agent"
+	app.Commands = []cli.Command{MetaDataSetCommand}
+	return app.Run(append([]string{"buildkite-agent", "set"}, args...))
+}
+
+func TestMetaDataSetThroughJobAPIURL(t *testing.T) {
+	// t.Parallel() // Can't be parallelised, because it uses the t.Setenv() function
+
+	var calls atomic.Int32
+	bk := fakeMetaDataSet(t, &calls)
+	agentClient := api.NewClient(logger.Discard, api.Config{Endpoint: bk.URL, Token: "llamas"})
+
+	sock, err := jobapi.NewSocketPath(t.TempDir())
+	if err != nil {
+		t.Fatalf("jobapi.NewSocketPath() error = %v", err)
+	}
+	srv, token, err := jobapi.NewServer(shell.TestingLogger{T: t}, sock, nil, replacer.NewMux(),
+		jobapi.WithBuildkiteAPI(agentClient),
+		jobapi.WithTCPListener("127.0.0.1:0"),
+	)
+	if err != nil {
+		t.Fatalf("jobapi.NewServer() error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	// As it might be inside a container, which can only reach the Job API's
+	// URL, and has no agent access token.
+	t.Setenv("BUILDKITE_JOB_ID", "job-1")
+	t.Setenv("BUILDKITE_AGENT_ACCESS_TOKEN", "")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_SOCKET", "")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_URL", srv.URL())
+	t.Setenv("BUILDKITE_AGENT_JOB_API_TOKEN", token)
+
+	if err := runMetaDataSet("llama", "alpaca"); err != nil {
+		t.Fatalf("meta-data set error = %v", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Buildkite got %d meta-data requests, want 1", got)
+	}
+}
+
+func TestMetaDataSetWithExplicitTokenSkipsJobAPI(t *testing.T) {
+	// t.Parallel() // Can't be parallelised, because it uses the t.Setenv() function
+
+	var calls atomic.Int32
+	bk := fakeMetaDataSet(t, &calls)
+
+	// The Job API variables point at a socket that isn't there, but the
+	// token given to the command means it talks to Buildkite directly.
+	t.Setenv("BUILDKITE_JOB_ID", "job-1")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_SOCKET", "/nonexistent/job-api.sock")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_URL", "")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_TOKEN", "not-a-token")
+
+	if err := runMetaDataSet("llama", "alpaca", "--agent-access-token", "llamas", "--endpoint", bk.URL); err != nil {
+		t.Fatalf("meta-data set error = %v", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Buildkite got %d meta-data requests, want 1", got)
+	}
+}
+
+func TestRequireAgentAccessToken(t *testing.T) {
+	// t.Parallel() // Can't be parallelised, because it uses the t.Setenv() function
+
+	t.Setenv("BUILDKITE_AGENT_JOB_API_SOCKET", "")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_URL", "")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_TOKEN", "")
+
+	if err := requireAgentAccessToken("llamas"); err != nil {
+		t.Errorf(`requireAgentAccessToken("llamas") = %v, want nil`, err)
+	}
+	if err := requireAgentAccessToken(""); err == nil {
+		t.Errorf(`requireAgentAccessToken("") without the Job API = nil, want an error`)
+	}
+
+	t.Setenv("BUILDKITE_AGENT_JOB_API_URL", "http://127.0.0.1:39821")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_TOKEN", "alpacas")
+	if err := requireAgentAccessToken(""); err != nil {
+		t.Errorf(`requireAgentAccessToken("") with the Job API URL = %v, want nil`, err)
+	}
+}
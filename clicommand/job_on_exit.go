@@ -0,0 +1,108 @@
+package clicommand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/buildkite/agent/v3/jobapi"
+	"github.com/urfave/cli"
+)
+
+const jobOnExitHelpDescription = `Usage:
+
+    buildkite-agent job on-exit [options...] -- [command] [args...]
+
+Description:
+
+Registers a command to be run when the job ends, for example to stop a
+service started by the job. Registered commands are run after the job's
+command and artifact upload, before any pre-exit hooks, even if the job was
+cancelled. They are run in the reverse of the order they were registered in,
+from the directory ′job on-exit′ was run in, each in its own section of the
+job log.
+
+A cleanup command that fails or times out is reported in the job log, but
+does not fail the job.
+
+Note that this subcommand is only available from within the job executor with
+the job-api experiment enabled.
+
+Examples:
+
+    $ docker run --detach --name postgres postgres:16
+    $ buildkite-agent job on-exit -- docker rm --force postgres
+
+    $ buildkite-agent job on-exit --timeout 30s -- sh -c 'kill "$(cat emulator.pid)"'`
+
+type JobOnExitConfig struct {
+	Timeout time.Duration `cli:"timeout"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var JobOnExitCommand = cli.Command{
+	Name:        "on-exit",
+	Usage:       "Registers a command to run when the job ends",
+	Description: jobOnExitHelpDescription,
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:   "timeout",
+			Value:  jobapi.DefaultCleanupTimeout,
+			Usage:  "How long the command may run for before it is interrupted",
+			EnvVar: "BUILDKITE_JOB_ON_EXIT_TIMEOUT",
+		},
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		LogLevelFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+	},
+	Action: jobOnExitAction,
+}
+
+func jobOnExitAction(c *cli.Context) error {
+	if c.NArg() == 0 {
+		fmt.Fprint(c.App.ErrWriter, jobOnExitHelpDescription)
+		return &SilentExitError{code: 1}
+	}
+
+	ctx := context.Background()
+	ctx, cfg, l, _, done := setupLoggerAndConfig[JobOnExitConfig](ctx, c)
+	defer done()
+
+	if cfg.Timeout <= 0 {
+		return errors.New("--timeout must be positive")
+	}
+
+	client, err := jobapi.NewDefaultClient(ctx)
+	if err != nil {
+		return fmt.Errorf(envClientErrMessage, err)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("couldn't determine the working directory: %w", err)
+	}
+
+	resp, err := client.CleanupCreate(ctx, &jobapi.CleanupCreateRequest{
+		Command: c.Args(),
+		Dir:     dir,
+		Timeout: cfg.Timeout.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't register the cleanup command: %w", err)
+	}
+
+	l.Debug("Registered cleanup command %d with timeout %s", resp.ID, resp.Timeout)
+	return nil
+}
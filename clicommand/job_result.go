@@ -0,0 +1,119 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/jobapi"
+	"github.com/urfave/cli"
+)
+
+const jobResultHelpDescription = `Usage:
+
+    buildkite-agent job result [options...]
+
+Description:
+
+Sets a structured result for the job, which is reported to Buildkite along
+with the job's exit status when the job finishes, and printed in the final
+section of the job log. This lets tools that act on failed jobs (such as retry
+automation) tell, for example, infrastructure problems from test failures
+without parsing the log.
+
+The result has a failure category, a one-line reason, and whether the job
+requests that a failure be treated as a soft failure. Each call replaces any
+result set previously, so the last one wins. Without any options, the current
+result is printed as JSON.
+
+Note that this subcommand is only available from within the job executor with
+the job-api experiment enabled.
+
+Examples:
+
+    $ buildkite-agent job result --category infra --reason "Couldn't pull the postgres image"
+
+    $ buildkite-agent job result --category lint --soft-fail
+
+    $ buildkite-agent job result
+    {"category":"lint","soft_fail":true}`
+
+type JobResultConfig struct {
+	Category string `cli:"category"`
+	Reason   string `cli:"reason"`
+	SoftFail bool   `cli:"soft-fail"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var JobResultCommand = cli.Command{
+	Name:        "result",
+	Usage:       "Sets a structured result for the job",
+	Description: jobResultHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "category",
+			Usage:  fmt.Sprintf("The category of failure, one of: %s", strings.Join(api.JobResultCategories, ", ")),
+			EnvVar: "BUILDKITE_JOB_RESULT_CATEGORY",
+		},
+		cli.StringFlag{
+			Name:   "reason",
+			Usage:  "A one-line description of the result",
+			EnvVar: "BUILDKITE_JOB_RESULT_REASON",
+		},
+		cli.BoolFlag{
+			Name:   "soft-fail",
+			Usage:  "Request that a failure of the job be treated as a soft failure",
+			EnvVar: "BUILDKITE_JOB_RESULT_SOFT_FAIL",
+		},
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		LogLevelFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+	},
+	Action: jobResultAction,
+}
+
+func jobResultAction(c *cli.Context) error {
+	if c.NArg() != 0 {
+		fmt.Fprint(c.App.ErrWriter, jobResultHelpDescription)
+		return &SilentExitError{code: 1}
+	}
+
+	ctx := context.Background()
+	ctx, cfg, _, _, done := setupLoggerAndConfig[JobResultConfig](ctx, c)
+	defer done()
+
+	client, err := jobapi.NewDefaultClient(ctx)
+	if err != nil {
+		return fmt.Errorf(envClientErrMessage, err)
+	}
+
+	if !c.IsSet("category") && !c.IsSet("reason") && !c.IsSet("soft-fail") {
+		res, err := client.ResultGet(ctx)
+		if err != nil {
+			return fmt.Errorf("couldn't get the job result: %w", err)
+		}
+		return json.NewEncoder(c.App.Writer).Encode(res)
+	}
+
+	_, err = client.ResultSet(ctx, &jobapi.JobResult{
+		Category: cfg.Category,
+		Reason:   cfg.Reason,
+		SoftFail: cfg.SoftFail,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't set the job result: %w", err)
+	}
+	return nil
+}
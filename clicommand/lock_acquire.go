@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/buildkite/agent/v3/lock"
@@ -24,6 +25,25 @@ is no ordering guarantee of which one will be given the lock next.
 To prevent separate processes unlocking each other, the output from ′lock
 acquire′ should be stored, and passed to ′lock release′.
 
+With ′--lease-ttl′, the lock is held under a lease, and the agent releases it
+if the lease is not renewed (with ′lock renew′) before it expires.
+
+With ′--release-on-exit′, the agent releases the lock as soon as the process
+that ran ′lock acquire′ exits, so a crashed script can't hold the lock
+forever. That process is whichever one started ′lock acquire′: in
+′token=$(buildkite-agent lock acquire ...)′ it is the subshell, which exits
+straight away, so run it from a script that holds the lock until it releases
+it, and write the token to a file instead. Don't use it to acquire a lock in
+one hook and release it in another.
+
+With ′--permits N′, the key is a counting semaphore rather than a lock: up to N
+processes can hold a permit at once, and waiting processes are given permits
+in the order they started waiting. All users of a semaphore must use the same
+N. Semaphores have their own keys, separate to locks. Permits are released
+with ′lock release′ and renewed with ′lock renew′, and ′--lease-ttl′ and
+′--release-on-exit′ apply to them, like locks.
+Semaphores are only available in the machine scope.
+
 Note that this subcommand is only available when an agent has been started
 with the ′agent-api′ experiment enabled.
 
@@ -32,7 +52,26 @@ Examples:
     #!/bin/bash
     token=$(buildkite-agent lock acquire llama)
     # your critical section here...
-    buildkite-agent lock release llama "${token}"`
+    buildkite-agent lock release llama "${token}"
+
+    #!/bin/bash
+    token=$(buildkite-agent lock acquire --lease-ttl 30s llama)
+    while sleep 10; do buildkite-agent lock renew --lease-ttl 30s llama "${token}"; done &
+    # your critical section here...
+    kill $!
+    buildkite-agent lock release llama "${token}"
+
+    #!/bin/bash
+    # At most 2 concurrent docker builds on this host
+    token=$(buildkite-agent lock acquire --permits 2 docker-build)
+    docker build .
+    buildkite-agent lock release docker-build "${token}"
+
+    #!/bin/bash
+    # Released when this script exits, even if it crashes
+    buildkite-agent lock acquire --release-on-exit llama > llama.token
+    # your critical section here...
+    buildkite-agent lock release llama "$(cat llama.token)"`
 
 type LockAcquireConfig struct {
 	// Common config options
@@ -40,6 +79,9 @@ type LockAcquireConfig struct {
 	SocketsPath string `cli:"sockets-path" normalize:"filepath"`
 
 	LockWaitTimeout time.Duration `cli:"lock-wait-timeout"`
+	LeaseTTL        time.Duration `cli:"lease-ttl"`
+	Permits         int           `cli:"permits"`
+	ReleaseOnExit   bool          `cli:"release-on-exit"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -57,6 +99,21 @@ func lockAcquireFlags() []cli.Flag {
 				Usage:  "Sets a maximum duration to wait for a lock before giving up",
 				EnvVar: "BUILDKITE_LOCK_WAIT_TIMEOUT",
 			},
+			cli.DurationFlag{
+				Name:   "lease-ttl",
+				Usage:  "Acquires the lock under a lease that expires unless renewed within this duration (default: no lease)",
+				EnvVar: "BUILDKITE_LOCK_LEASE_TTL",
+			},
+			cli.IntFlag{
+				Name:   "permits",
+				Usage:  "Acquires a permit from a counting semaphore with this many permits, instead of a lock. Only available in the machine scope (default: acquire a lock)",
+				EnvVar: "BUILDKITE_LOCK_PERMITS",
+			},
+			cli.BoolFlag{
+				Name:   "release-on-exit",
+				Usage:  "Releases the lock or permit as soon as the process that ran ′lock acquire′ exits. Don't use with ′$(...)′, since the subshell exits straight away",
+				EnvVar: "BUILDKITE_LOCK_RELEASE_ON_EXIT",
+			},
 		},
 		lockCommonFlags...,
 	)
@@ -81,8 +138,11 @@ func lockAcquireAction(c *cli.Context) error {
 	ctx, cfg, _, _, done := setupLoggerAndConfig[LockAcquireConfig](context.Background(), c)
 	defer done()
 
-	if cfg.LockScope != "machine" {
-		return errors.New("only 'machine' scope for locks is supported in this version.")
+	if err := checkLockScope(cfg.LockScope); err != nil {
+		return err
+	}
+	if err := checkPermits(cfg.Permits, cfg.LockScope); err != nil {
+		return err
 	}
 
 	if cfg.LockWaitTimeout != 0 {
@@ -91,16 +151,49 @@ func lockAcquireAction(c *cli.Context) error {
 		ctx = cctx
 	}
 
-	client, err := lock.NewClient(ctx, cfg.SocketsPath)
+	client, err := lock.NewScopedClient(ctx, cfg.SocketsPath, cfg.LockScope)
 	if err != nil {
 		return fmt.Errorf(lockClientErrMessage, err)
 	}
 
-	token, err := client.Lock(ctx, key)
-	if err != nil {
-		return fmt.Errorf("could not acquire lock: %w", err)
+	// This process exits as soon as the lock is acquired, so the holder is
+	// the process that ran it. Unless asked to, the agent doesn't release the
+	// lock when that process exits: it's often a subshell, or a hook that
+	// leaves the lock for a later hook to release.
+	opts := []lock.LockOption{lock.WithHolderPID(os.Getppid())}
+	if cfg.LeaseTTL > 0 {
+		opts = append(opts, lock.WithLease(cfg.LeaseTTL))
+	}
+	if cfg.ReleaseOnExit {
+		opts = append(opts, lock.WithReleaseOnExit())
+	}
+
+	var token string
+	if cfg.Permits > 0 {
+		token, err = client.Semaphore(key, cfg.Permits).Acquire(ctx, opts...)
+		if err != nil {
+			return fmt.Errorf("could not acquire permit: %w", err)
+		}
+	} else {
+		token, err = client.Lock(ctx, key, opts...)
+		if err != nil {
+			return fmt.Errorf("could not acquire lock: %w", err)
+		}
 	}
 
 	_, err = fmt.Fprintln(c.App.Writer, token)
 	return err
 }
+
+// checkPermits returns an error if --permits is invalid, or can't be used in
+// the lock scope.
+func checkPermits(permits int, scope string) error {
+	switch {
+	case permits < 0:
+		return errors.New("--permits must not be negative")
+	case permits > 0 && scope == lock.ClusterScope:
+		return fmt.Errorf("--permits can't be used with --lock-scope %s, since semaphores are only available in the %s scope", lock.ClusterScope, lock.MachineScope)
+	default:
+		return nil
+	}
+}
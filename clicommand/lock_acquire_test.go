@@ -0,0 +1,29 @@
+package clicommand
+
+import (
+	"testing"
+
+	"github.com/buildkite/agent/v3/lock"
+)
+
+func TestCheckPermits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		permits int
+		scope   string
+		wantErr bool
+	}{
+		{permits: 0, scope: lock.MachineScope},
+		{permits: 0, scope: lock.ClusterScope},
+		{permits: 2, scope: lock.MachineScope},
+		{permits: 2, scope: lock.ClusterScope, wantErr: true},
+		{permits: -1, scope: lock.MachineScope, wantErr: true},
+	}
+
+	for _, test := range tests {
+		if err := checkPermits(test.permits, test.scope); (err != nil) != test.wantErr {
+			t.Errorf("checkPermits(%d, %q) error = %v, want error %t", test.permits, test.scope, err, test.wantErr)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package clicommand
 
-import "github.com/urfave/cli"
+import (
+	"fmt"
+
+	"github.com/buildkite/agent/v3/lock"
+	"github.com/urfave/cli"
+)
 
 const lockClientErrMessage = `Could not connect to Agent API: %v
 This command can only be used when at least one agent is running with the
@@ -12,7 +17,7 @@ var lockCommonFlags = []cli.Flag{
 	cli.StringFlag{
 		Name:   "lock-scope",
 		Value:  "machine",
-		Usage:  "The scope for locks used in this operation. Currently only 'machine' scope is supported",
+		Usage:  "The scope for locks used in this operation: 'machine' (shared by agents on this host) or 'cluster' (shared by agents using the same --lock-cluster-backend)",
 		EnvVar: "BUILDKITE_LOCK_SCOPE",
 	},
 	cli.StringFlag{
@@ -22,3 +27,13 @@ var lockCommonFlags = []cli.Flag{
 		EnvVar: "BUILDKITE_SOCKETS_PATH",
 	},
 }
+
+// checkLockScope returns an error if scope is not a supported lock scope.
+func checkLockScope(scope string) error {
+	switch scope {
+	case lock.MachineScope, lock.ClusterScope:
+		return nil
+	default:
+		return fmt.Errorf("unknown lock scope %q (expected %q or %q)", scope, lock.MachineScope, lock.ClusterScope)
+	}
+}
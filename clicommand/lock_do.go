@@ -2,7 +2,6 @@ package clicommand
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"time"
 
@@ -86,8 +85,8 @@ func lockDoAction(c *cli.Context) error {
 	ctx, cfg, _, _, done := setupLoggerAndConfig[LockDoConfig](context.Background(), c)
 	defer done()
 
-	if cfg.LockScope != "machine" {
-		return errors.New("only 'machine' scope for locks is supported in this version.")
+	if err := checkLockScope(cfg.LockScope); err != nil {
+		return err
 	}
 
 	if cfg.LockWaitTimeout != 0 {
@@ -96,7 +95,7 @@ func lockDoAction(c *cli.Context) error {
 		ctx = cctx
 	}
 
-	client, err := lock.NewClient(ctx, cfg.SocketsPath)
+	client, err := lock.NewScopedClient(ctx, cfg.SocketsPath, cfg.LockScope)
 	if err != nil {
 		return fmt.Errorf(lockClientErrMessage, err)
 	}
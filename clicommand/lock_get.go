@@ -2,7 +2,6 @@ package clicommand
 
 import (
 	"context"
-	"errors"
 	"fmt"
 
 	"github.com/buildkite/agent/v3/lock"
@@ -61,11 +60,11 @@ func lockGetAction(c *cli.Context) error {
 	ctx, cfg, _, _, done := setupLoggerAndConfig[LockGetConfig](context.Background(), c)
 	defer done()
 
-	if cfg.LockScope != "machine" {
-		return errors.New("only 'machine' scope for locks is supported in this version.")
+	if err := checkLockScope(cfg.LockScope); err != nil {
+		return err
 	}
 
-	client, err := lock.NewClient(ctx, cfg.SocketsPath)
+	client, err := lock.NewScopedClient(ctx, cfg.SocketsPath, cfg.LockScope)
 	if err != nil {
 		return fmt.Errorf(lockClientErrMessage, err)
 	}
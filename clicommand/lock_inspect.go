@@ -0,0 +1,99 @@
+package clicommand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buildkite/agent/v3/lock"
+	"github.com/urfave/cli"
+)
+
+const lockInspectHelpDescription = `Usage:
+
+    buildkite-agent lock inspect [key]
+
+Description:
+
+Describes the state of a lock key: its value, how long it has had that value,
+when its lease expires, and which process holds it (for locks acquired with
+′lock acquire′). Any key not in use has an empty value.
+
+If a semaphore with the same key is in use (see ′lock acquire --permits′),
+its permits and holders are described too.
+
+Note that this subcommand is only available when an agent has been started
+with the ′agent-api′ experiment enabled.
+
+Examples:
+
+    $ buildkite-agent lock inspect llama
+    Key:     llama
+    Value:   acquired(pid=1234,otp=0f2c1a6e9d0b4c5e8a7f3b2d1c0e9f8a)
+    Age:     42s
+    Lease:   18s
+    Holder:  1234 (running)`
+
+type LockInspectConfig struct {
+	// Common config options
+	LockScope   string `cli:"lock-scope"`
+	SocketsPath string `cli:"sockets-path" normalize:"filepath"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var LockInspectCommand = cli.Command{
+	Name:        "inspect",
+	Usage:       "Describes a lock held by the agent leader",
+	Description: lockInspectHelpDescription,
+	Flags:       append(globalFlags(), lockCommonFlags...),
+	Action:      lockInspectAction,
+}
+
+func lockInspectAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		fmt.Fprint(c.App.ErrWriter, lockInspectHelpDescription)
+		return &SilentExitError{code: 1}
+	}
+	key := c.Args()[0]
+
+	ctx, cfg, _, _, done := setupLoggerAndConfig[LockInspectConfig](context.Background(), c)
+	defer done()
+
+	if cfg.LockScope != lock.MachineScope {
+		return errors.New("only 'machine' scope locks can be listed and inspected")
+	}
+
+	client, err := lock.NewClient(ctx, cfg.SocketsPath)
+	if err != nil {
+		return fmt.Errorf(lockClientErrMessage, err)
+	}
+
+	info, err := client.Inspect(ctx, key)
+	if err != nil {
+		return fmt.Errorf("couldn't inspect lock: %w", err)
+	}
+
+	now := time.Now()
+	fmt.Fprintf(c.App.Writer, "Key:     %s\n", info.Key)
+	fmt.Fprintf(c.App.Writer, "Value:   %s\n", info.Value)
+	fmt.Fprintf(c.App.Writer, "Age:     %s\n", lockAge(*info, now))
+	fmt.Fprintf(c.App.Writer, "Lease:   %s\n", lockLease(*info, now))
+	fmt.Fprintf(c.App.Writer, "Holder:  %s\n", lockHolder(*info))
+
+	sem, err := client.InspectSemaphore(ctx, key)
+	if err != nil {
+		return fmt.Errorf("couldn't inspect semaphore: %w", err)
+	}
+	if sem.Permits == 0 {
+		return nil
+	}
+	fmt.Fprintf(c.App.Writer, "\nSemaphore permits: %d (%d held, %d waiting)\n", sem.Permits, len(sem.Holders), sem.Waiting)
+	return writeLockTable(c.App.Writer, sem.Holders, now)
+}
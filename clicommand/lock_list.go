@@ -0,0 +1,147 @@
+package clicommand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/agentapi"
+	"github.com/buildkite/agent/v3/lock"
+	"github.com/urfave/cli"
+)
+
+const lockListHelpDescription = `Usage:
+
+    buildkite-agent lock list
+
+Description:
+
+Lists the locks currently in use, with their values, how long they have been
+held, when their leases expire, and which process holds them (for locks
+acquired with ′lock acquire′). Semaphores in use (see ′lock acquire --permits′)
+are listed afterwards.
+
+Note that this subcommand is only available when an agent has been started
+with the ′agent-api′ experiment enabled.
+
+Examples:
+
+    $ buildkite-agent lock list
+    KEY     VALUE                                                  AGE   LEASE  HOLDER
+    llama   acquired(pid=1234,otp=0f2c1a6e9d0b4c5e8a7f3b2d1c0e9f8a)  42s   18s    1234 (running)
+    alpaca  done                                                   5m3s  -      -
+
+    SEMAPHORE     PERMITS  HELD  WAITING  HOLDERS
+    docker-build  2        2     1        1301 (running), 1377 (running)`
+
+type LockListConfig struct {
+	// Common config options
+	LockScope   string `cli:"lock-scope"`
+	SocketsPath string `cli:"sockets-path" normalize:"filepath"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var LockListCommand = cli.Command{
+	Name:        "list",
+	Usage:       "Lists the locks held by the agent leader",
+	Description: lockListHelpDescription,
+	Flags:       append(globalFlags(), lockCommonFlags...),
+	Action:      lockListAction,
+}
+
+func lockListAction(c *cli.Context) error {
+	if c.NArg() != 0 {
+		fmt.Fprint(c.App.ErrWriter, lockListHelpDescription)
+		return &SilentExitError{code: 1}
+	}
+
+	ctx, cfg, _, _, done := setupLoggerAndConfig[LockListConfig](context.Background(), c)
+	defer done()
+
+	if cfg.LockScope != lock.MachineScope {
+		return errors.New("only 'machine' scope locks can be listed and inspected")
+	}
+
+	client, err := lock.NewClient(ctx, cfg.SocketsPath)
+	if err != nil {
+		return fmt.Errorf(lockClientErrMessage, err)
+	}
+
+	locks, sems, err := client.List(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't list locks: %w", err)
+	}
+
+	if err := writeLockTable(c.App.Writer, locks, time.Now()); err != nil {
+		return err
+	}
+	if len(sems) == 0 {
+		return nil
+	}
+	fmt.Fprintln(c.App.Writer)
+	return writeSemaphoreTable(c.App.Writer, sems)
+}
+
+// writeLockTable writes a table describing locks to w.
+func writeLockTable(w io.Writer, locks []agentapi.LockInfo, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tAGE\tLEASE\tHOLDER")
+	for _, l := range locks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			l.Key, l.Value, lockAge(l, now), lockLease(l, now), lockHolder(l))
+	}
+	return tw.Flush()
+}
+
+// writeSemaphoreTable writes a table describing semaphores to w.
+func writeSemaphoreTable(w io.Writer, sems []agentapi.SemaphoreInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEMAPHORE\tPERMITS\tHELD\tWAITING\tHOLDERS")
+	for _, s := range sems {
+		holders := make([]string, 0, len(s.Holders))
+		for _, h := range s.Holders {
+			holders = append(holders, lockHolder(h))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n",
+			s.Key, s.Permits, len(s.Holders), s.Waiting, strings.Join(holders, ", "))
+	}
+	return tw.Flush()
+}
+
+// lockAge describes how long a lock has had its value.
+func lockAge(l agentapi.LockInfo, now time.Time) string {
+	if l.Since.IsZero() {
+		return "-"
+	}
+	return now.Sub(l.Since).Round(time.Second).String()
+}
+
+// lockLease describes how long remains on a lock's lease.
+func lockLease(l agentapi.LockInfo, now time.Time) string {
+	if l.Expires == nil {
+		return "-"
+	}
+	return l.Expires.Sub(now).Round(time.Second).String()
+}
+
+// lockHolder describes the process holding a lock.
+func lockHolder(l agentapi.LockInfo) string {
+	if l.HolderPID == 0 {
+		return "-"
+	}
+	state := "running"
+	if l.HolderAlive != nil && !*l.HolderAlive {
+		state = "not running"
+	}
+	return fmt.Sprintf("%d (%s)", l.HolderPID, state)
+}
@@ -19,6 +19,9 @@ process that acquired the lock. To help prevent different processes unlocking
 each other unintentionally, the output from ′lock acquire′ is required as the
 second argument, namely, the ′token′ in the Usage section above.
 
+Semaphore permits acquired with ′lock acquire --permits′ are released the
+same way.
+
 Note that this subcommand is only available when an agent has been started
 with the ′agent-api′ experiment enabled.
 
@@ -60,11 +63,11 @@ func lockReleaseAction(c *cli.Context) error {
 	ctx, cfg, _, _, done := setupLoggerAndConfig[LockReleaseConfig](context.Background(), c)
 	defer done()
 
-	if cfg.LockScope != "machine" {
-		return fmt.Errorf("only 'machine' scope for locks is supported in this version.")
+	if err := checkLockScope(cfg.LockScope); err != nil {
+		return err
 	}
 
-	client, err := lock.NewClient(ctx, cfg.SocketsPath)
+	client, err := lock.NewScopedClient(ctx, cfg.SocketsPath, cfg.LockScope)
 	if err != nil {
 		return fmt.Errorf(lockClientErrMessage, err)
 	}
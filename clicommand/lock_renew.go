@@ -0,0 +1,105 @@
+package clicommand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buildkite/agent/v3/lock"
+	"github.com/urfave/cli"
+)
+
+const lockRenewHelpDescription = `Usage:
+
+    buildkite-agent lock renew [key] [token]
+
+Description:
+
+Renews the lease on a lock acquired with ′lock acquire --lease-ttl′, so that it
+expires ′--lease-ttl′ from now. The output from ′lock acquire′ is required as
+the second argument, namely, the ′token′ in the Usage section above.
+
+′lock renew′ fails if the lock is no longer held with that token, for example
+because the lease already expired.
+
+Leases on semaphore permits acquired with ′lock acquire --permits′ are renewed
+the same way.
+
+Note that this subcommand is only available when an agent has been started
+with the ′agent-api′ experiment enabled.
+
+Examples:
+
+    #!/bin/bash
+    token=$(buildkite-agent lock acquire --lease-ttl 30s llama)
+    while sleep 10; do buildkite-agent lock renew --lease-ttl 30s llama "${token}"; done &
+    # your critical section here...
+    kill $!
+    buildkite-agent lock release llama "${token}"`
+
+type LockRenewConfig struct {
+	// Common config options
+	LockScope   string `cli:"lock-scope"`
+	SocketsPath string `cli:"sockets-path" normalize:"filepath"`
+
+	LeaseTTL time.Duration `cli:"lease-ttl"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+func lockRenewFlags() []cli.Flag {
+	flags := append(
+		[]cli.Flag{
+			cli.DurationFlag{
+				Name:   "lease-ttl",
+				Value:  30 * time.Second,
+				Usage:  "How long from now the renewed lease lasts",
+				EnvVar: "BUILDKITE_LOCK_LEASE_TTL",
+			},
+		},
+		lockCommonFlags...,
+	)
+	return append(flags, globalFlags()...)
+}
+
+var LockRenewCommand = cli.Command{
+	Name:        "renew",
+	Usage:       "Renews the lease on a lock from the agent leader",
+	Description: lockRenewHelpDescription,
+	Flags:       lockRenewFlags(),
+	Action:      lockRenewAction,
+}
+
+func lockRenewAction(c *cli.Context) error {
+	if c.NArg() != 2 {
+		fmt.Fprint(c.App.ErrWriter, lockRenewHelpDescription)
+		return &SilentExitError{code: 1}
+	}
+	key, token := c.Args()[0], c.Args()[1]
+
+	ctx, cfg, _, _, done := setupLoggerAndConfig[LockRenewConfig](context.Background(), c)
+	defer done()
+
+	if err := checkLockScope(cfg.LockScope); err != nil {
+		return err
+	}
+	if cfg.LeaseTTL <= 0 {
+		return errors.New("--lease-ttl must be positive")
+	}
+
+	client, err := lock.NewScopedClient(ctx, cfg.SocketsPath, cfg.LockScope)
+	if err != nil {
+		return fmt.Errorf(lockClientErrMessage, err)
+	}
+
+	if _, err := client.Renew(ctx, key, token, cfg.LeaseTTL); err != nil {
+		return fmt.Errorf("could not renew lease: %w", err)
+	}
+	return nil
+}
@@ -87,7 +87,7 @@ type PipelineUploadConfig struct {
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
-	AgentAccessToken string `cli:"agent-access-token"` // required, but not in dry-run mode
+	AgentAccessToken string `cli:"agent-access-token"` // required (unless the Job API is available), but not in dry-run mode
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
 }
@@ -334,13 +334,13 @@ var PipelineUploadCommand = cli.Command{
 			return errors.New("missing job parameter. Usually this is set in the environment for a Buildkite job via BUILDKITE_JOB_ID.")
 		}
 
-		// Check we have an agent access token if not in dry run
-		if cfg.AgentAccessToken == "" {
-			return errors.New("missing agent-access-token parameter. Usually this is set in the environment for a Buildkite job via BUILDKITE_AGENT_ACCESS_TOKEN.")
+		// Check we have an agent access token (or the Job API) if not in dry run
+		if err := requireAgentAccessToken(cfg.AgentAccessToken); err != nil {
+			return err
 		}
 
 		uploader := &agent.PipelineUploader{
-			Client: api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken")),
+			Client: api.NewClient(l, loadJobAPIClientConfig(cfg, "AgentAccessToken")),
 			JobID:  cfg.Job,
 			Change: &api.PipelineChange{
 				UUID:     api.NewUUID(),
@@ -411,11 +411,17 @@ func (cfg *PipelineUploadConfig) parseAndInterpolate(ctx context.Context, src st
 		return nil, fmt.Errorf("pipeline parsing of %q failed: %w", src, err)
 	}
 	if !cfg.NoInterpolation {
-		if tracing, has := environ.Get(tracetools.EnvVarTraceContextKey); has {
-			if result.Env == nil {
-				result.Env = ordered.NewMap[string, string](1)
+		for _, key := range []string{
+			tracetools.EnvVarTraceContextKey,
+			tracetools.EnvVarTraceParentKey,
+			tracetools.EnvVarTraceStateKey,
+		} {
+			if tracing, has := environ.Get(key); has {
+				if result.Env == nil {
+					result.Env = ordered.NewMap[string, string](1)
+				}
+				result.Env.Set(key, tracing)
 			}
-			result.Env.Set(tracetools.EnvVarTraceContextKey, tracing)
 		}
 		preferRuntimeEnv := experiments.IsEnabled(ctx, experiments.InterpolationPrefersRuntimeEnv)
 		if err := result.Interpolate(environ, preferRuntimeEnv); err != nil {
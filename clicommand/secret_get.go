@@ -3,8 +3,10 @@ package clicommand
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/internal/secrets"
 	"github.com/buildkite/agent/v3/jobapi"
 	"github.com/urfave/cli"
 )
@@ -14,6 +16,8 @@ type SecretGetConfig struct {
 	Job           string `cli:"job" validate:"required"`
 	SkipRedaction bool   `cli:"skip-redaction"`
 
+	SecretsProviders []string `cli:"secrets-providers" normalize:"list"`
+
 	// Global flags
 	Debug       bool     `cli:"debug"`
 	LogLevel    string   `cli:"log-level"`
@@ -23,7 +27,7 @@ type SecretGetConfig struct {
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	AgentAccessToken string `cli:"agent-access-token"` // required, unless the Job API is available
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
 }
@@ -42,6 +46,25 @@ specified in this command is the key's name defined for the secret in its
 cluster. The key's name is case insensitive in this command, and the
 key's value is automatically redacted in the build logs.
 
+Secrets can also be read from other providers, chosen by the prefix of the
+key, with the agent's ′--secrets-providers′ option. For example, with
+′--secrets-providers "vault/=vault+https://vault.example.com:8200/secret"′,
+the key ′vault/prod/db#password′ is read from the password field of the
+prod/db secret in Vault, using the token in ′VAULT_TOKEN′. Providers are:
+
+    file:///path/to/secrets.yml?key=/path/to/jwks.json
+        A YAML file mapping keys to JWE-encrypted values, which are
+        decrypted with a key from the JWKS file.
+    command:///path/to/program?arg=...
+        Runs the program with any args followed by the key, and reads the
+        value from stdout. The program should exit 2 if there is no such
+        secret.
+    vault+https://host:port/mount?token-env=VAULT_TOKEN&namespace=...
+        Reads from a HashiCorp Vault KV version 2 secrets engine. Keys are
+        of the form ′path′ or ′path#field′ (the default field is ′value′).
+    buildkite:
+        Reads from Buildkite secrets.
+
 Examples:
 
 The following examples reference the same Buildkite secret ′key′:
@@ -59,6 +82,7 @@ The following examples reference the same Buildkite secret ′key′:
 			Usage:  "Skip redacting the retrieved secret from the logs. Then, the command will print the secret to the Job's logs if called directly.",
 			EnvVar: "BUILDKITE_AGENT_SECRET_GET_SKIP_SECRET_REDACTION",
 		},
+		SecretsProvidersFlag,
 
 		// API Flags
 		AgentAccessTokenFlag,
@@ -78,8 +102,21 @@ The following examples reference the same Buildkite secret ′key′:
 		ctx, cfg, l, _, done := setupLoggerAndConfig[SecretGetConfig](ctx, c)
 		defer done()
 
-		agentClient := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
-		secret, _, err := agentClient.GetSecret(ctx, &api.GetSecretRequest{Key: cfg.Key, JobID: cfg.Job})
+		if err := requireAgentAccessToken(cfg.AgentAccessToken); err != nil {
+			return err
+		}
+
+		agentClient := api.NewClient(l, loadJobAPIClientConfig(cfg, "AgentAccessToken"))
+		provider, err := secrets.ParseRouter(
+			cfg.SecretsProviders,
+			&secrets.BuildkiteProvider{Client: agentClient, JobID: cfg.Job},
+			os.Getenv,
+		)
+		if err != nil {
+			return err
+		}
+
+		value, err := provider.Get(ctx, cfg.Key)
 		if err != nil {
 			return err
 		}
@@ -90,7 +127,7 @@ The following examples reference the same Buildkite secret ′key′:
 		}
 
 		if !cfg.SkipRedaction {
-			if err := AddToRedactor(ctx, l, jobClient, secret.Value); err != nil {
+			if err := AddToRedactor(ctx, l, jobClient, value); err != nil {
 				if cfg.Debug {
 					return err
 				}
@@ -98,7 +135,7 @@ The following examples reference the same Buildkite secret ′key′:
 			}
 		}
 
-		_, err = fmt.Fprintln(c.App.Writer, secret.Value)
+		_, err = fmt.Fprintln(c.App.Writer, value)
 
 		return err
 	},
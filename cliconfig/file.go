@@ -16,11 +16,32 @@ type File struct {
 
 	// A map of key/values that was loaded from the file
 	Config map[string]string
+
+	// Values that were given as lists in YAML files, which are used as is
+	// for options that take a list, rather than being split on commas
+	Lists map[string][]string
+
+	// Config options for particular queues, from the queues: sections of
+	// YAML files, keyed by queue name
+	Queues map[string]Overrides
+
+	// The file (and queue) that each value was loaded from, which may be an
+	// included file or one in conf.d rather than Path
+	Sources map[string]Source
 }
 
+// Load loads the config file. The file can either be in the key=value format,
+// or YAML if it has a .yml or .yaml extension. YAML files can include other
+// YAML files. Whichever format the config file is in, any YAML files in the
+// conf.d directory next to it are loaded after it, in order of their names,
+// overriding it. Other files in conf.d are ignored, so existing installs that
+// happen to keep something else there aren't affected.
 func (f *File) Load() error {
 	// Set the default config
 	f.Config = map[string]string{}
+	f.Lists = map[string][]string{}
+	f.Queues = map[string]Overrides{}
+	f.Sources = map[string]Source{}
 
 	// Figure out the absolute path
 	absolutePath, err := f.AbsolutePath()
@@ -28,6 +49,61 @@ func (f *File) Load() error {
 		return fmt.Errorf("getting absolute path for %s: %w", f.Path, err)
 	}
 
+	if isYAML(absolutePath) {
+		err = f.loadYAML(absolutePath, nil)
+	} else {
+		err = f.loadKeyValues(absolutePath)
+	}
+	if err != nil {
+		return err
+	}
+
+	overlays, err := overlayPaths(absolutePath)
+	if err != nil {
+		return fmt.Errorf("finding config files in %s: %w", overlayDir, err)
+	}
+	for _, overlay := range overlays {
+		if err := f.loadYAML(overlay, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyQueue applies the config options for the given queue, if there are
+// any, over the other options.
+func (f *File) ApplyQueue(queue string) {
+	overrides, ok := f.Queues[queue]
+	if !ok {
+		return
+	}
+	for key, value := range overrides.Config {
+		delete(f.Lists, key)
+		f.Config[key] = value
+	}
+	for key, list := range overrides.Lists {
+		delete(f.Config, key)
+		f.Lists[key] = list
+	}
+	for key, source := range overrides.Sources {
+		f.Sources[key] = source
+	}
+}
+
+// Tags returns the tags option from the file.
+func (f *File) Tags() []string {
+	if tags, ok := f.Lists["tags"]; ok {
+		return tags
+	}
+	if tags, ok := f.Config["tags"]; ok {
+		return strings.Split(tags, ",")
+	}
+	return nil
+}
+
+// loadKeyValues loads a config file in the key=value format.
+func (f *File) loadKeyValues(absolutePath string) error {
 	// Open the file
 	file, err := os.Open(absolutePath)
 	if err != nil {
@@ -53,6 +129,7 @@ func (f *File) Load() error {
 			}
 
 			f.Config[key] = value
+			f.Sources[key] = Source{Kind: SourceFile, Name: absolutePath}
 		}
 	}
 
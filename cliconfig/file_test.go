@@ -0,0 +1,340 @@
+package cliconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+	"github.com/urfave/cli"
+)
+
+// writeFiles writes files (keyed by their path relative to dir) into dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("os.MkdirAll(%q) error = %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+		}
+	}
+}
+
+func TestFileLoadYAML(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"buildkite-agent.yml": strings.Join([]string{
+			"token: llamas",
+			"name: agent-%spawn",
+			"include: common/*.yml",
+			"tags:",
+			"  - queue=deploy",
+			"  - os=linux,arch=amd64",
+			"queues:",
+			"  deploy:",
+			"    spawn: 4",
+			"  default:",
+			"    spawn: 2",
+		}, "\n"),
+		"common/plugins.yml": strings.Join([]string{
+			"name: overridden",
+			"no-plugins: false",
+			"allowed-plugins: [docker, docker-compose]",
+		}, "\n"),
+		"conf.d/10-redact.yml": "redacted-vars: ['*_TOKEN', '*_SECRET']\n",
+		"conf.d/20-plugins.yaml": strings.Join([]string{
+			"no-plugins: true",
+			"queues:",
+			"  deploy:",
+			"    spawn: 8",
+		}, "\n"),
+		"conf.d/ignored.cfg": "token=ignored\n",
+	})
+
+	f := File{Path: filepath.Join(dir, "buildkite-agent.yml")}
+	if err := f.Load(); err != nil {
+		t.Fatalf("f.Load() error = %v", err)
+	}
+
+	wantConfig := map[string]string{
+		"token":      "llamas",
+		"name":       "agent-%spawn",
+		"no-plugins": "true",
+	}
+	if diff := cmp.Diff(f.Config, wantConfig); diff != "" {
+		t.Errorf("f.Config diff (-got +want):\n%s", diff)
+	}
+
+	wantLists := map[string][]string{
+		"tags":            {"queue=deploy", "os=linux,arch=amd64"},
+		"allowed-plugins": {"docker", "docker-compose"},
+		"redacted-vars":   {"*_TOKEN", "*_SECRET"},
+	}
+	if diff := cmp.Diff(f.Lists, wantLists); diff != "" {
+		t.Errorf("f.Lists diff (-got +want):\n%s", diff)
+	}
+
+	if got, want := queueFromTags(f.Tags()), "deploy"; got != want {
+		t.Errorf("queueFromTags(f.Tags()) = %q, want %q", got, want)
+	}
+	f.ApplyQueue("deploy")
+	if got, want := f.Config["spawn"], "8"; got != want {
+		t.Errorf("after f.ApplyQueue(deploy), f.Config[spawn] = %q, want %q", got, want)
+	}
+}
+
+func TestFileLoadYAMLErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:    "include cycle",
+			files:   map[string]string{"buildkite-agent.yml": "include: other.yml\n", "other.yml": "include: buildkite-agent.yml\n"},
+			wantErr: "includes itself",
+		},
+		{
+			name:    "missing include",
+			files:   map[string]string{"buildkite-agent.yml": "include: missing.yml\n"},
+			wantErr: "doesn't exist",
+		},
+		{
+			name:    "nested map",
+			files:   map[string]string{"buildkite-agent.yml": "tags:\n  queue: default\n"},
+			wantErr: "line 2: tags: expected a value or a list of values",
+		},
+		{
+			name:    "not a map",
+			files:   map[string]string{"buildkite-agent.yml": "- token\n"},
+			wantErr: "expected a map of config options",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			writeFiles(t, dir, test.files)
+			f := File{Path: filepath.Join(dir, "buildkite-agent.yml")}
+			err := f.Load()
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("f.Load() error = %v, want an error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileLoadKeyValuesWithOverlays(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"buildkite-agent.cfg":     "token=\"llamas\"\ntags=queue=default,os=linux\n",
+		"conf.d/tags.yml":         "tags: [queue=deploy]\n",
+		"conf.d/old.cfg":          "token=ignored\n",
+		"conf.d/README":           "not a config file\n",
+		"conf.d/nested/other.yml": "token: ignored\n",
+	})
+
+	f := File{Path: filepath.Join(dir, "buildkite-agent.cfg")}
+	if err := f.Load(); err != nil {
+		t.Fatalf("f.Load() error = %v", err)
+	}
+
+	// Only the YAML files directly in conf.d are loaded.
+	if diff := cmp.Diff(f.Config, map[string]string{"token": "llamas"}); diff != "" {
+		t.Errorf("f.Config diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(f.Tags(), []string{"queue=deploy"}); diff != "" {
+		t.Errorf("f.Tags() diff (-got +want):\n%s", diff)
+	}
+}
+
+type testConfig struct {
+	Config         string   `cli:"config"`
+	Token          string   `cli:"token"`
+	Spawn          int      `cli:"spawn"`
+	Tags           []string `cli:"tags" normalize:"list"`
+	AllowedPlugins []string `cli:"allowed-plugins" normalize:"list"`
+}
+
+// loadTestConfig loads a testConfig with the Loader from the given command
+// line arguments.
+func loadTestConfig(t *testing.T, args ...string) (testConfig, *Loader) {
+	t.Helper()
+
+	var cfg testConfig
+	var loader *Loader
+	app := cli.NewApp()
+	app.Name = "buildkite-agent"
+	app.Commands = []cli.Command{{
+		Name: "start",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config"},
+			cli.StringFlag{Name: "token", EnvVar: "CLICONFIG_TEST_TOKEN"},
+			cli.IntFlag{Name: "spawn", Value: 1},
+			cli.StringSliceFlag{Name: "tags", Value: &cli.StringSlice{}},
+			cli.StringSliceFlag{Name: "allowed-plugins", Value: &cli.StringSlice{}},
+		},
+		Action: func(c *cli.Context) error {
+			loader = &Loader{CLI: c, Config: &cfg, Logger: logger.Discard}
+			_, err := loader.Load()
+			return err
+		},
+	}}
+	if err := app.Run(append([]string{"buildkite-agent", "start"}, args...)); err != nil {
+		t.Fatalf("app.Run(%q) error = %v", args, err)
+	}
+	return cfg, loader
+}
+
+func TestLoaderYAML(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "buildkite-agent.yml")
+	writeFiles(t, dir, map[string]string{
+		"buildkite-agent.yml": strings.Join([]string{
+			"token: llamas",
+			"tags: [queue=deploy, os=linux]",
+			"allowed-plugins: [docker]",
+			"queues:",
+			"  deploy:",
+			"    spawn: 4",
+			"    allowed-plugins: [docker, ecr]",
+			"  build:",
+			"    spawn: 8",
+		}, "\n"),
+	})
+
+	tests := []struct {
+		name string
+		args []string
+		want testConfig
+	}{
+		{
+			name: "queue from config file",
+			args: []string{"--config", config},
+			want: testConfig{
+				Config:         config,
+				Token:          "llamas",
+				Spawn:          4,
+				Tags:           []string{"queue=deploy", "os=linux"},
+				AllowedPlugins: []string{"docker", "ecr"},
+			},
+		},
+		{
+			name: "queue from command line",
+			args: []string{"--config", config, "--tags", "queue=build"},
+			want: testConfig{
+				Config:         config,
+				Token:          "llamas",
+				Spawn:          8,
+				Tags:           []string{"queue=build"},
+				AllowedPlugins: []string{"docker"},
+			},
+		},
+		{
+			name: "command line overrides queue options",
+			args: []string{"--config", config, "--spawn", "2"},
+			want: testConfig{
+				Config:         config,
+				Token:          "llamas",
+				Spawn:          2,
+				Tags:           []string{"queue=deploy", "os=linux"},
+				AllowedPlugins: []string{"docker", "ecr"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, _ := loadTestConfig(t, test.args...)
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("loaded config diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoaderSources(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "buildkite-agent.yml")
+	writeFiles(t, dir, map[string]string{
+		"buildkite-agent.yml": strings.Join([]string{
+			"token: llamas",
+			"tags: [queue=deploy]",
+			"queues:",
+			"  deploy:",
+			"    spawn: 4",
+		}, "\n"),
+		"conf.d/plugins.yml": "allowed-plugins: [docker]\n",
+	})
+
+	tests := []struct {
+		name string
+		env  string
+		args []string
+		want map[string]Source
+	}{
+		{
+			name: "config file",
+			args: []string{"--config", config},
+			want: map[string]Source{
+				"config":          {Kind: SourceFlag, Name: "config"},
+				"token":           {Kind: SourceFile, Name: config},
+				"spawn":           {Kind: SourceFile, Name: config, Queue: "deploy"},
+				"tags":            {Kind: SourceFile, Name: config},
+				"allowed-plugins": {Kind: SourceFile, Name: filepath.Join(dir, "conf.d", "plugins.yml")},
+			},
+		},
+		{
+			name: "env and flags override the config file",
+			env:  "alpacas",
+			args: []string{"--config", config, "--spawn", "2", "--tags", "queue=build"},
+			want: map[string]Source{
+				"config":          {Kind: SourceFlag, Name: "config"},
+				"token":           {Kind: SourceEnv, Name: "CLICONFIG_TEST_TOKEN"},
+				"spawn":           {Kind: SourceFlag, Name: "spawn"},
+				"tags":            {Kind: SourceFlag, Name: "tags"},
+				"allowed-plugins": {Kind: SourceFile, Name: filepath.Join(dir, "conf.d", "plugins.yml")},
+			},
+		},
+		{
+			name: "defaults",
+			want: map[string]Source{
+				"config":          {Kind: SourceDefault},
+				"token":           {Kind: SourceDefault},
+				"spawn":           {Kind: SourceDefault},
+				"tags":            {Kind: SourceDefault},
+				"allowed-plugins": {Kind: SourceDefault},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.env != "" {
+				t.Setenv("CLICONFIG_TEST_TOKEN", test.env)
+			}
+
+			_, loader := loadTestConfig(t, test.args...)
+			if diff := cmp.Diff(loader.Sources, test.want); diff != "" {
+				t.Errorf("loader.Sources diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
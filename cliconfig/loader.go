@@ -8,6 +8,7 @@ import (
 	"os"
 	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -33,6 +34,58 @@ type Loader struct {
 
 	// The file that was used when loading this configuration
 	File *File
+
+	// If true, Load carries on after a field fails validation, and the
+	// validation errors are returned in ValidationErrors instead
+	ContinueOnValidationError bool
+
+	// The errors from fields that failed validation, when
+	// ContinueOnValidationError is true
+	ValidationErrors []error
+
+	// Where the value of each config option came from, keyed by the option's
+	// cli name. It's filled in by Load.
+	Sources map[string]Source
+}
+
+// The kinds of Source.
+const (
+	SourceDefault = "default"
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceArg     = "arg"
+)
+
+// Source describes where the value of a config option came from.
+type Source struct {
+	// Kind is one of the Source* constants
+	Kind string `json:"kind"`
+
+	// Name is the flag, environment variable, config file path or argument
+	// position that the value came from
+	Name string `json:"name,omitempty"`
+
+	// Queue is set for values from a config file's options for a queue
+	Queue string `json:"queue,omitempty"`
+}
+
+func (s Source) String() string {
+	switch s.Kind {
+	case SourceFlag:
+		return "flag --" + s.Name
+	case SourceEnv:
+		return "env " + s.Name
+	case SourceFile:
+		if s.Queue != "" {
+			return fmt.Sprintf("file %s (queue %s)", s.Name, s.Queue)
+		}
+		return "file " + s.Name
+	case SourceArg:
+		return "argument " + s.Name
+	default:
+		return s.Kind
+	}
 }
 
 var argCliNameRegexp = regexp.MustCompile(`arg:(\d+)`)
@@ -40,6 +93,8 @@ var argCliNameRegexp = regexp.MustCompile(`arg:(\d+)`)
 // Loads the config from the CLI and config files that are present and returns
 // any warnings or errors
 func (l *Loader) Load() (warnings []string, err error) {
+	l.Sources = make(map[string]Source)
+
 	// Try and find a config file, either passed in the command line using
 	// --config, or in one of the default configuration file paths.
 	if l.CLI.String("config") != "" {
@@ -72,12 +127,22 @@ func (l *Loader) Load() (warnings []string, err error) {
 		if err := l.File.Load(); err != nil {
 			return warnings, fmt.Errorf("loading config file: %w", err)
 		}
+
+		// Apply the options for the agent's queue, which is found from the
+		// tags given on the command line, or else in the config file.
+		if len(l.File.Queues) > 0 {
+			tags := l.File.Tags()
+			if l.cliValueIsSet("tags") {
+				tags = l.CLI.StringSlice("tags")
+			}
+			l.File.ApplyQueue(queueFromTags(tags))
+		}
 	}
 
 	// Now it's onto actually setting the fields. We start by getting all
 	// the fields from the configuration interface
 	var fields []string
-	fields, _ = reflections.Fields(l.Config)
+	fields, _ = reflections.FieldsDeep(l.Config)
 
 	// Loop through each of the fields, and look for tags and handle them
 	// appropriately
@@ -129,6 +194,7 @@ func (l *Loader) Load() (warnings []string, err error) {
 					if err != nil {
 						return warnings, fmt.Errorf("setting field %q to value %q: %w", renamedToFieldName, value, err)
 					}
+					l.Sources[renamedFieldCliName] = l.Sources[cliName]
 				}
 			}
 		}
@@ -164,7 +230,10 @@ func (l *Loader) Load() (warnings []string, err error) {
 			// error.
 			err := l.validateField(fieldName, label, validationRules)
 			if err != nil {
-				return warnings, err
+				if !l.ContinueOnValidationError {
+					return warnings, err
+				}
+				l.ValidationErrors = append(l.ValidationErrors, err)
 			}
 		}
 	}
@@ -172,7 +241,7 @@ func (l *Loader) Load() (warnings []string, err error) {
 	return warnings, nil
 }
 
-func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
+func (l *Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 	// Get the kind of field we need to set
 	fieldKind, err := reflections.GetFieldKind(l.Config, fieldName)
 	if err != nil {
@@ -200,6 +269,7 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 		// the position to exist.
 		if len(l.CLI.Args()) > argIndex {
 			value = l.CLI.Args()[argIndex]
+			l.Sources[cliName] = Source{Kind: SourceArg, Name: argNum}
 		}
 
 		// Otherwise see if we can pull it from an environment variable
@@ -209,6 +279,7 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 			if err == nil {
 				if envValue, envSet := os.LookupEnv(envName); envSet {
 					value = envValue
+					l.Sources[cliName] = Source{Kind: SourceEnv, Name: envName}
 				}
 			}
 		}
@@ -219,28 +290,18 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 		// We start by defaulting the value to what ever was provided
 		// by the configuration file
 		if l.File != nil {
-			if configFileValue, ok := l.File.Config[cliName]; ok {
+			if list, ok := l.File.Lists[cliName]; ok {
+				if fieldKind != reflect.Slice {
+					return fmt.Errorf("the config option %q in %s must be a single value, not a list", cliName, l.File.Path)
+				}
+				value = slices.Clone(list)
+				l.Sources[cliName] = l.File.Sources[cliName]
+			} else if configFileValue, ok := l.File.Config[cliName]; ok {
+				l.Sources[cliName] = l.File.Sources[cliName]
 				// Convert the config file value to its correct type
-				switch fieldKind {
-				case reflect.String:
-					value = configFileValue
-				case reflect.Slice:
-					value = strings.Split(configFileValue, ",")
-				case reflect.Bool:
-					value, _ = strconv.ParseBool(configFileValue)
-				case reflect.Int:
-					value, _ = strconv.Atoi(configFileValue)
-				case reflect.Int64:
-					switch fieldType {
-					case "int64":
-						value, _ = strconv.ParseInt(configFileValue, 10, 64)
-					case "time.Duration":
-						value, _ = time.ParseDuration(configFileValue)
-					default:
-						return fmt.Errorf("unsupported field type %s for kind int64", fieldType)
-					}
-				default:
-					return fmt.Errorf("unable to convert string to type %s", fieldKind)
+				value, err = convertString(fieldKind, fieldType, configFileValue)
+				if err != nil {
+					return err
 				}
 			}
 		}
@@ -269,6 +330,8 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 			default:
 				return fmt.Errorf("unable to handle type: %s", fieldKind)
 			}
+
+			l.Sources[cliName] = l.cliSource(cliName, fieldKind, fieldType, value)
 		}
 	}
 
@@ -283,6 +346,68 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 	return nil
 }
 
+// convertString converts a string value, such as one from a config file, to
+// the type of a field.
+func convertString(fieldKind reflect.Kind, fieldType, s string) (any, error) {
+	switch fieldKind {
+	case reflect.String:
+		return s, nil
+	case reflect.Slice:
+		return strings.Split(s, ","), nil
+	case reflect.Bool:
+		value, _ := strconv.ParseBool(s)
+		return value, nil
+	case reflect.Int:
+		value, _ := strconv.Atoi(s)
+		return value, nil
+	case reflect.Int64:
+		switch fieldType {
+		case "int64":
+			value, _ := strconv.ParseInt(s, 10, 64)
+			return value, nil
+		case "time.Duration":
+			value, _ := time.ParseDuration(s)
+			return value, nil
+		default:
+			return nil, fmt.Errorf("unsupported field type %s for kind int64", fieldType)
+		}
+	default:
+		return nil, fmt.Errorf("unable to convert string to type %s", fieldKind)
+	}
+}
+
+// cliSource returns the source of value, which was loaded from the CLI
+// context. cli.Context#IsSet doesn't distinguish between flags and
+// environment variables, so if the flag's environment variable is set, and
+// value is the same as the environment variable's, it's assumed to have come
+// from there.
+func (l Loader) cliSource(cliName string, fieldKind reflect.Kind, fieldType string, value any) Source {
+	if envVar := l.cliEnvVar(cliName); envVar != "" {
+		envValue, err := convertString(fieldKind, fieldType, os.Getenv(envVar))
+		if err == nil && reflect.DeepEqual(normalizeList(envValue), normalizeList(value)) {
+			return Source{Kind: SourceEnv, Name: envVar}
+		}
+	}
+	if l.CLI.IsSet(cliName) {
+		return Source{Kind: SourceFlag, Name: cliName}
+	}
+	return Source{Kind: SourceDefault}
+}
+
+// normalizeList trims the spaces around the items of a list value, as
+// cli.StringSliceFlag does, so list values can be compared.
+func normalizeList(value any) any {
+	list, ok := value.([]string)
+	if !ok {
+		return value
+	}
+	trimmed := make([]string, len(list))
+	for i, item := range list {
+		trimmed[i] = strings.TrimSpace(item)
+	}
+	return trimmed
+}
+
 func (l Loader) Errorf(format string, v ...any) error {
 	suffix := fmt.Sprintf(" See: `%s %s --help`", l.CLI.App.Name, l.CLI.Command.Name)
 
@@ -290,27 +415,31 @@ func (l Loader) Errorf(format string, v ...any) error {
 }
 
 func (l Loader) cliValueIsSet(cliName string) bool {
-	if l.CLI.IsSet(cliName) {
-		return true
-	} else {
-		// cli.Context#IsSet only checks to see if the command was set via the cli, not
-		// via the environment. So here we do some hacks to find out the name of the
-		// EnvVar, and return true if it was set.
-		for _, flag := range l.CLI.Command.Flags {
-			name, _ := reflections.GetField(flag, "Name")
-			envVar, _ := reflections.GetField(flag, "EnvVar")
-			if name == cliName && envVar != "" {
-				// Make sure envVar is a string
-				if envVarStr, ok := envVar.(string); ok {
-					envVarStr = strings.TrimSpace(string(envVarStr))
-
-					return os.Getenv(envVarStr) != ""
+	return l.CLI.IsSet(cliName) || l.cliEnvVar(cliName) != ""
+}
+
+// cliEnvVar returns the name of the environment variable that the flag was set
+// by, or "" if it wasn't set by one.
+func (l Loader) cliEnvVar(cliName string) string {
+	// cli.Context#IsSet only checks to see if the command was set via the cli, not
+	// via the environment. So here we do some hacks to find out the name of the
+	// EnvVar, and return it if it was set.
+	for _, flag := range l.CLI.Command.Flags {
+		name, _ := reflections.GetField(flag, "Name")
+		envVar, _ := reflections.GetField(flag, "EnvVar")
+		if name == cliName && envVar != "" {
+			// Make sure envVar is a string
+			if envVarStr, ok := envVar.(string); ok {
+				envVarStr = strings.TrimSpace(string(envVarStr))
+
+				if os.Getenv(envVarStr) != "" {
+					return envVarStr
 				}
 			}
 		}
 	}
 
-	return false
+	return ""
 }
 
 func (l Loader) fieldValueIsEmpty(fieldName string) bool {
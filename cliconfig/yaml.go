@@ -0,0 +1,240 @@
+package cliconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Keys in YAML config files that aren't config options.
+const (
+	// yamlIncludeKey is a path (or list of paths) to other YAML config files
+	// to load before the rest of the file. Relative paths are relative to the
+	// file's directory, and may be globs.
+	yamlIncludeKey = "include"
+
+	// yamlQueuesKey is a map of queue names to config options that are only
+	// used by agents on that queue.
+	yamlQueuesKey = "queues"
+)
+
+// overlayDir is the directory, next to the config file, that YAML config
+// fragments are loaded from after the config file.
+const overlayDir = "conf.d"
+
+// Overrides are config options that only apply in some circumstances, such as
+// the options for a queue.
+type Overrides struct {
+	Config  map[string]string
+	Lists   map[string][]string
+	Sources map[string]Source
+}
+
+// isYAML reports whether the config file at path is YAML, rather than the
+// key=value format.
+func isYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return true
+	default:
+		return false
+	}
+}
+
+// overlayPaths returns the paths of the YAML files in the overlay directory
+// next to path, sorted by name.
+func overlayPaths(path string) ([]string, error) {
+	dir := filepath.Join(filepath.Dir(path), overlayDir)
+	var paths []string
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// loadYAML loads the YAML config file at path (and the files it includes)
+// into f, overriding any values already loaded. stack is the chain of files
+// that included this one, for detecting include cycles.
+func (f *File) loadYAML(path string, stack []string) error {
+	if slices.Contains(stack, path) {
+		return fmt.Errorf("config file %s includes itself (via %s)", path, strings.Join(stack, " -> "))
+	}
+	stack = append(stack, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file %s: %w", path, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		// An empty file
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s: line %d: expected a map of config options", path, root.Line)
+	}
+
+	// Included files are loaded first, so this file can override them,
+	// wherever the include key is in the file.
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != yamlIncludeKey {
+			continue
+		}
+		includes, err := yamlStrings(value)
+		if err != nil {
+			return fmt.Errorf("config file %s: line %d: %s: %w", path, value.Line, yamlIncludeKey, err)
+		}
+		for _, include := range includes {
+			if err := f.loadYAMLInclude(path, include, stack); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case yamlIncludeKey:
+			// Already loaded
+
+		case yamlQueuesKey:
+			if value.Kind != yaml.MappingNode {
+				return fmt.Errorf("config file %s: line %d: %s must be a map of queue names to config options", path, value.Line, yamlQueuesKey)
+			}
+			for j := 0; j < len(value.Content); j += 2 {
+				queue, options := value.Content[j].Value, value.Content[j+1]
+				if options.Kind != yaml.MappingNode {
+					return fmt.Errorf("config file %s: line %d: the config options for queue %q must be a map", path, options.Line, queue)
+				}
+				overrides, ok := f.Queues[queue]
+				if !ok {
+					overrides = Overrides{Config: map[string]string{}, Lists: map[string][]string{}, Sources: map[string]Source{}}
+					f.Queues[queue] = overrides
+				}
+				for k := 0; k < len(options.Content); k += 2 {
+					source := Source{Kind: SourceFile, Name: path, Queue: queue}
+					if err := setYAMLValue(overrides, source, options.Content[k].Value, options.Content[k+1]); err != nil {
+						return fmt.Errorf("config file %s: line %d: %w", path, options.Content[k+1].Line, err)
+					}
+				}
+			}
+
+		default:
+			source := Source{Kind: SourceFile, Name: path}
+			if err := setYAMLValue(Overrides{Config: f.Config, Lists: f.Lists, Sources: f.Sources}, source, key.Value, value); err != nil {
+				return fmt.Errorf("config file %s: line %d: %w", path, value.Line, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadYAMLInclude loads the files matched by an include from the file at
+// path.
+func (f *File) loadYAMLInclude(path, include string, stack []string) error {
+	if !filepath.IsAbs(include) {
+		include = filepath.Join(filepath.Dir(path), include)
+	}
+
+	matches, err := filepath.Glob(include)
+	if err != nil {
+		return fmt.Errorf("config file %s: invalid include %q: %w", path, include, err)
+	}
+
+	// A glob that matches nothing is fine, but a missing file isn't.
+	if len(matches) == 0 && !strings.ContainsAny(include, "*?[") {
+		return fmt.Errorf("config file %s: included file %s doesn't exist", path, include)
+	}
+
+	for _, match := range matches {
+		if err := f.loadYAML(match, stack); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setYAMLValue sets the config option key to value, which may be a scalar or
+// a list of scalars, from source. A later value replaces an earlier one, even
+// if one is a list and the other isn't.
+func setYAMLValue(o Overrides, source Source, key string, value *yaml.Node) error {
+	if value.Kind == yaml.AliasNode {
+		value = value.Alias
+	}
+
+	switch value.Kind {
+	case yaml.ScalarNode:
+		delete(o.Lists, key)
+		if value.Tag == "!!null" {
+			o.Config[key] = ""
+		} else {
+			o.Config[key] = value.Value
+		}
+		o.Sources[key] = source
+		return nil
+
+	case yaml.SequenceNode:
+		list, err := yamlStrings(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		delete(o.Config, key)
+		o.Lists[key] = list
+		o.Sources[key] = source
+		return nil
+
+	default:
+		return fmt.Errorf("%s: expected a value or a list of values", key)
+	}
+}
+
+// yamlStrings returns the value of a scalar node, or the values of a sequence
+// of scalars.
+func yamlStrings(node *yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []string{node.Value}, nil
+
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: expected a list of values", item.Line)
+			}
+			values = append(values, item.Value)
+		}
+		return values, nil
+
+	default:
+		return nil, fmt.Errorf("expected a value or a list of values")
+	}
+}
+
+// queueFromTags returns the queue from a list of agent tags, which is
+// "default" if there's no queue tag.
+func queueFromTags(tags []string) string {
+	for _, tag := range tags {
+		for _, t := range strings.Split(tag, ",") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(t), "="); ok && strings.TrimSpace(k) == "queue" {
+				return strings.TrimSpace(v)
+			}
+		}
+	}
+	return "default"
+}
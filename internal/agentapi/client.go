@@ -9,22 +9,56 @@ import (
 	"github.com/buildkite/agent/v3/internal/socket"
 )
 
-const lockAPIPrefix = "http://agent/api/leader/v0/lock/"
+const (
+	lockAPIPrefix        = "http://agent/api/leader/v0/lock/"
+	clusterLockAPIPrefix = "http://agent/api/leader/v0/cluster-lock/"
+	workersAPIPrefix     = "http://agent/api/leader/v0/workers/"
+)
 
 // Client is a client for the agent API socket.
 type Client struct {
 	sc *socket.Client
+
+	// Prefix for lock API requests, which depends on the lock scope.
+	lockPrefix string
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	token string
+}
+
+// WithClientToken sends the token with each request. It is needed for worker
+// introspection and job control if the server was created with WithToken.
+func WithClientToken(token string) ClientOption {
+	return func(o *clientOptions) { o.token = token }
 }
 
 // NewClient creates a new Client using the socket at a given path. The context
 // is used for an internal check that the socket can be dialled.
-func NewClient(ctx context.Context, path string) (*Client, error) {
-	// The API is unauthenticated, hence the empty token.
-	sc, err := socket.NewClient(ctx, path, "")
+func NewClient(ctx context.Context, path string, opts ...ClientOption) (*Client, error) {
+	var o clientOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// Most of the API is unauthenticated, so the token is usually empty.
+	sc, err := socket.NewClient(ctx, path, o.token)
 	if err != nil {
 		return nil, err
 	}
-	return &Client{sc: sc}, nil
+	return &Client{sc: sc, lockPrefix: lockAPIPrefix}, nil
+}
+
+// ClusterScope returns a Client that uses the same socket, but whose lock
+// operations use the cluster lock scope (locks shared with agents on other
+// hosts, via the backend configured on the leader agent). Only LockGet,
+// LockCompareAndSwap, LockCompareAndSwapLease and LockRenew are supported in
+// the cluster scope.
+func (c *Client) ClusterScope() *Client {
+	return &Client{sc: c.sc, lockPrefix: clusterLockAPIPrefix}
 }
 
 // Ping pings the server. It returns a non-nil error if the ping fails, or the
@@ -45,7 +79,7 @@ func (c *Client) LockGet(ctx context.Context, key string) (string, error) {
 	uk := "?key=" + url.QueryEscape(key)
 
 	var resp ValueResponse
-	if err := c.sc.Do(ctx, "GET", lockAPIPrefix+uk, nil, &resp); err != nil {
+	if err := c.sc.Do(ctx, "GET", c.lockPrefix+uk, nil, &resp); err != nil {
 		return "", err
 	}
 	return resp.Value, nil
@@ -55,15 +89,147 @@ func (c *Client) LockGet(ctx context.Context, key string) (string, error) {
 // value, or performs no modification. It returns the most up-to-date value for
 // the key, and reports whether the new value was written.
 func (c *Client) LockCompareAndSwap(ctx context.Context, key, old, new string) (string, bool, error) {
+	return c.LockCompareAndSwapLease(ctx, key, old, new, 0)
+}
+
+// LockCompareAndSwapLease is like LockCompareAndSwap, but if ttl is non-zero,
+// the new value is held under a lease of that duration. Once the lease
+// expires, the value is removed unless the lease is renewed with LockRenew.
+func (c *Client) LockCompareAndSwapLease(ctx context.Context, key, old, new string, ttl time.Duration) (string, bool, error) {
 	uk := "?key=" + url.QueryEscape(key)
 
 	req := LockCASRequest{
 		Old: old,
 		New: new,
+		TTL: ttl,
 	}
 	var resp LockCASResponse
-	if err := c.sc.Do(ctx, "PATCH", lockAPIPrefix+uk, &req, &resp); err != nil {
+	if err := c.sc.Do(ctx, "PATCH", c.lockPrefix+uk, &req, &resp); err != nil {
 		return "", false, err
 	}
 	return resp.Value, resp.Swapped, nil
 }
+
+// LockRenew extends the lease on the lock key to ttl from now, if the current
+// value is token. It returns the most up-to-date value for the key and the
+// time the lease expires, and reports whether the lease was renewed.
+func (c *Client) LockRenew(ctx context.Context, key, token string, ttl time.Duration) (string, time.Time, bool, error) {
+	uk := "?key=" + url.QueryEscape(key)
+
+	req := LockRenewRequest{
+		Token: token,
+		TTL:   ttl,
+	}
+	var resp LockRenewResponse
+	if err := c.sc.Do(ctx, "POST", c.lockPrefix+"renew"+uk, &req, &resp); err != nil {
+		return "", time.Time{}, false, err
+	}
+	return resp.Value, resp.Expires, resp.Renewed, nil
+}
+
+// LockInfo describes the current state of the lock key.
+func (c *Client) LockInfo(ctx context.Context, key string) (*LockInfo, error) {
+	uk := "?key=" + url.QueryEscape(key)
+
+	var resp LockInfo
+	if err := c.sc.Do(ctx, "GET", c.lockPrefix+"info"+uk, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// LockList describes the current state of all locks that have a value, and
+// all semaphores in use.
+func (c *Client) LockList(ctx context.Context) ([]LockInfo, []SemaphoreInfo, error) {
+	var resp LockListResponse
+	if err := c.sc.Do(ctx, "GET", c.lockPrefix+"list", nil, &resp); err != nil {
+		return nil, nil, err
+	}
+	return resp.Locks, resp.Semaphores, nil
+}
+
+// SemaphoreAcquire tries to acquire a permit from the semaphore key, which
+// has the given number of permits, on behalf of token. If no permit is
+// available, token is queued, and SemaphoreAcquire must be called again
+// (within a few seconds) to keep its place in the queue. It reports whether a
+// permit was acquired, or else the position of token in the queue.
+// If ttl is non-zero, the permit is held under a lease of that duration.
+func (c *Client) SemaphoreAcquire(ctx context.Context, key, token string, permits int, ttl time.Duration) (bool, int, error) {
+	uk := "?key=" + url.QueryEscape(key)
+
+	req := SemaphoreAcquireRequest{
+		Token:   token,
+		Permits: permits,
+		TTL:     ttl,
+	}
+	var resp SemaphoreAcquireResponse
+	if err := c.sc.Do(ctx, "POST", c.lockPrefix+"semaphore/acquire"+uk, &req, &resp); err != nil {
+		return false, 0, err
+	}
+	return resp.Acquired, resp.Position, nil
+}
+
+// SemaphoreRelease releases the permit from the semaphore key held by token,
+// or removes token from the queue. It reports whether token was found.
+func (c *Client) SemaphoreRelease(ctx context.Context, key, token string) (bool, error) {
+	uk := "?key=" + url.QueryEscape(key)
+
+	req := SemaphoreReleaseRequest{Token: token}
+	var resp SemaphoreReleaseResponse
+	if err := c.sc.Do(ctx, "POST", c.lockPrefix+"semaphore/release"+uk, &req, &resp); err != nil {
+		return false, err
+	}
+	return resp.Released, nil
+}
+
+// SemaphoreRenew extends the lease on the permit from the semaphore key held
+// by token to ttl from now. It returns the time the lease expires, and
+// reports whether the lease was renewed.
+func (c *Client) SemaphoreRenew(ctx context.Context, key, token string, ttl time.Duration) (time.Time, bool, error) {
+	uk := "?key=" + url.QueryEscape(key)
+
+	req := LockRenewRequest{
+		Token: token,
+		TTL:   ttl,
+	}
+	var resp LockRenewResponse
+	if err := c.sc.Do(ctx, "POST", c.lockPrefix+"semaphore/renew"+uk, &req, &resp); err != nil {
+		return time.Time{}, false, err
+	}
+	return resp.Expires, resp.Renewed, nil
+}
+
+// SemaphoreInfo describes the current state of the semaphore key.
+func (c *Client) SemaphoreInfo(ctx context.Context, key string) (*SemaphoreInfo, error) {
+	uk := "?key=" + url.QueryEscape(key)
+
+	var resp SemaphoreInfo
+	if err := c.sc.Do(ctx, "GET", c.lockPrefix+"semaphore/info"+uk, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Workers describes the agent workers in the agent process serving the API.
+func (c *Client) Workers(ctx context.Context) (*WorkersResponse, error) {
+	var resp WorkersResponse
+	if err := c.sc.Do(ctx, "GET", workersAPIPrefix, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CancelJob cancels the job running on a worker, identified by the worker's
+// ID or name, or by the job ID (or both). It returns the ID of the job being
+// cancelled.
+func (c *Client) CancelJob(ctx context.Context, worker, jobID string) (string, error) {
+	req := &CancelJobRequest{
+		Worker: worker,
+		JobID:  jobID,
+	}
+	var resp CancelJobResponse
+	if err := c.sc.Do(ctx, "POST", workersAPIPrefix+"cancel-job", req, &resp); err != nil {
+		return "", err
+	}
+	return resp.JobID, nil
+}
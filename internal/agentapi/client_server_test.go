@@ -2,14 +2,20 @@ package agentapi
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/buildkite/agent/v3/internal/clusterlock"
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/buildkite/agent/v3/internal/system"
 	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
 )
 
 var testSocketCounter uint32
@@ -110,3 +116,302 @@ func TestLockOperations(t *testing.T) {
 		t.Errorf("cli.LockGet(ctx, %q) = %q, want %q", key, got, want)
 	}
 }
+
+func TestLockLeaseOperations(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	const key = "alpaca"
+	start, err := system.GetProcessStartTime(os.Getpid())
+	if err != nil {
+		t.Skipf("system.GetProcessStartTime(%d) error = %v", os.Getpid(), err)
+	}
+	token := fmt.Sprintf("acquired(pid=%d,start=%d,otp=00)", os.Getpid(), start)
+
+	if _, ok, err := cli.LockCompareAndSwapLease(ctx, key, "", token, time.Minute); err != nil || !ok {
+		t.Fatalf("cli.LockCompareAndSwapLease(ctx, %q, %q, %q, time.Minute) = (_, %t, %v), want (_, true, nil)", key, "", token, ok, err)
+	}
+
+	_, exp, ok, err := cli.LockRenew(ctx, key, token, time.Hour)
+	if err != nil || !ok {
+		t.Errorf("cli.LockRenew(ctx, %q, %q, time.Hour) = (_, _, %t, %v), want (_, _, true, nil)", key, token, ok, err)
+	}
+	if until := time.Until(exp); until < 59*time.Minute {
+		t.Errorf("cli.LockRenew(ctx, %q, %q, time.Hour) expires in %v, want about 1h", key, token, until)
+	}
+
+	info, err := cli.LockInfo(ctx, key)
+	if err != nil {
+		t.Fatalf("cli.LockInfo(ctx, %q) = error %v", key, err)
+	}
+	if info.Value != token || info.HolderPID != os.Getpid() || info.HolderAlive == nil || !*info.HolderAlive || info.Expires == nil {
+		t.Errorf("cli.LockInfo(ctx, %q) = %+v, want live holder %d with a lease", key, info, os.Getpid())
+	}
+
+	locks, _, err := cli.LockList(ctx)
+	if err != nil {
+		t.Fatalf("cli.LockList(ctx) = error %v", err)
+	}
+	if len(locks) != 1 || locks[0].Key != key {
+		t.Errorf("cli.LockList(ctx) = %+v, want one lock %q", locks, key)
+	}
+}
+
+func TestSemaphoreOperations(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	const key = "docker"
+	if ok, _, err := cli.SemaphoreAcquire(ctx, key, "a", 1, time.Minute); err != nil || !ok {
+		t.Fatalf("cli.SemaphoreAcquire(ctx, %q, a, 1, time.Minute) = (%t, _, %v), want (true, _, nil)", key, ok, err)
+	}
+	if ok, pos, err := cli.SemaphoreAcquire(ctx, key, "b", 1, 0); err != nil || ok || pos != 0 {
+		t.Errorf("cli.SemaphoreAcquire(ctx, %q, b, 1, 0) = (%t, %d, %v), want (false, 0, nil)", key, ok, pos, err)
+	}
+	if _, _, err := cli.SemaphoreAcquire(ctx, key, "c", 2, 0); err == nil {
+		t.Errorf("cli.SemaphoreAcquire(ctx, %q, c, 2, 0) = nil error, want permits mismatch", key)
+	}
+	if _, ok, err := cli.SemaphoreRenew(ctx, key, "a", time.Hour); err != nil || !ok {
+		t.Errorf("cli.SemaphoreRenew(ctx, %q, a, time.Hour) = (_, %t, %v), want (_, true, nil)", key, ok, err)
+	}
+
+	info, err := cli.SemaphoreInfo(ctx, key)
+	if err != nil {
+		t.Fatalf("cli.SemaphoreInfo(ctx, %q) = error %v", key, err)
+	}
+	if info.Permits != 1 || len(info.Holders) != 1 || info.Waiting != 1 {
+		t.Errorf("cli.SemaphoreInfo(ctx, %q) = %+v, want 1 permit, 1 holder, 1 waiting", key, info)
+	}
+
+	if ok, err := cli.SemaphoreRelease(ctx, key, "a"); err != nil || !ok {
+		t.Errorf("cli.SemaphoreRelease(ctx, %q, a) = (%t, %v), want (true, nil)", key, ok, err)
+	}
+	if ok, _, err := cli.SemaphoreAcquire(ctx, key, "b", 1, 0); err != nil || !ok {
+		t.Errorf("cli.SemaphoreAcquire(ctx, %q, b, 1, 0) = (%t, _, %v), want (true, _, nil)", key, ok, err)
+	}
+
+	_, sems, err := cli.LockList(ctx)
+	if err != nil {
+		t.Fatalf("cli.LockList(ctx) = error %v", err)
+	}
+	if len(sems) != 1 || sems[0].Key != key {
+		t.Errorf("cli.LockList(ctx) semaphores = %+v, want one semaphore %q", sems, key)
+	}
+}
+
+func TestServerWithStatePathSurvivesRestart(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	statePath := filepath.Join(t.TempDir(), "state.jsonl")
+	startServer := func() (*Server, *Client) {
+		t.Helper()
+		sockPath := testSocketPath()
+		svr, err := NewServer(sockPath, testLogger(t), WithStatePath(statePath))
+		if err != nil {
+			t.Fatalf("NewServer(%q, logger, WithStatePath(%q)) = error %v", sockPath, statePath, err)
+		}
+		if err := svr.Start(); err != nil {
+			t.Fatalf("svr.Start() = %v", err)
+		}
+		cli, err := NewClient(ctx, sockPath)
+		if err != nil {
+			t.Fatalf("NewClient(ctx, %q) = error %v", sockPath, err)
+		}
+		return svr, cli
+	}
+
+	svr, cli := startServer()
+	if _, ok, err := cli.LockCompareAndSwap(ctx, "setup", "", "done"); err != nil || !ok {
+		t.Fatalf("cli.LockCompareAndSwap(ctx, setup, %q, done) = (_, %t, %v), want (_, true, nil)", "", ok, err)
+	}
+	if err := svr.Shutdown(ctx); err != nil {
+		t.Fatalf("svr.Shutdown(ctx) = %v", err)
+	}
+
+	svr, cli = startServer()
+	t.Cleanup(func() { svr.Close() })
+	if got, err := cli.LockGet(ctx, "setup"); err != nil || got != "done" {
+		t.Errorf("cli.LockGet(ctx, setup) after restart = (%q, %v), want (done, nil)", got, err)
+	}
+}
+
+func TestClusterLockOperations(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	backend, err := clusterlock.NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("clusterlock.NewFileBackend(dir) = error %v", err)
+	}
+	sockPath := testSocketPath()
+	svr, err := NewServer(sockPath, testLogger(t), WithClusterLockBackend(backend))
+	if err != nil {
+		t.Fatalf("NewServer(%q, logger, WithClusterLockBackend(backend)) = error %v", sockPath, err)
+	}
+	if err := svr.Start(); err != nil {
+		t.Fatalf("svr.Start() = %v", err)
+	}
+	t.Cleanup(func() { svr.Close() })
+
+	machine, err := NewClient(ctx, sockPath)
+	if err != nil {
+		t.Fatalf("NewClient(ctx, %q) = error %v", sockPath, err)
+	}
+	cluster := machine.ClusterScope()
+
+	const key = "staging-db"
+	if _, ok, err := cluster.LockCompareAndSwapLease(ctx, key, "", "Kuzco", time.Minute); err != nil || !ok {
+		t.Fatalf("cluster.LockCompareAndSwapLease(ctx, %q, %q, Kuzco, time.Minute) = (_, %t, %v), want (_, true, nil)", key, "", ok, err)
+	}
+	if _, _, ok, err := cluster.LockRenew(ctx, key, "Kuzco", time.Minute); err != nil || !ok {
+		t.Errorf("cluster.LockRenew(ctx, %q, Kuzco, time.Minute) = (_, _, %t, %v), want (_, _, true, nil)", key, ok, err)
+	}
+
+	// The scopes are separate.
+	if got, err := machine.LockGet(ctx, key); err != nil || got != "" {
+		t.Errorf("machine.LockGet(ctx, %q) = (%q, %v), want (%q, nil)", key, got, err, "")
+	}
+
+	// The value is stored in the backend, where other hosts can see it.
+	if got, err := backend.Get(ctx, key); err != nil || got != "Kuzco" {
+		t.Errorf("backend.Get(ctx, %q) = (%q, %v), want (Kuzco, nil)", key, got, err)
+	}
+}
+
+func TestClusterLockWithoutBackend(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	if _, err := cli.ClusterScope().LockGet(ctx, "llama"); err == nil {
+		t.Errorf("cli.ClusterScope().LockGet(ctx, llama) = nil error, want error")
+	}
+}
+
+// fakeWorkers is a WorkerController with a fixed set of workers.
+type fakeWorkers struct {
+	workers   []WorkerInfo
+	cancelled []string
+}
+
+func (f *fakeWorkers) Workers() []WorkerInfo { return f.workers }
+
+func (f *fakeWorkers) CancelJob(worker, jobID string) (string, error) {
+	for _, w := range f.workers {
+		if worker != "" && worker != w.ID && worker != w.Name {
+			continue
+		}
+		if worker == "" && jobID != w.JobID {
+			continue
+		}
+		if w.JobID == "" || (jobID != "" && jobID != w.JobID) {
+			return "", ErrJobNotRunning
+		}
+		f.cancelled = append(f.cancelled, w.JobID)
+		return w.JobID, nil
+	}
+	return "", ErrWorkerNotFound
+}
+
+func TestWorkers(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	sockPath := testSocketPath()
+	svr, err := NewServer(sockPath, testLogger(t), WithToken("sekret"))
+	if err != nil {
+		t.Fatalf("NewServer(%q, logger, WithToken(sekret)) = error %v", sockPath, err)
+	}
+	if err := svr.Start(); err != nil {
+		t.Fatalf("svr.Start() = %v", err)
+	}
+	t.Cleanup(func() { svr.Close() })
+
+	cli, err := NewClient(ctx, sockPath, WithClientToken("sekret"))
+	if err != nil {
+		t.Fatalf("NewClient(ctx, %q, WithClientToken(sekret)) = error %v", sockPath, err)
+	}
+
+	// Not ready until the controller is set.
+	if _, err := cli.Workers(ctx); err == nil {
+		t.Errorf("cli.Workers(ctx) before SetWorkerController = nil error, want error")
+	}
+
+	fw := &fakeWorkers{workers: []WorkerInfo{
+		{ID: "uuid-1", Name: "agent-1", SpawnIndex: 1, State: "busy", JobID: "job-1"},
+		{ID: "uuid-2", Name: "agent-2", SpawnIndex: 2, State: "idle"},
+	}}
+	svr.SetWorkerController(fw)
+
+	resp, err := cli.Workers(ctx)
+	if err != nil {
+		t.Fatalf("cli.Workers(ctx) error = %v", err)
+	}
+	if resp.PID != os.Getpid() {
+		t.Errorf("cli.Workers(ctx).PID = %d, want %d", resp.PID, os.Getpid())
+	}
+	if diff := cmp.Diff(resp.Workers, fw.workers); diff != "" {
+		t.Errorf("cli.Workers(ctx).Workers diff (-got +want):\n%s", diff)
+	}
+
+	// Requests without the token are refused.
+	anon, err := NewClient(ctx, sockPath)
+	if err != nil {
+		t.Fatalf("NewClient(ctx, %q) = error %v", sockPath, err)
+	}
+	if _, err := anon.Workers(ctx); err == nil {
+		t.Errorf("anon.Workers(ctx) = nil error, want error")
+	}
+	if _, err := anon.CancelJob(ctx, "agent-1", ""); err == nil {
+		t.Errorf("anon.CancelJob(ctx, agent-1, \"\") = nil error, want error")
+	}
+	// ...but the lock API doesn't need it.
+	if _, err := anon.LockGet(ctx, "llama"); err != nil {
+		t.Errorf("anon.LockGet(ctx, llama) error = %v", err)
+	}
+
+	tests := []struct {
+		worker, jobID string
+		want          string
+		wantStatus    int
+	}{
+		{worker: "", jobID: "", wantStatus: http.StatusBadRequest},
+		{worker: "agent-3", jobID: "", wantStatus: http.StatusNotFound},
+		{worker: "agent-2", jobID: "", wantStatus: http.StatusConflict},
+		{worker: "agent-1", jobID: "job-2", wantStatus: http.StatusConflict},
+		{worker: "agent-1", jobID: "", want: "job-1"},
+		{worker: "uuid-1", jobID: "job-1", want: "job-1"},
+		{worker: "", jobID: "job-1", want: "job-1"},
+	}
+	for _, test := range tests {
+		got, err := cli.CancelJob(ctx, test.worker, test.jobID)
+		var apiErr socket.APIErr
+		if errors.As(err, &apiErr) {
+			if apiErr.StatusCode != test.wantStatus {
+				t.Errorf("cli.CancelJob(ctx, %q, %q) error = %v, want status %d", test.worker, test.jobID, err, test.wantStatus)
+			}
+			continue
+		}
+		if err != nil || got != test.want || test.wantStatus != 0 {
+			t.Errorf("cli.CancelJob(ctx, %q, %q) = (%q, %v), want (%q, status %d)", test.worker, test.jobID, got, err, test.want, test.wantStatus)
+		}
+	}
+	if got, want := len(fw.cancelled), 3; got != want {
+		t.Errorf("len(fw.cancelled) = %d, want %d", got, want)
+	}
+}
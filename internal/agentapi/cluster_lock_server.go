@@ -0,0 +1,125 @@
+package agentapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/buildkite/agent/v3/internal/clusterlock"
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+// clusterLockServer serves cluster-scoped lock requests by forwarding them to
+// a clusterlock.Backend. It supports the subset of the lock API needed for
+// locks and do-once (get, compare-and-swap, and renew).
+type clusterLockServer struct {
+	logger  logger.Logger
+	backend clusterlock.Backend // nil if not configured
+}
+
+// routes defines routes for the clusterLockServer.
+func (s *clusterLockServer) routes(r chi.Router) {
+	r.Use(s.requireBackend)
+	r.Get("/", s.getLock)
+	r.Patch("/", s.patchLock)
+	r.Post("/renew", s.renewLock)
+}
+
+// requireBackend responds with an error if no backend is configured.
+func (s *clusterLockServer) requireBackend(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.backend == nil {
+			if err := socket.WriteError(w, "no cluster lock backend is configured for this agent (see --lock-cluster-backend)", http.StatusNotImplemented); err != nil {
+				s.logger.Error("Agent API: couldn't write error: %v", err)
+			}
+			return
+		}
+		if r.URL.Query().Get("key") == "" {
+			if err := socket.WriteError(w, "key missing", http.StatusNotFound); err != nil {
+				s.logger.Error("Agent API: couldn't write error: %v", err)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getLock retrieves the current lock value.
+func (s *clusterLockServer) getLock(w http.ResponseWriter, r *http.Request) {
+	v, err := s.backend.Get(r.Context(), r.URL.Query().Get("key"))
+	if err != nil {
+		s.backendError(w, err)
+		return
+	}
+	resp := &ValueResponse{
+		Value: v,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
+// patchLock tries to atomically update the lock value.
+func (s *clusterLockServer) patchLock(w http.ResponseWriter, r *http.Request) {
+	var req LockCASRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := socket.WriteError(w, fmt.Sprintf("couldn't decode request body: %v", err), http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	v, ok, err := s.backend.CompareAndSwap(r.Context(), r.URL.Query().Get("key"), req.Old, req.New, req.TTL)
+	if err != nil {
+		s.backendError(w, err)
+		return
+	}
+	resp := &LockCASResponse{
+		Value:   v,
+		Swapped: ok,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
+// renewLock tries to extend the lease on the lock value.
+func (s *clusterLockServer) renewLock(w http.ResponseWriter, r *http.Request) {
+	var req LockRenewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := socket.WriteError(w, fmt.Sprintf("couldn't decode request body: %v", err), http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+	if req.TTL <= 0 {
+		if err := socket.WriteError(w, "ttl must be positive", http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	v, exp, ok, err := s.backend.Renew(r.Context(), r.URL.Query().Get("key"), req.Token, req.TTL)
+	if err != nil {
+		s.backendError(w, err)
+		return
+	}
+	resp := &LockRenewResponse{
+		Value:   v,
+		Renewed: ok,
+		Expires: exp,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
+// backendError reports an error from the backend.
+func (s *clusterLockServer) backendError(w http.ResponseWriter, err error) {
+	s.logger.Error("Agent API: cluster lock backend: %v", err)
+	if err := socket.WriteError(w, fmt.Sprintf("cluster lock backend: %v", err), http.StatusBadGateway); err != nil {
+		s.logger.Error("Agent API: couldn't write error: %v", err)
+	}
+}
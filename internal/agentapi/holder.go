@@ -0,0 +1,28 @@
+package agentapi
+
+import "github.com/buildkite/agent/v3/internal/system"
+
+// holderAlive reports whether the process holding a lock is still running.
+//
+// A pid from another PID namespace (such as a container sharing the agent's
+// sockets path) means nothing here, so such holders are assumed to be alive,
+// and their locks are only released when their leases expire. If the process
+// start time was recorded, a running process with a different start time has
+// reused the pid, so the holder is dead.
+func holderAlive(h lockHolder) bool {
+	if h.pidNS != 0 {
+		if ns, err := system.GetPIDNamespace(); err != nil || ns != h.pidNS {
+			return true
+		}
+	}
+	if !processAlive(h.pid) {
+		return false
+	}
+	if h.start != 0 {
+		start, err := system.GetProcessStartTime(h.pid)
+		if err == nil && start != h.start {
+			return false
+		}
+	}
+	return true
+}
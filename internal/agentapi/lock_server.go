@@ -2,6 +2,7 @@ package agentapi
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -24,10 +25,32 @@ func newLockServer(logger logger.Logger) *lockServer {
 	}
 }
 
+// newPersistentLockServer creates a lockServer containing a lockState
+// persisted at statePath.
+func newPersistentLockServer(logger logger.Logger, statePath string) (*lockServer, error) {
+	locks, err := newPersistentLockState(statePath, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &lockServer{
+		logger: logger,
+		locks:  locks,
+	}, nil
+}
+
 // routes defines routes for the lockServer.
 func (s *lockServer) routes(r chi.Router) {
 	r.Get("/", s.getLock)
 	r.Patch("/", s.patchLock)
+	r.Post("/renew", s.renewLock)
+	r.Get("/info", s.lockInfo)
+	r.Get("/list", s.listLocks)
+	r.Route("/semaphore", func(r chi.Router) {
+		r.Post("/acquire", s.acquireSemaphore)
+		r.Post("/release", s.releaseSemaphore)
+		r.Post("/renew", s.renewSemaphore)
+		r.Get("/info", s.semaphoreInfo)
+	})
 }
 
 // getLock atomically retrieves the current lock value.
@@ -39,8 +62,13 @@ func (s *lockServer) getLock(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	v, err := s.locks.load(key)
+	if err != nil {
+		s.stateError(w, err)
+		return
+	}
 	resp := &ValueResponse{
-		Value: s.locks.load(key),
+		Value: v,
 	}
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		s.logger.Error("Agent API: couldn't encode response body: %v", err)
@@ -65,7 +93,11 @@ func (s *lockServer) patchLock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	v, ok := s.locks.cas(key, req.Old, req.New)
+	v, ok, err := s.locks.cas(key, req.Old, req.New, req.TTL)
+	if err != nil {
+		s.stateError(w, err)
+		return
+	}
 	resp := &LockCASResponse{
 		Value:   v,
 		Swapped: ok,
@@ -81,3 +113,224 @@ func (s *lockServer) patchLock(w http.ResponseWriter, r *http.Request) {
 		s.logger.Error("Agent API: couldn't encode response body: %v", err)
 	}
 }
+
+// renewLock tries to extend the lease on the lock value.
+func (s *lockServer) renewLock(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		if err := socket.WriteError(w, "key missing", http.StatusNotFound); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	var req LockRenewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := socket.WriteError(w, fmt.Sprintf("couldn't decode request body: %v", err), http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+	if req.TTL <= 0 {
+		if err := socket.WriteError(w, "ttl must be positive", http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	v, exp, ok, err := s.locks.renew(key, req.Token, req.TTL)
+	if err != nil {
+		s.stateError(w, err)
+		return
+	}
+	resp := &LockRenewResponse{
+		Value:   v,
+		Renewed: ok,
+		Expires: exp,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
+// lockInfo describes the current state of one lock.
+func (s *lockServer) lockInfo(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		if err := socket.WriteError(w, "key missing", http.StatusNotFound); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+	resp, err := s.locks.info(key)
+	if err != nil {
+		s.stateError(w, err)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
+// listLocks describes the current state of all locks that have a value.
+func (s *lockServer) listLocks(w http.ResponseWriter, r *http.Request) {
+	locks, err := s.locks.list()
+	if err != nil {
+		s.stateError(w, err)
+		return
+	}
+	sems, err := s.locks.semList()
+	if err != nil {
+		s.stateError(w, err)
+		return
+	}
+	resp := &LockListResponse{
+		Locks:      locks,
+		Semaphores: sems,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
+// acquireSemaphore tries to acquire a semaphore permit, queueing for one if
+// none are available.
+func (s *lockServer) acquireSemaphore(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		if err := socket.WriteError(w, "key missing", http.StatusNotFound); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	var req SemaphoreAcquireRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := socket.WriteError(w, fmt.Sprintf("couldn't decode request body: %v", err), http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	ok, pos, err := s.locks.semAcquire(key, req.Token, req.Permits, req.TTL)
+	if err != nil {
+		code := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, errPermitsMismatch):
+			code = http.StatusConflict
+		case errors.Is(err, errInvalidPermits):
+			code = http.StatusBadRequest
+		}
+		if err := socket.WriteError(w, err, code); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	resp := &SemaphoreAcquireResponse{
+		Acquired: ok,
+		Position: pos,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
+// releaseSemaphore releases a semaphore permit, or stops waiting for one.
+func (s *lockServer) releaseSemaphore(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		if err := socket.WriteError(w, "key missing", http.StatusNotFound); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	var req SemaphoreReleaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := socket.WriteError(w, fmt.Sprintf("couldn't decode request body: %v", err), http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	ok, err := s.locks.semRelease(key, req.Token)
+	if err != nil {
+		s.stateError(w, err)
+		return
+	}
+	resp := &SemaphoreReleaseResponse{
+		Released: ok,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
+// renewSemaphore tries to extend the lease on a semaphore permit.
+func (s *lockServer) renewSemaphore(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		if err := socket.WriteError(w, "key missing", http.StatusNotFound); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	var req LockRenewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := socket.WriteError(w, fmt.Sprintf("couldn't decode request body: %v", err), http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+	if req.TTL <= 0 {
+		if err := socket.WriteError(w, "ttl must be positive", http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	exp, ok, err := s.locks.semRenew(key, req.Token, req.TTL)
+	if err != nil {
+		s.stateError(w, err)
+		return
+	}
+	resp := &LockRenewResponse{
+		Renewed: ok,
+		Expires: exp,
+	}
+	if ok {
+		resp.Value = req.Token
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
+// semaphoreInfo describes the current state of one semaphore.
+func (s *lockServer) semaphoreInfo(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		if err := socket.WriteError(w, "key missing", http.StatusNotFound); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+	resp, err := s.locks.semInfo(key)
+	if err != nil {
+		s.stateError(w, err)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
+// stateError reports an error reading or writing persisted lock state.
+func (s *lockServer) stateError(w http.ResponseWriter, err error) {
+	s.logger.Error("Agent API: %v", err)
+	if err := socket.WriteError(w, err, http.StatusInternalServerError); err != nil {
+		s.logger.Error("Agent API: couldn't write error: %v", err)
+	}
+}
@@ -1,36 +1,425 @@
 package agentapi
 
-import "sync"
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 
-// lockState is really just a concurrent map.
+	"github.com/buildkite/agent/v3/logger"
+)
+
+// holderRE matches the holder in tokens created by lock.Client.Lock and
+// lock.Semaphore.Acquire. Tokens from older versions, and tokens whose holder
+// isn't tracked, only have the pid.
+var holderRE = regexp.MustCompile(`^(?:acquired|permit)\(pid=(\d+),(?:start=(\d+),)?(?:pidns=(\d+),)?`)
+
+// lockHolder identifies the process holding a lock or permit.
+type lockHolder struct {
+	pid int
+
+	// When the process started, and the PID namespace it's in, or 0 if
+	// unknown (see system.GetProcessStartTime and system.GetPIDNamespace).
+	start, pidNS uint64
+}
+
+// tracked reports whether the lock or permit should be released when the
+// holder dies. Only tokens that record when the holder started are tracked:
+// the pid alone might be of a process that was never meant to hold the lock
+// until it exits, such as an older `lock acquire` that recorded its own pid.
+func (h lockHolder) tracked() bool {
+	return h.pid != 0 && h.start != 0
+}
+
+// holderOf returns the process holding a lock, if the value is a token created
+// by the lock package. Otherwise, the pid of the holder is 0.
+func holderOf(value string) lockHolder {
+	m := holderRE.FindStringSubmatch(value)
+	if m == nil {
+		return lockHolder{}
+	}
+	pid, err := strconv.Atoi(m[1])
+	if err != nil {
+		return lockHolder{}
+	}
+	h := lockHolder{pid: pid}
+	h.start, _ = strconv.ParseUint(m[2], 10, 64)
+	h.pidNS, _ = strconv.ParseUint(m[3], 10, 64)
+	return h
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// lockEntry is the state of a single lock key.
+type lockEntry struct {
+	value string
+
+	// When value was set.
+	since time.Time
+
+	// When the lease on value expires, or zero if there is no lease.
+	expires time.Time
+}
+
+// lockState is really just a concurrent map, with leases.
+//
+// A value may be set with a lease, in which case it is removed (the lock is
+// released) once the lease expires, unless it is renewed first. Values
+// created by lock.Client.Lock, leased or not, are also removed as soon as the
+// process holding them no longer exists.
+//
+// Expired values are removed lazily, whenever the key is accessed.
+//
+// lockState also holds counting semaphores (see semaphore_state.go), which use
+// a separate key space.
+//
+// Optionally, the state can be persisted in a fileStore, so that it survives
+// the agent restarting. All changes are made through walRecords, which are
+// written to the store before being applied.
 type lockState struct {
 	mu    sync.Mutex
-	locks map[string]string
+	locks map[string]*lockEntry
+	sems  map[string]*semaphore
+
+	// If not nil, the state is persisted here.
+	store  *fileStore
+	logger logger.Logger
+
+	// For testing.
+	now   func() time.Time
+	alive func(lockHolder) bool
 }
 
 // newLockState creates a new empty lockServer.
 func newLockState() *lockState {
 	return &lockState{
-		locks: make(map[string]string),
+		locks:  make(map[string]*lockEntry),
+		sems:   make(map[string]*semaphore),
+		logger: logger.Discard,
+		now:    time.Now,
+		alive:  holderAlive,
 	}
 }
 
-// load atomically retrieves the current value for the lock.
-func (s *lockState) load(key string) string {
+// newPersistentLockState creates a lockState persisted in a log file at path,
+// recovering any existing state from the file.
+func newPersistentLockState(path string, l logger.Logger) (*lockState, error) {
+	store, err := newFileStore(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating Agent API state log: %w", err)
+	}
+	s := newLockState()
+	s.store, s.logger = store, l
+
+	// Recover the state now (rather than on first use) so that any problems
+	// are reported at startup, and compact away whatever has expired since.
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.locks[key]
+	defer s.store.end()
+	if err := s.sync(); err != nil {
+		return nil, err
+	}
+	s.compact()
+	return s, nil
 }
 
-// cas atomically attempts to swap the old value for the key for a new
-// value. It reports whether the swap succeeded, returning the (new or existing)
-// value.
-func (s *lockState) cas(key, old, new string) (string, bool) {
+// close closes the store, if any.
+func (s *lockState) close() error {
+	if s.store == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Close()
+}
+
+// transact calls f with s.mu held, and applies the changes it returns. If the
+// state is persisted, changes from other processes are applied before calling
+// f, and the changes returned by f are written to the store before they are
+// applied.
+//
+// f may also make changes that are not persisted, such as removing expired
+// values (which are removed again anyway when the state is recovered).
+func (s *lockState) transact(f func(now time.Time) []walRecord) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.locks[key] == old {
-		s.locks[key] = new
-		return new, true
+
+	if s.store == nil {
+		for _, rec := range f(s.now()) {
+			s.apply(rec)
+		}
+		return nil
+	}
+
+	defer s.store.end()
+	if err := s.sync(); err != nil {
+		return err
+	}
+	recs := f(s.now())
+	if len(recs) == 0 {
+		return nil
+	}
+	if err := s.store.append(recs); err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		s.apply(rec)
+	}
+	if s.store.needsCompaction() {
+		s.compact()
+	}
+	return nil
+}
+
+// sync locks the store, and applies any changes written to it by other
+// processes. s.mu must be held, and s.store.end must be called afterwards.
+func (s *lockState) sync() error {
+	recs, reset, err := s.store.begin()
+	if err != nil {
+		return err
+	}
+	if reset {
+		clear(s.locks)
+		clear(s.sems)
+	}
+	for _, rec := range recs {
+		s.apply(rec)
+	}
+	return nil
+}
+
+// compact replaces the contents of the store with a snapshot of the current
+// state. Failure to compact is not fatal (the log just grows), so errors are
+// only logged. s.mu must be held and the store must be locked.
+func (s *lockState) compact() {
+	now := s.now()
+	var snapshot []walRecord
+	for _, key := range sortedKeys(s.locks) {
+		s.expire(key, now)
+		e := s.locks[key]
+		if e == nil {
+			continue
+		}
+		snapshot = append(snapshot, walRecord{
+			Op:      opSet,
+			Key:     key,
+			Value:   e.value,
+			Since:   e.since,
+			Expires: e.expires,
+		})
+	}
+	for _, key := range sortedKeys(s.sems) {
+		s.expireSem(key, now)
+		sem := s.sems[key]
+		if sem == nil {
+			continue
+		}
+		for _, token := range sortedKeys(sem.holders) {
+			h := sem.holders[token]
+			snapshot = append(snapshot, walRecord{
+				Op:      opSemSet,
+				Key:     key,
+				Value:   token,
+				Permits: sem.permits,
+				Since:   h.since,
+				Expires: h.expires,
+			})
+		}
+	}
+	if err := s.store.compact(snapshot); err != nil {
+		s.logger.Warn("Agent API: couldn't compact state log: %v", err)
+	}
+}
+
+// apply applies one change to the state. s.mu must be held.
+func (s *lockState) apply(rec walRecord) {
+	switch rec.Op {
+	case opSet:
+		s.locks[rec.Key] = &lockEntry{
+			value:   rec.Value,
+			since:   rec.Since,
+			expires: rec.Expires,
+		}
+
+	case opDelete:
+		delete(s.locks, rec.Key)
+
+	case opSemSet:
+		sem := s.sems[rec.Key]
+		if sem == nil {
+			sem = &semaphore{
+				permits: rec.Permits,
+				holders: make(map[string]*lockEntry),
+			}
+			s.sems[rec.Key] = sem
+		}
+		sem.holders[rec.Value] = &lockEntry{
+			value:   rec.Value,
+			since:   rec.Since,
+			expires: rec.Expires,
+		}
+
+	case opSemDelete:
+		sem := s.sems[rec.Key]
+		if sem == nil {
+			return
+		}
+		delete(sem.holders, rec.Value)
+		if len(sem.holders) == 0 && len(sem.queue) == 0 {
+			delete(s.sems, rec.Key)
+		}
+	}
+}
+
+// expire removes the value for the key if its lease has expired, or its
+// holder is tracked and dead. s.mu must be held.
+func (s *lockState) expire(key string, now time.Time) {
+	e := s.locks[key]
+	if e == nil {
+		return
+	}
+	if !e.expires.IsZero() && now.After(e.expires) {
+		delete(s.locks, key)
+		return
+	}
+	if h := holderOf(e.value); h.tracked() && !s.alive(h) {
+		delete(s.locks, key)
+	}
+}
+
+// load atomically retrieves the current value for the lock.
+func (s *lockState) load(key string) (string, error) {
+	var value string
+	err := s.transact(func(now time.Time) []walRecord {
+		s.expire(key, now)
+		if e := s.locks[key]; e != nil {
+			value = e.value
+		}
+		return nil
+	})
+	return value, err
+}
+
+// cas atomically attempts to swap the old value for the key for a new
+// value. It reports whether the swap succeeded, returning the (new or existing)
+// value. If ttl is non-zero, the new value has a lease of that duration.
+func (s *lockState) cas(key, old, new string, ttl time.Duration) (string, bool, error) {
+	var (
+		value   string
+		swapped bool
+	)
+	err := s.transact(func(now time.Time) []walRecord {
+		s.expire(key, now)
+
+		if e := s.locks[key]; e != nil {
+			value = e.value
+		}
+		if value != old {
+			return nil
+		}
+
+		value, swapped = new, true
+		if new == "" {
+			return []walRecord{{Op: opDelete, Key: key}}
+		}
+		rec := walRecord{Op: opSet, Key: key, Value: new, Since: now}
+		if ttl > 0 {
+			rec.Expires = now.Add(ttl)
+		}
+		return []walRecord{rec}
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return value, swapped, nil
+}
+
+// renew atomically extends the lease on the value for the key, if the current
+// value is token. It reports whether the lease was renewed, returning the
+// current value and the new expiry time.
+func (s *lockState) renew(key, token string, ttl time.Duration) (string, time.Time, bool, error) {
+	var (
+		value   string
+		expires time.Time
+		renewed bool
+	)
+	err := s.transact(func(now time.Time) []walRecord {
+		s.expire(key, now)
+
+		e := s.locks[key]
+		if e == nil {
+			return nil
+		}
+		value, expires = e.value, e.expires
+		if e.value != token {
+			return nil
+		}
+		expires, renewed = now.Add(ttl), true
+		return []walRecord{{Op: opSet, Key: key, Value: token, Since: e.since, Expires: expires}}
+	})
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+	return value, expires, renewed, nil
+}
+
+// info returns information about one lock.
+func (s *lockState) info(key string) (LockInfo, error) {
+	var info LockInfo
+	err := s.transact(func(now time.Time) []walRecord {
+		s.expire(key, now)
+		info = s.infoLocked(key)
+		return nil
+	})
+	return info, err
+}
+
+// list returns information about all held locks, sorted by key.
+func (s *lockState) list() ([]LockInfo, error) {
+	var infos []LockInfo
+	err := s.transact(func(now time.Time) []walRecord {
+		infos = make([]LockInfo, 0, len(s.locks))
+		for _, key := range sortedKeys(s.locks) {
+			s.expire(key, now)
+			if _, ok := s.locks[key]; !ok {
+				continue
+			}
+			infos = append(infos, s.infoLocked(key))
+		}
+		return nil
+	})
+	return infos, err
+}
+
+// infoLocked returns information about one lock. s.mu must be held.
+func (s *lockState) infoLocked(key string) LockInfo {
+	info := LockInfo{Key: key}
+	e := s.locks[key]
+	if e == nil {
+		return info
+	}
+	info.Value = e.value
+	info.Since = e.since
+	if !e.expires.IsZero() {
+		exp := e.expires
+		info.Expires = &exp
+	}
+	if h := holderOf(e.value); h.pid != 0 {
+		info.HolderPID = h.pid
+		if h.tracked() {
+			alive := s.alive(h)
+			info.HolderAlive = &alive
+		}
 	}
-	return s.locks[key], false
+	return info
 }
@@ -0,0 +1,188 @@
+package agentapi
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/system"
+)
+
+// fakeClock is a manually-advanced clock for lockState.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func testLockState(alive map[int]bool) (*lockState, *fakeClock) {
+	clk := &fakeClock{t: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)}
+	s := newLockState()
+	s.now = clk.now
+	s.alive = func(h lockHolder) bool { return alive[h.pid] }
+	return s, clk
+}
+
+func TestLockStateLeaseExpiry(t *testing.T) {
+	t.Parallel()
+	s, clk := testLockState(nil)
+
+	const key, token = "llama", "Kuzco"
+	if _, ok, _ := s.cas(key, "", token, time.Minute); !ok {
+		t.Fatalf("s.cas(%q, %q, %q, time.Minute) swapped = false, want true", key, "", token)
+	}
+
+	clk.advance(50 * time.Second)
+	if got, _ := s.load(key); got != token {
+		t.Errorf("s.load(%q) before expiry = %q, want %q", key, got, token)
+	}
+
+	// Renewing pushes the expiry out by another TTL from now.
+	if _, exp, ok, _ := s.renew(key, token, time.Minute); !ok || !exp.Equal(clk.t.Add(time.Minute)) {
+		t.Errorf("s.renew(%q, %q, time.Minute) = (_, %v, %t), want (_, %v, true)", key, token, exp, ok, clk.t.Add(time.Minute))
+	}
+
+	clk.advance(50 * time.Second)
+	if got, _ := s.load(key); got != token {
+		t.Errorf("s.load(%q) after renewal = %q, want %q", key, got, token)
+	}
+
+	// Renewing with the wrong token does nothing.
+	if got, _, ok, _ := s.renew(key, "Yzma", time.Minute); ok || got != token {
+		t.Errorf("s.renew(%q, %q, time.Minute) = (%q, _, %t), want (%q, _, false)", key, "Yzma", got, ok, token)
+	}
+
+	clk.advance(11 * time.Second)
+	if got, _ := s.load(key); got != "" {
+		t.Errorf("s.load(%q) after expiry = %q, want empty", key, got)
+	}
+
+	// Once expired, the lock can be acquired by someone else.
+	if _, ok, _ := s.cas(key, "", "Yzma", 0); !ok {
+		t.Errorf("s.cas(%q, %q, %q, 0) swapped = false, want true", key, "", "Yzma")
+	}
+}
+
+func TestLockStateDeadHolder(t *testing.T) {
+	t.Parallel()
+	alive := map[int]bool{42: true, 43: true, 44: true, 45: true}
+	s, _ := testLockState(alive)
+
+	leased := "acquired(pid=42,start=5678,otp=abcd)"
+	unleased := "acquired(pid=43,start=5678,pidns=4026531836,otp=abcd)"
+	s.cas("leased", "", leased, time.Hour)
+	s.cas("unleased", "", unleased, 0)
+
+	// Tokens without a start time are from older agents, which recorded the
+	// pid of `lock acquire` itself, or from holders that asked not to be
+	// tracked. Their holders' pids mean nothing.
+	legacy := "acquired(pid=44,otp=abcd)"
+	untracked := "acquired(pid=45,pidns=4026531836,otp=abcd)"
+	s.cas("legacy", "", legacy, 0)
+	s.cas("untracked", "", untracked, 0)
+
+	info, _ := s.info("leased")
+	if info.HolderPID != 42 || info.HolderAlive == nil || !*info.HolderAlive {
+		t.Errorf("s.info(leased) = %+v, want HolderPID 42 and HolderAlive true", info)
+	}
+	info, _ = s.info("legacy")
+	if info.HolderPID != 44 || info.HolderAlive != nil {
+		t.Errorf("s.info(legacy) = %+v, want HolderPID 44 and no HolderAlive", info)
+	}
+
+	for pid := range alive {
+		alive[pid] = false
+	}
+
+	// A leased lock with a dead holder is released.
+	if got, _ := s.load("leased"); got != "" {
+		t.Errorf("s.load(leased) after holder died = %q, want empty", got)
+	}
+
+	// So is a lock without a lease.
+	if got, _ := s.load("unleased"); got != "" {
+		t.Errorf("s.load(unleased) after holder died = %q, want empty", got)
+	}
+	if _, ok, _ := s.cas("unleased", "", "Kronk", 0); !ok {
+		t.Errorf("s.cas(%q, %q, %q, 0) swapped = false, want true", "unleased", "", "Kronk")
+	}
+
+	// Values not created by the lock package have no holder, and stay put.
+	if got, _ := s.load("unleased"); got != "Kronk" {
+		t.Errorf("s.load(unleased) = %q, want %q", got, "Kronk")
+	}
+
+	// Untracked locks stay held, even though the pid is dead.
+	if got, _ := s.load("legacy"); got != legacy {
+		t.Errorf("s.load(legacy) = %q, want %q", got, legacy)
+	}
+	if _, ok, _ := s.cas("legacy", "", "Kronk", 0); ok {
+		t.Errorf("s.cas(%q, %q, %q, 0) swapped = true, want false", "legacy", "", "Kronk")
+	}
+	if got, _ := s.load("untracked"); got != untracked {
+		t.Errorf("s.load(untracked) = %q, want %q", got, untracked)
+	}
+}
+
+func TestLockStateList(t *testing.T) {
+	t.Parallel()
+	s, clk := testLockState(nil)
+
+	s.cas("b", "", "2", 0)
+	s.cas("a", "", "1", 0)
+	s.cas("c", "", "3", time.Second)
+	clk.advance(2 * time.Second)
+
+	infos, _ := s.list()
+	var keys []string
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("s.list() keys = %q, want [a b]", keys)
+	}
+}
+
+func TestHolderOf(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		value string
+		want  lockHolder
+	}{
+		{"acquired(pid=1234,otp=00ff)", lockHolder{pid: 1234}},
+		{"permit(pid=1234,start=5678,pidns=4026531836,otp=00ff)", lockHolder{pid: 1234, start: 5678, pidNS: 4026531836}},
+		{"acquired(pid=1234,start=5678,otp=00ff)", lockHolder{pid: 1234, start: 5678}},
+		{"doing", lockHolder{}},
+		{"", lockHolder{}},
+		{"acquired(pid=,otp=00ff)", lockHolder{}},
+	}
+	for _, test := range tests {
+		if got := holderOf(test.value); got != test.want {
+			t.Errorf("holderOf(%q) = %+v, want %+v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestHolderAlive(t *testing.T) {
+	t.Parallel()
+
+	pid := os.Getpid()
+	start, err := system.GetProcessStartTime(pid)
+	if err != nil {
+		t.Skipf("system.GetProcessStartTime(%d) error = %v", pid, err)
+	}
+
+	if !holderAlive(lockHolder{pid: pid, start: start}) {
+		t.Errorf("holderAlive(this process) = false, want true")
+	}
+	if holderAlive(lockHolder{pid: pid, start: start + 1}) {
+		t.Errorf("holderAlive(this pid, different start time) = true, want false, since the pid was reused")
+	}
+
+	ns, err := system.GetPIDNamespace()
+	if err != nil {
+		return
+	}
+	if !holderAlive(lockHolder{pid: pid, start: start + 1, pidNS: ns + 1}) {
+		t.Errorf("holderAlive(holder in another PID namespace) = false, want true, since it can't be checked")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // DefaultSocketPath constructs the default path for the Agent API socket.
@@ -11,7 +13,30 @@ func DefaultSocketPath(base string) string {
 	return filepath.Join(base, fmt.Sprintf("agent-%d", os.Getpid()))
 }
 
+// AgentSocketPaths returns the paths to the Agent API sockets of all agents
+// using the sockets directory base (including sockets left behind by agents
+// that have since exited).
+func AgentSocketPaths(base string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(base, "agent-[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	sockets := paths[:0]
+	for _, p := range paths {
+		if _, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(p), "agent-")); err == nil {
+			sockets = append(sockets, p)
+		}
+	}
+	return sockets, nil
+}
+
 // LeaderPath returns the path to the socket pointing to the leader agent.
 func LeaderPath(base string) string {
 	return filepath.Join(base, "agent-leader")
 }
+
+// StatePath returns the path to the log file used to persist the Agent API
+// state (see WithStatePath).
+func StatePath(base string) string {
+	return filepath.Join(base, "agent-api-state.jsonl")
+}
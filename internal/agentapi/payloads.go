@@ -16,6 +16,10 @@ type ValueResponse struct {
 type LockCASRequest struct {
 	Old string `json:"old"`
 	New string `json:"new"`
+
+	// TTL is the duration of the lease on the new value. If non-zero, the new
+	// value is removed when the lease expires, unless it is renewed first.
+	TTL time.Duration `json:"ttl,omitempty"`
 }
 
 // LockCASResponse is the response body for the PATCH /lock/{key} endpoint.
@@ -23,3 +27,126 @@ type LockCASResponse struct {
 	Value   string `json:"value"`
 	Swapped bool   `json:"swapped"`
 }
+
+// LockRenewRequest is the request body for the POST /lock/renew endpoint.
+type LockRenewRequest struct {
+	Token string        `json:"token"`
+	TTL   time.Duration `json:"ttl"`
+}
+
+// LockRenewResponse is the response body for the POST /lock/renew endpoint.
+type LockRenewResponse struct {
+	Value   string    `json:"value"`
+	Renewed bool      `json:"renewed"`
+	Expires time.Time `json:"expires"`
+}
+
+// LockInfo describes the current state of a lock. It is the response body
+// for the GET /lock/info endpoint.
+type LockInfo struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+
+	// When the value was set.
+	Since time.Time `json:"since"`
+
+	// When the lease on the value expires. Nil if there is no lease.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// The process that acquired the lock, if the value is a token from
+	// lock.Client.Lock, and whether that process is still running (only if
+	// the lock is released when it exits).
+	HolderPID   int   `json:"holder_pid,omitempty"`
+	HolderAlive *bool `json:"holder_alive,omitempty"`
+}
+
+// LockListResponse is the response body for the GET /lock/list endpoint.
+type LockListResponse struct {
+	Locks      []LockInfo      `json:"locks"`
+	Semaphores []SemaphoreInfo `json:"semaphores"`
+}
+
+// SemaphoreAcquireRequest is the request body for the
+// POST /lock/semaphore/acquire endpoint.
+type SemaphoreAcquireRequest struct {
+	Token   string        `json:"token"`
+	Permits int           `json:"permits"`
+	TTL     time.Duration `json:"ttl,omitempty"`
+}
+
+// SemaphoreAcquireResponse is the response body for the
+// POST /lock/semaphore/acquire endpoint.
+type SemaphoreAcquireResponse struct {
+	Acquired bool `json:"acquired"`
+
+	// Position of the token in the queue, if not acquired.
+	Position int `json:"position"`
+}
+
+// SemaphoreReleaseRequest is the request body for the
+// POST /lock/semaphore/release endpoint.
+type SemaphoreReleaseRequest struct {
+	Token string `json:"token"`
+}
+
+// SemaphoreReleaseResponse is the response body for the
+// POST /lock/semaphore/release endpoint.
+type SemaphoreReleaseResponse struct {
+	Released bool `json:"released"`
+}
+
+// SemaphoreInfo describes the current state of a semaphore. It is the
+// response body for the GET /lock/semaphore/info endpoint.
+type SemaphoreInfo struct {
+	Key     string     `json:"key"`
+	Permits int        `json:"permits"`
+	Holders []LockInfo `json:"holders"`
+	Waiting int        `json:"waiting"`
+}
+
+// WorkerInfo describes an agent worker. Each agent process runs one worker
+// per spawned agent (see --spawn).
+type WorkerInfo struct {
+	// The agent's ID and name, as registered with Buildkite.
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	SpawnIndex int `json:"spawn_index"`
+
+	// "idle", "busy", or "refusing" (connected, but not accepting jobs).
+	State string `json:"state"`
+
+	// Why the worker is refusing jobs, if it is.
+	Reason string `json:"reason,omitempty"`
+
+	// The job the worker is running, if busy.
+	JobID string `json:"job_id,omitempty"`
+
+	// Whether the worker is stopping, and will not accept any more jobs.
+	Stopping bool `json:"stopping"`
+}
+
+// WorkersResponse is the response body for the GET /workers endpoint.
+type WorkersResponse struct {
+	// The agent process serving the API.
+	PID int `json:"pid"`
+
+	Workers []WorkerInfo `json:"workers"`
+}
+
+// CancelJobRequest is the request body for the POST /workers/cancel-job
+// endpoint. At least one of Worker or JobID is required. If both are given,
+// the job is only cancelled if the worker is still running that job.
+type CancelJobRequest struct {
+	// The ID or name of the worker.
+	Worker string `json:"worker,omitempty"`
+
+	JobID string `json:"job_id,omitempty"`
+}
+
+// CancelJobResponse is the response body for the POST /workers/cancel-job
+// endpoint.
+type CancelJobResponse struct {
+	// The job that was cancelled.
+	JobID string `json:"job_id"`
+}
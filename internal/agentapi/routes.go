@@ -25,6 +25,8 @@ func (s *Server) router(log logger.Logger) chi.Router {
 	r.Route("/api/leader/v0", func(r chi.Router) {
 		r.Get("/ping", pingHandler(log))
 		r.Route("/lock", s.lockSvr.routes)
+		r.Route("/cluster-lock", s.clusterLockSvr.routes)
+		r.Route("/workers", s.workersSvr.routes)
 	})
 
 	return r
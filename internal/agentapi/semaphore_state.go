@@ -0,0 +1,254 @@
+package agentapi
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// waiterTimeout is how long a semaphore waiter may go without polling before
+// it is removed from the queue. Clients poll much more often than this.
+const waiterTimeout = 5 * time.Second
+
+// errPermitsMismatch is returned when acquiring a semaphore with a different
+// number of permits to the one it is currently in use with.
+var errPermitsMismatch = errors.New("semaphore permits mismatch")
+
+// errInvalidPermits is returned when acquiring a semaphore with fewer than one
+// permit.
+var errInvalidPermits = errors.New("permits must be at least 1")
+
+// semaphore is the state of a single counting semaphore.
+type semaphore struct {
+	permits int
+
+	// The holders of permits, by token.
+	holders map[string]*lockEntry
+
+	// Processes waiting for a permit, in order of arrival.
+	queue []*semWaiter
+}
+
+// semWaiter is a process waiting for a semaphore permit.
+type semWaiter struct {
+	token    string
+	lastSeen time.Time
+}
+
+// expireSem removes semaphore permits whose leases have expired or whose
+// tracked holders are dead, and waiters that have stopped polling or are
+// tracked and dead. If the
+// semaphore is then unused, it is removed entirely. s.mu must be held.
+func (s *lockState) expireSem(key string, now time.Time) {
+	sem := s.sems[key]
+	if sem == nil {
+		return
+	}
+	for token, h := range sem.holders {
+		if !h.expires.IsZero() && now.After(h.expires) {
+			delete(sem.holders, token)
+			continue
+		}
+		if h := holderOf(token); h.tracked() && !s.alive(h) {
+			delete(sem.holders, token)
+		}
+	}
+	sem.queue = slices.DeleteFunc(sem.queue, func(w *semWaiter) bool {
+		if now.Sub(w.lastSeen) > waiterTimeout {
+			return true
+		}
+		h := holderOf(w.token)
+		return h.tracked() && !s.alive(h)
+	})
+	if len(sem.holders) == 0 && len(sem.queue) == 0 {
+		delete(s.sems, key)
+	}
+}
+
+// semAcquire tries to acquire a permit from the semaphore for the key, on
+// behalf of token. The semaphore is created with the given number of permits
+// if it is not in use.
+//
+// Permits are granted in the order that tokens first tried to acquire them.
+// If a permit is not available, token is queued, and semAcquire must be
+// called again (within waiterTimeout) to keep its place in the queue. It
+// reports whether a permit was acquired; otherwise it returns the position of
+// token in the queue (starting from 0).
+//
+// If ttl is non-zero, the permit is held under a lease of that duration.
+func (s *lockState) semAcquire(key, token string, permits int, ttl time.Duration) (bool, int, error) {
+	if permits < 1 {
+		return false, 0, fmt.Errorf("%w, got %d", errInvalidPermits, permits)
+	}
+
+	var (
+		acquired bool
+		pos      int
+		permErr  error
+	)
+	err := s.transact(func(now time.Time) []walRecord {
+		s.expireSem(key, now)
+
+		sem := s.sems[key]
+		if sem == nil {
+			sem = &semaphore{
+				permits: permits,
+				holders: make(map[string]*lockEntry),
+			}
+			s.sems[key] = sem
+		}
+		if sem.permits != permits {
+			permErr = fmt.Errorf("%w: %q is in use with %d permits, not %d", errPermitsMismatch, key, sem.permits, permits)
+			return nil
+		}
+		if _, held := sem.holders[token]; held {
+			acquired = true
+			return nil
+		}
+
+		pos = slices.IndexFunc(sem.queue, func(w *semWaiter) bool { return w.token == token })
+		if pos < 0 {
+			pos = len(sem.queue)
+			sem.queue = append(sem.queue, &semWaiter{token: token})
+		}
+		sem.queue[pos].lastSeen = now
+
+		// Only the waiters at the front of the queue may take free permits,
+		// so that later arrivals can't overtake earlier ones.
+		if pos >= sem.permits-len(sem.holders) {
+			return nil
+		}
+
+		sem.queue = slices.Delete(sem.queue, pos, pos+1)
+		acquired, pos = true, 0
+		rec := walRecord{Op: opSemSet, Key: key, Value: token, Permits: permits, Since: now}
+		if ttl > 0 {
+			rec.Expires = now.Add(ttl)
+		}
+		return []walRecord{rec}
+	})
+	if err == nil {
+		err = permErr
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return acquired, pos, nil
+}
+
+// semRelease releases the permit held by token, or removes token from the
+// queue if it is waiting. It reports whether token was holding or waiting.
+func (s *lockState) semRelease(key, token string) (bool, error) {
+	var found bool
+	err := s.transact(func(now time.Time) []walRecord {
+		s.expireSem(key, now)
+
+		sem := s.sems[key]
+		if sem == nil {
+			return nil
+		}
+		if i := slices.IndexFunc(sem.queue, func(w *semWaiter) bool { return w.token == token }); i >= 0 {
+			sem.queue = slices.Delete(sem.queue, i, i+1)
+			found = true
+		}
+		if _, held := sem.holders[token]; held {
+			found = true
+			return []walRecord{{Op: opSemDelete, Key: key, Value: token}}
+		}
+		if len(sem.holders) == 0 && len(sem.queue) == 0 {
+			delete(s.sems, key)
+		}
+		return nil
+	})
+	return found, err
+}
+
+// semRenew extends the lease on the permit held by token. It reports whether
+// the lease was renewed, returning the new expiry time.
+func (s *lockState) semRenew(key, token string, ttl time.Duration) (time.Time, bool, error) {
+	var (
+		expires time.Time
+		renewed bool
+	)
+	err := s.transact(func(now time.Time) []walRecord {
+		s.expireSem(key, now)
+
+		sem := s.sems[key]
+		if sem == nil {
+			return nil
+		}
+		h := sem.holders[token]
+		if h == nil {
+			return nil
+		}
+		expires, renewed = now.Add(ttl), true
+		return []walRecord{{Op: opSemSet, Key: key, Value: token, Permits: sem.permits, Since: h.since, Expires: expires}}
+	})
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return expires, renewed, nil
+}
+
+// semInfo returns information about one semaphore.
+func (s *lockState) semInfo(key string) (SemaphoreInfo, error) {
+	var info SemaphoreInfo
+	err := s.transact(func(now time.Time) []walRecord {
+		s.expireSem(key, now)
+		info = s.semInfoLocked(key)
+		return nil
+	})
+	return info, err
+}
+
+// semList returns information about all semaphores in use, sorted by key.
+func (s *lockState) semList() ([]SemaphoreInfo, error) {
+	var infos []SemaphoreInfo
+	err := s.transact(func(now time.Time) []walRecord {
+		infos = make([]SemaphoreInfo, 0, len(s.sems))
+		for _, key := range sortedKeys(s.sems) {
+			s.expireSem(key, now)
+			if _, ok := s.sems[key]; !ok {
+				continue
+			}
+			infos = append(infos, s.semInfoLocked(key))
+		}
+		return nil
+	})
+	return infos, err
+}
+
+// semInfoLocked returns information about one semaphore. s.mu must be held.
+func (s *lockState) semInfoLocked(key string) SemaphoreInfo {
+	info := SemaphoreInfo{Key: key}
+	sem := s.sems[key]
+	if sem == nil {
+		return info
+	}
+	info.Permits = sem.permits
+	info.Waiting = len(sem.queue)
+	for token, h := range sem.holders {
+		hi := LockInfo{
+			Key:   key,
+			Value: token,
+			Since: h.since,
+		}
+		if !h.expires.IsZero() {
+			exp := h.expires
+			hi.Expires = &exp
+		}
+		if h := holderOf(token); h.pid != 0 {
+			hi.HolderPID = h.pid
+			if h.tracked() {
+				alive := s.alive(h)
+				hi.HolderAlive = &alive
+			}
+		}
+		info.Holders = append(info.Holders, hi)
+	}
+	slices.SortFunc(info.Holders, func(a, b LockInfo) int {
+		return a.Since.Compare(b.Since)
+	})
+	return info
+}
@@ -0,0 +1,119 @@
+package agentapi
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSemaphoreFIFO(t *testing.T) {
+	t.Parallel()
+	s, _ := testLockState(nil)
+
+	const key = "emulators"
+	acquire := func(token string) (bool, int) {
+		t.Helper()
+		ok, pos, err := s.semAcquire(key, token, 2, 0)
+		if err != nil {
+			t.Fatalf("s.semAcquire(%q, %q, 2, 0) = error %v", key, token, err)
+		}
+		return ok, pos
+	}
+
+	for _, token := range []string{"a", "b"} {
+		if ok, _ := acquire(token); !ok {
+			t.Errorf("acquire(%q) = false, want true", token)
+		}
+	}
+	if ok, pos := acquire("c"); ok || pos != 0 {
+		t.Errorf("acquire(c) = (%t, %d), want (false, 0)", ok, pos)
+	}
+	if ok, pos := acquire("d"); ok || pos != 1 {
+		t.Errorf("acquire(d) = (%t, %d), want (false, 1)", ok, pos)
+	}
+
+	// Once a permit is released, d must not overtake c.
+	if ok, _ := s.semRelease(key, "a"); !ok {
+		t.Errorf("s.semRelease(%q, a) = false, want true", key)
+	}
+	if ok, _ := acquire("d"); ok {
+		t.Errorf("acquire(d) = true before c, want false")
+	}
+	if ok, _ := acquire("c"); !ok {
+		t.Errorf("acquire(c) = false, want true")
+	}
+	if ok, pos := acquire("d"); ok || pos != 0 {
+		t.Errorf("acquire(d) = (%t, %d), want (false, 0)", ok, pos)
+	}
+
+	info, _ := s.semInfo(key)
+	if info.Permits != 2 || len(info.Holders) != 2 || info.Waiting != 1 {
+		t.Errorf("s.semInfo(%q) = %+v, want 2 permits, 2 holders, 1 waiting", key, info)
+	}
+}
+
+func TestSemaphorePermitsMismatch(t *testing.T) {
+	t.Parallel()
+	s, _ := testLockState(nil)
+
+	if _, _, err := s.semAcquire("docker", "a", 2, 0); err != nil {
+		t.Fatalf("s.semAcquire(docker, a, 2, 0) = error %v", err)
+	}
+	if _, _, err := s.semAcquire("docker", "b", 3, 0); !errors.Is(err, errPermitsMismatch) {
+		t.Errorf("s.semAcquire(docker, b, 3, 0) = error %v, want errPermitsMismatch", err)
+	}
+	if _, _, err := s.semAcquire("docker", "b", 0, 0); err == nil {
+		t.Errorf("s.semAcquire(docker, b, 0, 0) = nil error, want error")
+	}
+
+	// Once the semaphore is no longer in use, it can be used with a different
+	// number of permits.
+	s.semRelease("docker", "a")
+	if _, _, err := s.semAcquire("docker", "b", 3, 0); err != nil {
+		t.Errorf("s.semAcquire(docker, b, 3, 0) after release = error %v", err)
+	}
+}
+
+func TestSemaphoreExpiry(t *testing.T) {
+	t.Parallel()
+	alive := map[int]bool{42: true}
+	s, clk := testLockState(alive)
+
+	const key = "gpu"
+	s.semAcquire(key, "leased", 1, time.Minute)
+	if ok, _, _ := s.semAcquire(key, "waiter", 1, 0); ok {
+		t.Fatalf("s.semAcquire(%q, waiter, 1, 0) = true, want false", key)
+	}
+
+	// The waiter keeps polling while the lease runs out.
+	for range 13 {
+		clk.advance(5 * time.Second)
+		s.semAcquire(key, "waiter", 1, 0)
+	}
+	info, _ := s.semInfo(key)
+	if len(info.Holders) != 1 || info.Holders[0].Value != "waiter" {
+		t.Errorf("s.semInfo(%q) holders = %+v, want [waiter]", key, info.Holders)
+	}
+
+	// A waiter that stops polling loses its place.
+	s.semAcquire(key, "quitter", 1, 0)
+	clk.advance(waiterTimeout + time.Second)
+	if info, _ := s.semInfo(key); info.Waiting != 0 {
+		t.Errorf("s.semInfo(%q) waiting = %d, want 0", key, info.Waiting)
+	}
+
+	// A permit held by a dead process is released, if its holder is tracked.
+	s.semRelease(key, "waiter")
+	token := "permit(pid=42,start=5678,otp=00)"
+	s.semAcquire(key, token, 1, 0)
+	alive[42] = false
+	if info, _ := s.semInfo(key); len(info.Holders) != 0 {
+		t.Errorf("s.semInfo(%q) holders = %+v after holder died, want none", key, info.Holders)
+	}
+
+	untracked := "permit(pid=42,otp=01)"
+	s.semAcquire(key, untracked, 1, 0)
+	if info, _ := s.semInfo(key); len(info.Holders) != 1 || info.Holders[0].HolderAlive != nil {
+		t.Errorf("s.semInfo(%q) holders = %+v, want the untracked permit, without HolderAlive", key, info.Holders)
+	}
+}
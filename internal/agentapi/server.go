@@ -1,6 +1,10 @@
 package agentapi
 
 import (
+	"context"
+	"errors"
+
+	"github.com/buildkite/agent/v3/internal/clusterlock"
 	"github.com/buildkite/agent/v3/internal/socket"
 	"github.com/buildkite/agent/v3/logger"
 )
@@ -9,19 +13,100 @@ import (
 type Server struct {
 	*socket.Server
 
-	lockSvr *lockServer
+	lockSvr        *lockServer
+	clusterLockSvr *clusterLockServer
+	workersSvr     *workersServer
+}
+
+// ServerOption configures a Server.
+type ServerOption func(*serverOptions)
+
+type serverOptions struct {
+	statePath      string
+	clusterBackend clusterlock.Backend
+	token          string
+}
+
+// WithStatePath persists the server state (locks and semaphores) in a log
+// file at path, so that it survives the agent restarting. Agents sharing a
+// sockets directory should use the same path (see StatePath), so that any of
+// them can take over as leader without losing state.
+func WithStatePath(path string) ServerOption {
+	return func(o *serverOptions) { o.statePath = path }
+}
+
+// WithClusterLockBackend serves cluster-scoped lock requests using the
+// backend. The server closes the backend when it is closed or shut down.
+func WithClusterLockBackend(b clusterlock.Backend) ServerOption {
+	return func(o *serverOptions) { o.clusterBackend = b }
+}
+
+// WithToken requires requests for worker introspection and job control to
+// have the token as a bearer token. Other requests (such as for locks) are
+// only protected by the permissions on the socket.
+func WithToken(token string) ServerOption {
+	return func(o *serverOptions) { o.token = token }
 }
 
 // NewServer creates a new Agent API server that, when started, listens on the
 // socketPath.
-func NewServer(socketPath string, log logger.Logger) (*Server, error) {
+func NewServer(socketPath string, log logger.Logger, opts ...ServerOption) (*Server, error) {
+	var o serverOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	s := &Server{
 		lockSvr: newLockServer(log),
+		clusterLockSvr: &clusterLockServer{
+			logger:  log,
+			backend: o.clusterBackend,
+		},
+		workersSvr: &workersServer{
+			logger: log,
+			token:  o.token,
+		},
 	}
+	if o.statePath != "" {
+		lockSvr, err := newPersistentLockServer(log, o.statePath)
+		if err != nil {
+			return nil, err
+		}
+		s.lockSvr = lockSvr
+	}
+
 	svr, err := socket.NewServer(socketPath, s.router(log))
 	if err != nil {
-		return nil, err
+		return nil, errors.Join(err, s.closeState())
 	}
 	s.Server = svr
 	return s, nil
 }
+
+// Close immediately closes down the server, and the state log and cluster
+// lock backend (if any).
+func (s *Server) Close() error {
+	return errors.Join(s.Server.Close(), s.closeState())
+}
+
+// Shutdown gracefully shuts down the server, then closes the state log and
+// cluster lock backend (if any).
+func (s *Server) Shutdown(ctx context.Context) error {
+	return errors.Join(s.Server.Shutdown(ctx), s.closeState())
+}
+
+// SetWorkerController sets the WorkerController used to serve worker
+// introspection and job control requests. Until it is called, those requests
+// fail.
+func (s *Server) SetWorkerController(wc WorkerController) {
+	s.workersSvr.setController(wc)
+}
+
+// closeState closes the state log and cluster lock backend.
+func (s *Server) closeState() error {
+	err := s.lockSvr.locks.close()
+	if b := s.clusterLockSvr.backend; b != nil {
+		err = errors.Join(err, b.Close())
+	}
+	return err
+}
@@ -0,0 +1,250 @@
+package agentapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// compactEvery is the number of records appended to the state log between
+// compactions.
+const compactEvery = 1000
+
+// Operations recorded in the state log.
+const (
+	opSet       = "set"        // set a lock value
+	opDelete    = "delete"     // remove a lock value
+	opSemSet    = "sem-set"    // grant (or renew) a semaphore permit
+	opSemDelete = "sem-delete" // release a semaphore permit
+)
+
+// walRecord is one entry in the state log. Replaying the records in order
+// reproduces the lock state (apart from semaphore queues, which are not
+// persisted).
+type walRecord struct {
+	Op      string    `json:"op"`
+	Key     string    `json:"key"`
+	Value   string    `json:"value,omitempty"`
+	Permits int       `json:"permits,omitempty"`
+	Since   time.Time `json:"since"`
+	Expires time.Time `json:"expires"`
+}
+
+// fileStore is a write-ahead log of lock state, stored as JSON lines in a
+// file. The log is shared between all agents using the same sockets
+// directory: every operation on the state happens with an exclusive flock
+// held, after first replaying any records appended by other processes. This
+// way, whichever agent becomes leader continues from the same state.
+//
+// The log is periodically compacted by replacing it with a snapshot of the
+// current state.
+type fileStore struct {
+	path string
+	lock *flock.Flock
+
+	// The log file as of the last begin, and how much of it has been
+	// replayed. A nil file means the log must be replayed from scratch.
+	file   *os.File
+	offset int64
+
+	// Records appended since the last compaction.
+	writes int
+}
+
+// newFileStore creates a fileStore for the log at path. The log is not read
+// until the first begin.
+func newFileStore(path string) (*fileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	return &fileStore{
+		path: path,
+		lock: flock.New(path + ".lock"),
+	}, nil
+}
+
+// begin locks the log, and returns the records written to it since the last
+// call to begin. reset reports whether the records replace all existing
+// state (rather than following on from the records previously returned).
+// end must be called after begin returns, whether or not it returns an error.
+func (f *fileStore) begin() (recs []walRecord, reset bool, err error) {
+	if err := f.lock.Lock(); err != nil {
+		return nil, false, fmt.Errorf("locking state log: %w", err)
+	}
+
+	// Has the log been replaced (compacted by another process) or removed?
+	fi, err := os.Stat(f.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if f.file != nil {
+			f.file.Close()
+		}
+		f.file, err = os.OpenFile(f.path, os.O_RDWR|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, false, fmt.Errorf("creating state log: %w", err)
+		}
+		f.offset = 0
+		return nil, true, nil
+
+	case err != nil:
+		return nil, false, fmt.Errorf("checking state log: %w", err)
+	}
+
+	if f.file != nil {
+		cur, err := f.file.Stat()
+		if err != nil || !os.SameFile(fi, cur) {
+			f.file.Close()
+			f.file = nil
+		}
+	}
+	if f.file == nil {
+		f.file, err = os.OpenFile(f.path, os.O_RDWR, 0o600)
+		if err != nil {
+			return nil, false, fmt.Errorf("opening state log: %w", err)
+		}
+		f.offset = 0
+		reset = true
+	}
+
+	if fi.Size() == f.offset {
+		return nil, reset, nil
+	}
+	recs, err = f.readFrom(f.offset)
+	return recs, reset, err
+}
+
+// readFrom reads the records in the log from the given offset to the end.
+// An incomplete record at the end of the log (from a process that crashed
+// while writing it) is discarded.
+func (f *fileStore) readFrom(offset int64) ([]walRecord, error) {
+	if _, err := f.file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f.file)
+	var recs []walRecord
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				if err := f.file.Truncate(offset); err != nil {
+					return nil, fmt.Errorf("truncating incomplete record in state log: %w", err)
+				}
+			}
+			f.offset = offset
+			return recs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading state log: %w", err)
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("corrupt record in state log %s at offset %d: %w", f.path, offset, err)
+		}
+		recs = append(recs, rec)
+		offset += int64(len(line))
+	}
+}
+
+// append writes records to the end of the log. The log must be locked.
+func (f *fileStore) append(recs []walRecord) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	if _, err := f.file.WriteAt(buf.Bytes(), f.offset); err != nil {
+		f.invalidate()
+		return fmt.Errorf("writing state log: %w", err)
+	}
+	if err := f.file.Sync(); err != nil {
+		f.invalidate()
+		return fmt.Errorf("syncing state log: %w", err)
+	}
+	f.offset += int64(buf.Len())
+	f.writes += len(recs)
+	return nil
+}
+
+// needsCompaction reports whether enough has been written to the log since
+// the last compaction that it should be compacted.
+func (f *fileStore) needsCompaction() bool {
+	return f.writes >= compactEvery
+}
+
+// compact replaces the log with the records in snapshot, which should
+// describe the entire current state. The log must be locked.
+func (f *fileStore) compact(snapshot []walRecord) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("creating state snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // in case anything fails
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, rec := range snapshot {
+		if err := enc.Encode(rec); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing state snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing state snapshot: %w", err)
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		tmp.Close()
+		return fmt.Errorf("replacing state log: %w", err)
+	}
+
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, f.offset, f.writes = tmp, size, 0
+	return nil
+}
+
+// end unlocks the log.
+func (f *fileStore) end() {
+	f.lock.Unlock()
+}
+
+// invalidate forgets how much of the log has been replayed, so that the next
+// begin replays it from scratch. This is used to recover from errors that
+// leave the in-memory state and the log out of step.
+func (f *fileStore) invalidate() {
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, f.offset = nil, 0
+}
+
+// Close closes the log.
+func (f *fileStore) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,163 @@
+package agentapi
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+)
+
+func testPersistentLockState(t *testing.T, path string) *lockState {
+	t.Helper()
+	s, err := newPersistentLockState(path, logger.Discard)
+	if err != nil {
+		t.Fatalf("newPersistentLockState(%q, logger.Discard) = error %v", path, err)
+	}
+	t.Cleanup(func() { s.close() })
+	return s
+}
+
+func TestPersistentLockStateRecovers(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+
+	s := testPersistentLockState(t, path)
+	if _, ok, err := s.cas("once", "", "done", 0); err != nil || !ok {
+		t.Fatalf("s.cas(once, %q, done, 0) = (_, %t, %v), want (_, true, nil)", "", ok, err)
+	}
+	if _, ok, err := s.cas("leased", "", "Kuzco", time.Hour); err != nil || !ok {
+		t.Fatalf("s.cas(leased, %q, Kuzco, time.Hour) = (_, %t, %v), want (_, true, nil)", "", ok, err)
+	}
+	if _, ok, err := s.cas("gone", "", "Yzma", 0); err != nil || !ok {
+		t.Fatalf("s.cas(gone, %q, Yzma, 0) = (_, %t, %v), want (_, true, nil)", "", ok, err)
+	}
+	if _, ok, err := s.cas("gone", "Yzma", "", 0); err != nil || !ok {
+		t.Fatalf("s.cas(gone, Yzma, %q, 0) = (_, %t, %v), want (_, true, nil)", "", ok, err)
+	}
+	if ok, _, err := s.semAcquire("docker", "permit-a", 2, 0); err != nil || !ok {
+		t.Fatalf("s.semAcquire(docker, permit-a, 2, 0) = (%t, _, %v), want (true, _, nil)", ok, err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatalf("s.close() = %v", err)
+	}
+
+	// A new lockState (as in a restarted agent) recovers the state.
+	s2 := testPersistentLockState(t, path)
+	for key, want := range map[string]string{
+		"once":   "done",
+		"leased": "Kuzco",
+		"gone":   "",
+	} {
+		if got, err := s2.load(key); err != nil || got != want {
+			t.Errorf("s2.load(%q) = (%q, %v), want (%q, nil)", key, got, err, want)
+		}
+	}
+	info, err := s2.info("leased")
+	if err != nil || info.Expires == nil {
+		t.Errorf("s2.info(leased) = (%+v, %v), want a lease", info, err)
+	}
+	sem, err := s2.semInfo("docker")
+	if err != nil || sem.Permits != 2 || len(sem.Holders) != 1 || sem.Holders[0].Value != "permit-a" {
+		t.Errorf("s2.semInfo(docker) = (%+v, %v), want 2 permits held by permit-a", sem, err)
+	}
+}
+
+func TestPersistentLockStateShared(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+
+	// Two agents sharing a sockets directory share the log, so whichever is
+	// leader sees the same state.
+	s1 := testPersistentLockState(t, path)
+	s2 := testPersistentLockState(t, path)
+
+	if _, ok, err := s1.cas("llama", "", "Kuzco", 0); err != nil || !ok {
+		t.Fatalf("s1.cas(llama, %q, Kuzco, 0) = (_, %t, %v), want (_, true, nil)", "", ok, err)
+	}
+	if got, ok, err := s2.cas("llama", "", "Yzma", 0); err != nil || ok || got != "Kuzco" {
+		t.Errorf("s2.cas(llama, %q, Yzma, 0) = (%q, %t, %v), want (Kuzco, false, nil)", "", got, ok, err)
+	}
+	if _, ok, err := s2.cas("llama", "Kuzco", "", 0); err != nil || !ok {
+		t.Errorf("s2.cas(llama, Kuzco, %q, 0) = (_, %t, %v), want (_, true, nil)", "", ok, err)
+	}
+	if got, err := s1.load("llama"); err != nil || got != "" {
+		t.Errorf("s1.load(llama) = (%q, %v), want (%q, nil)", got, err, "")
+	}
+}
+
+func TestPersistentLockStateIncompleteRecord(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+
+	s := testPersistentLockState(t, path)
+	if _, _, err := s.cas("llama", "", "Kuzco", 0); err != nil {
+		t.Fatalf("s.cas(llama, %q, Kuzco, 0) = error %v", "", err)
+	}
+	s.close()
+
+	// Simulate a crash part-way through writing a record.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("os.OpenFile(%q) = error %v", path, err)
+	}
+	f.WriteString(`{"op":"set","key":"alpaca","val`)
+	f.Close()
+
+	s2 := testPersistentLockState(t, path)
+	if got, err := s2.load("llama"); err != nil || got != "Kuzco" {
+		t.Errorf("s2.load(llama) = (%q, %v), want (Kuzco, nil)", got, err)
+	}
+	if got, err := s2.load("alpaca"); err != nil || got != "" {
+		t.Errorf("s2.load(alpaca) = (%q, %v), want (%q, nil)", got, err, "")
+	}
+}
+
+func TestPersistentLockStateCorrupt(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	data := "{\"op\":\"set\",\"key\":\"llama\",\"value\":\"Kuzco\"}\nnot json\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%q) = %v", path, err)
+	}
+
+	if _, err := newPersistentLockState(path, logger.Discard); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("newPersistentLockState(%q, logger.Discard) = error %v, want corrupt record error", path, err)
+	}
+}
+
+func TestPersistentLockStateCompacts(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+
+	s := testPersistentLockState(t, path)
+	if _, _, err := s.cas("once", "", "done", 0); err != nil {
+		t.Fatalf("s.cas(once, %q, done, 0) = error %v", "", err)
+	}
+	for i := range compactEvery + 10 {
+		old, new := "", "Kuzco"
+		if i%2 == 1 {
+			old, new = new, old
+		}
+		if _, ok, err := s.cas("llama", old, new, 0); err != nil || !ok {
+			t.Fatalf("s.cas(llama, %q, %q, 0) = (_, %t, %v), want (_, true, nil)", old, new, ok, err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) = error %v", path, err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines > 20 {
+		t.Errorf("state log has %d records after compaction, want at most 20", lines)
+	}
+	s.close()
+
+	s2 := testPersistentLockState(t, path)
+	if got, err := s2.load("once"); err != nil || got != "done" {
+		t.Errorf("s2.load(once) = (%q, %v), want (done, nil)", got, err)
+	}
+}
@@ -0,0 +1,136 @@
+package agentapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	// ErrWorkerNotFound is returned by WorkerController.CancelJob when no
+	// worker matches the request.
+	ErrWorkerNotFound = errors.New("no such worker")
+
+	// ErrJobNotRunning is returned by WorkerController.CancelJob when the
+	// worker isn't running a job, or isn't running the requested job.
+	ErrJobNotRunning = errors.New("job is not running")
+)
+
+// WorkerController provides information about the agent workers in an agent
+// process, and control over the jobs they run. It is implemented by
+// agent.AgentPool.
+type WorkerController interface {
+	// Workers describes the current state of each worker.
+	Workers() []WorkerInfo
+
+	// CancelJob cancels the job running on a worker, identified by the
+	// worker's ID or name, or by the job ID (or both), and returns the ID of
+	// the job being cancelled.
+	CancelJob(worker, jobID string) (string, error)
+}
+
+// workersServer serves worker introspection and job control requests.
+type workersServer struct {
+	logger logger.Logger
+
+	// If not empty, requests must have this bearer token.
+	token string
+
+	mu         sync.Mutex
+	controller WorkerController // nil until the workers have been created
+}
+
+// routes defines routes for the workersServer.
+func (s *workersServer) routes(r chi.Router) {
+	if s.token != "" {
+		r.Use(socket.AuthMiddleware(s.token, s.logger.Error))
+	}
+	r.Get("/", s.getWorkers)
+	r.Post("/cancel-job", s.cancelJob)
+}
+
+// setController sets the WorkerController used to serve requests.
+func (s *workersServer) setController(wc WorkerController) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.controller = wc
+}
+
+// getController returns the WorkerController, or writes an error response
+// and returns nil if there is none yet.
+func (s *workersServer) getController(w http.ResponseWriter) WorkerController {
+	s.mu.Lock()
+	wc := s.controller
+	s.mu.Unlock()
+	if wc == nil {
+		if err := socket.WriteError(w, "agent workers have not started yet", http.StatusServiceUnavailable); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+	}
+	return wc
+}
+
+// getWorkers describes the workers.
+func (s *workersServer) getWorkers(w http.ResponseWriter, r *http.Request) {
+	wc := s.getController(w)
+	if wc == nil {
+		return
+	}
+	resp := &WorkersResponse{
+		PID:     os.Getpid(),
+		Workers: wc.Workers(),
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
+// cancelJob cancels a job.
+func (s *workersServer) cancelJob(w http.ResponseWriter, r *http.Request) {
+	var req CancelJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := socket.WriteError(w, fmt.Sprintf("couldn't decode request body: %v", err), http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+	if req.Worker == "" && req.JobID == "" {
+		if err := socket.WriteError(w, "worker or job_id is required", http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	wc := s.getController(w)
+	if wc == nil {
+		return
+	}
+
+	jobID, err := wc.CancelJob(req.Worker, req.JobID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrWorkerNotFound):
+			code = http.StatusNotFound
+		case errors.Is(err, ErrJobNotRunning):
+			code = http.StatusConflict
+		}
+		if err := socket.WriteError(w, err, code); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	s.logger.Info("Agent API: Cancelling job %s", jobID)
+	resp := &CancelJobResponse{JobID: jobID}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
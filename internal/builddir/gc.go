@@ -0,0 +1,215 @@
+package builddir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/system"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/dustin/go-humanize"
+	"github.com/gofrs/flock"
+)
+
+// ErrInsufficientDiskSpace is returned by Collect when the build path has
+// less free space than required, and no more checkouts can be removed.
+var ErrInsufficientDiskSpace = errors.New("insufficient disk space")
+
+// Collector removes the least recently used checkout directories from a build
+// path when the disk it's on runs low on space. Checkouts are directories
+// named <build-path>/<agent-name>/<org>/<pipeline>, as created by the job
+// executor. Checkouts locked by a running job (see LockCheckout) are never
+// removed.
+//
+// A Collector is safe to share between agent workers.
+type Collector struct {
+	// The directory the checkouts are in.
+	BuildPath string
+
+	// The number of bytes that should be free on the disk the build path is
+	// on. Checkouts are removed until at least this much space is free.
+	MinFreeSpace uint64
+
+	// Where to log the checkouts that are removed.
+	Logger logger.Logger
+
+	// Only one collection runs at a time.
+	mu sync.Mutex
+
+	// For testing.
+	diskSpace func(path string) (system.DiskSpace, error)
+}
+
+// checkout is a checkout directory, and when it was last used.
+type checkout struct {
+	dir      string
+	lastUsed time.Time
+}
+
+// Collect makes sure there's at least MinFreeSpace bytes free on the disk the
+// build path is on, removing the least recently used checkouts if there isn't.
+// It returns an error wrapping ErrInsufficientDiskSpace if there still isn't
+// enough space after every checkout that isn't in use has been removed.
+func (c *Collector) Collect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	space, err := c.getDiskSpace()
+	if err != nil {
+		return err
+	}
+	if space.Available >= c.MinFreeSpace {
+		return nil
+	}
+
+	checkouts, staleLocks, err := c.checkouts()
+	if err != nil {
+		return err
+	}
+	c.removeStaleLocks(staleLocks)
+
+	for _, co := range checkouts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		removed, err := c.remove(co)
+		if err != nil {
+			c.Logger.Warn("Couldn't remove checkout %s: %v", co.dir, err)
+			continue
+		}
+		if !removed {
+			c.Logger.Debug("Not removing checkout %s, since a job is using it", co.dir)
+			continue
+		}
+		c.Logger.Info("Removed checkout %s, last used %s ago, to free up disk space",
+			co.dir, time.Since(co.lastUsed).Round(time.Second))
+
+		if space, err = c.getDiskSpace(); err != nil {
+			return err
+		}
+		if space.Available >= c.MinFreeSpace {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s free in %s, but %s is required, and there are no checkouts left that can be removed",
+		ErrInsufficientDiskSpace, humanize.IBytes(space.Available), c.BuildPath, humanize.IBytes(c.MinFreeSpace))
+}
+
+// getDiskSpace returns the space on the disk the build path is on.
+func (c *Collector) getDiskSpace() (system.DiskSpace, error) {
+	getDiskSpace := system.GetDiskSpace
+	if c.diskSpace != nil {
+		getDiskSpace = c.diskSpace
+	}
+	space, err := getDiskSpace(c.BuildPath)
+	if err != nil {
+		return system.DiskSpace{}, fmt.Errorf("getting disk space of %s: %w", c.BuildPath, err)
+	}
+	return space, nil
+}
+
+// checkouts returns the checkout directories in the build path, least
+// recently used first. A checkout was last used when its lock file was last
+// modified, or if it has no lock file (because it was made by an older
+// version of the agent), when the directory was last modified. It also
+// returns the paths of lock files for checkouts that don't exist.
+func (c *Collector) checkouts() (checkouts []checkout, staleLocks []string, err error) {
+	// <build-path>/<agent-name>/<org>/<pipeline>
+	dirs := []string{c.BuildPath}
+	for level := range 3 {
+		var next, locks []string
+		for _, dir := range dirs {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return nil, nil, fmt.Errorf("listing checkouts: %w", err)
+			}
+			for _, entry := range entries {
+				path := filepath.Join(dir, entry.Name())
+				switch {
+				case entry.IsDir():
+					next = append(next, path)
+				case level == 2 && strings.HasSuffix(entry.Name(), lockSuffix):
+					locks = append(locks, path)
+				}
+			}
+		}
+		dirs = next
+		for _, lock := range locks {
+			if !slices.Contains(dirs, strings.TrimSuffix(lock, lockSuffix)) {
+				staleLocks = append(staleLocks, lock)
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		info, err := os.Stat(LockPath(dir))
+		if err != nil {
+			info, err = os.Stat(dir)
+		}
+		if err != nil {
+			// It's been removed in the meantime
+			continue
+		}
+		checkouts = append(checkouts, checkout{dir: dir, lastUsed: info.ModTime()})
+	}
+
+	sort.SliceStable(checkouts, func(i, j int) bool {
+		return checkouts[i].lastUsed.Before(checkouts[j].lastUsed)
+	})
+	return checkouts, staleLocks, nil
+}
+
+// removeStaleLocks removes lock files for checkouts that don't exist, such as
+// those removed by hand, unless a job has them locked (for example, because
+// it's about to make the checkout).
+func (c *Collector) removeStaleLocks(paths []string) {
+	for _, path := range paths {
+		lock := flock.New(path)
+		ok, err := lock.TryLock()
+		if err != nil || !ok {
+			continue
+		}
+		if _, err := os.Stat(strings.TrimSuffix(path, lockSuffix)); errors.Is(err, os.ErrNotExist) {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				c.Logger.Debug("Couldn't remove stale checkout lock file %s: %v", path, err)
+			}
+		}
+		_ = lock.Unlock()
+	}
+}
+
+// remove removes a checkout directory and its lock file, unless a job has it
+// locked. It reports whether it was removed. A job waiting for the lock while
+// it's removed notices the lock file has gone (see LockCheckout), and locks a
+// new one.
+func (c *Collector) remove(co checkout) (bool, error) {
+	lock := flock.New(LockPath(co.dir))
+	ok, err := lock.TryLock()
+	if err != nil {
+		return false, fmt.Errorf("locking checkout: %w", err)
+	}
+	if !ok {
+		return false, nil
+	}
+	defer lock.Unlock()
+
+	if err := os.RemoveAll(co.dir); err != nil {
+		return false, err
+	}
+	if err := os.Remove(LockPath(co.dir)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		c.Logger.Debug("Couldn't remove the lock file for checkout %s: %v", co.dir, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,267 @@
+package builddir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/system"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/gofrs/flock"
+)
+
+// makeCheckout makes a checkout directory in buildPath, last used at lastUsed.
+func makeCheckout(t *testing.T, buildPath, name string, lastUsed time.Time) string {
+	t.Helper()
+
+	dir := filepath.Join(buildPath, "agent-1", "org", name)
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		t.Fatalf("os.MkdirAll(%q) error = %v", dir, err)
+	}
+	if err := os.WriteFile(LockPath(dir), nil, 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", LockPath(dir), err)
+	}
+	if err := os.Chtimes(LockPath(dir), lastUsed, lastUsed); err != nil {
+		t.Fatalf("os.Chtimes(%q) error = %v", LockPath(dir), err)
+	}
+	return dir
+}
+
+// fakeDiskSpace returns a diskSpace func for a disk where each checkout in
+// dirs takes up 1 byte, and 1 byte is free.
+func fakeDiskSpace(dirs ...string) func(string) (system.DiskSpace, error) {
+	return func(string) (system.DiskSpace, error) {
+		space := system.DiskSpace{Available: 1, Total: uint64(len(dirs)) + 1}
+		for _, dir := range dirs {
+			if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+				space.Available++
+			}
+		}
+		return space, nil
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestCollectorRemovesLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	buildPath := t.TempDir()
+	now := time.Now()
+	oldest := makeCheckout(t, buildPath, "oldest", now.Add(-3*time.Hour))
+	older := makeCheckout(t, buildPath, "older", now.Add(-2*time.Hour))
+	newest := makeCheckout(t, buildPath, "newest", now.Add(-time.Hour))
+
+	c := &Collector{
+		BuildPath:    buildPath,
+		MinFreeSpace: 3,
+		Logger:       logger.Discard,
+		diskSpace:    fakeDiskSpace(oldest, older, newest),
+	}
+	if err := c.Collect(context.Background()); err != nil {
+		t.Fatalf("c.Collect(ctx) error = %v", err)
+	}
+
+	if exists(oldest) || exists(older) {
+		t.Errorf("The two least recently used checkouts still exist, want them removed")
+	}
+	if !exists(newest) {
+		t.Errorf("The most recently used checkout %q was removed, want it kept", newest)
+	}
+	if exists(LockPath(oldest)) || exists(LockPath(older)) {
+		t.Errorf("The lock files for the removed checkouts still exist, want them removed")
+	}
+	if !exists(LockPath(newest)) {
+		t.Errorf("The lock file for %q was removed, want it kept", newest)
+	}
+}
+
+func TestCollectorRemovesStaleLocks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	buildPath := t.TempDir()
+	now := time.Now()
+	dir := makeCheckout(t, buildPath, "pipeline", now.Add(-time.Hour))
+	stale := makeCheckout(t, buildPath, "stale", now.Add(-time.Hour))
+	waiting := makeCheckout(t, buildPath, "waiting", now.Add(-time.Hour))
+	for _, d := range []string{stale, waiting} {
+		if err := os.RemoveAll(d); err != nil {
+			t.Fatalf("os.RemoveAll(%q) error = %v", d, err)
+		}
+	}
+
+	// A job that has locked its checkout, but not made it yet.
+	lock, err := LockCheckout(ctx, waiting)
+	if err != nil {
+		t.Fatalf("LockCheckout(ctx, %q) error = %v", waiting, err)
+	}
+	defer lock.Unlock()
+
+	c := &Collector{
+		BuildPath:    buildPath,
+		MinFreeSpace: 2,
+		Logger:       logger.Discard,
+		diskSpace:    fakeDiskSpace(dir),
+	}
+	if err := c.Collect(ctx); err != nil {
+		t.Fatalf("c.Collect(ctx) error = %v", err)
+	}
+
+	if exists(LockPath(stale)) {
+		t.Errorf("The lock file %q for a missing checkout still exists, want it removed", LockPath(stale))
+	}
+	if !exists(LockPath(waiting)) {
+		t.Errorf("The lock file %q held by a job was removed, want it kept", LockPath(waiting))
+	}
+}
+
+func TestCollectorSkipsLockedCheckouts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	buildPath := t.TempDir()
+	now := time.Now()
+	locked := makeCheckout(t, buildPath, "locked", now.Add(-2*time.Hour))
+	unlocked := makeCheckout(t, buildPath, "unlocked", now.Add(-time.Hour))
+
+	lock, err := LockCheckout(ctx, locked)
+	if err != nil {
+		t.Fatalf("LockCheckout(ctx, %q) error = %v", locked, err)
+	}
+	defer lock.Unlock()
+
+	c := &Collector{
+		BuildPath:    buildPath,
+		MinFreeSpace: 3,
+		Logger:       logger.Discard,
+		diskSpace:    fakeDiskSpace(locked, unlocked),
+	}
+	if err := c.Collect(ctx); !errors.Is(err, ErrInsufficientDiskSpace) {
+		t.Errorf("c.Collect(ctx) error = %v, want %v", err, ErrInsufficientDiskSpace)
+	}
+
+	if !exists(locked) {
+		t.Errorf("The locked checkout %q was removed, want it kept", locked)
+	}
+	if exists(unlocked) {
+		t.Errorf("The unlocked checkout %q still exists, want it removed", unlocked)
+	}
+}
+
+func TestCollectorDoesNothingWithEnoughSpace(t *testing.T) {
+	t.Parallel()
+
+	buildPath := t.TempDir()
+	dir := makeCheckout(t, buildPath, "pipeline", time.Now().Add(-time.Hour))
+
+	c := &Collector{
+		BuildPath:    buildPath,
+		MinFreeSpace: 1,
+		Logger:       logger.Discard,
+		diskSpace:    fakeDiskSpace(dir),
+	}
+	if err := c.Collect(context.Background()); err != nil {
+		t.Fatalf("c.Collect(ctx) error = %v", err)
+	}
+	if !exists(dir) {
+		t.Errorf("The checkout %q was removed, want it kept", dir)
+	}
+}
+
+func TestLockCheckoutMarksCheckoutUsed(t *testing.T) {
+	t.Parallel()
+
+	buildPath := t.TempDir()
+	dir := makeCheckout(t, buildPath, "pipeline", time.Now().Add(-time.Hour))
+
+	lock, err := LockCheckout(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("LockCheckout(ctx, %q) error = %v", dir, err)
+	}
+	defer lock.Unlock()
+
+	info, err := os.Stat(LockPath(dir))
+	if err != nil {
+		t.Fatalf("os.Stat(%q) error = %v", LockPath(dir), err)
+	}
+	if since := time.Since(info.ModTime()); since > time.Minute {
+		t.Errorf("Lock file last modified %v ago, want it to have just been modified", since)
+	}
+}
+
+func TestLockCheckoutWhileRemoved(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	buildPath := t.TempDir()
+	dir := makeCheckout(t, buildPath, "pipeline", time.Now().Add(-time.Hour))
+
+	// As the garbage collector does while removing the checkout.
+	gcLock := flock.New(LockPath(dir))
+	if ok, err := gcLock.TryLock(); err != nil || !ok {
+		t.Fatalf("gcLock.TryLock() = %t, %v, want true, nil", ok, err)
+	}
+
+	locked := make(chan *flock.Flock)
+	go func() {
+		lock, err := LockCheckout(ctx, dir)
+		if err != nil {
+			t.Errorf("LockCheckout(ctx, %q) error = %v", dir, err)
+		}
+		locked <- lock
+	}()
+
+	// Give LockCheckout time to start waiting on the old lock file.
+	time.Sleep(2 * lockRetryDelay)
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("os.RemoveAll(%q) error = %v", dir, err)
+	}
+	if err := os.Remove(LockPath(dir)); err != nil {
+		t.Fatalf("os.Remove(%q) error = %v", LockPath(dir), err)
+	}
+	gcLock.Unlock()
+
+	lock := <-locked
+	if lock == nil {
+		return
+	}
+	defer lock.Unlock()
+
+	// The job's lock is on the new lock file, so the garbage collector can't
+	// take it.
+	gcLock = flock.New(LockPath(dir))
+	if ok, err := gcLock.TryLock(); err != nil || ok {
+		t.Errorf("gcLock.TryLock() after LockCheckout = %t, %v, want false, nil", ok, err)
+	}
+	gcLock.Unlock()
+}
+
+func TestInBuildPath(t *testing.T) {
+	t.Parallel()
+
+	buildPath := filepath.Join("var", "lib", "buildkite-agent", "builds")
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{dir: filepath.Join(buildPath, "agent-1", "org", "pipeline"), want: true},
+		{dir: buildPath, want: false},
+		{dir: filepath.Join(buildPath, ".."), want: false},
+		{dir: filepath.Join(buildPath+"-other", "pipeline"), want: false},
+		{dir: "", want: false},
+	}
+
+	for _, test := range tests {
+		if got := InBuildPath(buildPath, test.dir); got != test.want {
+			t.Errorf("InBuildPath(%q, %q) = %t, want %t", buildPath, test.dir, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Package builddir manages the checkout directories the agent keeps in its
+// build path: locking them while jobs use them, and removing the least
+// recently used ones when the disk runs low on space.
+package builddir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// lockRetryDelay is how often to retry taking a lock on a checkout directory.
+const lockRetryDelay = 100 * time.Millisecond
+
+// lockSuffix is appended to a checkout directory's path to make the path of
+// its lock file. The lock file lives beside the checkout (rather than in it),
+// so it survives the checkout being removed and cloned again by the job. The
+// garbage collector removes it along with the checkout.
+const lockSuffix = ".checkoutlock"
+
+// LockPath returns the path of the lock file for a checkout directory.
+func LockPath(checkoutDir string) string {
+	return filepath.Clean(checkoutDir) + lockSuffix
+}
+
+// InBuildPath reports whether dir is a checkout directory within buildPath,
+// and so is managed by the build directory garbage collector.
+func InBuildPath(buildPath, dir string) bool {
+	if buildPath == "" || dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(buildPath, dir)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// LockCheckout takes a shared lock on a checkout directory, waiting until ctx
+// is done if the garbage collector is removing it. Any number of jobs can hold
+// the shared lock at once, but while one does, the garbage collector won't
+// remove the directory. The lock file's modification time is updated, which is
+// what the garbage collector uses as the time the checkout was last used.
+func LockCheckout(ctx context.Context, checkoutDir string) (*flock.Flock, error) {
+	path := LockPath(checkoutDir)
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return nil, fmt.Errorf("creating directory for checkout lock: %w", err)
+	}
+
+	for {
+		lock, err := tryLockCheckout(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("locking checkout %q: %w", checkoutDir, err)
+		}
+		if lock == nil {
+			// The garbage collector removed the lock file while we were
+			// waiting for it, so the lock we got is on a file no-one else will
+			// use. Try again with a new one.
+			continue
+		}
+
+		now := time.Now()
+		if err := os.Chtimes(path, now, now); err != nil {
+			_ = lock.Close()
+			return nil, fmt.Errorf("marking checkout %q as used: %w", checkoutDir, err)
+		}
+		return lock, nil
+	}
+}
+
+// tryLockCheckout takes a shared lock on the lock file at path, creating it if
+// need be. It returns a nil lock if the file at path was removed or replaced
+// while it was being locked.
+func tryLockCheckout(ctx context.Context, path string) (*flock.Flock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	before, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := flock.New(path)
+	ok, err := lock.TryRLockContext(ctx, lockRetryDelay)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ctx.Err()
+	}
+
+	// The garbage collector only removes the lock file while it holds the
+	// exclusive lock, so once the shared lock is held, the file at path can't
+	// change. If it's still the one from before, that's the one locked.
+	after, err := os.Stat(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = lock.Close()
+		return nil, err
+	}
+	if err != nil || !os.SameFile(before, after) {
+		_ = lock.Close()
+		return nil, nil
+	}
+	return lock, nil
+}
@@ -0,0 +1,87 @@
+// Package clusterlock provides backends for locks shared between agents on
+// different hosts (the "cluster" lock scope).
+//
+// The leader agent on each host forwards cluster-scoped lock operations from
+// the Agent API to a Backend, so the same `buildkite-agent lock` commands work
+// in both scopes.
+package clusterlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backend stores lock values shared between hosts. The operations have the
+// same semantics as the machine-scoped Agent API lock state: a key that is not
+// in use has the value "", and values can have a lease, after which they are
+// removed unless renewed.
+type Backend interface {
+	// Get returns the current value for the key.
+	Get(ctx context.Context, key string) (string, error)
+
+	// CompareAndSwap atomically replaces the value for the key with new, if
+	// the current value is old. It reports whether the swap happened,
+	// returning the (new or existing) value. If ttl is non-zero, the new
+	// value has a lease of that duration.
+	CompareAndSwap(ctx context.Context, key, old, new string, ttl time.Duration) (string, bool, error)
+
+	// Renew extends the lease on the value for the key to ttl from now, if
+	// the current value is token. It reports whether the lease was renewed,
+	// returning the current value and the time the lease expires.
+	Renew(ctx context.Context, key, token string, ttl time.Duration) (string, time.Time, bool, error)
+
+	// Close releases any resources used by the backend.
+	Close() error
+}
+
+// Open returns the Backend described by a URL:
+//
+//   - file:///path/to/dir stores locks as files in a directory on a shared
+//     filesystem, such as NFS (see FileBackend).
+//   - redis://[[user]:password@]host[:port][/db] and rediss://... (with TLS)
+//     store locks in a Redis server, or anything else that speaks the Redis
+//     protocol (see RedisBackend). The "prefix" query parameter sets the
+//     prefix used for Redis keys.
+func Open(spec string) (Backend, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster lock backend: %w", err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, errors.New("file cluster lock backend requires a path")
+		}
+		return NewFileBackend(u.Path)
+
+	case "redis", "rediss":
+		b := &RedisBackend{
+			Addr:   u.Host,
+			TLS:    u.Scheme == "rediss",
+			Prefix: u.Query().Get("prefix"),
+		}
+		if u.Port() == "" {
+			b.Addr = u.Host + ":6379"
+		}
+		if u.User != nil {
+			b.Password, _ = u.User.Password()
+			b.Username = u.User.Username()
+		}
+		if db := strings.Trim(u.Path, "/"); db != "" {
+			b.DB, err = strconv.Atoi(db)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redis database %q", db)
+			}
+		}
+		return b, nil
+
+	default:
+		return nil, fmt.Errorf("unknown cluster lock backend type %q", u.Scheme)
+	}
+}
@@ -0,0 +1,233 @@
+package clusterlock
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-memory stand-in for a Redis server, implementing just
+// the commands used by RedisBackend: AUTH, SELECT, GET, SET (with PX), DEL,
+// PEXPIRE, and optimistic transactions (WATCH, UNWATCH, MULTI, EXEC, DISCARD).
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu      sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+	// Incremented whenever a key is modified, for WATCH.
+	versions map[string]int
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(tcp, 127.0.0.1:0) = error %v", err)
+	}
+	f := &fakeRedis{
+		ln:       ln,
+		password: password,
+		values:   make(map[string]string),
+		expires:  make(map[string]time.Time),
+		versions: make(map[string]int),
+	}
+	t.Cleanup(func() { ln.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+// fakeRedisConn is the per-connection state.
+type fakeRedisConn struct {
+	authed  bool
+	watched map[string]int
+	queue   [][]string // nil unless in MULTI
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	cs := &fakeRedisConn{authed: f.password == ""}
+	for {
+		v, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		arr, _ := v.([]any)
+		args := make([]string, len(arr))
+		for i, a := range arr {
+			args[i], _ = a.(string)
+		}
+		writeFakeReply(w, f.command(cs, args))
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// command runs one command, returning the reply.
+func (f *fakeRedis) command(cs *fakeRedisConn, args []string) any {
+	if len(args) == 0 {
+		return redisError("ERR empty command")
+	}
+	cmd := strings.ToUpper(args[0])
+	if cmd == "AUTH" {
+		if args[len(args)-1] != f.password {
+			return redisError("WRONGPASS invalid password")
+		}
+		cs.authed = true
+		return "OK"
+	}
+	if !cs.authed {
+		return redisError("NOAUTH Authentication required.")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if cs.queue != nil && cmd != "EXEC" && cmd != "DISCARD" {
+		cs.queue = append(cs.queue, args)
+		return "QUEUED"
+	}
+
+	switch cmd {
+	case "SELECT":
+		return "OK"
+
+	case "WATCH":
+		if cs.watched == nil {
+			cs.watched = make(map[string]int)
+		}
+		for _, k := range args[1:] {
+			f.expire(k)
+			cs.watched[k] = f.versions[k]
+		}
+		return "OK"
+
+	case "UNWATCH":
+		cs.watched = nil
+		return "OK"
+
+	case "MULTI":
+		cs.queue = [][]string{}
+		return "OK"
+
+	case "DISCARD":
+		cs.queue, cs.watched = nil, nil
+		return "OK"
+
+	case "EXEC":
+		queue, watched := cs.queue, cs.watched
+		cs.queue, cs.watched = nil, nil
+		for k, v := range watched {
+			f.expire(k)
+			if f.versions[k] != v {
+				return nil
+			}
+		}
+		replies := make([]any, 0, len(queue))
+		for _, q := range queue {
+			replies = append(replies, f.exec(q))
+		}
+		return replies
+
+	default:
+		return f.exec(args)
+	}
+}
+
+// exec runs a data command. f.mu must be held.
+func (f *fakeRedis) exec(args []string) any {
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		f.expire(args[1])
+		if v, ok := f.values[args[1]]; ok {
+			return v
+		}
+		return nil
+
+	case "SET":
+		k := args[1]
+		f.values[k] = args[2]
+		delete(f.expires, k)
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			f.expires[k] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		f.versions[k]++
+		return "OK"
+
+	case "DEL":
+		f.expire(args[1])
+		if _, ok := f.values[args[1]]; !ok {
+			return int64(0)
+		}
+		delete(f.values, args[1])
+		delete(f.expires, args[1])
+		f.versions[args[1]]++
+		return int64(1)
+
+	case "PEXPIRE":
+		k := args[1]
+		f.expire(k)
+		if _, ok := f.values[k]; !ok {
+			return int64(0)
+		}
+		ms, _ := strconv.Atoi(args[2])
+		f.expires[k] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		f.versions[k]++
+		return int64(1)
+
+	default:
+		return redisError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+}
+
+// expire removes the key if it has expired. f.mu must be held.
+func (f *fakeRedis) expire(k string) {
+	if exp, ok := f.expires[k]; ok && time.Now().After(exp) {
+		delete(f.values, k)
+		delete(f.expires, k)
+		f.versions[k]++
+	}
+}
+
+func writeFakeReply(w *bufio.Writer, v any) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case redisError:
+		fmt.Fprintf(w, "-%s\r\n", string(v))
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		if v == "OK" || v == "QUEUED" {
+			fmt.Fprintf(w, "+%s\r\n", v)
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			writeFakeReply(w, e)
+		}
+	}
+}
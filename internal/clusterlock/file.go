@@ -0,0 +1,206 @@
+package clusterlock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// fileLockRetryDelay is how often to retry taking the flock on a key.
+const fileLockRetryDelay = 10 * time.Millisecond
+
+// FileBackend stores locks as files in a directory, which is intended to be on
+// a filesystem shared between hosts (such as NFS). Each key has a JSON file
+// holding its value, and a separate lock file that is flocked while the value
+// is changed.
+//
+// The filesystem must support flock (on Linux NFS clients, flock is emulated
+// with POSIX byte-range locks, which requires NFSv4 or a working lockd). Lease
+// expiry uses the clock of each host, so clocks should be synchronised.
+type FileBackend struct {
+	dir string
+
+	// For testing.
+	now func() time.Time
+}
+
+// fileEntry is the content of the file for one key.
+type fileEntry struct {
+	Value   string    `json:"value"`
+	Since   time.Time `json:"since"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewFileBackend returns a FileBackend storing locks in dir, creating dir if
+// needed.
+func NewFileBackend(dir string) (*FileBackend, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating cluster lock directory: %w", err)
+	}
+	return &FileBackend{dir: dir, now: time.Now}, nil
+}
+
+// path returns the path of the file for the key.
+func (b *FileBackend) path(key string) string {
+	return filepath.Join(b.dir, url.PathEscape(key)+".json")
+}
+
+// read returns the current entry for the key, or nil if the key is not in use
+// (including if its lease has expired).
+func (b *FileBackend) read(key string, now time.Time) (*fileEntry, error) {
+	data, err := os.ReadFile(b.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading lock %q: %w", key, err)
+	}
+	var e fileEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("decoding lock %q: %w", key, err)
+	}
+	if !e.Expires.IsZero() && now.After(e.Expires) {
+		return nil, nil
+	}
+	return &e, nil
+}
+
+// write replaces the entry for the key. A nil entry removes the key.
+func (b *FileBackend) write(key string, e *fileEntry) error {
+	path := b.path(key)
+	if e == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing lock %q: %w", key, err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// Write a new file and rename it into place, so that Get (which doesn't
+	// take the flock) never sees a partial write.
+	tmp, err := os.CreateTemp(b.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("writing lock %q: %w", key, err)
+	}
+	defer os.Remove(tmp.Name()) // in case anything fails
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing lock %q: %w", key, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing lock %q: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing lock %q: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing lock %q: %w", key, err)
+	}
+	return nil
+}
+
+// withLock calls f with the flock for the key held.
+func (b *FileBackend) withLock(ctx context.Context, key string, f func() error) error {
+	fl := flock.New(b.path(key) + ".lock")
+	locked, err := fl.TryLockContext(ctx, fileLockRetryDelay)
+	if err != nil {
+		return fmt.Errorf("locking %q: %w", key, err)
+	}
+	if !locked {
+		return fmt.Errorf("couldn't lock %q", key)
+	}
+	defer fl.Unlock()
+	return f()
+}
+
+// Get returns the current value for the key.
+func (b *FileBackend) Get(ctx context.Context, key string) (string, error) {
+	e, err := b.read(key, b.now())
+	if err != nil || e == nil {
+		return "", err
+	}
+	return e.Value, nil
+}
+
+// CompareAndSwap atomically replaces the value for the key with new, if the
+// current value is old.
+func (b *FileBackend) CompareAndSwap(ctx context.Context, key, old, new string, ttl time.Duration) (string, bool, error) {
+	var (
+		value   string
+		swapped bool
+	)
+	err := b.withLock(ctx, key, func() error {
+		now := b.now()
+		e, err := b.read(key, now)
+		if err != nil {
+			return err
+		}
+		if e != nil {
+			value = e.Value
+		}
+		if value != old {
+			return nil
+		}
+
+		var ne *fileEntry
+		if new != "" {
+			ne = &fileEntry{Value: new, Since: now}
+			if ttl > 0 {
+				ne.Expires = now.Add(ttl)
+			}
+		}
+		if err := b.write(key, ne); err != nil {
+			return err
+		}
+		value, swapped = new, true
+		return nil
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return value, swapped, nil
+}
+
+// Renew extends the lease on the value for the key, if it is token.
+func (b *FileBackend) Renew(ctx context.Context, key, token string, ttl time.Duration) (string, time.Time, bool, error) {
+	var (
+		value   string
+		expires time.Time
+		renewed bool
+	)
+	err := b.withLock(ctx, key, func() error {
+		now := b.now()
+		e, err := b.read(key, now)
+		if err != nil || e == nil {
+			return err
+		}
+		value, expires = e.Value, e.Expires
+		if e.Value != token {
+			return nil
+		}
+		e.Expires = now.Add(ttl)
+		if err := b.write(key, e); err != nil {
+			return err
+		}
+		expires, renewed = e.Expires, true
+		return nil
+	})
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+	return value, expires, renewed, nil
+}
+
+// Close does nothing.
+func (b *FileBackend) Close() error { return nil }
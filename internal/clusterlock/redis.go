@@ -0,0 +1,270 @@
+package clusterlock
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRedisPrefix is prepended to lock keys to make Redis keys.
+	defaultRedisPrefix = "buildkite-agent:lock:"
+
+	// redisTimeout limits each operation when the context has no deadline.
+	redisTimeout = 5 * time.Second
+
+	// redisMaxIdle is the number of idle connections kept for reuse.
+	redisMaxIdle = 4
+
+	// redisMaxAttempts limits retries of transactions that were aborted
+	// because the key changed concurrently.
+	redisMaxAttempts = 10
+)
+
+// RedisBackend stores locks in a Redis server (or another server speaking the
+// Redis protocol, such as Valkey or KeyDB). Each lock is a string key, and
+// leases are implemented with key expiry. Compare-and-swap uses optimistic
+// transactions (WATCH/MULTI/EXEC), so no server-side scripting is needed.
+type RedisBackend struct {
+	// Address of the server, as host:port.
+	Addr string
+
+	// Credentials, if the server requires authentication. Username is only
+	// needed for Redis ACLs.
+	Username string
+	Password string
+
+	// Database number to SELECT.
+	DB int
+
+	// Whether to connect using TLS.
+	TLS bool
+
+	// Prefix for Redis keys. If empty, "buildkite-agent:lock:" is used.
+	Prefix string
+
+	mu   sync.Mutex
+	idle []*respConn
+}
+
+// key returns the Redis key for a lock key.
+func (b *RedisBackend) key(key string) string {
+	if b.Prefix == "" {
+		return defaultRedisPrefix + key
+	}
+	return b.Prefix + key
+}
+
+// conn returns a connection to the server, reusing an idle one if possible.
+// The connection should be returned with put.
+func (b *RedisBackend) conn(ctx context.Context) (*respConn, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+
+	b.mu.Lock()
+	if n := len(b.idle); n > 0 {
+		c := b.idle[n-1]
+		b.idle = b.idle[:n-1]
+		b.mu.Unlock()
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			c.close()
+			return nil, err
+		}
+		return c, nil
+	}
+	b.mu.Unlock()
+
+	var (
+		nc  net.Conn
+		err error
+	)
+	if b.TLS {
+		host, _, _ := net.SplitHostPort(b.Addr)
+		d := &tls.Dialer{Config: &tls.Config{ServerName: host}}
+		nc, err = d.DialContext(ctx, "tcp", b.Addr)
+	} else {
+		var d net.Dialer
+		nc, err = d.DialContext(ctx, "tcp", b.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting to redis: %w", err)
+	}
+	if err := nc.SetDeadline(deadline); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	c := newRESPConn(nc)
+	if b.Password != "" {
+		args := []string{"AUTH", b.Password}
+		if b.Username != "" {
+			args = []string{"AUTH", b.Username, b.Password}
+		}
+		if _, err := c.do(args...); err != nil {
+			c.close()
+			return nil, fmt.Errorf("authenticating to redis: %w", err)
+		}
+	}
+	if b.DB != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(b.DB)); err != nil {
+			c.close()
+			return nil, fmt.Errorf("selecting redis database: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// put returns a connection for reuse, or closes it if the operation using it
+// failed (possibly leaving the connection in an unknown state).
+func (b *RedisBackend) put(c *respConn, err error) {
+	if err != nil {
+		c.close()
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.idle) >= redisMaxIdle {
+		c.close()
+		return
+	}
+	b.idle = append(b.idle, c)
+}
+
+// getString sends GET, treating a missing key as "".
+func getString(c *respConn, key string) (string, error) {
+	v, err := c.do("GET", key)
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("redis: unexpected GET reply %v", v)
+	}
+}
+
+// Get returns the current value for the key.
+func (b *RedisBackend) Get(ctx context.Context, key string) (value string, err error) {
+	c, err := b.conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer func() { b.put(c, err) }()
+	return getString(c, b.key(key))
+}
+
+// transact runs an optimistic transaction on the Redis key k. check is called
+// with the current value, and returns whether to proceed; if so, cmds are
+// executed atomically, provided k hasn't changed in the
+// meantime. If it has, the transaction is retried. It reports whether the
+// commands were executed.
+func (b *RedisBackend) transact(ctx context.Context, k string, check func(cur string) bool, cmds [][]string) (cur string, done bool, err error) {
+	c, err := b.conn(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	defer func() { b.put(c, err) }()
+
+	for range redisMaxAttempts {
+		if _, err := c.do("WATCH", k); err != nil {
+			return "", false, err
+		}
+		cur, err = getString(c, k)
+		if err != nil {
+			return "", false, err
+		}
+		if !check(cur) {
+			_, err := c.do("UNWATCH")
+			return cur, false, err
+		}
+
+		if _, err := c.do("MULTI"); err != nil {
+			return "", false, err
+		}
+		for _, cmd := range cmds {
+			if _, err := c.do(cmd...); err != nil {
+				c.do("DISCARD")
+				return "", false, err
+			}
+		}
+		res, err := c.do("EXEC")
+		if err != nil {
+			return "", false, err
+		}
+		if res == nil {
+			// The key changed after WATCH; try again.
+			continue
+		}
+		replies, ok := res.([]any)
+		if !ok {
+			return "", false, fmt.Errorf("redis: unexpected EXEC reply %v", res)
+		}
+		for _, r := range replies {
+			if rerr, ok := r.(redisError); ok {
+				return "", false, rerr
+			}
+		}
+		return cur, true, nil
+	}
+	return "", false, fmt.Errorf("redis: too much contention on %q", k)
+}
+
+// CompareAndSwap atomically replaces the value for the key with new, if the
+// current value is old.
+func (b *RedisBackend) CompareAndSwap(ctx context.Context, key, old, new string, ttl time.Duration) (string, bool, error) {
+	k := b.key(key)
+	cmd := []string{"DEL", k}
+	if new != "" {
+		cmd = []string{"SET", k, new}
+		if ttl > 0 {
+			cmd = append(cmd, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+		}
+	}
+
+	cur, done, err := b.transact(ctx, k, func(cur string) bool { return cur == old }, [][]string{cmd})
+	if err != nil {
+		return "", false, err
+	}
+	if !done {
+		return cur, false, nil
+	}
+	return new, true, nil
+}
+
+// Renew extends the lease on the value for the key, if it is token.
+func (b *RedisBackend) Renew(ctx context.Context, key, token string, ttl time.Duration) (string, time.Time, bool, error) {
+	k := b.key(key)
+	ms := strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)
+	expires := time.Now().Add(ttl)
+
+	cur, done, err := b.transact(ctx, k, func(cur string) bool { return cur == token }, [][]string{{"PEXPIRE", k, ms}})
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+	if !done {
+		return cur, time.Time{}, false, nil
+	}
+	return token, expires, true, nil
+}
+
+// Close closes idle connections.
+func (b *RedisBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var errs []error
+	for _, c := range b.idle {
+		errs = append(errs, c.close())
+	}
+	b.idle = nil
+	return errors.Join(errs...)
+}
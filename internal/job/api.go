@@ -2,15 +2,20 @@ package job
 
 import (
 	"fmt"
+	"os"
 
+	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/internal/redact"
 	"github.com/buildkite/agent/v3/internal/socket"
 	"github.com/buildkite/agent/v3/jobapi"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/agent/v3/version"
 )
 
 // startJobAPI starts the job API server, iff the OS of the box supports it otherwise it returns a
-// noop cleanup function. It also sets the BUILDKITE_AGENT_JOB_API_SOCKET and
-// BUILDKITE_AGENT_JOB_API_TOKEN environment variables
+// noop cleanup function. It also sets the BUILDKITE_AGENT_JOB_API_SOCKET,
+// BUILDKITE_AGENT_JOB_API_TOKEN and BUILDKITE_AGENT_JOB_API_READ_TOKEN
+// environment variables, and BUILDKITE_AGENT_JOB_API_URL if it listens on TCP
 func (e *Executor) startJobAPI() (cleanup func(), err error) {
 	cleanup = func() {}
 
@@ -30,6 +35,14 @@ We'll continue to run your job, but you won't be able to use the Job API`)
 	if e.ExecutorConfig.Debug {
 		jobAPIOpts = append(jobAPIOpts, jobapi.WithDebug())
 	}
+	if e.ExecutorConfig.JobAPIListen != "" {
+		jobAPIOpts = append(jobAPIOpts, jobapi.WithTCPListener(e.ExecutorConfig.JobAPIListen))
+	}
+	if e.ExecutorConfig.AgentAccessToken != "" {
+		// Let commands in the job talk to Buildkite through the Job API,
+		// without needing the access token themselves.
+		jobAPIOpts = append(jobAPIOpts, jobapi.WithBuildkiteAPI(e.agentAPIClient()))
+	}
 	srv, token, err := jobapi.NewServer(e.shell.Logger, socketPath, e.shell.Env, e.redactors, jobAPIOpts...)
 	if err != nil {
 		return cleanup, fmt.Errorf("creating job API server: %w", err)
@@ -37,7 +50,9 @@ We'll continue to run your job, but you won't be able to use the Job API`)
 
 	e.shell.Env.Set("BUILDKITE_AGENT_JOB_API_SOCKET", socketPath)
 	e.shell.Env.Set("BUILDKITE_AGENT_JOB_API_TOKEN", token)
+	e.shell.Env.Set("BUILDKITE_AGENT_JOB_API_READ_TOKEN", srv.ReadOnlyToken())
 
+	redactionAdded := false
 	if redact.Match(e.shell.Logger, e.RedactedVars, "BUILDKITE_AGENT_JOB_API_TOKEN") {
 		// The Job API token lets the job talk to this executor. When the job ends,
 		// the socket should be closed and the token becomes meaningless. Also, the
@@ -51,16 +66,86 @@ We'll continue to run your job, but you won't be able to use the Job API`)
 		// Conclusion: if the name matches, redact the Job API token.
 		// This depends on startJobAPI being called after setupRedactors.
 		e.redactors.Add(token)
+		redactionAdded = true
+	}
+	if redact.Match(e.shell.Logger, e.RedactedVars, "BUILDKITE_AGENT_JOB_API_READ_TOKEN") {
+		e.redactors.Add(srv.ReadOnlyToken())
+		redactionAdded = true
 	}
 
 	if err := srv.Start(); err != nil {
 		return cleanup, fmt.Errorf("starting Job API server: %w", err)
 	}
 
+	if url := srv.URL(); url != "" {
+		// The listener only lasts as long as the job, and requests to it need
+		// one of the tokens above.
+		e.shell.Env.Set("BUILDKITE_AGENT_JOB_API_URL", url)
+	}
+	e.jobAPI.Store(srv)
+	if redactionAdded {
+		srv.Publish(jobapi.Event{Type: jobapi.EventRedactionAdded})
+	}
+
 	return func() {
+		e.jobAPI.Store(nil)
 		err = srv.Stop()
 		if err != nil {
 			e.shell.Errorf("Error stopping Job API server: %v", err)
 		}
+
+		// Commands the executor runs after this, such as uploading the trace
+		// file, can't use the Job API any more, so they need the access token.
+		for _, name := range jobAPIEnvVars {
+			e.shell.Env.Remove(name)
+		}
+		if e.AgentAccessToken != "" {
+			e.shell.Env.Set("BUILDKITE_AGENT_ACCESS_TOKEN", e.AgentAccessToken)
+		}
 	}, nil
 }
+
+// jobAPIEnvVars are the variables startJobAPI sets to tell commands in the job
+// how to reach the Job API.
+var jobAPIEnvVars = []string{
+	"BUILDKITE_AGENT_JOB_API_SOCKET",
+	"BUILDKITE_AGENT_JOB_API_TOKEN",
+	"BUILDKITE_AGENT_JOB_API_READ_TOKEN",
+	"BUILDKITE_AGENT_JOB_API_URL",
+}
+
+// publishEvent publishes a job lifecycle event to the Job API, if it is
+// running.
+func (e *Executor) publishEvent(ev jobapi.Event) {
+	e.jobAPI.Load().Publish(ev)
+}
+
+// takeAgentAccessToken removes the agent access token from the job
+// environment, and from the environment of this process (which commands also
+// inherit). Under Kubernetes, the token only arrives in the environment once
+// the container has registered with the agent, so if the executor wasn't
+// given a token, it is taken from there.
+func (e *Executor) takeAgentAccessToken() {
+	for _, name := range []string{"BUILDKITE_BOOTSTRAP_ACCESS_TOKEN", "BUILDKITE_AGENT_ACCESS_TOKEN"} {
+		if token, exists := e.shell.Env.Get(name); exists && e.AgentAccessToken == "" {
+			e.AgentAccessToken = token
+		}
+		e.shell.Env.Remove(name)
+		os.Unsetenv(name)
+	}
+	if endpoint, _ := e.shell.Env.Get("BUILDKITE_AGENT_ENDPOINT"); endpoint != "" {
+		e.AgentEndpoint = endpoint
+	}
+}
+
+// agentAPIClient returns a Buildkite Agent API client using the endpoint and
+// access token the executor was given by the agent. They're read from the
+// executor config rather than the job environment, so the proxy keeps working
+// when the token isn't in the job environment.
+func (e *Executor) agentAPIClient() *api.Client {
+	return api.NewClient(logger.Discard, api.Config{
+		Endpoint:  e.ExecutorConfig.AgentEndpoint,
+		Token:     e.ExecutorConfig.AgentAccessToken,
+		UserAgent: version.UserAgent(),
+	})
+}
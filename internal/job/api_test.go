@@ -0,0 +1,150 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/job/hook"
+	"github.com/buildkite/agent/v3/internal/job/shell"
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/buildkite/agent/v3/jobapi"
+	"github.com/buildkite/agent/v3/logger"
+)
+
+func TestStartJobAPI_ProxyWithoutAccessTokenInEnv(t *testing.T) {
+	t.Parallel()
+
+	if !socket.Available() {
+		t.Skip("the Job API isn't available on this machine")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	var annotated, setMetaData atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs/job-1/annotations", func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Token llamas"; got != want {
+			t.Errorf("Authorization header = %q, want %q", got, want)
+		}
+		annotated.Store(true)
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("POST /jobs/job-1/data/set", func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Token llamas"; got != want {
+			t.Errorf("Authorization header = %q, want %q", got, want)
+		}
+		setMetaData.Store(true)
+		w.WriteHeader(http.StatusCreated)
+	})
+	bk := httptest.NewServer(mux)
+	t.Cleanup(bk.Close)
+
+	// The agent gives the executor its endpoint and access token, but they
+	// aren't in the job environment.
+	e := New(ExecutorConfig{
+		SocketsPath:      t.TempDir(),
+		AgentEndpoint:    bk.URL,
+		AgentAccessToken: "llamas",
+	})
+	var err error
+	e.shell, err = shell.New()
+	if err != nil {
+		t.Fatalf("shell.New() error = %v", err)
+	}
+	e.shell.Logger = shell.TestingLogger{T: t}
+	e.shell.Env.Remove("BUILDKITE_AGENT_ACCESS_TOKEN")
+
+	cleanup, err := e.startJobAPI()
+	if err != nil {
+		t.Fatalf("e.startJobAPI() error = %v", err)
+	}
+	t.Cleanup(cleanup)
+
+	sock, _ := e.shell.Env.Get("BUILDKITE_AGENT_JOB_API_SOCKET")
+	token, _ := e.shell.Env.Get("BUILDKITE_AGENT_JOB_API_TOKEN")
+	client := api.NewClient(logger.Discard, jobapi.APIClientConfig(sock, token))
+
+	if _, err := client.Annotate(ctx, "job-1", &api.Annotation{Body: "hello"}); err != nil {
+		t.Errorf("client.Annotate() error = %v", err)
+	}
+	if _, err := client.SetMetaData(ctx, "job-1", &api.MetaData{Key: "llama", Value: "alpaca"}); err != nil {
+		t.Errorf("client.SetMetaData() error = %v", err)
+	}
+	if !annotated.Load() || !setMetaData.Load() {
+		t.Errorf("Buildkite got annotation = %t, meta-data = %t, want both proxied", annotated.Load(), setMetaData.Load())
+	}
+}
+
+func TestApplyEnvironmentChanges_PublishesEvents(t *testing.T) {
+	t.Parallel()
+
+	if !socket.Available() {
+		t.Skip("the Job API isn't available on this machine")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	e := New(ExecutorConfig{
+		SocketsPath:  t.TempDir(),
+		RedactedVars: []string{"*_TOKEN"},
+	})
+	var err error
+	e.shell, err = shell.New()
+	if err != nil {
+		t.Fatalf("shell.New() error = %v", err)
+	}
+	e.shell.Logger = shell.TestingLogger{T: t}
+	e.shell.Env.Set("LLAMA", "alpaca")
+
+	cleanup, err := e.startJobAPI()
+	if err != nil {
+		t.Fatalf("e.startJobAPI() error = %v", err)
+	}
+	t.Cleanup(cleanup)
+
+	// The hook set a variable to the value it already has, so nothing changed.
+	e.applyEnvironmentChanges(hook.EnvChanges{Diff: env.Diff{
+		Added: map[string]string{"LLAMA": "alpaca"},
+	}})
+	e.applyEnvironmentChanges(hook.EnvChanges{Diff: env.Diff{
+		Added: map[string]string{"SECRET_TOKEN": "hunter2hunter2"},
+	}})
+
+	sock, _ := e.shell.Env.Get("BUILDKITE_AGENT_JOB_API_SOCKET")
+	token, _ := e.shell.Env.Get("BUILDKITE_AGENT_JOB_API_TOKEN")
+	client, err := jobapi.NewClient(ctx, sock, token)
+	if err != nil {
+		t.Fatalf("jobapi.NewClient() error = %v", err)
+	}
+
+	var got []jobapi.EventType
+	errStop := errors.New("stop")
+	if err := client.Events(ctx, 0, func(ev jobapi.Event) error {
+		got = append(got, ev.Type)
+		if ev.Type == jobapi.EventEnvChanged && !slices.Equal(ev.Added, []string{"SECRET_TOKEN"}) {
+			t.Errorf("env_changed event Added = %q, want [SECRET_TOKEN]", ev.Added)
+		}
+		if len(got) == 3 {
+			return errStop
+		}
+		return nil
+	}); !errors.Is(err, errStop) {
+		t.Fatalf("client.Events() error = %v", err)
+	}
+
+	// The Job API tokens match *_TOKEN, so they were redacted first.
+	want := []jobapi.EventType{jobapi.EventRedactionAdded, jobapi.EventEnvChanged, jobapi.EventRedactionAdded}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
@@ -112,6 +112,8 @@ func (e *Executor) CheckoutPhase(ctx context.Context) error {
 		return err
 	}
 
+	e.lockCheckoutDir(ctx)
+
 	// Remove the checkout directory if BUILDKITE_CLEAN_CHECKOUT is present
 	if e.CleanCheckout {
 		e.shell.Headerf("Cleaning pipeline checkout")
@@ -693,8 +695,8 @@ func (e *Executor) defaultCheckoutPhase(ctx context.Context) error {
 		}
 	}
 
-	if _, hasToken := e.shell.Env.Get("BUILDKITE_AGENT_ACCESS_TOKEN"); !hasToken {
-		e.shell.Warningf("Skipping sending Git information to Buildkite as $BUILDKITE_AGENT_ACCESS_TOKEN is missing")
+	if e.AgentAccessToken == "" {
+		e.shell.Warningf("Skipping sending Git information to Buildkite as the agent access token is missing")
 		return nil
 	}
 
@@ -0,0 +1,66 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/buildkite/agent/v3/jobapi"
+	"github.com/buildkite/agent/v3/process"
+)
+
+// runCleanups runs the cleanup commands registered through the Job API, most
+// recently registered first. Each runs with its own timeout, even if the job
+// has been cancelled, though after a cancellation no cleanup runs past the
+// signal grace period. Failures are reported, but don't fail the job, and
+// don't prevent the remaining cleanups from running.
+func (e *Executor) runCleanups(ctx context.Context) {
+	for _, c := range e.jobAPI.Load().Cleanups() {
+		e.shell.Headerf("Running cleanup %s", process.FormatCommand(c.Command[0], c.Command[1:]))
+		if err := e.runCleanup(ctx, c); err != nil {
+			e.shell.Warningf("Cleanup failed: %v", err)
+		}
+	}
+}
+
+// runCleanup runs a single cleanup command.
+func (e *Executor) runCleanup(ctx context.Context, c jobapi.Cleanup) error {
+	timeout := c.Timeout
+	if remaining, ok := e.remainingGracePeriod(); ok && remaining < timeout {
+		// After a cancellation, the agent kills the job once the signal grace
+		// period is up, so give up on the cleanup before then rather than have
+		// it killed partway through.
+		if remaining <= 0 {
+			return fmt.Errorf("skipped, as the signal grace period of %v has elapsed", e.ExecutorConfig.SignalGracePeriod)
+		}
+		timeout = remaining
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if c.Dir != "" && c.Dir != e.shell.Getwd() {
+		prev := e.shell.Getwd()
+		if err := e.shell.Chdir(c.Dir); err != nil {
+			return err
+		}
+		defer func() { _ = e.shell.Chdir(prev) }()
+	}
+
+	err := e.shell.Run(ctx, c.Command[0], c.Command[1:]...)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("timed out after %v: %w", timeout, err)
+	}
+	return err
+}
+
+// remainingGracePeriod returns how much of the signal grace period is left, if
+// the job has been cancelled and there is a grace period.
+func (e *Executor) remainingGracePeriod() (time.Duration, bool) {
+	e.cancelMu.Lock()
+	defer e.cancelMu.Unlock()
+	if !e.cancelled || e.ExecutorConfig.SignalGracePeriod <= 0 {
+		return 0, false
+	}
+	return e.ExecutorConfig.SignalGracePeriod - time.Since(e.cancelledAt), true
+}
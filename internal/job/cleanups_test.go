@@ -0,0 +1,105 @@
+package job
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/job/shell"
+	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/buildkite/agent/v3/jobapi"
+)
+
+func TestRunCleanups(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("cleanup commands in this test use sh")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	e := New(ExecutorConfig{})
+	e.shell = shell.NewTestShell(t)
+
+	sock, err := jobapi.NewSocketPath(os.TempDir())
+	if err != nil {
+		t.Fatalf("jobapi.NewSocketPath(%q) error = %v", os.TempDir(), err)
+	}
+	srv, token, err := jobapi.NewServer(shell.TestingLogger{T: t}, sock, e.shell.Env, replacer.NewMux())
+	if err != nil {
+		t.Fatalf("jobapi.NewServer(...) error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+	e.jobAPI.Store(srv)
+
+	cli, err := jobapi.NewClient(ctx, sock, token)
+	if err != nil {
+		t.Fatalf("jobapi.NewClient(ctx, %q, token) error = %v", sock, err)
+	}
+
+	dir := t.TempDir()
+	for _, req := range []*jobapi.CleanupCreateRequest{
+		{Command: []string{"sh", "-c", "echo first >> log"}, Dir: dir},
+		{Command: []string{"sh", "-c", "exit 1"}},
+		{Command: []string{"sh", "-c", "sleep 30; echo slow >> log"}, Dir: dir, Timeout: "100ms"},
+		{Command: []string{"sh", "-c", "echo second >> log"}, Dir: dir},
+	} {
+		if _, err := cli.CleanupCreate(ctx, req); err != nil {
+			t.Fatalf("cli.CleanupCreate(ctx, %v) error = %v", req, err)
+		}
+	}
+
+	// Failing and timed-out cleanups don't stop the others from running.
+	start := time.Now()
+	e.runCleanups(ctx)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("e.runCleanups(ctx) took %v, want the timeout to interrupt the slow cleanup", elapsed)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatalf("os.ReadFile(log) error = %v", err)
+	}
+	if want := "second\nfirst\n"; string(got) != want {
+		t.Errorf("cleanup log = %q, want %q", got, want)
+	}
+}
+
+func TestRunCleanupAfterCancelStopsAtGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("cleanup commands in this test use sh")
+	}
+
+	e := New(ExecutorConfig{SignalGracePeriod: 200 * time.Millisecond})
+	e.shell = shell.NewTestShell(t)
+	if err := e.Cancel(); err != nil {
+		t.Fatalf("e.Cancel() error = %v", err)
+	}
+
+	cleanup := jobapi.Cleanup{Command: []string{"sh", "-c", "sleep 30"}, Timeout: 5 * time.Minute}
+
+	start := time.Now()
+	if err := e.runCleanup(context.Background(), cleanup); err == nil {
+		t.Errorf("e.runCleanup(ctx, %v) error = nil, want a timeout", cleanup)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("e.runCleanup(ctx, %v) took %v, want it stopped at the end of the grace period", cleanup, elapsed)
+	}
+
+	// Once the grace period is over, cleanups are skipped.
+	time.Sleep(200 * time.Millisecond)
+	if err := e.runCleanup(context.Background(), cleanup); err == nil || !strings.Contains(err.Error(), "skipped") {
+		t.Errorf("e.runCleanup(ctx, %v) error = %v, want it skipped", cleanup, err)
+	}
+}
@@ -165,12 +165,29 @@ type ExecutorConfig struct {
 	// Service name to use when reporting traces.
 	TracingServiceName string
 
+	// Format of the trace file written by the file tracing backend.
+	TracingFileFormat string
+
 	// Whether to start the JobAPI
 	JobAPI bool
 
+	// If not empty, a TCP address the Job API also listens on
+	JobAPIListen string
+
+	// The Agent API endpoint, and the agent's access token for it. The token
+	// is kept out of the job environment. If it is set, the Job API proxies
+	// requests to Buildkite for the job.
+	AgentEndpoint    string
+	AgentAccessToken string
+
+	// Path to a file to write the result set by the job (through the Job
+	// API) to, for the agent to report
+	JobResultFile string
+
 	// Whether to enable Kubernetes support, and which container we're running in
-	KubernetesExec        bool
-	KubernetesContainerID int
+	KubernetesExec          bool
+	KubernetesContainerID   int
+	KubernetesContainerName string
 
 	// The warnings that have been disabled by the user
 	DisabledWarnings []string
@@ -17,25 +17,31 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/buildkite/agent/v3/agent/plugin"
 	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/builddir"
 	"github.com/buildkite/agent/v3/internal/experiments"
 	"github.com/buildkite/agent/v3/internal/file"
 	"github.com/buildkite/agent/v3/internal/job/hook"
 	"github.com/buildkite/agent/v3/internal/job/shell"
 	"github.com/buildkite/agent/v3/internal/redact"
 	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/buildkite/agent/v3/internal/secrets"
 	"github.com/buildkite/agent/v3/internal/shellscript"
 	"github.com/buildkite/agent/v3/internal/tempfile"
 	"github.com/buildkite/agent/v3/internal/utils"
+	"github.com/buildkite/agent/v3/jobapi"
 	"github.com/buildkite/agent/v3/kubernetes"
 	"github.com/buildkite/agent/v3/process"
 	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/buildkite/roko"
 	"github.com/buildkite/shellwords"
+	"github.com/gofrs/flock"
+	"golang.org/x/exp/maps"
 )
 
 // Executor represents the phases of execution in a Buildkite Job. It's run as
@@ -59,14 +65,27 @@ type Executor struct {
 	// Directories to clean up at end of job execution
 	cleanupDirs []string
 
+	// A shared lock on the checkout directory, held for the whole job so the
+	// agent's build directory garbage collection doesn't remove it
+	checkoutLock *flock.Flock
+
 	// A channel to track cancellation
-	cancelMu  sync.Mutex
-	cancelCh  chan struct{}
-	cancelled bool
+	cancelMu    sync.Mutex
+	cancelCh    chan struct{}
+	cancelled   bool
+	cancelledAt time.Time
 
 	// redactors for the job logs. The will be populated with values both from environment variable and through the Job API.
 	// In order for the latter to happen, a reference is passed into the the Job API server as well
 	redactors *replacer.Mux
+
+	// secretsProvider is used to resolve BUILDKITE_SECRETS_ENV. If nil, one
+	// is created from the job environment when needed.
+	secretsProvider secrets.Provider
+
+	// jobAPI is the running Job API server, if any. Lifecycle events are
+	// published to it.
+	jobAPI atomic.Pointer[jobapi.Server]
 }
 
 // New returns a new executor instance
@@ -102,7 +121,7 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 	}
 
 	if e.KubernetesExec {
-		socket := &kubernetes.Client{ID: e.KubernetesContainerID}
+		socket := &kubernetes.Client{ID: e.KubernetesContainerID, Name: e.KubernetesContainerName}
 		if err := e.kubernetesSetup(ctx, socket); err != nil {
 			e.shell.Errorf("Failed to start kubernetes socket client: %v", err)
 			return 1
@@ -131,12 +150,18 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 	go func() {
 		<-e.cancelCh
 		e.shell.Commentf("Received cancellation signal, interrupting")
+		e.publishEvent(jobapi.Event{Type: jobapi.EventCancelled})
 		cancel()
 	}()
 
 	// Create an empty env for us to keep track of our env changes in
 	e.shell.Env = env.FromSlice(os.Environ())
 
+	// The agent access token and job result file are for the executor, not
+	// the job
+	e.takeAgentAccessToken()
+	e.takeJobResultFile()
+
 	// Initialize the job API, iff the experiment is enabled. Noop otherwise
 	if e.JobAPI {
 		cleanup, err := e.startJobAPI()
@@ -145,10 +170,19 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 			return 1
 		}
 		defer cleanup()
+
+		// Runs after tearDown, so that pre-exit hooks can set the result.
+		defer e.reportResult()
 	} else {
 		e.shell.OptionalWarningf("job-api-disabled", "The Job API has been disabled. Features like automatic redaction of secrets and polyglot hooks will either not work or have degraded functionality")
 	}
 
+	// Without the Job API, commands in the job can only reach Buildkite with
+	// the token itself
+	if e.jobAPI.Load() == nil && e.AgentAccessToken != "" {
+		e.shell.Env.Set("BUILDKITE_AGENT_ACCESS_TOKEN", e.AgentAccessToken)
+	}
+
 	// Tear down the environment (and fire pre-exit hook) before we exit
 	defer func() {
 		if err = e.tearDown(nonCancelCtx); err != nil {
@@ -193,12 +227,12 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 		phaseErr = e.preparePlugins()
 
 		if phaseErr == nil {
-			phaseErr = e.PluginPhase(ctx)
+			phaseErr = e.runPhase("plugin", func() error { return e.PluginPhase(ctx) })
 		}
 	}
 
 	if phaseErr == nil && e.includePhase("checkout") {
-		phaseErr = e.CheckoutPhase(ctx)
+		phaseErr = e.runPhase("checkout", func() error { return e.CheckoutPhase(ctx) })
 	} else {
 		checkoutDir, exists := e.shell.Env.Get("BUILDKITE_BUILD_CHECKOUT_PATH")
 		if exists {
@@ -207,12 +241,16 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 	}
 
 	if phaseErr == nil && e.includePhase("plugin") {
-		phaseErr = e.VendoredPluginPhase(ctx)
+		phaseErr = e.runPhase("vendored-plugin", func() error { return e.VendoredPluginPhase(ctx) })
 	}
 
 	if phaseErr == nil && e.includePhase("command") {
 		var commandErr error
-		phaseErr, commandErr = e.CommandPhase(ctx)
+		phaseErr = e.runPhase("command", func() error {
+			var err error
+			err, commandErr = e.CommandPhase(ctx)
+			return err
+		})
 		/*
 			Five possible states at this point:
 
@@ -238,7 +276,7 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 		}
 
 		// Only upload artifacts as part of the command phase
-		if err = e.artifactPhase(ctx); err != nil {
+		if err = e.runPhase("artifact", func() error { return e.artifactPhase(ctx) }); err != nil {
 			e.shell.Errorf("%v", err)
 
 			if commandErr != nil {
@@ -275,6 +313,26 @@ func (e *Executor) includePhase(phase string) bool {
 	return slices.Contains(e.Phases, phase)
 }
 
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := maps.Keys(m)
+	slices.Sort(keys)
+	return keys
+}
+
+// runPhase runs a job phase, publishing phase_start and phase_end events
+// around it.
+func (e *Executor) runPhase(phase string, f func() error) error {
+	e.publishEvent(jobapi.Event{Type: jobapi.EventPhaseStart, Phase: phase})
+	err := f()
+	end := jobapi.Event{Type: jobapi.EventPhaseEnd, Phase: phase}
+	if err != nil {
+		end.Error = err.Error()
+	}
+	e.publishEvent(end)
+	return err
+}
+
 // Cancel interrupts any running shell processes and causes the job to stop.
 func (e *Executor) Cancel() error {
 	// Closing e.cancelCh broadcasts to any goroutine receiving that the job is
@@ -286,6 +344,7 @@ func (e *Executor) Cancel() error {
 		return errors.New("already cancelled")
 	}
 	e.cancelled = true
+	e.cancelledAt = time.Now()
 	close(e.cancelCh)
 	return nil
 }
@@ -300,7 +359,7 @@ type HookConfig struct {
 }
 
 func (e *Executor) tracingImplementationSpecificHookScope(scope string) string {
-	if e.TracingBackend != tracetools.BackendOpenTelemetry {
+	if e.TracingBackend != tracetools.BackendOpenTelemetry && e.TracingBackend != tracetools.BackendFile {
 		return scope
 	}
 
@@ -346,6 +405,33 @@ func (e *Executor) executeHook(ctx context.Context, hookCfg HookConfig) error {
 
 	e.shell.Headerf("Running %s hook", hookName)
 
+	e.publishEvent(jobapi.Event{
+		Type:   jobapi.EventHookStart,
+		Hook:   hookCfg.Name,
+		Source: hookCfg.Scope,
+		Plugin: hookCfg.PluginName,
+		Path:   hookCfg.Path,
+	})
+
+	err = e.runHook(ctx, hookName, hookCfg)
+
+	end := jobapi.Event{
+		Type:   jobapi.EventHookEnd,
+		Hook:   hookCfg.Name,
+		Source: hookCfg.Scope,
+		Plugin: hookCfg.PluginName,
+		Path:   hookCfg.Path,
+	}
+	if err != nil {
+		end.Error = err.Error()
+	}
+	e.publishEvent(end)
+
+	return err
+}
+
+// runHook runs a hook script in the way appropriate to its type.
+func (e *Executor) runHook(ctx context.Context, hookName string, hookCfg HookConfig) error {
 	if !experiments.IsEnabled(ctx, experiments.PolyglotHooks) {
 		return e.runWrappedShellScriptHook(ctx, hookName, hookCfg)
 	}
@@ -575,11 +661,37 @@ func (e *Executor) applyEnvironmentChanges(changes hook.EnvChanges) {
 		return
 	}
 
+	// The hook's changes may already have been made (e.g. through the Job
+	// API), so only report what actually changed.
+	before := e.shell.Env.Copy()
 	e.shell.Env.Apply(changes.Diff)
+	applied := e.shell.Env.Diff(before)
+	if applied.Empty() {
+		return
+	}
+
+	e.publishEvent(jobapi.Event{
+		Type:    jobapi.EventEnvChanged,
+		Added:   sortedKeys(applied.Added),
+		Changed: sortedKeys(applied.Changed),
+		Removed: sortedKeys(applied.Removed),
+	})
 
 	// reset output redactors based on new environment variable values
 	e.redactors.Add(redact.Values(e.shell, e.ExecutorConfig.RedactedVars, e.shell.Env.Dump())...)
 
+	// Only new values to redact are worth telling anyone about.
+	redactionAdded := false
+	for k, v := range applied.Added {
+		redactionAdded = redactionAdded || e.redactsVar(k, v)
+	}
+	for k, v := range applied.Changed {
+		redactionAdded = redactionAdded || e.redactsVar(k, v.New)
+	}
+	if redactionAdded {
+		e.publishEvent(jobapi.Event{Type: jobapi.EventRedactionAdded})
+	}
+
 	// First, let see any of the environment variables are supposed
 	// to change the job configuration at run time.
 	executorConfigEnvChanges := e.ExecutorConfig.ReadFromEnvironment(e.shell.Env)
@@ -616,6 +728,12 @@ func (e *Executor) applyEnvironmentChanges(changes hook.EnvChanges) {
 	}
 }
 
+// redactsVar reports whether the value of an environment variable is redacted
+// from the job output.
+func (e *Executor) redactsVar(name, value string) bool {
+	return len(value) >= redact.LengthMin && redact.Match(e.shell, e.ExecutorConfig.RedactedVars, name)
+}
+
 func (e *Executor) hasGlobalHook(name string) bool {
 	_, err := e.globalHookPath(name)
 	return err == nil
@@ -719,6 +837,37 @@ func addRepositoryHostToSSHKnownHosts(ctx context.Context, sh *shell.Shell, repo
 	}
 }
 
+// lockCheckoutDir takes a shared lock on the checkout directory, if it's in the
+// build path, so that the agent's build directory garbage collection won't
+// remove it while the job is running. Jobs sharing a checkout directory can
+// all hold the lock at once. It's called by the checkout phase, once the
+// environment and pre-checkout hooks have had a chance to change
+// BUILDKITE_BUILD_CHECKOUT_PATH.
+func (e *Executor) lockCheckoutDir(ctx context.Context) {
+	checkoutPath, _ := e.shell.Env.Get("BUILDKITE_BUILD_CHECKOUT_PATH")
+	if !builddir.InBuildPath(e.BuildPath, checkoutPath) {
+		return
+	}
+
+	lock, err := builddir.LockCheckout(ctx, checkoutPath)
+	if err != nil {
+		e.shell.Warningf("Failed to lock the checkout directory, so it could be removed to free up disk space while the job is running: %v", err)
+		return
+	}
+	e.checkoutLock = lock
+}
+
+// unlockCheckoutDir releases the lock taken by lockCheckoutDir.
+func (e *Executor) unlockCheckoutDir() {
+	if e.checkoutLock == nil {
+		return
+	}
+	if err := e.checkoutLock.Unlock(); err != nil {
+		e.shell.Warningf("Failed to unlock the checkout directory: %v", err)
+	}
+	e.checkoutLock = nil
+}
+
 // setUp is run before all the phases run. It's responsible for initializing the
 // job environment
 func (e *Executor) setUp(ctx context.Context) error {
@@ -772,6 +921,12 @@ func (e *Executor) setUp(ctx context.Context) error {
 	// Disable any interactive Git/SSH prompting
 	e.shell.Env.Set("GIT_TERMINAL_PROMPT", "0")
 
+	// Secrets are injected before any hooks run, so they can be used by all
+	// of them.
+	if err = e.injectSecrets(ctx); err != nil {
+		return err
+	}
+
 	// It's important to do this before checking out plugins, in case you want
 	// to use the global environment hook to whitelist the plugins that are
 	// allowed to be used.
@@ -784,6 +939,11 @@ func (e *Executor) tearDown(ctx context.Context) error {
 	span, ctx := tracetools.StartSpanFromContext(ctx, "pre-exit", e.ExecutorConfig.TracingBackend)
 	var err error
 	defer func() { span.FinishWithError(err) }()
+	defer e.unlockCheckoutDir()
+
+	// Cleanups registered during the job (e.g. with `job on-exit`) tear down
+	// things the job started, so they run before pre-exit hooks.
+	e.runCleanups(ctx)
 
 	// In vanilla agent usage, there's always a command phase.
 	// But over in agent-stack-k8s, which splits the agent phases among
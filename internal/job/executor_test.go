@@ -1,10 +1,15 @@
 package job
 
 import (
+	"bytes"
 	"context"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/buildkite/agent/v3/internal/job/shell"
+	"github.com/buildkite/agent/v3/internal/socket"
 	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
@@ -85,3 +90,131 @@ func TestStartTracing_Datadog(t *testing.T) {
 	assert.Equal(t, spanImpl.Span, opentracing.SpanFromContext(ctx))
 	stopper()
 }
+
+func TestStartTracing_File(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake buildkite-agent is a shell script")
+	}
+
+	// With the file tracing backend, spans are written to a trace file, which
+	// is uploaded with `buildkite-agent artifact upload` when tracing stops.
+	// The fake buildkite-agent copies the file it's given to uploadDir.
+	binDir, uploadDir := t.TempDir(), t.TempDir()
+	script := "#!/bin/sh\ncp \"$3\" " + uploadDir + "/\n"
+	if err := os.WriteFile(filepath.Join(binDir, "buildkite-agent"), []byte(script), 0o755); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	e := New(ExecutorConfig{
+		TracingBackend:    tracetools.BackendFile,
+		TracingFileFormat: tracetools.FileFormatChrome,
+	})
+	var err error
+	e.shell, err = shell.New(shell.WithLogger(shell.TestingLogger{T: t}))
+	assert.NoError(t, err)
+	e.shell.Env.Set("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	span, ctx, stopper := e.startTracing(context.Background())
+	assert.IsType(t, &tracetools.OpenTelemetrySpan{}, span)
+
+	child, _ := tracetools.StartSpanFromContext(ctx, "command", e.TracingBackend)
+	child.FinishWithError(nil)
+	span.FinishWithError(nil)
+	stopper()
+
+	b, err := os.ReadFile(filepath.Join(uploadDir, "job-trace.json"))
+	if err != nil {
+		t.Fatalf("reading uploaded trace file: %v", err)
+	}
+	assert.Contains(t, string(b), `"name":"command"`)
+}
+
+func TestStartTracing_FileContinuesTrace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake buildkite-agent is a shell script")
+	}
+
+	binDir, uploadDir := t.TempDir(), t.TempDir()
+	script := "#!/bin/sh\ncp \"$3\" " + uploadDir + "/\n"
+	if err := os.WriteFile(filepath.Join(binDir, "buildkite-agent"), []byte(script), 0o755); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	e := New(ExecutorConfig{
+		JobID:             "job-1",
+		TracingBackend:    tracetools.BackendFile,
+		TracingFileFormat: tracetools.FileFormatOTLPJSON,
+	})
+	var logs bytes.Buffer
+	var err error
+	e.shell, err = shell.New(shell.WithLogger(shell.NewWriterLogger(&logs, false, nil)))
+	assert.NoError(t, err)
+	e.shell.Env.Set("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// The agent's job span, which the job's spans should be children of.
+	e.shell.Env.Set(tracetools.EnvVarTraceParentKey, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	span, _, stopper := e.startTracing(context.Background())
+	span.FinishWithError(nil)
+	stopper()
+
+	b, err := os.ReadFile(filepath.Join(uploadDir, "job-trace.otlp.json"))
+	if err != nil {
+		t.Fatalf("reading uploaded trace file: %v", err)
+	}
+	assert.Contains(t, string(b), `"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`)
+	assert.Contains(t, string(b), `"parentSpanId":"00f067aa0ba902b7"`)
+	assert.Contains(t, logs.String(), "buildkite-agent artifact download job-trace.otlp.json . --step job-1")
+}
+
+func TestStartTracing_FileWithJobAPI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake buildkite-agent is a shell script")
+	}
+	if !socket.Available() {
+		t.Skip("the Job API isn't available on this machine")
+	}
+
+	// The trace file is uploaded after the Job API has stopped, so the upload
+	// has to use the access token rather than the Job API. The fake
+	// buildkite-agent records the environment it was run with.
+	binDir, uploadDir := t.TempDir(), t.TempDir()
+	script := "#!/bin/sh\ncp \"$3\" " + uploadDir + "/\nenv > " + uploadDir + "/env\n"
+	if err := os.WriteFile(filepath.Join(binDir, "buildkite-agent"), []byte(script), 0o755); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	e := New(ExecutorConfig{
+		JobAPI:            true,
+		SocketsPath:       t.TempDir(),
+		AgentAccessToken:  "llamas",
+		TracingBackend:    tracetools.BackendFile,
+		TracingFileFormat: tracetools.FileFormatChrome,
+	})
+	var err error
+	e.shell, err = shell.New(shell.WithLogger(shell.TestingLogger{T: t}))
+	assert.NoError(t, err)
+	e.shell.Env.Set("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// Same order as Run: tracing starts first and stops last.
+	span, _, stopper := e.startTracing(context.Background())
+	cleanup, err := e.startJobAPI()
+	if err != nil {
+		t.Fatalf("e.startJobAPI() error = %v", err)
+	}
+	assert.True(t, e.shell.Env.Exists("BUILDKITE_AGENT_JOB_API_SOCKET"))
+	cleanup()
+	span.FinishWithError(nil)
+	stopper()
+
+	if _, err := os.Stat(filepath.Join(uploadDir, "job-trace.json")); err != nil {
+		t.Fatalf("trace file wasn't uploaded: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(uploadDir, "env"))
+	if err != nil {
+		t.Fatalf("reading the upload's environment: %v", err)
+	}
+	assert.NotContains(t, string(b), "BUILDKITE_AGENT_JOB_API_SOCKET=")
+	assert.NotContains(t, string(b), "BUILDKITE_AGENT_JOB_API_TOKEN=")
+	assert.Contains(t, string(b), "BUILDKITE_AGENT_ACCESS_TOKEN=llamas\n")
+}
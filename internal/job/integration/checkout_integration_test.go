@@ -12,6 +12,7 @@ import (
 	"sync/atomic"
 	"testing"
 
+	"github.com/buildkite/agent/v3/internal/builddir"
 	"github.com/buildkite/agent/v3/internal/experiments"
 	"github.com/buildkite/agent/v3/internal/job"
 	"github.com/buildkite/bintest/v3"
@@ -974,3 +975,38 @@ func (mf subDirMatcher) String() string {
 func matchSubDir(dir string) bintest.Matcher {
 	return subDirMatcher{dir: filepath.Clean(dir)}
 }
+
+func TestCheckoutLockFollowsEnvironmentHook(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Not supported on windows")
+	}
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	movedDir := filepath.Join(tester.BuildDir, "test-agent", "test", "moved")
+	var script = []string{
+		"#!/bin/bash",
+		"export BUILDKITE_BUILD_CHECKOUT_PATH=" + movedDir,
+	}
+
+	if err := os.WriteFile(filepath.Join(tester.HooksDir, "environment"), []byte(strings.Join(script, "\n")), 0700); err != nil {
+		t.Fatalf("os.WriteFile(environment, script, 0700) = %v", err)
+	}
+
+	tester.ExpectGlobalHook("checkout").Once()
+
+	tester.RunAndCheck(t)
+
+	if _, err := os.Stat(builddir.LockPath(movedDir)); err != nil {
+		t.Errorf("os.Stat(%q) error = %v, want the checkout set by the environment hook to be locked", builddir.LockPath(movedDir), err)
+	}
+	if _, err := os.Stat(builddir.LockPath(tester.CheckoutDir())); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("os.Stat(%q) error = %v, want %v", builddir.LockPath(tester.CheckoutDir()), err, os.ErrNotExist)
+	}
+}
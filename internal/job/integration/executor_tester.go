@@ -110,7 +110,7 @@ func NewExecutorTester(ctx context.Context) (*ExecutorTester, error) {
 			"BUILDKITE_ARTIFACT_PATHS=",
 			"BUILDKITE_COMMAND=true",
 			"BUILDKITE_JOB_ID=1111-1111-1111-1111",
-			"BUILDKITE_AGENT_ACCESS_TOKEN=test-token-please-ignore",
+			"BUILDKITE_BOOTSTRAP_ACCESS_TOKEN=test-token-please-ignore",
 			fmt.Sprintf("BUILDKITE_REDACTED_VARS=%s", strings.Join(*clicommand.RedactedVars.Value, ",")),
 		},
 		PathDir:    pathDir,
@@ -8,6 +8,8 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/buildkite/agent/v3/jobapi"
@@ -144,3 +146,52 @@ func TestBootstrapRunsJobAPI(t *testing.T) {
 
 	tester.RunAndCheck(t)
 }
+
+func TestJobResultIsOnlySetThroughJobAPI(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewExecutorTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	resultPath := filepath.Join(t.TempDir(), "job-result")
+
+	tester.ExpectGlobalHook("command").Once().AndCallFunc(func(c *bintest.Call) {
+		// The job can't see the result file, so can't skip the Job API's
+		// validation by writing to it.
+		if got := c.GetEnv("BUILDKITE_BOOTSTRAP_JOB_RESULT_FILE"); got != "" {
+			t.Errorf("BUILDKITE_BOOTSTRAP_JOB_RESULT_FILE = %q, want it unset", got)
+		}
+
+		cli, err := jobapi.NewClient(mainCtx, c.GetEnv("BUILDKITE_AGENT_JOB_API_SOCKET"), c.GetEnv("BUILDKITE_AGENT_JOB_API_TOKEN"))
+		if err != nil {
+			t.Errorf("jobapi.NewClient() error = %v", err)
+			c.Exit(1)
+			return
+		}
+		if _, err := cli.ResultSet(mainCtx, &jobapi.JobResult{Category: "llamas"}); err == nil {
+			t.Errorf("cli.ResultSet(category llamas) error = nil, want an error")
+		}
+		if _, err := cli.ResultSet(mainCtx, &jobapi.JobResult{Category: "infra", Reason: "registry unavailable", SoftFail: true}); err != nil {
+			t.Errorf("cli.ResultSet(category infra) error = %v", err)
+		}
+		c.Exit(0)
+	})
+
+	tester.RunAndCheck(t, "BUILDKITE_BOOTSTRAP_JOB_RESULT_FILE="+resultPath)
+
+	data, err := os.ReadFile(resultPath)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error = %v", resultPath, err)
+	}
+	var got jobapi.JobResult
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal(result file) error = %v", err)
+	}
+	want := jobapi.JobResult{Category: "infra", Reason: "registry unavailable", SoftFail: true}
+	if got != want {
+		t.Errorf("result file = %+v, want %+v", got, want)
+	}
+}
@@ -10,6 +10,29 @@ import (
 	"github.com/buildkite/bintest/v3"
 )
 
+func TestAgentTokenIsNotInJobEnv(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("setting up executor tester: %v", err)
+	}
+	defer tester.Close()
+
+	tester.ExpectGlobalHook("command").AndCallFunc(func(c *bintest.Call) {
+		for _, name := range []string{"BUILDKITE_AGENT_ACCESS_TOKEN", "BUILDKITE_BOOTSTRAP_ACCESS_TOKEN"} {
+			if got := c.GetEnv(name); got != "" {
+				fmt.Fprintf(c.Stderr, "%s = %q, want it unset\n", name, got)
+				c.Exit(1)
+				return
+			}
+		}
+		c.Exit(0)
+	})
+
+	tester.RunAndCheck(t)
+}
+
 func TestRedactorRedactsAgentToken(t *testing.T) {
 	t.Parallel()
 
@@ -19,12 +42,13 @@ func TestRedactorRedactsAgentToken(t *testing.T) {
 	}
 	defer tester.Close()
 
+	// Without the Job API, the job is given the agent token.
 	tester.ExpectGlobalHook("command").AndCallFunc(func(c *bintest.Call) {
 		fmt.Fprintf(c.Stderr, "The agent token is: %s\n", c.GetEnv("BUILDKITE_AGENT_ACCESS_TOKEN"))
 		c.Exit(0)
 	})
 
-	err = tester.Run(t)
+	err = tester.Run(t, "BUILDKITE_AGENT_NO_JOB_API=true")
 	if err != nil {
 		t.Fatalf("running executor tester: %v", err)
 	}
@@ -48,7 +72,7 @@ func TestRedactorDoesNotRedactAgentToken_WhenNotInRedactedVars(t *testing.T) {
 		c.Exit(0)
 	})
 
-	err = tester.Run(t, `BUILDKITE_REDACTED_VARS=""`)
+	err = tester.Run(t, `BUILDKITE_REDACTED_VARS=""`, "BUILDKITE_AGENT_NO_JOB_API=true")
 	if err != nil {
 		t.Fatalf("running executor tester: %v", err)
 	}
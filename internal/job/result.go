@@ -0,0 +1,49 @@
+package job
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// reportResult prints the result the job set through the Job API, if any, and
+// writes it to the job result file, for the agent to report to Buildkite when
+// the job finishes.
+func (e *Executor) reportResult() {
+	res := e.jobAPI.Load().Result()
+	if res == nil {
+		return
+	}
+
+	e.shell.Headerf("Job result")
+	if res.Category != "" {
+		e.shell.Printf("Category: %s", res.Category)
+	}
+	if res.Reason != "" {
+		e.shell.Printf("Reason: %s", res.Reason)
+	}
+	if res.SoftFail {
+		e.shell.Printf("Soft fail requested")
+	}
+
+	if e.JobResultFile == "" {
+		e.shell.Warningf("This agent doesn't support reporting job results, so the result will only appear in the log")
+		return
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		e.shell.Errorf("Couldn't encode the job result: %v", err)
+		return
+	}
+	if err := os.WriteFile(e.JobResultFile, data, 0o600); err != nil {
+		e.shell.Errorf("Couldn't write the job result file: %v", err)
+	}
+}
+
+// takeJobResultFile removes the path of the job result file from the job
+// environment, and from the environment of this process (which commands also
+// inherit), so that the job can only set its result through the Job API.
+func (e *Executor) takeJobResultFile() {
+	e.shell.Env.Remove("BUILDKITE_BOOTSTRAP_JOB_RESULT_FILE")
+	os.Unsetenv("BUILDKITE_BOOTSTRAP_JOB_RESULT_FILE")
+}
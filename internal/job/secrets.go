@@ -0,0 +1,63 @@
+package job
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/buildkite/agent/v3/internal/secrets"
+	"github.com/buildkite/agent/v3/jobapi"
+)
+
+// injectSecrets resolves the secrets listed in BUILDKITE_SECRETS_ENV, and
+// sets them as environment variables for the rest of the job. The values are
+// also added to the redactors. Any failure to fetch a secret fails the job.
+func (e *Executor) injectSecrets(ctx context.Context) error {
+	mapping, _ := e.shell.Env.Get("BUILDKITE_SECRETS_ENV")
+	envSecrets, err := secrets.ParseEnv(secrets.SplitList(mapping))
+	if err != nil {
+		return err
+	}
+	if len(envSecrets) == 0 {
+		return nil
+	}
+
+	provider := e.secretsProvider
+	if provider == nil {
+		if provider, err = e.newSecretsProvider(); err != nil {
+			return err
+		}
+	}
+
+	e.shell.Headerf("Fetching secrets")
+	for _, s := range envSecrets {
+		value, err := provider.Get(ctx, s.Key)
+		if err != nil {
+			return fmt.Errorf("failed to fetch secret %q for environment variable %s: %w", s.Key, s.Name, err)
+		}
+
+		// Add the value to the redactors before it could be printed anywhere.
+		e.redactors.Add(value)
+		e.publishEvent(jobapi.Event{Type: jobapi.EventRedactionAdded})
+		e.shell.Env.Set(s.Name, value)
+		e.shell.Commentf("Set %s from secret %q", s.Name, s.Key)
+	}
+
+	return nil
+}
+
+// newSecretsProvider creates the secrets provider described by the job
+// environment, falling back to Buildkite secrets.
+func (e *Executor) newSecretsProvider() (secrets.Provider, error) {
+	client := e.agentAPIClient()
+
+	providers, _ := e.shell.Env.Get("BUILDKITE_SECRETS_PROVIDERS")
+	getenv := func(name string) string {
+		v, _ := e.shell.Env.Get(name)
+		return v
+	}
+	return secrets.ParseRouter(
+		secrets.SplitList(providers),
+		&secrets.BuildkiteProvider{Client: client, JobID: e.JobID},
+		getenv,
+	)
+}
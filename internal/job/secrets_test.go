@@ -0,0 +1,71 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/job/shell"
+	"github.com/buildkite/agent/v3/internal/redact"
+	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/buildkite/agent/v3/internal/secrets"
+	"gotest.tools/v3/assert"
+)
+
+type fakeSecretsProvider map[string]string
+
+func (p fakeSecretsProvider) Get(_ context.Context, key string) (string, error) {
+	v, ok := p[key]
+	if !ok {
+		return "", fmt.Errorf("%q: %w", key, secrets.ErrNotFound)
+	}
+	return v, nil
+}
+
+func TestInjectSecrets(t *testing.T) {
+	t.Parallel()
+
+	sh, err := shell.New(shell.WithLogger(shell.DiscardLogger))
+	assert.NilError(t, err)
+	sh.Env = env.FromMap(map[string]string{
+		"BUILDKITE_SECRETS_ENV": "DB_PASSWORD=prod/db,API_KEY=prod/api",
+	})
+
+	e := New(ExecutorConfig{})
+	e.shell = sh
+	e.secretsProvider = fakeSecretsProvider{"prod/db": "hunter2", "prod/api": "sw0rdf1sh"}
+
+	var logs strings.Builder
+	rdc := replacer.New(&logs, nil, redact.Redact)
+	e.redactors.Append(rdc)
+
+	assert.NilError(t, e.injectSecrets(context.Background()))
+
+	for name, want := range map[string]string{"DB_PASSWORD": "hunter2", "API_KEY": "sw0rdf1sh"} {
+		got, _ := e.shell.Env.Get(name)
+		assert.Equal(t, got, want, "e.shell.Env.Get(%q)", name)
+	}
+
+	fmt.Fprint(rdc, "hunter2 sw0rdf1sh")
+	assert.NilError(t, rdc.Flush())
+	assert.Equal(t, logs.String(), "[REDACTED] [REDACTED]")
+}
+
+func TestInjectSecretsFailure(t *testing.T) {
+	t.Parallel()
+
+	sh, err := shell.New(shell.WithLogger(shell.DiscardLogger))
+	assert.NilError(t, err)
+	sh.Env = env.FromMap(map[string]string{
+		"BUILDKITE_SECRETS_ENV": "DB_PASSWORD=prod/nope",
+	})
+
+	e := New(ExecutorConfig{})
+	e.shell = sh
+	e.secretsProvider = fakeSecretsProvider{}
+
+	err = e.injectSecrets(context.Background())
+	assert.ErrorContains(t, err, `failed to fetch secret "prod/nope" for environment variable DB_PASSWORD`)
+}
@@ -28,7 +28,6 @@ import (
 	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/buildkite/shellwords"
 	"github.com/gofrs/flock"
-	"github.com/opentracing/opentracing-go"
 )
 
 const lockRetryDuration = time.Second
@@ -368,16 +367,19 @@ func (s *Shell) RunAndCapture(ctx context.Context, command string, arg ...string
 // injectTraceCtx adds tracing information to the given env vars to support
 // distributed tracing across jobs/builds.
 func (s *Shell) injectTraceCtx(ctx context.Context, env *env.Environment) {
-	span := opentracing.SpanFromContext(ctx)
-	// Not all shell runs will have tracing (nor do they really need to).
-	if span == nil {
-		return
-	}
-	if err := tracetools.EncodeTraceContext(span, env.Dump()); err != nil {
-		if s.Debug {
-			s.Logger.Warningf("Failed to encode trace context: %v", err)
+	// Not all shell runs will have tracing (nor do they really need to), in
+	// which case nothing is injected.
+	traceEnv := make(map[string]string)
+	for _, backend := range []string{tracetools.BackendDatadog, tracetools.BackendOpenTelemetry} {
+		if err := tracetools.InjectTraceContext(ctx, backend, traceEnv); err != nil {
+			if s.Debug {
+				s.Logger.Warningf("Failed to encode trace context: %v", err)
+			}
+			return
 		}
-		return
+	}
+	for k, v := range traceEnv {
+		env.Set(k, v)
 	}
 }
 
@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -18,6 +19,7 @@ import (
 	"github.com/buildkite/bintest/v3"
 	"github.com/gofrs/flock"
 	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/trace"
 	"gotest.tools/v3/assert"
 )
 
@@ -521,3 +523,39 @@ func TestRound(t *testing.T) {
 		})
 	}
 }
+
+func TestRunPassesTraceContext(t *testing.T) {
+	t.Parallel()
+
+	proxy, err := bintest.CompileProxy("llamas")
+	if err != nil {
+		t.Fatalf("bintest.CompileProxy(llamas) error = %v", err)
+	}
+	defer proxy.Close()
+
+	sh := newShellForTest(t)
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	envCh := make(chan []string, 1)
+	go func() {
+		call := <-proxy.Ch
+		envCh <- call.Env
+		call.Exit(0)
+	}()
+
+	if err := sh.Run(ctx, proxy.Path); err != nil {
+		t.Fatalf("sh.Run(ctx, %q) error = %v", proxy.Path, err)
+	}
+
+	want := "TRACEPARENT=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	if env := <-envCh; !slices.Contains(env, want) {
+		t.Errorf("command env = %q, want it to contain %q", env, want)
+	}
+}
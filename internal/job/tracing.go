@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"slices"
 	"strconv"
 
@@ -11,15 +12,10 @@ import (
 	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/buildkite/agent/v3/version"
 	"github.com/opentracing/opentracing-go"
-	"go.opentelemetry.io/contrib/propagators/aws/xray"
-	"go.opentelemetry.io/contrib/propagators/b3"
-	"go.opentelemetry.io/contrib/propagators/jaeger"
-	"go.opentelemetry.io/contrib/propagators/ot"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
@@ -50,6 +46,9 @@ func (e *Executor) startTracing(ctx context.Context) (tracetools.Span, context.C
 	case tracetools.BackendOpenTelemetry:
 		return e.startTracingOpenTelemetry(ctx)
 
+	case tracetools.BackendFile:
+		return e.startTracingFile(ctx)
+
 	case tracetools.BackendNone:
 		return &tracetools.NoopSpan{}, ctx, noopStopper
 
@@ -131,6 +130,118 @@ func (e *Executor) startTracingOpenTelemetry(ctx context.Context) (tracetools.Sp
 		return &tracetools.NoopSpan{}, ctx, noopStopper
 	}
 
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(e.openTelemetryResource()),
+	)
+
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(tracetools.OpenTelemetryPropagator())
+
+	// Continue the trace started by the agent, if there is one.
+	ctx = tracetools.DecodeOpenTelemetryTraceContext(ctx, e.shell.Env.Dump())
+
+	span, ctx := e.startOpenTelemetryRootSpan(ctx, tracerProvider)
+
+	stop := func() {
+		ctx := context.Background()
+		_ = tracerProvider.ForceFlush(ctx)
+		_ = tracerProvider.Shutdown(ctx)
+	}
+
+	return span, ctx, stop
+}
+
+// startTracingFile records spans with OpenTelemetry, like
+// startTracingOpenTelemetry, but writes them to a trace file that's uploaded
+// as an artifact when the job finishes, for those without a tracing backend.
+func (e *Executor) startTracingFile(ctx context.Context) (tracetools.Span, context.Context, stopper) {
+	dir, err := os.MkdirTemp("", "buildkite-trace-")
+	if err != nil {
+		e.shell.Errorf("Error creating a directory for the trace file %s. Disabling tracing.", err)
+		return &tracetools.NoopSpan{}, ctx, noopStopper
+	}
+
+	name := "job-trace.json"
+	if e.TracingFileFormat == tracetools.FileFormatOTLPJSON {
+		name = "job-trace.otlp.json"
+	}
+
+	exporter, err := tracetools.NewFileExporter(filepath.Join(dir, name), e.TracingFileFormat)
+	if err != nil {
+		e.shell.Errorf("Error creating trace file exporter %s. Disabling tracing.", err)
+		_ = os.RemoveAll(dir)
+		return &tracetools.NoopSpan{}, ctx, noopStopper
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(e.openTelemetryResource()),
+	)
+
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(tracetools.OpenTelemetryPropagator())
+
+	// Continue the trace started by the agent, if there is one, so the spans
+	// in the file are linked to the agent's job span.
+	ctx = tracetools.DecodeOpenTelemetryTraceContext(ctx, e.shell.Env.Dump())
+
+	span, ctx := e.startOpenTelemetryRootSpan(ctx, tracerProvider)
+
+	stop := func() {
+		defer os.RemoveAll(dir)
+
+		// Shutting down the provider writes the trace file.
+		ctx := context.Background()
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			e.shell.Errorf("Error writing the trace file: %v", err)
+			return
+		}
+		if err := e.uploadTraceFile(ctx, dir, name); err != nil {
+			e.shell.Errorf("Error uploading the trace file: %v", err)
+		}
+	}
+
+	return span, ctx, stop
+}
+
+// uploadTraceFile uploads the trace file written by the file tracing backend
+// as an artifact, and prints how to view it.
+func (e *Executor) uploadTraceFile(ctx context.Context, dir, name string) error {
+	e.shell.Headerf("Uploading job trace")
+
+	// Upload from the trace file's directory, so that the artifact path is
+	// just the file name.
+	wd := e.shell.Getwd()
+	if err := e.shell.Chdir(dir); err != nil {
+		return err
+	}
+	defer func() { _ = e.shell.Chdir(wd) }()
+
+	args := []string{"artifact", "upload", name}
+	if e.ArtifactUploadDestination != "" {
+		args = append(args, e.ArtifactUploadDestination)
+	}
+	if err := e.shell.Run(ctx, "buildkite-agent", args...); err != nil {
+		return err
+	}
+
+	download := fmt.Sprintf("buildkite-agent artifact download %s . --step %s", name, e.JobID)
+	switch e.TracingFileFormat {
+	case tracetools.FileFormatChrome:
+		e.shell.Printf("The trace of this job was uploaded as the artifact %s. To view it, download it (with `%s`) and open it in %s", name, download, perfettoURL)
+	case tracetools.FileFormatOTLPJSON:
+		e.shell.Printf("The trace of this job was uploaded as the artifact %s, in the OTLP JSON format. To download it, run `%s`", name, download)
+	}
+	return nil
+}
+
+// perfettoURL is a trace viewer that can open Chrome trace event files.
+const perfettoURL = "https://ui.perfetto.dev"
+
+// openTelemetryResource returns the OpenTelemetry resource that spans of the
+// job are recorded against.
+func (e *Executor) openTelemetryResource() *resource.Resource {
 	attributes := []attribute.KeyValue{
 		semconv.ServiceNameKey.String(e.ExecutorConfig.TracingServiceName),
 		semconv.ServiceVersionKey.String(version.Version()),
@@ -145,22 +256,11 @@ func (e *Executor) startTracingOpenTelemetry(ctx context.Context) (tracetools.Sp
 
 	attributes = append(attributes, extras...)
 
-	resources := resource.NewWithAttributes(semconv.SchemaURL, attributes...)
-	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
-		sdktrace.WithResource(resources),
-	)
-
-	otel.SetTracerProvider(tracerProvider)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{},
-		propagation.Baggage{},
-		b3.New(),
-		&jaeger.Jaeger{},
-		&ot.OT{},
-		&xray.Propagator{},
-	))
+	return resource.NewWithAttributes(semconv.SchemaURL, attributes...)
+}
 
+// startOpenTelemetryRootSpan starts the span for the whole job.
+func (e *Executor) startOpenTelemetryRootSpan(ctx context.Context, tracerProvider trace.TracerProvider) (tracetools.Span, context.Context) {
 	tracer := tracerProvider.Tracer(
 		"buildkite-agent",
 		trace.WithInstrumentationVersion(version.Version()),
@@ -173,13 +273,7 @@ func (e *Executor) startTracingOpenTelemetry(ctx context.Context) (tracetools.Sp
 		),
 	)
 
-	stop := func() {
-		ctx := context.Background()
-		_ = tracerProvider.ForceFlush(ctx)
-		_ = tracerProvider.Shutdown(ctx)
-	}
-
-	return tracetools.NewOpenTelemetrySpan(span), ctx, stop
+	return tracetools.NewOpenTelemetrySpan(span), ctx
 }
 
 func GenericTracingExtras(e *Executor, env *env.Environment) map[string]any {
@@ -291,7 +385,7 @@ func (e *Executor) implementationSpecificSpanName(otelName, ddName string) strin
 	switch e.TracingBackend {
 	case tracetools.BackendDatadog:
 		return ddName
-	case tracetools.BackendOpenTelemetry:
+	case tracetools.BackendOpenTelemetry, tracetools.BackendFile:
 		fallthrough
 	default:
 		return otelName
@@ -0,0 +1,201 @@
+package replacer
+
+import "slices"
+
+// maxSparseEdges is the number of edges above which a node gets a dense
+// transition table. Up to this many edges, a linear scan is fast enough.
+const maxSparseEdges = 8
+
+// automaton is an Aho–Corasick automaton matching a fixed set of needles.
+//
+// Nodes are stored in a slice and refer to one another by index. Node 0 is the
+// root, which represents the empty string. Every other node represents a prefix
+// of one or more needles.
+//
+// Transitions out of the root are stored densely, since the root is visited
+// for almost every byte of input that doesn't match anything. Nodes with many
+// children (typically those near the root) also get a dense table, which
+// includes the transitions that would otherwise be found by following failure
+// links. Transitions out of every other node are stored sparsely (most nodes
+// deep in the trie have a single child).
+type automaton struct {
+	// The needles this automaton was built from.
+	needles []string
+
+	// Dense transitions out of the root. 0 means "stay at the root".
+	root [256]int32
+
+	// Dense transition tables for nodes with many children.
+	dense []*[256]int32
+
+	// All the nodes, root first.
+	nodes []acNode
+
+	// The current node while matching a stream.
+	state int32
+}
+
+// acNode is a single node in the automaton.
+type acNode struct {
+	// Trie edges to child nodes, sorted by byte.
+	edges []acEdge
+
+	// If non-zero, a.dense[dense-1] is a complete transition table for this
+	// node, and edges and fail need not be consulted while matching.
+	dense int32
+
+	// The node for the longest proper suffix of this node's string that is
+	// also a prefix of some needle.
+	fail int32
+
+	// Length of the string represented by this node.
+	depth int32
+
+	// Length of the longest suffix of this node's string that could still
+	// become part of a longer match: the depth of the first node with
+	// children found by following failure links, starting with this node.
+	hold int32
+
+	// Length of the longest needle that is a suffix of this node's string,
+	// or 0 if there is none. Because all needles ending at the same position
+	// overlap, only the longest one matters for redaction.
+	matchLen int32
+}
+
+// acEdge is a trie edge.
+type acEdge struct {
+	c  byte
+	to int32
+}
+
+// newAutomaton builds an automaton from needles. needles should not contain
+// empty strings or duplicates.
+func newAutomaton(needles []string) *automaton {
+	a := &automaton{
+		needles: needles,
+		nodes:   make([]acNode, 1, 1+len(needles)*8),
+	}
+
+	// 1. Build the trie.
+	for _, s := range needles {
+		n := int32(0)
+		for i := 0; i < len(s); i++ {
+			n = a.insertEdge(n, s[i])
+		}
+		a.nodes[n].matchLen = int32(len(s))
+	}
+	for i := range a.nodes {
+		if len(a.nodes[i].edges) > 0 {
+			a.nodes[i].hold = a.nodes[i].depth
+		}
+	}
+
+	// 2. Compute failure links breadth-first, so that the failure link of a
+	//    node's parent (which is always shallower) is known before the node.
+	//    Each node inherits matchLen and hold from its failure link if they are
+	//    longer, which saves following failure links while matching.
+	queue := make([]int32, 0, len(a.nodes))
+	for _, e := range a.nodes[0].edges {
+		queue = append(queue, e.to)
+	}
+	for i := 0; i < len(queue); i++ {
+		n := queue[i]
+		if len(a.nodes[n].edges) > maxSparseEdges {
+			a.densify(n)
+		}
+		for _, e := range a.nodes[n].edges {
+			a.nodes[e.to].fail = a.next(a.nodes[n].fail, e.c)
+			node, fail := &a.nodes[e.to], &a.nodes[a.nodes[e.to].fail]
+			node.matchLen = max(node.matchLen, fail.matchLen)
+			node.hold = max(node.hold, fail.hold)
+			queue = append(queue, e.to)
+		}
+	}
+
+	return a
+}
+
+// densify builds a complete transition table for n. The failure link of n
+// must already be known.
+func (a *automaton) densify(n int32) {
+	table := new([256]int32)
+	for c := range table {
+		if t := a.child(n, byte(c)); t != 0 {
+			table[c] = t
+			continue
+		}
+		table[c] = a.next(a.nodes[n].fail, byte(c))
+	}
+	a.dense = append(a.dense, table)
+	a.nodes[n].dense = int32(len(a.dense))
+}
+
+// insertEdge returns the child of n for byte c, creating it if needed.
+func (a *automaton) insertEdge(n int32, c byte) int32 {
+	if t := a.child(n, c); t != 0 {
+		return t
+	}
+	t := int32(len(a.nodes))
+	a.nodes = append(a.nodes, acNode{depth: a.nodes[n].depth + 1})
+	i, _ := slices.BinarySearchFunc(a.nodes[n].edges, c, func(e acEdge, c byte) int {
+		return int(e.c) - int(c)
+	})
+	a.nodes[n].edges = slices.Insert(a.nodes[n].edges, i, acEdge{c: c, to: t})
+	if n == 0 {
+		a.root[c] = t
+	}
+	return t
+}
+
+// child returns the child of n for byte c, or 0 if there isn't one. (The root
+// is never a child, so 0 is unambiguous.)
+func (a *automaton) child(n int32, c byte) int32 {
+	if n == 0 {
+		return a.root[c]
+	}
+	edges := a.nodes[n].edges
+	if len(edges) <= maxSparseEdges {
+		for _, e := range edges {
+			if e.c == c {
+				return e.to
+			}
+		}
+		return 0
+	}
+	i, found := slices.BinarySearchFunc(edges, c, func(e acEdge, c byte) int {
+		return int(e.c) - int(c)
+	})
+	if !found {
+		return 0
+	}
+	return edges[i].to
+}
+
+// next returns the node reached from n on byte c, following failure links as
+// needed.
+func (a *automaton) next(n int32, c byte) int32 {
+	for n != 0 {
+		if d := a.nodes[n].dense; d != 0 {
+			return a.dense[d-1][c]
+		}
+		if t := a.child(n, c); t != 0 {
+			return t
+		}
+		n = a.nodes[n].fail
+	}
+	return a.root[c]
+}
+
+// step advances the current state by one byte, and returns the length of the
+// longest needle ending at that byte (or 0 if none).
+func (a *automaton) step(c byte) int {
+	a.state = a.next(a.state, c)
+	return int(a.nodes[a.state].matchLen)
+}
+
+// pending returns the length of the current partial match, that is, the
+// number of bytes at the end of the input seen so far that could still become
+// part of a match.
+func (a *automaton) pending() int {
+	return int(a.nodes[a.state].hold)
+}
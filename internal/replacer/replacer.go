@@ -4,27 +4,32 @@ package replacer
 import (
 	"fmt"
 	"io"
+	"slices"
 	"sync"
 )
 
 type unit struct{}
 
-// Replacer is a straightforward streaming string replacer suitable for
-// detecting or redacting secrets in a stream.
+// Replacer is a streaming string replacer suitable for detecting or redacting
+// secrets in a stream.
 //
-// The algorithm is intended to be easier to maintain than certain
-// high-performance multi-string search algorithms, and also geared towards
-// ensuring strings don't escape (for instance, by matching overlaps), at the
-// expense of ultimate efficiency.
+// Needles are matched with Aho–Corasick automata, so the cost of each Write is
+// proportional to the length of the input, and does not grow with the number
+// of needles. It is geared towards ensuring strings don't escape (for
+// instance, by merging overlapping matches into a single replacement).
+//
+// To support adding needles without rebuilding one large automaton each time,
+// needles are spread across a small number of automata with geometrically
+// decreasing sizes (the "logarithmic method"). Adding needles builds a new
+// small automaton, and only merges it with existing automata of a similar
+// size, so each needle is rebuilt O(log n) times over the life of the
+// Replacer.
 type Replacer struct {
 	// The replacement callback.
 	replacement func([]byte) []byte
 
-	// Strings to search for (looking for these needles in the haystack),
-	// organised by first byte.
-	// Why first byte? Because looking up needles by the first byte is a lot
-	// faster than _filtering_ all the needles by first byte.
-	needlesByFirstByte [256]map[string]unit
+	// All the needles (strings to search for in the haystack).
+	needles map[string]unit
 
 	// For synchronising writes. Each write can touch everything below.
 	mu sync.Mutex
@@ -35,12 +40,9 @@ type Replacer struct {
 	// Intermediate buffer to account for partially-written data.
 	buf []byte
 
-	// Current redaction partialMatches - if we have begun matching a potential
-	// needle there will be at least one of these.
-	// nextMatches is the next set of partialMatches.
-	// Write alternates between these two, rather than creating a new slice to
-	// hold the next set of matches for every byte of the input.
-	partialMatches, nextMatches []partialMatch
+	// The automata searching for needles, from largest to smallest. Each
+	// needle is in exactly one automaton.
+	levels []*automaton
 
 	// The ranges in buf we must replace on flush.
 	completedMatches []subrange
@@ -72,8 +74,6 @@ func New(dst io.Writer, needles []string, replacement func([]byte) []byte) *Repl
 
 		// Preallocate a few things.
 		buf:              make([]byte, 0, 65536),
-		partialMatches:   make([]partialMatch, 0, len(needles)),
-		nextMatches:      make([]partialMatch, 0, len(needles)),
 		completedMatches: make([]subrange, 0, len(needles)),
 	}
 	r.Reset(needles)
@@ -101,9 +101,8 @@ func (r *Replacer) Write(b []byte) (int, error) {
 	//
 	// Step 2 is complicated by the fact that each Write could contain a partial
 	// needle at the start or the end. So a buffer is needed to hold onto any
-	// incomplete matches (in case they _don't_ match), as well as some extra
-	// state (r.partialMatches) for tracking where we are in each incomplete
-	// match.
+	// incomplete matches (in case they _don't_ match). Each automaton's state
+	// tracks how much of the end of the buffer is an incomplete match.
 	//
 	// Step 4 (mostly in flushUpTo) only looks complicated because it has to
 	// alternate between unmatched and matched ranges, *and* handle the case
@@ -115,57 +114,8 @@ func (r *Replacer) Write(b []byte) (int, error) {
 	r.buf = append(r.buf, b...)
 
 	// 2. Search through b to find instances of strings to redact. Store the
-	//    ranges of redactions in r.redact.
-	for n, c := range b {
-		bufidx := n + prevBufLen // where we are in the whole buffer
-
-		// In the middle of matching?
-		for _, s := range r.partialMatches {
-			// Does the needle match on this byte?
-			if c != s.needle[s.matched] {
-				// No - drop this partial match.
-				continue
-			}
-
-			// It matched!
-			s.matched++
-
-			// Have we fully matched this needle?
-			if s.matched < len(s.needle) {
-				// This state survives for another byte.
-				r.nextMatches = append(r.nextMatches, s)
-				continue
-			}
-
-			// Match complete; save range to redact.
-			r.completedMatches = append(r.completedMatches, subrange{
-				from: bufidx - len(s.needle) + 1,
-				to:   bufidx + 1,
-			})
-		}
-
-		// Start matching something?
-		for s, _ := range r.needlesByFirstByte[c] {
-			if len(s) == 1 {
-				// A pathological case; in practice we don't redact secrets
-				// smaller than RedactLengthMin.
-				r.completedMatches = append(r.completedMatches, subrange{
-					from: bufidx,
-					to:   bufidx + 1,
-				})
-				continue
-			}
-			r.nextMatches = append(r.nextMatches, partialMatch{
-				needle:  s,
-				matched: 1,
-			})
-		}
-
-		// r.nextMatches now contains the new set of partial matches.
-		// Re-use the array underlying the old r.partialMatches for the new
-		// r.nextMatches, instead of allocating a new one.
-		r.partialMatches, r.nextMatches = r.nextMatches, r.partialMatches[:0]
-	}
+	//    ranges of redactions in r.completedMatches.
+	r.search(prevBufLen)
 
 	// 3. Merge overlapping redaction ranges.
 	// Because they were added from start to end, they are in order.
@@ -174,8 +124,8 @@ func (r *Replacer) Write(b []byte) (int, error) {
 	// 4. Write as much of the buffer as we can without spilling incomplete
 	//    matches.
 	limit := len(r.buf)
-	for _, s := range r.partialMatches {
-		if to := len(r.buf) - s.matched; to < limit {
+	for _, a := range r.levels {
+		if to := len(r.buf) - a.pending(); to < limit {
 			limit = to
 		}
 	}
@@ -188,6 +138,37 @@ func (r *Replacer) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
+// search feeds r.buf[from:] through every automaton, appending the range of
+// the longest match ending at each byte to r.completedMatches.
+func (r *Replacer) search(from int) {
+	r.searchLevels(r.levels, from)
+}
+
+// searchLevels feeds r.buf[from:] through the given automata.
+func (r *Replacer) searchLevels(levels []*automaton, from int) {
+	for bufidx := from; bufidx < len(r.buf); bufidx++ {
+		c := r.buf[bufidx]
+
+		// All needles ending here overlap one another, so only the longest
+		// one is needed.
+		longest := 0
+		for _, a := range levels {
+			if n := a.step(c); n > longest {
+				longest = n
+			}
+		}
+		if longest == 0 {
+			continue
+		}
+
+		// Match complete; save range to redact.
+		r.completedMatches = append(r.completedMatches, subrange{
+			from: bufidx - longest + 1,
+			to:   bufidx + 1,
+		})
+	}
+}
+
 // Flush writes all buffered data to the destination. It assumes there is no
 // more data in the stream, and so any incomplete matches are non-matches.
 func (r *Replacer) Flush() error {
@@ -196,7 +177,9 @@ func (r *Replacer) Flush() error {
 
 	// Since there is no more incoming data, any remaining partial matches
 	// cannot complete.
-	r.partialMatches = r.partialMatches[:0]
+	for _, a := range r.levels {
+		a.state = 0
+	}
 	return r.flushUpTo(len(r.buf))
 }
 
@@ -290,11 +273,7 @@ func (r *Replacer) flushUpTo(limit int) error {
 
 // Size returns the number of needles
 func (r *Replacer) Size() int {
-	sum := 0
-	for _, n := range r.needlesByFirstByte {
-		sum += len(n)
-	}
-	return sum
+	return len(r.needles)
 }
 
 // Needle returns the current needles
@@ -303,27 +282,25 @@ func (r *Replacer) Needles() []string {
 	defer r.mu.Unlock()
 
 	needles := make([]string, 0, r.Size())
-	for _, m := range r.needlesByFirstByte {
-		for n := range m {
-			needles = append(needles, n)
-		}
+	for n := range r.needles {
+		needles = append(needles, n)
 	}
 	return needles
 }
 
 // Reset removes all current needes and sets new set of needles. It is not
 // necessary to Flush beforehand, but:
-//   - any previous needles which have begun matching will continue matching
-//     (until they reach a terminal state), and
-//   - any new needles will not be compared against existing buffer content,
-//     only data passed to Write calls after Reset.
+//   - any previous needles which have begun matching, but are not among the
+//     new needles, will stop matching, and
+//   - the new needles will be compared against any data that has been written
+//     but not yet forwarded to the destination, but not against data that has
+//     already been forwarded.
 func (r *Replacer) Reset(needles []string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	for i := range r.needlesByFirstByte {
-		r.needlesByFirstByte[i] = nil
-	}
+	r.needles = make(map[string]unit, len(needles))
+	r.levels = r.levels[:0]
 
 	r.unsafeAdd(needles)
 }
@@ -332,8 +309,9 @@ func (r *Replacer) Reset(needles []string) {
 // Flush beforehand, but:
 //   - any previous strings which have begun matching will continue matching
 //     (until they reach a terminal state), and
-//   - any new strings will not be compared against existing buffer content,
-//     only data passed to Write calls after Add.
+//   - any new strings will be compared against any data that has been written
+//     but not yet forwarded to the destination, but not against data that has
+//     already been forwarded.
 func (r *Replacer) Add(needles ...string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -342,22 +320,53 @@ func (r *Replacer) Add(needles ...string) {
 }
 
 func (r *Replacer) unsafeAdd(needles []string) {
+	added := make([]string, 0, len(needles))
 	for _, s := range needles {
 		if len(s) == 0 {
 			continue
 		}
-		if r.needlesByFirstByte[s[0]] == nil {
-			r.needlesByFirstByte[s[0]] = map[string]unit{s: {}}
+		if _, ok := r.needles[s]; ok {
 			continue
 		}
-		r.needlesByFirstByte[s[0]][s] = unit{}
+		r.needles[s] = unit{}
+		added = append(added, s)
+	}
+	if len(added) == 0 {
+		return
 	}
-}
 
-// partialMatch tracks how far through one of the needles we have matched.
-type partialMatch struct {
-	needle  string
-	matched int
+	// Merge the new needles with any existing automata that are no larger,
+	// so that the sizes of the levels keep decreasing geometrically, and there
+	// are never more than O(log n) of them.
+	for len(r.levels) > 0 {
+		last := r.levels[len(r.levels)-1]
+		if len(last.needles) > len(added) {
+			break
+		}
+		added = append(added, last.needles...)
+		r.levels = r.levels[:len(r.levels)-1]
+	}
+	a := newAutomaton(added)
+	r.levels = append(r.levels, a)
+
+	// The new automaton starts at the root, but the buffer may hold the
+	// beginning of a match (for the existing needles it was merged from, or
+	// the new needles). Bring it up to date by feeding it the buffer.
+	if len(r.buf) == 0 {
+		return
+	}
+	n := len(r.completedMatches)
+	r.searchLevels([]*automaton{a}, 0)
+	if len(r.completedMatches) == n {
+		return
+	}
+
+	// The existing matches and new matches are each sorted by "to", but
+	// mergeOverlaps needs them sorted overall.
+	slices.SortStableFunc(r.completedMatches, func(a, b subrange) int {
+		return a.to - b.to
+	})
+	r.completedMatches = mergeOverlaps(r.completedMatches)
 }
 
 // subrange designates a contiguous range in a buffer (slice indexes: inclusive
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"slices"
 	"strings"
 	"testing"
@@ -265,6 +266,114 @@ func TestAddingNeedles(t *testing.T) {
 	assert.Equal(t, buf.String(), "redact [REDACTED] and [REDACTED] but not pre-secret3333\nnow redact [REDACTED], [REDACTED], and [REDACTED]\n")
 }
 
+func TestReplacerAddMidMatch(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	replacer := replacer.New(&buf, []string{"Lorem ipsum dolor sEt"}, redact.Redact)
+
+	// "Lorem ip" is held back, because it might become "Lorem ipsum dolor sEt".
+	fmt.Fprint(replacer, "Lorem ip")
+
+	// The new needle is compared against the held-back data, and so finishes
+	// matching in the next write.
+	replacer.Add("ipsum")
+
+	fmt.Fprint(replacer, "sum dolor sit amet")
+	replacer.Flush()
+
+	if got, want := buf.String(), "Lorem [REDACTED] dolor sit amet"; got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestReplacerManyNeedles(t *testing.T) {
+	t.Parallel()
+
+	rnd := rand.New(rand.NewPCG(0, 1))
+	needles := randomNeedles(rnd, 2000, "abc", 2, 12)
+	haystack := randomNeedles(rnd, 1, "abcd", 20000, 20000)[0]
+
+	// Add the needles in batches of varying size, to exercise merging.
+	var got strings.Builder
+	r := replacer.New(&got, needles[:100], redact.Redact)
+	for i := 100; i < len(needles); i += i / 10 {
+		r.Add(needles[i:min(i+i/10, len(needles))]...)
+	}
+	if got, want := r.Size(), len(needles); got != want {
+		t.Errorf("r.Size() = %d, want %d", got, want)
+	}
+
+	// Write in irregularly-sized pieces.
+	for in := haystack; len(in) > 0; {
+		n := min(rnd.IntN(100), len(in))
+		if _, err := r.Write([]byte(in[:n])); err != nil {
+			t.Fatalf("r.Write(%q) error = %v", in[:n], err)
+		}
+		in = in[n:]
+	}
+	if err := r.Flush(); err != nil {
+		t.Fatalf("r.Flush() = %v", err)
+	}
+
+	if diff := cmp.Diff(got.String(), naiveRedact(haystack, needles)); diff != "" {
+		t.Errorf("post-redaction diff (-got +want):\n%s", diff)
+	}
+}
+
+// naiveRedact redacts needles in s the slow and obvious way, for comparison.
+func naiveRedact(s string, needles []string) string {
+	type span struct{ from, to int }
+	var spans []span
+	for _, n := range needles {
+		for i := 0; i+len(n) <= len(s); i++ {
+			if s[i:i+len(n)] == n {
+				spans = append(spans, span{i, i + len(n)})
+			}
+		}
+	}
+
+	// Merge overlapping (but not adjacent) spans.
+	slices.SortFunc(spans, func(a, b span) int { return a.from - b.from })
+	var merged []span
+	for _, sp := range spans {
+		if len(merged) > 0 && sp.from < merged[len(merged)-1].to {
+			merged[len(merged)-1].to = max(merged[len(merged)-1].to, sp.to)
+			continue
+		}
+		merged = append(merged, sp)
+	}
+
+	var sb strings.Builder
+	prev := 0
+	for _, sp := range merged {
+		sb.WriteString(s[prev:sp.from])
+		sb.Write(redact.Redact([]byte(s[sp.from:sp.to])))
+		prev = sp.to
+	}
+	sb.WriteString(s[prev:])
+	return sb.String()
+}
+
+// randomNeedles returns count random strings drawn from alphabet, with lengths
+// between minLen and maxLen.
+func randomNeedles(rnd *rand.Rand, count int, alphabet string, minLen, maxLen int) []string {
+	seen := make(map[string]bool, count)
+	out := make([]string, 0, count)
+	for len(out) < count {
+		b := make([]byte, minLen+rnd.IntN(maxLen-minLen+1))
+		for i := range b {
+			b[i] = alphabet[rnd.IntN(len(alphabet))]
+		}
+		if seen[string(b)] {
+			continue
+		}
+		seen[string(b)] = true
+		out = append(out, string(b))
+	}
+	return out
+}
+
 func BenchmarkReplacer(b *testing.B) {
 	b.ResetTimer()
 	r := replacer.New(io.Discard, bigLipsumSecrets, redact.Redact)
@@ -274,6 +383,25 @@ func BenchmarkReplacer(b *testing.B) {
 	r.Flush()
 }
 
+// BenchmarkReplacerNeedles shows that throughput doesn't depend on the number
+// of needles.
+func BenchmarkReplacerNeedles(b *testing.B) {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	for _, count := range []int{10, 100, 1000, 10000} {
+		b.Run(fmt.Sprintf("%d needles", count), func(b *testing.B) {
+			rnd := rand.New(rand.NewPCG(0, uint64(count)))
+			needles := append(randomNeedles(rnd, count, alphabet, 20, 40), bigLipsumSecrets...)
+			r := replacer.New(io.Discard, needles, redact.Redact)
+			b.SetBytes(int64(len(bigLipsum) + 1))
+			b.ResetTimer()
+			for range b.N {
+				fmt.Fprintln(r, bigLipsum)
+			}
+			r.Flush()
+		})
+	}
+}
+
 func FuzzReplacer(f *testing.F) {
 	f.Add(lipsum, 10, "", "", "", "")
 	f.Add(lipsum, 10, "ipsum", "", "", "")
@@ -0,0 +1,30 @@
+package secrets
+
+import (
+	"context"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+// APIClient is the subset of api.Client used by BuildkiteProvider.
+type APIClient interface {
+	GetSecret(context.Context, *api.GetSecretRequest) (*api.Secret, *api.Response, error)
+}
+
+// BuildkiteProvider reads secrets from Buildkite secrets, through the Agent
+// API.
+type BuildkiteProvider struct {
+	Client APIClient
+
+	// The job the secrets are for.
+	JobID string
+}
+
+// Get reads a secret from Buildkite secrets.
+func (p *BuildkiteProvider) Get(ctx context.Context, key string) (string, error) {
+	secret, _, err := p.Client.GetSecret(ctx, &api.GetSecretRequest{Key: key, JobID: p.JobID})
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// CommandProvider gets secrets by running an external program. The program is
+// run with Args followed by the key, and should print the value to stdout. A
+// single trailing newline is removed from the output. If the secret doesn't
+// exist the program should exit with status 2 (and any other failure should
+// exit with some other non-zero status).
+type CommandProvider struct {
+	Path string
+	Args []string
+}
+
+// Get runs the program to get a secret.
+func (p *CommandProvider) Get(ctx context.Context, key string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, slices.Concat(p.Args, []string{key})...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if exitErr := new(exec.ExitError); errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+			return "", fmt.Errorf("%s: %q: %w", p.Path, key, ErrNotFound)
+		}
+		if msg != "" {
+			return "", fmt.Errorf("%s failed: %w: %s", p.Path, err, msg)
+		}
+		return "", fmt.Errorf("%s failed: %w", p.Path, err)
+	}
+
+	out := stdout.String()
+	out = strings.TrimSuffix(out, "\n")
+	out = strings.TrimSuffix(out, "\r")
+	return out, nil
+}
@@ -0,0 +1,76 @@
+package secrets_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/buildkite/agent/v3/internal/secrets"
+	"gotest.tools/v3/assert"
+)
+
+func TestCommandProvider(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a shell script")
+	}
+
+	script := filepath.Join(t.TempDir(), "fetch-secret")
+	assert.NilError(t, os.WriteFile(script, []byte(`#!/bin/sh
+case "$2" in
+  deploy_key) echo "$1-s3cr3t" ;;
+  missing) exit 2 ;;
+  *) echo "boom" >&2; exit 1 ;;
+esac
+`), 0o755))
+
+	p := &secrets.CommandProvider{Path: script, Args: []string{"prefix"}}
+
+	got, err := p.Get(context.Background(), "deploy_key")
+	assert.NilError(t, err)
+	assert.Equal(t, got, "prefix-s3cr3t")
+
+	_, err = p.Get(context.Background(), "missing")
+	assert.Check(t, errors.Is(err, secrets.ErrNotFound), "p.Get(missing) error = %v, want ErrNotFound", err)
+
+	_, err = p.Get(context.Background(), "other")
+	assert.ErrorContains(t, err, "boom")
+}
+
+func TestCommandProviderConcurrentGets(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a shell script")
+	}
+
+	script := filepath.Join(t.TempDir(), "fetch-secret")
+	assert.NilError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$1-$2\"\n"), 0o755))
+
+	// Args has spare capacity, so appending the key to it would share the
+	// backing array between concurrent calls
+	args := make([]string, 1, 4)
+	args[0] = "prefix"
+	p := &secrets.CommandProvider{Path: script, Args: args}
+
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	errs := make(chan error, len(keys))
+	for _, key := range keys {
+		go func() {
+			got, err := p.Get(context.Background(), key)
+			if err == nil && got != "prefix-"+key {
+				err = fmt.Errorf("p.Get(%q) = %q, want %q", key, got, "prefix-"+key)
+			}
+			errs <- err
+		}()
+	}
+	for range keys {
+		assert.NilError(t, <-errs)
+	}
+	assert.DeepEqual(t, p.Args, []string{"prefix"})
+}
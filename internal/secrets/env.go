@@ -0,0 +1,49 @@
+package secrets
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnvSecret maps an environment variable to the key of a secret that should
+// be used as its value.
+type EnvSecret struct {
+	Name string
+	Key  string
+}
+
+// ParseEnv parses a list of NAME=KEY mappings, as found in
+// BUILDKITE_SECRETS_ENV. Empty items are ignored. If a name appears more than
+// once, the last mapping for it wins.
+func ParseEnv(items []string) ([]EnvSecret, error) {
+	out := make([]EnvSecret, 0, len(items))
+	index := make(map[string]int, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(item, "=")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("invalid secret environment mapping %q: expected NAME=KEY", item)
+		}
+		if i, ok := index[name]; ok {
+			out[i].Key = key
+			continue
+		}
+		index[name] = len(out)
+		out = append(out, EnvSecret{Name: name, Key: key})
+	}
+	return out, nil
+}
+
+// SplitList splits a comma-separated list, as used for list-valued
+// environment variables such as BUILDKITE_SECRETS_ENV and
+// BUILDKITE_SECRETS_PROVIDERS.
+func SplitList(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
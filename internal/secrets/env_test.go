@@ -0,0 +1,24 @@
+package secrets_test
+
+import (
+	"testing"
+
+	"github.com/buildkite/agent/v3/internal/secrets"
+	"gotest.tools/v3/assert"
+)
+
+func TestParseEnv(t *testing.T) {
+	t.Parallel()
+
+	got, err := secrets.ParseEnv(secrets.SplitList("DB_PASSWORD=prod/db, API_KEY=vault/api#key,,DB_PASSWORD=prod/db2"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []secrets.EnvSecret{
+		{Name: "DB_PASSWORD", Key: "prod/db2"},
+		{Name: "API_KEY", Key: "vault/api#key"},
+	})
+
+	for _, bad := range []string{"DB_PASSWORD", "=prod/db", "DB_PASSWORD="} {
+		_, err := secrets.ParseEnv([]string{bad})
+		assert.Check(t, err != nil, "ParseEnv(%q) error = nil, want an error", bad)
+	}
+}
@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"gopkg.in/yaml.v3"
+)
+
+// FileProvider reads secrets from a local YAML file. The file is a mapping of
+// keys to values, where each value is encrypted as a JWE (in compact
+// serialisation). Values are decrypted with the keys in a JWKS file, each of
+// which must have its `alg` set.
+//
+// For example:
+//
+//	db_password: eyJhbGciOiJSU0EtT0FFUC0yNTYiLCJlbmMiOiJBMjU2R0NNIn0.ZXhh...
+//	deploy_key: eyJhbGciOiJSU0EtT0FFUC0yNTYiLCJlbmMiOiJBMjU2R0NNIn0.cXVp...
+//
+// Only the values are encrypted, so that changes to the file can be reviewed
+// without decrypting it.
+type FileProvider struct {
+	path    string
+	keyPath string
+}
+
+// NewFileProvider returns a FileProvider that reads secrets from the file at
+// path, decrypting them with the JWKS file at keyPath.
+func NewFileProvider(path, keyPath string) *FileProvider {
+	return &FileProvider{path: path, keyPath: keyPath}
+}
+
+// Get reads and decrypts one secret from the file.
+func (p *FileProvider) Get(ctx context.Context, key string) (string, error) {
+	src, err := os.ReadFile(p.path)
+	if err != nil {
+		return "", fmt.Errorf("reading secrets file: %w", err)
+	}
+
+	var values map[string]string
+	if err := yaml.Unmarshal(src, &values); err != nil {
+		return "", fmt.Errorf("parsing secrets file %s: %w", p.path, err)
+	}
+
+	encrypted, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("%q in %s: %w", key, p.path, ErrNotFound)
+	}
+
+	keys, err := jwk.ReadFile(p.keyPath)
+	if err != nil {
+		return "", fmt.Errorf("reading secrets key file: %w", err)
+	}
+
+	value, err := jwe.Decrypt([]byte(strings.TrimSpace(encrypted)), jwe.WithKeySet(keys, jwe.WithRequireKid(false)))
+	if err != nil {
+		return "", fmt.Errorf("decrypting %q in %s: %w", key, p.path, err)
+	}
+	return string(value), nil
+}
@@ -0,0 +1,54 @@
+package secrets_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/agent/v3/internal/secrets"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"gopkg.in/yaml.v3"
+	"gotest.tools/v3/assert"
+)
+
+func TestFileProvider(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "key.json")
+	secretsPath := filepath.Join(dir, "secrets.yml")
+
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+	priv, err := jwk.FromRaw(raw)
+	assert.NilError(t, err)
+	assert.NilError(t, priv.Set(jwk.AlgorithmKey, jwa.RSA_OAEP_256))
+
+	set := jwk.NewSet()
+	assert.NilError(t, set.AddKey(priv))
+	keyJSON, err := json.Marshal(set)
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(keyPath, keyJSON, 0o600))
+
+	encrypted, err := jwe.Encrypt([]byte("hunter2"), jwe.WithKey(jwa.RSA_OAEP_256, raw.PublicKey))
+	assert.NilError(t, err)
+	secretsYAML, err := yaml.Marshal(map[string]string{"db_password": string(encrypted)})
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(secretsPath, secretsYAML, 0o600))
+
+	p := secrets.NewFileProvider(secretsPath, keyPath)
+
+	got, err := p.Get(context.Background(), "db_password")
+	assert.NilError(t, err)
+	assert.Equal(t, got, "hunter2")
+
+	_, err = p.Get(context.Background(), "nope")
+	assert.Check(t, errors.Is(err, secrets.ErrNotFound), "p.Get(nope) error = %v, want ErrNotFound", err)
+}
@@ -0,0 +1,165 @@
+// Package secrets provides pluggable sources of secrets for the
+// `buildkite-agent secret get` command.
+//
+// Each source implements Provider. A Router chooses between providers using
+// the prefix of the requested key, so that secrets can be migrated from one
+// source to another a few keys at a time.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrNotFound is returned (wrapped) by providers when the secret doesn't
+// exist.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider is a source of secrets.
+type Provider interface {
+	// Get returns the value of the secret with the given key.
+	Get(ctx context.Context, key string) (string, error)
+}
+
+// Router is a Provider that forwards each request to another provider chosen
+// by the longest matching key prefix. The prefix is removed from the key
+// before it is passed on. Keys that don't match any prefix are passed
+// unaltered to the fallback provider.
+type Router struct {
+	routes   []route
+	fallback Provider
+}
+
+type route struct {
+	prefix   string
+	provider Provider
+}
+
+// NewRouter returns a Router with no routes, that passes every key to
+// fallback.
+func NewRouter(fallback Provider) *Router {
+	return &Router{fallback: fallback}
+}
+
+// Handle routes keys beginning with prefix to p. If prefix is already routed,
+// the route is replaced.
+func (r *Router) Handle(prefix string, p Provider) {
+	for i, rt := range r.routes {
+		if rt.prefix == prefix {
+			r.routes[i].provider = p
+			return
+		}
+	}
+	r.routes = append(r.routes, route{prefix: prefix, provider: p})
+}
+
+// Get passes the key to the provider for the longest matching prefix.
+func (r *Router) Get(ctx context.Context, key string) (string, error) {
+	p, k := r.Route(key)
+	if p == nil {
+		return "", fmt.Errorf("no secrets provider configured for key %q", key)
+	}
+	return p.Get(ctx, k)
+}
+
+// Route returns the provider that would handle the key, and the key as it
+// would be passed to that provider.
+func (r *Router) Route(key string) (Provider, string) {
+	var best *route
+	for i, rt := range r.routes {
+		if !strings.HasPrefix(key, rt.prefix) {
+			continue
+		}
+		if best == nil || len(rt.prefix) > len(best.prefix) {
+			best = &r.routes[i]
+		}
+	}
+	if best == nil {
+		return r.fallback, key
+	}
+	return best.provider, strings.TrimPrefix(key, best.prefix)
+}
+
+// ParseRouter parses provider specifications, and returns a Router using them.
+// Each spec has the form PREFIX=URL, where the scheme of the URL selects the
+// kind of provider:
+//
+//   - file:///path/to/secrets.yml?key=/path/to/key.json reads a YAML file of
+//     JWE-encrypted values (see FileProvider).
+//   - command:///path/to/program?arg=--flag runs a program (see
+//     CommandProvider).
+//   - vault+https://vault.example.com:8200/secret reads from a HashiCorp Vault
+//     KV version 2 secrets engine (see VaultProvider). The path of the URL is
+//     the mount path of the secrets engine.
+//   - buildkite: reads Buildkite secrets through the Agent API (using
+//     fallback, which is expected to be a BuildkiteProvider).
+//
+// getenv is used to look up environment variables (such as VAULT_TOKEN).
+func ParseRouter(specs []string, fallback Provider, getenv func(string) string) (*Router, error) {
+	r := NewRouter(fallback)
+	for _, spec := range specs {
+		prefix, u, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid secrets provider %q: expected PREFIX=URL", spec)
+		}
+		p, err := parseProvider(u, fallback, getenv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secrets provider for prefix %q: %w", prefix, err)
+		}
+		r.Handle(prefix, p)
+	}
+	return r, nil
+}
+
+func parseProvider(spec string, fallback Provider, getenv func(string) string) (Provider, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, errors.New("file provider requires a path")
+		}
+		if q.Get("key") == "" {
+			return nil, errors.New("file provider requires a key parameter")
+		}
+		return NewFileProvider(u.Path, q.Get("key")), nil
+
+	case "command":
+		if u.Path == "" {
+			return nil, errors.New("command provider requires a path")
+		}
+		return &CommandProvider{Path: u.Path, Args: q["arg"]}, nil
+
+	case "vault+http", "vault+https":
+		tokenEnv := "VAULT_TOKEN"
+		if te := q.Get("token-env"); te != "" {
+			tokenEnv = te
+		}
+		addr := url.URL{
+			Scheme: strings.TrimPrefix(u.Scheme, "vault+"),
+			Host:   u.Host,
+		}
+		return &VaultProvider{
+			Address:   addr.String(),
+			Mount:     strings.Trim(u.Path, "/"),
+			Token:     getenv(tokenEnv),
+			Namespace: q.Get("namespace"),
+		}, nil
+
+	case "buildkite":
+		if fallback == nil {
+			return nil, errors.New("buildkite provider is not available")
+		}
+		return fallback, nil
+
+	default:
+		return nil, fmt.Errorf("unknown provider type %q", u.Scheme)
+	}
+}
@@ -0,0 +1,85 @@
+package secrets_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildkite/agent/v3/internal/secrets"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+)
+
+// staticProvider returns its name and the key, so tests can see which
+// provider was used.
+type staticProvider string
+
+func (p staticProvider) Get(_ context.Context, key string) (string, error) {
+	return string(p) + ":" + key, nil
+}
+
+func TestRouterLongestPrefix(t *testing.T) {
+	t.Parallel()
+
+	r := secrets.NewRouter(staticProvider("fallback"))
+	r.Handle("prod/", staticProvider("prod"))
+	r.Handle("prod/db/", staticProvider("db"))
+
+	for key, want := range map[string]string{
+		"deploy_key":      "fallback:deploy_key",
+		"prod/api":        "prod:api",
+		"prod/db/primary": "db:primary",
+		"prodx":           "fallback:prodx",
+	} {
+		got, err := r.Get(context.Background(), key)
+		assert.NilError(t, err)
+		assert.Equal(t, got, want, "key = %q", key)
+	}
+}
+
+func TestParseRouter(t *testing.T) {
+	t.Parallel()
+
+	getenv := func(name string) string {
+		return map[string]string{"VAULT_TOKEN": "t0k3n", "OTHER_TOKEN": "other"}[name]
+	}
+	r, err := secrets.ParseRouter([]string{
+		"local/=file:///etc/secrets.yml?key=/etc/secrets.json",
+		"cmd/=command:///usr/local/bin/fetch-secret?arg=--quiet",
+		"vault/=vault+https://vault.example.com:8200/secret",
+		"ns/=vault+http://127.0.0.1:8200/kv/?token-env=OTHER_TOKEN&namespace=team",
+		"bk/=buildkite:",
+	}, staticProvider("fallback"), getenv)
+	assert.NilError(t, err)
+
+	p, key := r.Route("local/foo")
+	assert.Equal(t, key, "foo")
+	assert.DeepEqual(t, p, secrets.NewFileProvider("/etc/secrets.yml", "/etc/secrets.json"), cmp.AllowUnexported(secrets.FileProvider{}))
+
+	p, _ = r.Route("cmd/foo")
+	assert.DeepEqual(t, p, &secrets.CommandProvider{Path: "/usr/local/bin/fetch-secret", Args: []string{"--quiet"}})
+
+	p, _ = r.Route("vault/foo")
+	assert.DeepEqual(t, p, &secrets.VaultProvider{Address: "https://vault.example.com:8200", Mount: "secret", Token: "t0k3n"})
+
+	p, _ = r.Route("ns/foo")
+	assert.DeepEqual(t, p, &secrets.VaultProvider{Address: "http://127.0.0.1:8200", Mount: "kv", Token: "other", Namespace: "team"})
+
+	p, key = r.Route("bk/foo")
+	assert.Equal(t, key, "foo")
+	assert.Equal(t, p, secrets.Provider(staticProvider("fallback")))
+}
+
+func TestParseRouterErrors(t *testing.T) {
+	t.Parallel()
+
+	getenv := func(string) string { return "" }
+	for _, spec := range []string{
+		"no-equals-sign",
+		"x/=file:///etc/secrets.yml",
+		"x/=command:",
+		"x/=ftp://example.com/",
+	} {
+		_, err := secrets.ParseRouter([]string{spec}, staticProvider("fallback"), getenv)
+		assert.Check(t, err != nil, "ParseRouter(%q) error = nil, want an error", spec)
+	}
+}
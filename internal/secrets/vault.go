@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// vaultDefaultField is the field read from a Vault secret when the key
+// doesn't name one.
+const vaultDefaultField = "value"
+
+// VaultProvider reads secrets from a HashiCorp Vault KV version 2 secrets
+// engine, over the Vault HTTP API.
+//
+// Keys have the form PATH or PATH#FIELD. PATH is the path of the secret within
+// the secrets engine, and FIELD is the field within the secret to return. If
+// FIELD is omitted, the "value" field is returned.
+type VaultProvider struct {
+	// Address of the Vault server, e.g. https://vault.example.com:8200
+	Address string
+
+	// Mount path of the secrets engine, e.g. "secret"
+	Mount string
+
+	// Token used to authenticate with Vault.
+	Token string
+
+	// Vault Enterprise namespace, if any.
+	Namespace string
+
+	// HTTP client to use. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// vaultKVResponse is the part of the response to a KV v2 read that we need.
+type vaultKVResponse struct {
+	Data struct {
+		Data map[string]any `json:"data"`
+	} `json:"data"`
+}
+
+// Get reads a secret from Vault.
+func (p *VaultProvider) Get(ctx context.Context, key string) (string, error) {
+	if p.Token == "" {
+		return "", errors.New("no vault token is available (is VAULT_TOKEN set?)")
+	}
+
+	secretPath, field, _ := strings.Cut(key, "#")
+	if field == "" {
+		field = vaultDefaultField
+	}
+
+	u, err := url.Parse(p.Address)
+	if err != nil {
+		return "", fmt.Errorf("invalid vault address %q: %w", p.Address, err)
+	}
+	u = u.JoinPath("v1", p.Mount, "data", secretPath)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("reading %q from vault: %w", secretPath, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("%q in vault: %w", secretPath, ErrNotFound)
+
+	case resp.StatusCode != http.StatusOK:
+		// Vault error responses look like {"errors":["..."]}
+		var verr struct {
+			Errors []string `json:"errors"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, &verr) == nil && len(verr.Errors) > 0 {
+			return "", fmt.Errorf("reading %q from vault: %s: %s", secretPath, resp.Status, strings.Join(verr.Errors, ", "))
+		}
+		return "", fmt.Errorf("reading %q from vault: %s", secretPath, resp.Status)
+	}
+
+	var kv vaultKVResponse
+	if err := json.NewDecoder(resp.Body).Decode(&kv); err != nil {
+		return "", fmt.Errorf("decoding vault response for %q: %w", secretPath, err)
+	}
+
+	v, ok := kv.Data.Data[field]
+	if !ok {
+		return "", fmt.Errorf("field %q of %q in vault: %w", field, secretPath, ErrNotFound)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+
+	// Not a string; return it as JSON.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
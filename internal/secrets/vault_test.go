@@ -0,0 +1,75 @@
+package secrets_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/v3/internal/secrets"
+	"gotest.tools/v3/assert"
+)
+
+// fakeVault is a minimal stand-in for the KV v2 read API of a Vault server.
+func fakeVault(t *testing.T, token string, kv map[string]map[string]any) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		path, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/data/")
+		if r.Method != http.MethodGet || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data, ok := kv[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"data":     data,
+				"metadata": map[string]any{"version": 1},
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultProvider(t *testing.T) {
+	t.Parallel()
+
+	srv := fakeVault(t, "t0k3n", map[string]map[string]any{
+		"prod/db": {"value": "hunter2", "username": "admin", "port": 5432},
+	})
+
+	p := &secrets.VaultProvider{Address: srv.URL, Mount: "secret", Token: "t0k3n"}
+	ctx := context.Background()
+
+	for key, want := range map[string]string{
+		"prod/db":          "hunter2",
+		"prod/db#username": "admin",
+		"prod/db#port":     "5432",
+	} {
+		got, err := p.Get(ctx, key)
+		assert.NilError(t, err)
+		assert.Equal(t, got, want, "key = %q", key)
+	}
+
+	for _, key := range []string{"prod/nope", "prod/db#nope"} {
+		_, err := p.Get(ctx, key)
+		assert.Check(t, errors.Is(err, secrets.ErrNotFound), "p.Get(%q) error = %v, want ErrNotFound", key, err)
+	}
+
+	bad := &secrets.VaultProvider{Address: srv.URL, Mount: "secret", Token: "wrong"}
+	_, err := bad.Get(ctx, "prod/db")
+	assert.ErrorContains(t, err, "permission denied")
+}
@@ -28,7 +28,8 @@ func (e APIErr) Error() string {
 	return fmt.Sprintf("API status %d: %s", e.StatusCode, e.Msg)
 }
 
-// Client is a client for a HTTP-over-Unix Domain Socket API.
+// Client is a client for a HTTP-over-Unix Domain Socket API (or the same API
+// served over TCP).
 type Client struct {
 	cli   *http.Client
 	token string
@@ -50,10 +51,21 @@ func NewClient(ctx context.Context, path, token string) (*Client, error) {
 		}
 	}
 
+	return newClient(ctx, "unix", path, token)
+}
+
+// NewTCPClient creates a new Client that makes requests to the same kind of
+// API, but over TCP to the given address (host:port). The context is used for
+// an internal check that the address can be dialled.
+func NewTCPClient(ctx context.Context, address, token string) (*Client, error) {
+	return newClient(ctx, "tcp", address, token)
+}
+
+func newClient(ctx context.Context, network, address, token string) (*Client, error) {
 	dialer := net.Dialer{}
 
 	// Try to connect to the socket.
-	test, err := dialer.DialContext(ctx, "unix", path)
+	test, err := dialer.DialContext(ctx, network, address)
 	if err != nil {
 		return nil, fmt.Errorf("socket test connection: %w", err)
 	}
@@ -64,7 +76,7 @@ func NewClient(ctx context.Context, path, token string) (*Client, error) {
 			Transport: &http.Transport{
 				// Ignore arguments, dial socket
 				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
-					return dialer.DialContext(ctx, "unix", path)
+					return dialer.DialContext(ctx, network, address)
 				},
 			},
 		},
@@ -77,18 +89,50 @@ func NewClient(ctx context.Context, path, token string) (*Client, error) {
 // added in the Authorization header. The response is deserialised, either into
 // the object passed into resp if the status is 200 OK, otherwise into an error.
 func (c *Client) Do(ctx context.Context, method, url string, req, resp any) error {
+	hresp, err := c.do(ctx, method, url, req, nil)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+
+	if resp == nil {
+		return nil
+	}
+	if err := json.NewDecoder(hresp.Body).Decode(resp); err != nil {
+		return fmt.Errorf("decoding response: %w:", err)
+	}
+	return nil
+}
+
+// Stream is like Do, but instead of deserialising a successful response, it
+// returns the response body for the caller to read incrementally. Any extra
+// request headers can be passed in header. The caller must close the body.
+func (c *Client) Stream(ctx context.Context, method, url string, header http.Header) (io.ReadCloser, error) {
+	hresp, err := c.do(ctx, method, url, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	return hresp.Body, nil
+}
+
+// do makes the request, and turns error responses into errors. If the error
+// is nil, the caller must close the response body.
+func (c *Client) do(ctx context.Context, method, url string, req any, header http.Header) (*http.Response, error) {
 	var body io.Reader
 	if req != nil {
 		buf, err := json.Marshal(req)
 		if err != nil {
-			return fmt.Errorf("marshalling request: %w", err)
+			return nil, fmt.Errorf("marshalling request: %w", err)
 		}
 		body = bytes.NewReader(buf)
 	}
 
 	hreq, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
-		return fmt.Errorf("creating a request: %w", err)
+		return nil, fmt.Errorf("creating a request: %w", err)
+	}
+	for k, v := range header {
+		hreq.Header[k] = v
 	}
 	if c.token != "" {
 		hreq.Header.Set("Authorization", "Bearer "+c.token)
@@ -96,28 +140,21 @@ func (c *Client) Do(ctx context.Context, method, url string, req, resp any) erro
 
 	hresp, err := c.cli.Do(hreq)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer hresp.Body.Close()
-	dec := json.NewDecoder(hresp.Body)
 
 	switch hresp.StatusCode / 100 {
 	case 4, 5:
+		defer hresp.Body.Close()
 		var er ErrorResponse
-		if err := dec.Decode(&er); err != nil {
-			return fmt.Errorf("decoding error response: %w", err)
+		if err := json.NewDecoder(hresp.Body).Decode(&er); err != nil {
+			return nil, fmt.Errorf("decoding error response: %w", err)
 		}
-		return APIErr{
+		return nil, APIErr{
 			Msg:        er.Error,
 			StatusCode: hresp.StatusCode,
 		}
 	}
 
-	if resp == nil {
-		return nil
-	}
-	if err := dec.Decode(resp); err != nil {
-		return fmt.Errorf("decoding response: %w:", err)
-	}
-	return nil
+	return hresp, nil
 }
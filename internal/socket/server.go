@@ -20,8 +20,8 @@ type Server struct {
 // NewServer creates a server that, when started, will listen on a socket at the
 // given path.
 func NewServer(socketPath string, handler http.Handler) (*Server, error) {
-	if len(socketPath) >= socketPathLength() {
-		return nil, fmt.Errorf("socket path %s is too long (path length: %d, max %d characters). This is a limitation of your host OS", socketPath, len(socketPath), socketPathLength())
+	if err := CheckPathLength(socketPath); err != nil {
+		return nil, err
 	}
 
 	if err := os.MkdirAll(filepath.Dir(socketPath), os.FileMode(0700)); err != nil {
@@ -44,6 +44,15 @@ func socketPathLength() int {
 	}
 }
 
+// CheckPathLength returns an error if a socket can't be created at path on
+// this OS because the path is too long.
+func CheckPathLength(path string) error {
+	if len(path) >= socketPathLength() {
+		return fmt.Errorf("socket path %s is too long (path length: %d, max %d characters). This is a limitation of your host OS", path, len(path), socketPathLength())
+	}
+	return nil
+}
+
 // GenerateToken generates a new random token that contains approximately
 // 8*len bits of entropy.
 func GenerateToken(len int) (string, error) {
@@ -0,0 +1,8 @@
+package system
+
+// DiskSpace describes the space on a filesystem, in bytes.
+type DiskSpace struct {
+	// Available is the space that unprivileged users can use
+	Available uint64
+	Total     uint64
+}
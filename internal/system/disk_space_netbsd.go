@@ -0,0 +1,17 @@
+//go:build netbsd
+
+package system
+
+import "golang.org/x/sys/unix"
+
+// GetDiskSpace returns the space on the filesystem containing path.
+func GetDiskSpace(path string) (DiskSpace, error) {
+	var st unix.Statvfs_t
+	if err := unix.Statvfs(path, &st); err != nil {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{
+		Available: st.Bavail * uint64(st.Frsize),
+		Total:     st.Blocks * uint64(st.Frsize),
+	}, nil
+}
@@ -0,0 +1,17 @@
+//go:build openbsd
+
+package system
+
+import "golang.org/x/sys/unix"
+
+// GetDiskSpace returns the space on the filesystem containing path.
+func GetDiskSpace(path string) (DiskSpace, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{
+		Available: uint64(st.F_bavail) * uint64(st.F_bsize),
+		Total:     uint64(st.F_blocks) * uint64(st.F_bsize),
+	}, nil
+}
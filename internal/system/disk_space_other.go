@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || dragonfly || openbsd || netbsd || windows)
+
+package system
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// GetDiskSpace isn't supported on this platform, so it always returns an error
+// wrapping errors.ErrUnsupported.
+func GetDiskSpace(path string) (DiskSpace, error) {
+	return DiskSpace{}, fmt.Errorf("getting the disk space on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package system
+
+import "golang.org/x/sys/unix"
+
+// GetDiskSpace returns the space on the filesystem containing path.
+func GetDiskSpace(path string) (DiskSpace, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{
+		Available: uint64(st.Bavail) * uint64(st.Bsize),
+		Total:     uint64(st.Blocks) * uint64(st.Bsize),
+	}, nil
+}
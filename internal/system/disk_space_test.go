@@ -0,0 +1,16 @@
+package system
+
+import "testing"
+
+func TestGetDiskSpace(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	space, err := GetDiskSpace(dir)
+	if err != nil {
+		t.Fatalf("GetDiskSpace(%q) error = %v", dir, err)
+	}
+	if space.Total == 0 || space.Available > space.Total {
+		t.Errorf("GetDiskSpace(%q) = %+v, want a non-zero Total that's at least Available", dir, space)
+	}
+}
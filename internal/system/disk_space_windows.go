@@ -0,0 +1,18 @@
+//go:build windows
+
+package system
+
+import "golang.org/x/sys/windows"
+
+// GetDiskSpace returns the space on the volume containing path.
+func GetDiskSpace(path string) (DiskSpace, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return DiskSpace{}, err
+	}
+	var available, total uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &available, &total, nil); err != nil {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{Available: available, Total: total}, nil
+}
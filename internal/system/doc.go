@@ -1,2 +1,4 @@
-// Package system provides a way to log OS-specific platform information.
+// Package system provides a way to log OS-specific platform information, and
+// to get the disk space, load average and available memory of the host, and
+// the identity of processes.
 package system
@@ -0,0 +1,32 @@
+//go:build darwin || freebsd || dragonfly || openbsd || netbsd
+
+package system
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// GetLoadAverage returns the system's load average over the last minute.
+func GetLoadAverage() (float64, error) {
+	// vm.loadavg is a struct loadavg { fixpt_t ldavg[3]; long fscale; }, where
+	// fixpt_t is 32 bits, and long is 32 or 64 bits (aligned to its size).
+	b, err := unix.SysctlRaw("vm.loadavg")
+	if err != nil {
+		return 0, err
+	}
+
+	var scale uint64
+	switch {
+	case len(b) >= 24:
+		scale = binary.NativeEndian.Uint64(b[16:24])
+	case len(b) >= 16:
+		scale = uint64(binary.NativeEndian.Uint32(b[12:16]))
+	}
+	if scale == 0 {
+		return 0, fmt.Errorf("unexpected vm.loadavg value %x", b)
+	}
+	return float64(binary.NativeEndian.Uint32(b[0:4])) / float64(scale), nil
+}
@@ -0,0 +1,13 @@
+package system
+
+import "golang.org/x/sys/unix"
+
+// GetLoadAverage returns the system's load average over the last minute.
+func GetLoadAverage() (float64, error) {
+	var info unix.Sysinfo_t
+	if err := unix.Sysinfo(&info); err != nil {
+		return 0, err
+	}
+	// The load averages are fixed-point numbers, with 16 bits of fraction
+	return float64(info.Loads[0]) / (1 << 16), nil
+}
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || dragonfly || openbsd || netbsd)
+
+package system
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// GetLoadAverage isn't supported on this platform (Windows has no load
+// average), so it always returns an error wrapping errors.ErrUnsupported.
+func GetLoadAverage() (float64, error) {
+	return 0, fmt.Errorf("getting the load average on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
@@ -0,0 +1,21 @@
+package system
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetLoadAverage(t *testing.T) {
+	t.Parallel()
+
+	load, err := GetLoadAverage()
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("GetLoadAverage() error = %v", err)
+	}
+	if err != nil {
+		t.Fatalf("GetLoadAverage() error = %v", err)
+	}
+	if load < 0 {
+		t.Errorf("GetLoadAverage() = %v, want it to be at least 0", load)
+	}
+}
@@ -0,0 +1,33 @@
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// GetAvailableMemory returns how much memory is available for starting new
+// processes without swapping, in bytes.
+func GetAvailableMemory() (uint64, error) {
+	meminfo, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+
+	// Lines look like "MemAvailable:   12345678 kB"
+	scanner := bufio.NewScanner(bytes.NewReader(meminfo))
+	for scanner.Scan() {
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) != 3 || string(fields[0]) != "MemAvailable:" || string(fields[2]) != "kB" {
+			continue
+		}
+		kb, err := strconv.ParseUint(string(fields[1]), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parsing MemAvailable in /proc/meminfo: %w", err)
+		}
+		return kb * 1024, nil
+	}
+	return 0, fmt.Errorf("no MemAvailable in /proc/meminfo")
+}
@@ -0,0 +1,15 @@
+//go:build !(linux || windows)
+
+package system
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// GetAvailableMemory isn't supported on this platform, so it always returns an
+// error wrapping errors.ErrUnsupported.
+func GetAvailableMemory() (uint64, error) {
+	return 0, fmt.Errorf("getting the available memory on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
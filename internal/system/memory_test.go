@@ -0,0 +1,21 @@
+package system
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetAvailableMemory(t *testing.T) {
+	t.Parallel()
+
+	available, err := GetAvailableMemory()
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("GetAvailableMemory() error = %v", err)
+	}
+	if err != nil {
+		t.Fatalf("GetAvailableMemory() error = %v", err)
+	}
+	if available == 0 {
+		t.Errorf("GetAvailableMemory() = 0, want some memory to be available")
+	}
+}
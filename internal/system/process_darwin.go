@@ -0,0 +1,31 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// GetProcessStartTime returns when the process with the given pid started, in
+// microseconds since the Unix epoch. Together with the pid, it identifies a
+// process even if the pid is later reused.
+func GetProcessStartTime(pid int) (uint64, error) {
+	kp, err := unix.SysctlKinfoProc("kern.proc.pid", pid)
+	if err != nil {
+		return 0, err
+	}
+	if kp.Proc.P_pid != int32(pid) {
+		// There's no such process
+		return 0, fmt.Errorf("process %d: %w", pid, unix.ESRCH)
+	}
+	start := kp.Proc.P_starttime
+	return uint64(start.Sec)*1e6 + uint64(start.Usec), nil
+}
+
+// GetPIDNamespace isn't supported on this platform (which has no PID
+// namespaces), so it always returns an error wrapping errors.ErrUnsupported.
+func GetPIDNamespace() (uint64, error) {
+	return 0, fmt.Errorf("getting the PID namespace on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
@@ -0,0 +1,44 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// GetProcessStartTime returns when the process with the given pid started, in
+// clock ticks since the system booted. Together with the pid, it identifies a
+// process even if the pid is later reused.
+func GetProcessStartTime(pid int) (uint64, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name (field 2) is in parentheses and can contain spaces,
+	// so the fields are counted from after it. The start time is field 22.
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// GetPIDNamespace returns an identifier for the PID namespace of the current
+// process. Pids are only meaningful within the same namespace.
+func GetPIDNamespace() (uint64, error) {
+	info, err := os.Stat("/proc/self/ns/pid")
+	if err != nil {
+		return 0, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("unexpected stat result for /proc/self/ns/pid")
+	}
+	return st.Ino, nil
+}
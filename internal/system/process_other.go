@@ -0,0 +1,21 @@
+//go:build !(linux || darwin || windows)
+
+package system
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// GetProcessStartTime isn't supported on this platform, so it always returns
+// an error wrapping errors.ErrUnsupported.
+func GetProcessStartTime(pid int) (uint64, error) {
+	return 0, fmt.Errorf("getting the start time of a process on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
+
+// GetPIDNamespace isn't supported on this platform, so it always returns an
+// error wrapping errors.ErrUnsupported.
+func GetPIDNamespace() (uint64, error) {
+	return 0, fmt.Errorf("getting the PID namespace on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
@@ -0,0 +1,32 @@
+package system
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestGetProcessStartTime(t *testing.T) {
+	t.Parallel()
+
+	pid := os.Getpid()
+	start, err := GetProcessStartTime(pid)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("GetProcessStartTime(%d) error = %v", pid, err)
+	}
+	if err != nil {
+		t.Fatalf("GetProcessStartTime(%d) error = %v", pid, err)
+	}
+	if start == 0 {
+		t.Errorf("GetProcessStartTime(%d) = 0, want a start time", pid)
+	}
+
+	again, err := GetProcessStartTime(pid)
+	if err != nil || again != start {
+		t.Errorf("GetProcessStartTime(%d) = %d, %v the second time, want %d, nil", pid, again, err, start)
+	}
+
+	if _, err := GetProcessStartTime(os.Getppid()); err != nil {
+		t.Errorf("GetProcessStartTime(%d) error = %v", os.Getppid(), err)
+	}
+}
@@ -0,0 +1,32 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/windows"
+)
+
+// GetProcessStartTime returns when the process with the given pid was
+// created, in 100-nanosecond intervals since January 1, 1601. Together with
+// the pid, it identifies a process even if the pid is later reused.
+func GetProcessStartTime(pid int) (uint64, error) {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(h)
+
+	var creation, exit, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(h, &creation, &exit, &kernel, &user); err != nil {
+		return 0, err
+	}
+	return uint64(creation.HighDateTime)<<32 | uint64(creation.LowDateTime), nil
+}
+
+// GetPIDNamespace isn't supported on this platform (which has no PID
+// namespaces), so it always returns an error wrapping errors.ErrUnsupported.
+func GetPIDNamespace() (uint64, error) {
+	return 0, fmt.Errorf("getting the PID namespace on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
@@ -0,0 +1,85 @@
+package jobapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/buildkite/agent/v3/internal/socket"
+)
+
+// TokenScope is the set of requests a Job API token permits.
+type TokenScope string
+
+const (
+	// ScopeRead permits requests that inspect the job, but don't change it:
+	// reading the environment (except for the mutating token and the agent
+	// access token), streaming events, reading meta-data, and checking on
+	// pipeline uploads.
+	ScopeRead TokenScope = "read"
+
+	// ScopeMutate permits all requests.
+	ScopeMutate TokenScope = "mutate"
+)
+
+type scopeContextKey struct{}
+
+// authenticate checks the bearer token of each request against the server's
+// tokens, and records the token's scope in the request context.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authType, reqToken, found := strings.Cut(r.Header.Get("Authorization"), " ")
+		var msg string
+		switch {
+		case r.Header.Get("Authorization") == "":
+			msg = "authorization header is required"
+		case !found:
+			msg = "invalid authorization header: must be in the form `Bearer <token>`"
+		case authType != "Bearer":
+			msg = "invalid authorization header: type must be Bearer"
+		}
+
+		scope := s.tokenScope(reqToken)
+		if msg == "" && scope == "" {
+			msg = "invalid authorization token"
+		}
+		if msg != "" {
+			if err := socket.WriteError(w, msg, http.StatusUnauthorized); err != nil {
+				s.Logger.Errorf("Job API: couldn't write error: %v", err)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scopeContextKey{}, scope)))
+	})
+}
+
+// tokenScope returns the scope of the token, or "" if it is not one of the
+// server's tokens.
+func (s *Server) tokenScope(token string) TokenScope {
+	switch {
+	case token == "":
+		return ""
+	case subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1:
+		return ScopeMutate
+	case subtle.ConstantTimeCompare([]byte(token), []byte(s.readToken)) == 1:
+		return ScopeRead
+	default:
+		return ""
+	}
+}
+
+// requireMutate responds with an error to requests authenticated with a
+// read-only token.
+func (s *Server) requireMutate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(scopeContextKey{}) != ScopeMutate {
+			if err := socket.WriteError(w, "this token is read-only, and can't be used to change the job", http.StatusForbidden); err != nil {
+				s.Logger.Errorf("Job API: couldn't write error: %v", err)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,150 @@
+package jobapi_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/job/shell"
+	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/buildkite/agent/v3/jobapi"
+)
+
+func TestTCPListenerWithScopedTokens(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sock, err := jobapi.NewSocketPath(os.TempDir())
+	if err != nil {
+		t.Fatalf("jobapi.NewSocketPath(%q) error = %v", os.TempDir(), err)
+	}
+	environ := testEnviron()
+	srv, token, err := jobapi.NewServer(shell.TestingLogger{T: t}, sock, environ, replacer.NewMux(), jobapi.WithTCPListener("127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("jobapi.NewServer(..., WithTCPListener(127.0.0.1:0)) error = %v", err)
+	}
+	// As the executor does.
+	environ.Set("BUILDKITE_AGENT_JOB_API_TOKEN", token)
+	environ.Set("BUILDKITE_AGENT_JOB_API_READ_TOKEN", srv.ReadOnlyToken())
+	environ.Set("BUILDKITE_AGENT_ACCESS_TOKEN", "llamas")
+	environ.Set("BUILDKITE_SECRETS_ENV", "DB_PASSWORD=db/password, API_KEY=api")
+	environ.Set("DB_PASSWORD", "hunter2")
+	environ.Set("API_KEY", "alpacas")
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+
+	readCli, err := jobapi.NewURLClient(ctx, srv.URL(), srv.ReadOnlyToken())
+	if err != nil {
+		t.Fatalf("jobapi.NewURLClient(ctx, %q, readToken) error = %v", srv.URL(), err)
+	}
+	readEnv, err := readCli.EnvGet(ctx)
+	if err != nil {
+		t.Errorf("readCli.EnvGet(ctx) error = %v", err)
+	}
+	// The read-only token can't be used to get a more powerful token, or the
+	// values of secrets.
+	for _, name := range []string{"BUILDKITE_AGENT_JOB_API_TOKEN", "BUILDKITE_AGENT_ACCESS_TOKEN", "DB_PASSWORD", "API_KEY"} {
+		if v, ok := readEnv[name]; ok {
+			t.Errorf("readCli.EnvGet(ctx)[%q] = %q, want it missing", name, v)
+		}
+	}
+	if got, want := readEnv["MOUNTAIN"], "cotopaxi"; got != want {
+		t.Errorf("readCli.EnvGet(ctx)[MOUNTAIN] = %q, want %q", got, want)
+	}
+	_, err = readCli.EnvUpdate(ctx, &jobapi.EnvUpdateRequest{Env: map[string]string{"MOUNTAIN": "chimborazo"}})
+	var apiErr socket.APIErr
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("readCli.EnvUpdate(ctx, req) error = %v, want status %d", err, http.StatusForbidden)
+	}
+
+	cli, err := jobapi.NewURLClient(ctx, srv.URL(), token)
+	if err != nil {
+		t.Fatalf("jobapi.NewURLClient(ctx, %q, token) error = %v", srv.URL(), err)
+	}
+	if _, err := cli.EnvUpdate(ctx, &jobapi.EnvUpdateRequest{Env: map[string]string{"MOUNTAIN": "chimborazo"}}); err != nil {
+		t.Errorf("cli.EnvUpdate(ctx, req) error = %v", err)
+	}
+	if mutateEnv, err := cli.EnvGet(ctx); err != nil || mutateEnv["BUILDKITE_AGENT_JOB_API_TOKEN"] != token {
+		t.Errorf("cli.EnvGet(ctx) = %v, %v, want BUILDKITE_AGENT_JOB_API_TOKEN to be the token", mutateEnv, err)
+	} else if mutateEnv["DB_PASSWORD"] != "hunter2" {
+		t.Errorf("cli.EnvGet(ctx)[DB_PASSWORD] = %q, want %q", mutateEnv["DB_PASSWORD"], "hunter2")
+	}
+
+	badCli, err := jobapi.NewURLClient(ctx, srv.URL(), "not-a-token")
+	if err != nil {
+		t.Fatalf("jobapi.NewURLClient(ctx, %q, not-a-token) error = %v", srv.URL(), err)
+	}
+	_, err = badCli.EnvGet(ctx)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("badCli.EnvGet(ctx) error = %v, want status %d", err, http.StatusUnauthorized)
+	}
+
+	// The listener shuts down with the server.
+	url := srv.URL()
+	if err := srv.Stop(); err != nil {
+		t.Fatalf("srv.Stop() = %v", err)
+	}
+	if _, err := jobapi.NewURLClient(ctx, url, token); err == nil {
+		t.Errorf("jobapi.NewURLClient(ctx, %q, token) after srv.Stop() error = nil, want an error", url)
+	}
+}
+
+func TestTCPListenerRejectsUnspecifiedAddress(t *testing.T) {
+	t.Parallel()
+
+	for _, addr := range []string{":0", "0.0.0.0:0", "[::]:0", "127.0.0.1"} {
+		sock, err := jobapi.NewSocketPath(os.TempDir())
+		if err != nil {
+			t.Fatalf("jobapi.NewSocketPath(%q) error = %v", os.TempDir(), err)
+		}
+		if _, _, err := jobapi.NewServer(shell.TestingLogger{T: t}, sock, testEnviron(), replacer.NewMux(), jobapi.WithTCPListener(addr)); err == nil {
+			t.Errorf("jobapi.NewServer(..., WithTCPListener(%q)) error = nil, want an error", addr)
+		}
+	}
+}
+
+func TestNewDefaultClientFallsBackToURL(t *testing.T) {
+	// t.Parallel() // Can't be parallelised, because it uses the t.Setenv() function
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sock, err := jobapi.NewSocketPath(os.TempDir())
+	if err != nil {
+		t.Fatalf("jobapi.NewSocketPath(%q) error = %v", os.TempDir(), err)
+	}
+	srv, _, err := jobapi.NewServer(shell.TestingLogger{T: t}, sock, testEnviron(), replacer.NewMux(), jobapi.WithTCPListener("127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("jobapi.NewServer(..., WithTCPListener(127.0.0.1:0)) error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	// As it might be inside a container: the socket isn't mounted, and only
+	// the read-only token was passed in.
+	t.Setenv("BUILDKITE_AGENT_JOB_API_SOCKET", "/nonexistent/job-api.sock")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_URL", srv.URL())
+	t.Setenv("BUILDKITE_AGENT_JOB_API_TOKEN", "")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_READ_TOKEN", srv.ReadOnlyToken())
+
+	cli, err := jobapi.NewDefaultClient(ctx)
+	if err != nil {
+		t.Fatalf("jobapi.NewDefaultClient(ctx) error = %v", err)
+	}
+	got, err := cli.EnvGet(ctx)
+	if err != nil {
+		t.Fatalf("cli.EnvGet(ctx) error = %v", err)
+	}
+	if got["MOUNTAIN"] != "cotopaxi" {
+		t.Errorf("cli.EnvGet(ctx)[MOUNTAIN] = %q, want %q", got["MOUNTAIN"], "cotopaxi")
+	}
+}
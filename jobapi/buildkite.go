@@ -0,0 +1,330 @@
+package jobapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/go-chi/chi/v5"
+)
+
+// BuildkiteAPI is the subset of the Buildkite Agent API that the Job API
+// proxies on behalf of the job, so that commands such as annotate, meta-data,
+// artifact and step commands don't need the agent access token.
+// *api.Client implements BuildkiteAPI.
+type BuildkiteAPI interface {
+	Annotate(ctx context.Context, jobID string, annotation *api.Annotation) (*api.Response, error)
+	AnnotationRemove(ctx context.Context, jobID, context string) (*api.Response, error)
+	SetMetaData(ctx context.Context, jobID string, metaData *api.MetaData) (*api.Response, error)
+	GetMetaData(ctx context.Context, scope, id, key string) (*api.MetaData, *api.Response, error)
+	ExistsMetaData(ctx context.Context, scope, id, key string) (*api.MetaDataExists, *api.Response, error)
+	MetaDataKeys(ctx context.Context, scope, id string) ([]string, *api.Response, error)
+	CreateArtifacts(ctx context.Context, jobID string, batch *api.ArtifactBatch) (*api.ArtifactBatchCreateResponse, *api.Response, error)
+	UpdateArtifacts(ctx context.Context, jobID string, artifactStates map[string]string) (*api.Response, error)
+	SearchArtifacts(ctx context.Context, buildID string, opt *api.ArtifactSearchOptions) ([]*api.Artifact, *api.Response, error)
+	UploadPipeline(ctx context.Context, jobID string, pipeline *api.PipelineChange, headers ...api.Header) (*api.Response, error)
+	PipelineUploadStatus(ctx context.Context, jobID, uuid string, headers ...api.Header) (*api.PipelineUploadStatus, *api.Response, error)
+	StepExport(ctx context.Context, stepIDOrKey string, req *api.StepExportRequest) (*api.StepExportResponse, *api.Response, error)
+	StepUpdate(ctx context.Context, stepIDOrKey string, update *api.StepUpdate) (*api.Response, error)
+	OIDCToken(ctx context.Context, req *api.OIDCTokenRequest) (*api.OIDCToken, *api.Response, error)
+	GetSecret(ctx context.Context, req *api.GetSecretRequest) (*api.Secret, *api.Response, error)
+	GenerateGithubCodeAccessToken(ctx context.Context, repoURL, jobID string) (string, *api.Response, error)
+}
+
+// WithBuildkiteAPI enables the /buildkite routes, which proxy requests to the
+// Buildkite Agent API using client.
+func WithBuildkiteAPI(client BuildkiteAPI) ServerOpts {
+	return func(s *Server) {
+		s.buildkite = client
+	}
+}
+
+// Headers from Buildkite responses that are passed back to the job.
+var proxiedResponseHeaders = []string{"Location", "Retry-After"}
+
+// Headers from job requests that are passed on to Buildkite.
+var proxiedRequestHeaders = []string{"X-Buildkite-Backoff-Sequence"}
+
+// buildkiteRoutes defines the /buildkite routes. The paths mirror those of the
+// Buildkite Agent API, so that an api.Client configured with
+// APIClientConfig can be used in place of one talking to Buildkite directly.
+// Requests are made with the agent access token, so they are subject to the
+// same permissions as if the job had used the token itself. Requests that
+// change the build, or that return credentials (OIDC tokens, secrets and
+// GitHub tokens), need the mutating token.
+func (s *Server) buildkiteRoutes(r chi.Router) {
+	r.Use(s.requireBuildkiteAPI)
+	r.With(s.requireMutate).Post("/jobs/{id}/annotations", s.proxyAnnotate)
+	r.With(s.requireMutate).Delete("/jobs/{id}/annotations/{context}", s.proxyAnnotationRemove)
+	r.With(s.requireMutate).Post("/jobs/{id}/data/set", s.proxySetMetaData)
+	r.Post("/{scope:jobs|builds}/{id}/data/get", s.proxyGetMetaData)
+	r.Post("/{scope:jobs|builds}/{id}/data/exists", s.proxyExistsMetaData)
+	r.Post("/{scope:jobs|builds}/{id}/data/keys", s.proxyMetaDataKeys)
+	r.With(s.requireMutate).Post("/jobs/{id}/artifacts", s.proxyCreateArtifacts)
+	r.With(s.requireMutate).Put("/jobs/{id}/artifacts", s.proxyUpdateArtifacts)
+	r.Get("/builds/{id}/artifacts/search", s.proxySearchArtifacts)
+	r.With(s.requireMutate).Post("/jobs/{id}/pipelines", s.proxyUploadPipeline)
+	r.Get("/jobs/{id}/pipelines/{uuid}", s.proxyPipelineUploadStatus)
+	r.Post("/steps/{id}/export", s.proxyStepExport)
+	r.With(s.requireMutate).Put("/steps/{id}", s.proxyStepUpdate)
+	r.With(s.requireMutate).Post("/jobs/{id}/oidc/tokens", s.proxyOIDCToken)
+	r.With(s.requireMutate).Get("/jobs/{id}/secrets", s.proxyGetSecret)
+	r.With(s.requireMutate).Post("/jobs/{id}/github_code_access_token", s.proxyGithubCodeAccessToken)
+}
+
+// requireBuildkiteAPI responds with an error if the server has no
+// BuildkiteAPI client.
+func (s *Server) requireBuildkiteAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.buildkite == nil {
+			s.writeProxyError(w, http.StatusNotImplemented, "this Job API does not proxy requests to Buildkite")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) proxyAnnotate(w http.ResponseWriter, r *http.Request) {
+	var req api.Annotation
+	if !s.decodeProxyRequest(w, r, &req) {
+		return
+	}
+	resp, err := s.buildkite.Annotate(r.Context(), pathParam(r, "id"), &req)
+	s.writeProxyResponse(w, resp, err, nil)
+}
+
+func (s *Server) proxyAnnotationRemove(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.buildkite.AnnotationRemove(r.Context(), pathParam(r, "id"), pathParam(r, "context"))
+	s.writeProxyResponse(w, resp, err, nil)
+}
+
+func (s *Server) proxySetMetaData(w http.ResponseWriter, r *http.Request) {
+	var req api.MetaData
+	if !s.decodeProxyRequest(w, r, &req) {
+		return
+	}
+	resp, err := s.buildkite.SetMetaData(r.Context(), pathParam(r, "id"), &req)
+	s.writeProxyResponse(w, resp, err, nil)
+}
+
+func (s *Server) proxyGetMetaData(w http.ResponseWriter, r *http.Request) {
+	var req api.MetaData
+	if !s.decodeProxyRequest(w, r, &req) {
+		return
+	}
+	md, resp, err := s.buildkite.GetMetaData(r.Context(), metaDataScope(r), pathParam(r, "id"), req.Key)
+	s.writeProxyResponse(w, resp, err, md)
+}
+
+func (s *Server) proxyExistsMetaData(w http.ResponseWriter, r *http.Request) {
+	var req api.MetaData
+	if !s.decodeProxyRequest(w, r, &req) {
+		return
+	}
+	exists, resp, err := s.buildkite.ExistsMetaData(r.Context(), metaDataScope(r), pathParam(r, "id"), req.Key)
+	s.writeProxyResponse(w, resp, err, exists)
+}
+
+func (s *Server) proxyMetaDataKeys(w http.ResponseWriter, r *http.Request) {
+	keys, resp, err := s.buildkite.MetaDataKeys(r.Context(), metaDataScope(r), pathParam(r, "id"))
+	s.writeProxyResponse(w, resp, err, keys)
+}
+
+func (s *Server) proxyCreateArtifacts(w http.ResponseWriter, r *http.Request) {
+	var req api.ArtifactBatch
+	if !s.decodeProxyRequest(w, r, &req) {
+		return
+	}
+	created, resp, err := s.buildkite.CreateArtifacts(r.Context(), pathParam(r, "id"), &req)
+	s.writeProxyResponse(w, resp, err, created)
+}
+
+func (s *Server) proxyUpdateArtifacts(w http.ResponseWriter, r *http.Request) {
+	var req api.ArtifactBatchUpdateRequest
+	if !s.decodeProxyRequest(w, r, &req) {
+		return
+	}
+	states := make(map[string]string, len(req.Artifacts))
+	for _, a := range req.Artifacts {
+		states[a.ID] = a.State
+	}
+	resp, err := s.buildkite.UpdateArtifacts(r.Context(), pathParam(r, "id"), states)
+	s.writeProxyResponse(w, resp, err, nil)
+}
+
+func (s *Server) proxySearchArtifacts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opt := &api.ArtifactSearchOptions{
+		Query:              q.Get("query"),
+		Scope:              q.Get("scope"),
+		State:              q.Get("state"),
+		IncludeRetriedJobs: q.Get("include_retried_jobs") == "true",
+		IncludeDuplicates:  q.Get("include_duplicates") == "true",
+	}
+	artifacts, resp, err := s.buildkite.SearchArtifacts(r.Context(), pathParam(r, "id"), opt)
+	s.writeProxyResponse(w, resp, err, artifacts)
+}
+
+func (s *Server) proxyUploadPipeline(w http.ResponseWriter, r *http.Request) {
+	var req api.PipelineChange
+	if !s.decodeProxyRequest(w, r, &req) {
+		return
+	}
+	resp, err := s.buildkite.UploadPipeline(r.Context(), pathParam(r, "id"), &req, requestHeaders(r)...)
+	s.writeProxyResponse(w, resp, err, nil)
+}
+
+func (s *Server) proxyPipelineUploadStatus(w http.ResponseWriter, r *http.Request) {
+	status, resp, err := s.buildkite.PipelineUploadStatus(r.Context(), pathParam(r, "id"), pathParam(r, "uuid"), requestHeaders(r)...)
+	s.writeProxyResponse(w, resp, err, status)
+}
+
+func (s *Server) proxyStepExport(w http.ResponseWriter, r *http.Request) {
+	var req api.StepExportRequest
+	if !s.decodeProxyRequest(w, r, &req) {
+		return
+	}
+	out, resp, err := s.buildkite.StepExport(r.Context(), pathParam(r, "id"), &req)
+	s.writeProxyResponse(w, resp, err, out)
+}
+
+func (s *Server) proxyStepUpdate(w http.ResponseWriter, r *http.Request) {
+	var req api.StepUpdate
+	if !s.decodeProxyRequest(w, r, &req) {
+		return
+	}
+	resp, err := s.buildkite.StepUpdate(r.Context(), pathParam(r, "id"), &req)
+	s.writeProxyResponse(w, resp, err, nil)
+}
+
+func (s *Server) proxyOIDCToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Audience string   `json:"audience,omitempty"`
+		Lifetime int      `json:"lifetime,omitempty"`
+		Claims   []string `json:"claims,omitempty"`
+	}
+	if !s.decodeProxyRequest(w, r, &req) {
+		return
+	}
+	token, resp, err := s.buildkite.OIDCToken(r.Context(), &api.OIDCTokenRequest{
+		Job:      pathParam(r, "id"),
+		Audience: req.Audience,
+		Lifetime: req.Lifetime,
+		Claims:   req.Claims,
+	})
+	s.writeProxyResponse(w, resp, err, token)
+}
+
+func (s *Server) proxyGetSecret(w http.ResponseWriter, r *http.Request) {
+	secret, resp, err := s.buildkite.GetSecret(r.Context(), &api.GetSecretRequest{
+		Key:   r.URL.Query().Get("key"),
+		JobID: pathParam(r, "id"),
+	})
+	s.writeProxyResponse(w, resp, err, secret)
+}
+
+func (s *Server) proxyGithubCodeAccessToken(w http.ResponseWriter, r *http.Request) {
+	var req api.GithubCodeAccessTokenRequest
+	if !s.decodeProxyRequest(w, r, &req) {
+		return
+	}
+	token, resp, err := s.buildkite.GenerateGithubCodeAccessToken(r.Context(), req.RepoURL, pathParam(r, "id"))
+	var body any
+	if err == nil {
+		body = &api.GithubCodeAccessTokenResponse{Token: token}
+	}
+	s.writeProxyResponse(w, resp, err, body)
+}
+
+// metaDataScope returns the meta-data scope ("job" or "build") of a request.
+func metaDataScope(r *http.Request) string {
+	if chi.URLParam(r, "scope") == "builds" {
+		return "build"
+	}
+	return "job"
+}
+
+// pathParam returns the unescaped value of a URL parameter. (api.Client
+// escapes dots in IDs, which Rails would otherwise misinterpret.)
+func pathParam(r *http.Request, name string) string {
+	p := chi.URLParam(r, name)
+	if u, err := url.PathUnescape(p); err == nil {
+		return u
+	}
+	return p
+}
+
+// requestHeaders returns the headers of the request to pass on to Buildkite.
+func requestHeaders(r *http.Request) []api.Header {
+	var headers []api.Header
+	for _, name := range proxiedRequestHeaders {
+		if v := r.Header.Get(name); v != "" {
+			headers = append(headers, api.Header{Name: name, Value: v})
+		}
+	}
+	return headers
+}
+
+// decodeProxyRequest decodes the request body into v. If it fails, it writes
+// an error response and returns false.
+func (s *Server) decodeProxyRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		s.writeProxyError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeProxyResponse writes the result of a request to Buildkite as the
+// response to the job, preserving the status code and relevant headers, and
+// encoding body (if not nil) when the request succeeded.
+func (s *Server) writeProxyResponse(w http.ResponseWriter, resp *api.Response, err error, body any) {
+	if err != nil {
+		var errResp *api.ErrorResponse
+		if !errors.As(err, &errResp) || errResp.Response == nil {
+			// The request didn't get a response from Buildkite.
+			s.writeProxyError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		copyHeaders(w.Header(), errResp.Response.Header)
+		s.writeProxyError(w, errResp.Response.StatusCode, errResp.Message)
+		return
+	}
+
+	code := http.StatusOK
+	if resp != nil && resp.Response != nil {
+		copyHeaders(w.Header(), resp.Header)
+		code = resp.StatusCode
+	}
+	w.WriteHeader(code)
+	if body == nil {
+		body = struct{}{}
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.Logger.Errorf("Job API: couldn't encode response body: %v", err)
+	}
+}
+
+// writeProxyError writes an error response in the form used by the Buildkite
+// Agent API, so that the api.Client in the job can decode it.
+func (s *Server) writeProxyError(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+	}{msg}); err != nil {
+		s.Logger.Errorf("Job API: couldn't write error: %v", err)
+	}
+}
+
+// copyHeaders copies the proxied response headers from src to dst.
+func copyHeaders(dst, src http.Header) {
+	for _, name := range proxiedResponseHeaders {
+		if v := src.Get(name); v != "" {
+			dst.Set(name, v)
+		}
+	}
+}
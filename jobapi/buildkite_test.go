@@ -0,0 +1,256 @@
+package jobapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/internal/job/shell"
+	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/buildkite/agent/v3/jobapi"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeBuildkite is a stand-in for the parts of the Buildkite Agent API
+// proxied by the Job API.
+func fakeBuildkite(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	handle := func(pattern string, h func(w http.ResponseWriter, r *http.Request)) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if got, want := r.Header.Get("Authorization"), "Token llamas"; got != want {
+				t.Errorf("%s %s Authorization header = %q, want %q", r.Method, r.URL.Path, got, want)
+			}
+			h(w, r)
+		})
+	}
+	handle("POST /jobs/job-1/annotations", func(w http.ResponseWriter, r *http.Request) {
+		var a api.Annotation
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil || a.Body != "hello" {
+			t.Errorf("annotation = %+v, %v", a, err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	handle("POST /jobs/job-1/data/set", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	handle("POST /builds/build-1/data/get", func(w http.ResponseWriter, r *http.Request) {
+		var md api.MetaData
+		json.NewDecoder(r.Body).Decode(&md)
+		if md.Key != "llama" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "No key"})
+			return
+		}
+		json.NewEncoder(w).Encode(&api.MetaData{Key: "llama", Value: "alpaca"})
+	})
+	handle("POST /builds/build-1/data/exists", func(w http.ResponseWriter, r *http.Request) {
+		var md api.MetaData
+		json.NewDecoder(r.Body).Decode(&md)
+		json.NewEncoder(w).Encode(&api.MetaDataExists{Exists: md.Key == "llama"})
+	})
+	handle("GET /jobs/job-1/secrets", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&api.Secret{Key: r.URL.Query().Get("key"), Value: "hunter2"})
+	})
+	handle("POST /jobs/job-1/artifacts", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&api.ArtifactBatchCreateResponse{ID: "batch-1", ArtifactIDs: []string{"a1"}})
+	})
+	handle("PUT /jobs/job-1/artifacts", func(w http.ResponseWriter, r *http.Request) {
+		var req api.ArtifactBatchUpdateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Artifacts) != 1 || req.Artifacts[0].ID != "a1" || req.Artifacts[0].State != "finished" {
+			t.Errorf("artifact update = %+v", req.Artifacts)
+		}
+	})
+	handle("POST /jobs/job-1/pipelines", func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("X-Buildkite-Backoff-Sequence"), "3"; got != want {
+			t.Errorf("X-Buildkite-Backoff-Sequence = %q, want %q", got, want)
+		}
+		w.Header().Set("Location", "https://agent.example.com/v3/jobs/job-1/pipelines/upload-1")
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	handle("GET /jobs/job-1/pipelines/upload-1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&api.PipelineUploadStatus{State: "applied"})
+	})
+	svr := httptest.NewServer(mux)
+	t.Cleanup(svr.Close)
+	return svr
+}
+
+func TestBuildkiteProxy(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	bk := fakeBuildkite(t)
+	agentClient := api.NewClient(logger.Discard, api.Config{Endpoint: bk.URL, Token: "llamas"})
+
+	sockName, err := jobapi.NewSocketPath(t.TempDir())
+	if err != nil {
+		t.Fatalf("jobapi.NewSocketPath() error = %v", err)
+	}
+	srv, token, err := jobapi.NewServer(shell.TestingLogger{T: t}, sockName, testEnviron(), replacer.NewMux(), jobapi.WithBuildkiteAPI(agentClient))
+	if err != nil {
+		t.Fatalf("jobapi.NewServer() error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	// The job's client has the Job API token, not the agent access token.
+	client := api.NewClient(logger.Discard, jobapi.APIClientConfig(srv.SocketPath, token))
+
+	if _, err := client.Annotate(ctx, "job-1", &api.Annotation{Body: "hello"}); err != nil {
+		t.Errorf("client.Annotate() error = %v", err)
+	}
+	if _, err := client.SetMetaData(ctx, "job-1", &api.MetaData{Key: "llama", Value: "alpaca"}); err != nil {
+		t.Errorf("client.SetMetaData() error = %v", err)
+	}
+
+	md, _, err := client.GetMetaData(ctx, "build", "build-1", "llama")
+	if err != nil {
+		t.Errorf("client.GetMetaData(llama) error = %v", err)
+	} else if md.Value != "alpaca" {
+		t.Errorf("client.GetMetaData(llama).Value = %q, want %q", md.Value, "alpaca")
+	}
+	// Errors from Buildkite keep their status code.
+	if _, _, err := client.GetMetaData(ctx, "build", "build-1", "camel"); !api.IsErrHavingStatus(err, http.StatusNotFound) {
+		t.Errorf("client.GetMetaData(camel) error = %v, want status 404", err)
+	}
+
+	exists, _, err := client.ExistsMetaData(ctx, "build", "build-1", "llama")
+	if err != nil {
+		t.Errorf("client.ExistsMetaData(llama) error = %v", err)
+	} else if !exists.Exists {
+		t.Errorf("client.ExistsMetaData(llama).Exists = false, want true")
+	}
+
+	secret, _, err := client.GetSecret(ctx, &api.GetSecretRequest{Key: "password", JobID: "job-1"})
+	if err != nil {
+		t.Errorf("client.GetSecret(password) error = %v", err)
+	} else if secret.Value != "hunter2" {
+		t.Errorf("client.GetSecret(password).Value = %q, want %q", secret.Value, "hunter2")
+	}
+
+	// Secrets can't be read with the read-only token.
+	readClient := api.NewClient(logger.Discard, jobapi.APIClientConfig(srv.SocketPath, srv.ReadOnlyToken()))
+	if _, _, err := readClient.GetSecret(ctx, &api.GetSecretRequest{Key: "password", JobID: "job-1"}); !api.IsErrHavingStatus(err, http.StatusForbidden) {
+		t.Errorf("readClient.GetSecret(password) error = %v, want status 403", err)
+	}
+
+	created, _, err := client.CreateArtifacts(ctx, "job-1", &api.ArtifactBatch{ID: "batch-1"})
+	if err != nil {
+		t.Errorf("client.CreateArtifacts() error = %v", err)
+	} else if diff := cmp.Diff(created, &api.ArtifactBatchCreateResponse{ID: "batch-1", ArtifactIDs: []string{"a1"}}); diff != "" {
+		t.Errorf("client.CreateArtifacts() diff (-got +want):\n%s", diff)
+	}
+	if _, err := client.UpdateArtifacts(ctx, "job-1", map[string]string{"a1": "finished"}); err != nil {
+		t.Errorf("client.UpdateArtifacts() error = %v", err)
+	}
+
+	resp, err := client.UploadPipeline(ctx, "job-1", &api.PipelineChange{UUID: "upload-1"}, api.Header{Name: "X-Buildkite-Backoff-Sequence", Value: "3"})
+	if err != nil {
+		t.Fatalf("client.UploadPipeline() error = %v", err)
+	}
+	if got, want := resp.StatusCode, http.StatusAccepted; got != want {
+		t.Errorf("client.UploadPipeline() status = %d, want %d", got, want)
+	}
+	if got, want := resp.Header.Get("Retry-After"), "2"; got != want {
+		t.Errorf("client.UploadPipeline() Retry-After = %q, want %q", got, want)
+	}
+	if loc, err := resp.Location(); err != nil || loc.Path != "/v3/jobs/job-1/pipelines/upload-1" {
+		t.Errorf("client.UploadPipeline() Location = %v, %v", loc, err)
+	}
+
+	status, _, err := client.PipelineUploadStatus(ctx, "job-1", "upload-1")
+	if err != nil {
+		t.Errorf("client.PipelineUploadStatus() error = %v", err)
+	} else if status.State != "applied" {
+		t.Errorf("client.PipelineUploadStatus().State = %q, want %q", status.State, "applied")
+	}
+}
+
+func TestDefaultAPIClientConfigFallsBackToURL(t *testing.T) {
+	// t.Parallel() // Can't be parallelised, because it uses the t.Setenv() function
+
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	bk := fakeBuildkite(t)
+	agentClient := api.NewClient(logger.Discard, api.Config{Endpoint: bk.URL, Token: "llamas"})
+
+	sockName, err := jobapi.NewSocketPath(t.TempDir())
+	if err != nil {
+		t.Fatalf("jobapi.NewSocketPath() error = %v", err)
+	}
+	srv, token, err := jobapi.NewServer(shell.TestingLogger{T: t}, sockName, testEnviron(), replacer.NewMux(),
+		jobapi.WithBuildkiteAPI(agentClient),
+		jobapi.WithTCPListener("127.0.0.1:0"),
+	)
+	if err != nil {
+		t.Fatalf("jobapi.NewServer() error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	// As it might be inside a container: the socket isn't mounted.
+	t.Setenv("BUILDKITE_AGENT_JOB_API_SOCKET", "/nonexistent/job-api.sock")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_URL", srv.URL())
+	t.Setenv("BUILDKITE_AGENT_JOB_API_TOKEN", token)
+
+	conf, err := jobapi.DefaultAPIClientConfig()
+	if err != nil {
+		t.Fatalf("jobapi.DefaultAPIClientConfig() error = %v", err)
+	}
+	if got, want := conf.Endpoint, srv.URL()+"/api/current-job/v0/buildkite/"; got != want {
+		t.Errorf("jobapi.DefaultAPIClientConfig().Endpoint = %q, want %q", got, want)
+	}
+
+	client := api.NewClient(logger.Discard, conf)
+	if _, err := client.Annotate(ctx, "job-1", &api.Annotation{Body: "hello"}); err != nil {
+		t.Errorf("client.Annotate() error = %v", err)
+	}
+	md, _, err := client.GetMetaData(ctx, "build", "build-1", "llama")
+	if err != nil {
+		t.Errorf("client.GetMetaData(llama) error = %v", err)
+	} else if md.Value != "alpaca" {
+		t.Errorf("client.GetMetaData(llama).Value = %q, want %q", md.Value, "alpaca")
+	}
+
+	// The read-only token can't be used to proxy requests.
+	t.Setenv("BUILDKITE_AGENT_JOB_API_TOKEN", "")
+	t.Setenv("BUILDKITE_AGENT_JOB_API_READ_TOKEN", srv.ReadOnlyToken())
+	if _, err := jobapi.DefaultAPIClientConfig(); err == nil {
+		t.Errorf("jobapi.DefaultAPIClientConfig() with only the read-only token error = nil, want an error")
+	}
+}
+
+func TestBuildkiteProxyWithoutClient(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	srv, token, err := testServer(t, testEnviron(), replacer.NewMux())
+	if err != nil {
+		t.Fatalf("testServer() error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	client := api.NewClient(logger.Discard, jobapi.APIClientConfig(srv.SocketPath, token))
+	if _, err := client.Annotate(ctx, "job-1", &api.Annotation{Body: "hello"}); !api.IsErrHavingStatus(err, http.StatusNotImplemented) {
+		t.Errorf("client.Annotate() error = %v, want status 501", err)
+	}
+}
@@ -0,0 +1,88 @@
+package jobapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/socket"
+)
+
+// DefaultCleanupTimeout is how long a cleanup command may run for, if no
+// timeout was given when it was registered.
+const DefaultCleanupTimeout = 5 * time.Minute
+
+// Cleanup is a command registered through the Job API to be run when the job
+// ends, before pre-exit hooks.
+type Cleanup struct {
+	ID      int
+	Command []string
+	Dir     string
+	Timeout time.Duration
+}
+
+// Cleanups returns the cleanup commands registered so far, in the order they
+// should be run (most recently registered first). It returns nil on a nil
+// Server.
+func (s *Server) Cleanups() []Cleanup {
+	if s == nil {
+		return nil
+	}
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	cleanups := slices.Clone(s.cleanups)
+	slices.Reverse(cleanups)
+	return cleanups
+}
+
+func (s *Server) createCleanup(w http.ResponseWriter, r *http.Request) {
+	var req CleanupCreateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	defer r.Body.Close()
+	if err != nil {
+		if err := socket.WriteError(w, fmt.Errorf("failed to decode request body: %w", err), http.StatusBadRequest); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	if len(req.Command) == 0 || req.Command[0] == "" {
+		if err := socket.WriteError(w, "command must not be empty", http.StatusUnprocessableEntity); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	timeout := DefaultCleanupTimeout
+	if req.Timeout != "" {
+		timeout, err = time.ParseDuration(req.Timeout)
+		if err == nil && timeout <= 0 {
+			err = errors.New("timeout must be positive")
+		}
+		if err != nil {
+			if err := socket.WriteError(w, fmt.Errorf("invalid timeout %q: %w", req.Timeout, err), http.StatusUnprocessableEntity); err != nil {
+				s.Logger.Errorf("Job API: couldn't write error: %v", err)
+			}
+			return
+		}
+	}
+
+	s.mtx.Lock()
+	c := Cleanup{
+		ID:      len(s.cleanups) + 1,
+		Command: req.Command,
+		Dir:     req.Dir,
+		Timeout: timeout,
+	}
+	s.cleanups = append(s.cleanups, c)
+	s.mtx.Unlock()
+
+	resp := CleanupCreateResponse{ID: c.ID, Timeout: timeout.String()}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.Logger.Errorf("Job API: couldn't encode or write response: %v", err)
+	}
+}
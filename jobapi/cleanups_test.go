@@ -0,0 +1,96 @@
+package jobapi_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/buildkite/agent/v3/jobapi"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCleanups(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv, token, err := testServer(t, testEnviron(), replacer.NewMux())
+	if err != nil {
+		t.Fatalf("testServer(t, env, mux) error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	cli, err := jobapi.NewClient(ctx, srv.SocketPath, token)
+	if err != nil {
+		t.Fatalf("jobapi.NewClient(ctx, %q, token) error = %v", srv.SocketPath, err)
+	}
+
+	reqs := []*jobapi.CleanupCreateRequest{
+		{Command: []string{"docker", "rm", "-f", "postgres"}, Dir: "/work"},
+		{Command: []string{"./stop-emulator"}, Timeout: "30s"},
+	}
+	for i, req := range reqs {
+		resp, err := cli.CleanupCreate(ctx, req)
+		if err != nil {
+			t.Fatalf("cli.CleanupCreate(ctx, %v) error = %v", req, err)
+		}
+		if got, want := resp.ID, i+1; got != want {
+			t.Errorf("cli.CleanupCreate(ctx, %v).ID = %d, want %d", req, got, want)
+		}
+	}
+
+	// Cleanups are run most recently registered first.
+	want := []jobapi.Cleanup{
+		{ID: 2, Command: []string{"./stop-emulator"}, Timeout: 30 * time.Second},
+		{ID: 1, Command: []string{"docker", "rm", "-f", "postgres"}, Dir: "/work", Timeout: jobapi.DefaultCleanupTimeout},
+	}
+	if diff := cmp.Diff(srv.Cleanups(), want); diff != "" {
+		t.Errorf("srv.Cleanups() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestCleanupsInvalid(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv, token, err := testServer(t, testEnviron(), replacer.NewMux())
+	if err != nil {
+		t.Fatalf("testServer(t, env, mux) error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	cli, err := jobapi.NewClient(ctx, srv.SocketPath, token)
+	if err != nil {
+		t.Fatalf("jobapi.NewClient(ctx, %q, token) error = %v", srv.SocketPath, err)
+	}
+
+	for _, req := range []*jobapi.CleanupCreateRequest{
+		{},
+		{Command: []string{""}},
+		{Command: []string{"true"}, Timeout: "soon"},
+		{Command: []string{"true"}, Timeout: "-1s"},
+	} {
+		_, err := cli.CleanupCreate(ctx, req)
+		var apiErr socket.APIErr
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("cli.CleanupCreate(ctx, %v) error = %v, want status %d", req, err, http.StatusUnprocessableEntity)
+		}
+	}
+
+	if got := srv.Cleanups(); len(got) != 0 {
+		t.Errorf("srv.Cleanups() = %v, want none", got)
+	}
+}
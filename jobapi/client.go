@@ -1,21 +1,34 @@
 package jobapi
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/internal/socket"
 )
 
 const (
 	envURL        = "http://job/api/current-job/v0/env"
 	redactionsURL = "http://job/api/current-job/v0/redactions"
+	cleanupsURL   = "http://job/api/current-job/v0/cleanups"
+	resultURL     = "http://job/api/current-job/v0/result"
+	eventsURL     = "http://job/api/current-job/v0/events"
+	buildkiteURL  = "http://job/api/current-job/v0/buildkite/"
 )
 
 var (
-	errNoJobAPISocketEnv = errors.New("BUILDKITE_AGENT_JOB_API_SOCKET empty or undefined")
+	errNoJobAPISocketEnv = errors.New("BUILDKITE_AGENT_JOB_API_SOCKET and BUILDKITE_AGENT_JOB_API_URL empty or undefined")
 	errNoJobAPITokenEnv  = errors.New("BUILDKITE_AGENT_JOB_API_TOKEN empty or undefined")
 )
 
@@ -24,14 +37,32 @@ type Client struct {
 	client *socket.Client
 }
 
-// NewDefaultClient returns a new Job API Client with the default socket path
-// and token.
+// NewDefaultClient returns a new Job API Client, using the socket path or URL,
+// and the token, from the environment. The socket is preferred, but if it
+// isn't available (for example, inside a container that doesn't have it
+// mounted) the URL of the TCP listener is used instead. If only a read-only
+// token is available, the client can only make requests that don't change the
+// job.
 func NewDefaultClient(ctx context.Context) (*Client, error) {
-	sock, token, err := DefaultSocketPath()
-	if err != nil {
-		return nil, err
+	sock := os.Getenv("BUILDKITE_AGENT_JOB_API_SOCKET")
+	url := os.Getenv("BUILDKITE_AGENT_JOB_API_URL")
+	if sock == "" && url == "" {
+		return nil, errNoJobAPISocketEnv
 	}
 
+	token := os.Getenv("BUILDKITE_AGENT_JOB_API_TOKEN")
+	if token == "" {
+		token = os.Getenv("BUILDKITE_AGENT_JOB_API_READ_TOKEN")
+	}
+	if token == "" {
+		return nil, errNoJobAPITokenEnv
+	}
+
+	if url != "" {
+		if _, err := os.Stat(sock); sock == "" || err != nil {
+			return NewURLClient(ctx, url, token)
+		}
+	}
 	return NewClient(ctx, sock, token)
 }
 
@@ -59,6 +90,33 @@ func NewClient(ctx context.Context, sock, token string) (*Client, error) {
 	return &Client{client: cli}, nil
 }
 
+// NewURLClient creates a new Job API Client that connects to the server's TCP
+// listener (see WithTCPListener) at the given base URL, e.g.
+// "http://127.0.0.1:39821".
+func NewURLClient(ctx context.Context, baseURL, token string) (*Client, error) {
+	u, err := parseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := socket.NewTCPClient(ctx, u.Host, token)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{client: cli}, nil
+}
+
+// parseURL parses the base URL of the server's TCP listener.
+func parseURL(baseURL string) (*neturl.URL, error) {
+	u, err := neturl.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Job API URL: %w", err)
+	}
+	if u.Scheme != "http" || u.Port() == "" {
+		return nil, fmt.Errorf("invalid Job API URL %q: must be of the form http://host:port", baseURL)
+	}
+	return u, nil
+}
+
 // EnvGet gets the current environment variables from within the job executor.
 func (c *Client) EnvGet(ctx context.Context) (map[string]string, error) {
 	var resp EnvGetResponse
@@ -102,3 +160,158 @@ func (c *Client) RedactionCreate(ctx context.Context, text string) (string, erro
 	}
 	return resp.Redacted, nil
 }
+
+// CleanupCreate registers a command to be run when the job ends.
+func (c *Client) CleanupCreate(ctx context.Context, req *CleanupCreateRequest) (*CleanupCreateResponse, error) {
+	var resp CleanupCreateResponse
+	if err := c.client.Do(ctx, http.MethodPost, cleanupsURL, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ResultGet gets the result the job has set so far. If none has been set, all
+// its fields are empty.
+func (c *Client) ResultGet(ctx context.Context) (*JobResult, error) {
+	var resp JobResult
+	if err := c.client.Do(ctx, http.MethodGet, resultURL, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ResultSet sets the result of the job, replacing any set previously.
+func (c *Client) ResultSet(ctx context.Context, res *JobResult) (*JobResult, error) {
+	var resp JobResult
+	if err := c.client.Do(ctx, http.MethodPut, resultURL, res, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Events streams job lifecycle events, calling f with each one in order.
+// Only events with IDs after afterID are streamed; pass 0 to receive all the
+// events the server has retained. Events returns when the stream ends (for
+// example, because the job has finished), when f returns an error, or when
+// ctx is done.
+func (c *Client) Events(ctx context.Context, afterID uint64, f func(Event) error) error {
+	header := make(http.Header)
+	if afterID > 0 {
+		header.Set("Last-Event-ID", strconv.FormatUint(afterID, 10))
+	}
+	body, err := c.client.Stream(ctx, http.MethodGet, eventsURL, header)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// Each event is a group of "field: value" lines ending in a blank line.
+	// The data field contains the whole event as JSON, so the other fields
+	// can be ignored.
+	var data []byte
+	sc := bufio.NewScanner(body)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) > 0 {
+			if rest, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+				data = append(data, bytes.TrimPrefix(rest, []byte(" "))...)
+			}
+			continue
+		}
+		if len(data) == 0 {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return fmt.Errorf("decoding event: %w", err)
+		}
+		data = data[:0]
+		if err := f(ev); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return sc.Err()
+}
+
+// DefaultAPIClientConfig returns configuration for an api.Client that makes
+// requests to Buildkite through the Job API (see APIClientConfig), using the
+// socket path or URL, and the token, from the environment. Like
+// NewDefaultClient, the socket is preferred, but the URL is used if the socket
+// isn't available. Proxying requests needs the token that can change the job,
+// not the read-only one.
+func DefaultAPIClientConfig() (api.Config, error) {
+	sock := os.Getenv("BUILDKITE_AGENT_JOB_API_SOCKET")
+	url := os.Getenv("BUILDKITE_AGENT_JOB_API_URL")
+	if sock == "" && url == "" {
+		return api.Config{}, errNoJobAPISocketEnv
+	}
+
+	token := os.Getenv("BUILDKITE_AGENT_JOB_API_TOKEN")
+	if token == "" {
+		return api.Config{}, errNoJobAPITokenEnv
+	}
+
+	if url != "" {
+		if _, err := os.Stat(sock); sock == "" || err != nil {
+			return URLAPIClientConfig(url, token)
+		}
+	}
+	return APIClientConfig(sock, token), nil
+}
+
+// APIClientConfig returns configuration for an api.Client that makes requests
+// to Buildkite through the Job API at the socket (see WithBuildkiteAPI),
+// rather than directly with an agent access token. Only the requests proxied
+// by the Job API (see BuildkiteAPI) are supported.
+func APIClientConfig(sock, token string) api.Config {
+	var dialer net.Dialer
+	return apiClientConfig(buildkiteURL, token, &http.Transport{
+		// Ignore arguments, dial socket
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", sock)
+		},
+	})
+}
+
+// URLAPIClientConfig is like APIClientConfig, but makes requests to the
+// server's TCP listener (see WithTCPListener) at the given base URL, e.g.
+// "http://127.0.0.1:39821".
+func URLAPIClientConfig(baseURL, token string) (api.Config, error) {
+	u, err := parseURL(baseURL)
+	if err != nil {
+		return api.Config{}, err
+	}
+	endpoint := u.JoinPath("/api/current-job/v0/buildkite/").String()
+	// The listener is on the local host or a bridge to it, so requests to it
+	// mustn't go through any proxy from the environment.
+	return apiClientConfig(endpoint, token, &http.Transport{}), nil
+}
+
+func apiClientConfig(endpoint, token string, transport http.RoundTripper) api.Config {
+	return api.Config{
+		Endpoint: endpoint,
+		HTTPClient: &http.Client{
+			Timeout: 60 * time.Second,
+			Transport: &bearerTransport{
+				token:    token,
+				delegate: transport,
+			},
+		},
+	}
+}
+
+// bearerTransport adds a bearer token to each request.
+type bearerTransport struct {
+	token    string
+	delegate http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.delegate.RoundTrip(req)
+}
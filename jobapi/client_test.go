@@ -130,6 +130,7 @@ func TestClient_NoSocket(t *testing.T) {
 	t.Cleanup(canc)
 
 	t.Setenv("BUILDKITE_AGENT_JOB_API_SOCKET", "") // This may be set if the test is being run by a buildkite agent!
+	t.Setenv("BUILDKITE_AGENT_JOB_API_URL", "")
 	_, err := NewDefaultClient(ctx)
 	assert.ErrorIs(t, err, errNoJobAPISocketEnv, "NewDefaultClient() error = %v, want %v", err, errNoJobAPISocketEnv)
 }
@@ -142,6 +143,7 @@ func TestClient_NoToken(t *testing.T) {
 
 	t.Setenv("BUILDKITE_AGENT_JOB_API_SOCKET", "/tmp/fake-socket") // Just to make sure it's set
 	t.Setenv("BUILDKITE_AGENT_JOB_API_TOKEN", "")                  // This may be set if the test is being run by a buildkite agent!
+	t.Setenv("BUILDKITE_AGENT_JOB_API_READ_TOKEN", "")
 
 	_, err := NewDefaultClient(ctx)
 	assert.ErrorIs(t, err, errNoJobAPITokenEnv, "NewDefaultClient() error = %v, want %v", err, errNoJobAPITokenEnv)
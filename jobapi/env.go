@@ -4,17 +4,40 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/internal/secrets"
 	"github.com/buildkite/agent/v3/internal/socket"
 	"golang.org/x/exp/maps"
 )
 
-func (s *Server) getEnv(w http.ResponseWriter, _ *http.Request) {
+// readScopeHiddenEnv are the environment variables that aren't returned to
+// requests made with a read-only token, since they would grant more access
+// than the token itself. Variables set from secrets (see
+// BUILDKITE_SECRETS_ENV) are hidden too.
+var readScopeHiddenEnv = []string{
+	"BUILDKITE_AGENT_JOB_API_TOKEN",
+	"BUILDKITE_AGENT_ACCESS_TOKEN",
+	"BUILDKITE_BOOTSTRAP_ACCESS_TOKEN",
+}
+
+func (s *Server) getEnv(w http.ResponseWriter, r *http.Request) {
 	s.mtx.RLock()
 	normalizedEnv := s.environ.Dump()
 	s.mtx.RUnlock()
 
+	if r.Context().Value(scopeContextKey{}) != ScopeMutate {
+		for _, name := range readScopeHiddenEnv {
+			delete(normalizedEnv, name)
+		}
+		// Nor are the variables set from secrets.
+		for _, item := range secrets.SplitList(normalizedEnv["BUILDKITE_SECRETS_ENV"]) {
+			name, _, _ := strings.Cut(item, "=")
+			delete(normalizedEnv, strings.TrimSpace(name))
+		}
+	}
+
 	resp := EnvGetResponse{Env: normalizedEnv}
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
@@ -87,6 +110,10 @@ func (s *Server) patchEnv(w http.ResponseWriter, r *http.Request) {
 
 	resp.Normalize()
 
+	if len(added) > 0 || len(updated) > 0 {
+		s.Publish(Event{Type: EventEnvChanged, Added: added, Changed: updated})
+	}
+
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		s.Logger.Errorf("Job API: couldn't encode or write response: %v", err)
@@ -131,6 +158,10 @@ func (s *Server) deleteEnv(w http.ResponseWriter, r *http.Request) {
 	resp := EnvDeleteResponse{Deleted: deleted}
 	resp.Normalize()
 
+	if len(deleted) > 0 {
+		s.Publish(Event{Type: EventEnvChanged, Removed: deleted})
+	}
+
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		s.Logger.Errorf("Job API: couldn't encode or write response: %v", err)
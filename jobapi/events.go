@@ -0,0 +1,231 @@
+package jobapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/socket"
+)
+
+// EventType is the type of a job lifecycle event.
+type EventType string
+
+const (
+	EventPhaseStart     EventType = "phase_start"
+	EventPhaseEnd       EventType = "phase_end"
+	EventHookStart      EventType = "hook_start"
+	EventHookEnd        EventType = "hook_end"
+	EventEnvChanged     EventType = "env_changed"
+	EventRedactionAdded EventType = "redaction_added"
+	EventCancelled      EventType = "cancelled"
+)
+
+// eventHistorySize is how many past events the server retains for replay to
+// clients that connect (or reconnect) part-way through a job.
+const eventHistorySize = 256
+
+// eventBufferSize is how many events can be queued for a single client before
+// it is considered too slow, and disconnected.
+const eventBufferSize = 64
+
+// Event is a job lifecycle event, streamed by the GET /events endpoint.
+// Events never contain environment variable values or redacted strings.
+type Event struct {
+	// ID increases by one with each event published during the job.
+	ID   uint64    `json:"id"`
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Phase is set for phase_start and phase_end events, e.g. "checkout".
+	Phase string `json:"phase,omitempty"`
+
+	// Hook, Source, Plugin and Path are set for hook_start and hook_end
+	// events. Source is where the hook came from: "global", "local" or
+	// "plugin".
+	Hook   string `json:"hook,omitempty"`
+	Source string `json:"source,omitempty"`
+	Plugin string `json:"plugin,omitempty"`
+	Path   string `json:"path,omitempty"`
+
+	// Error is set for phase_end and hook_end events if the phase or hook
+	// failed.
+	Error string `json:"error,omitempty"`
+
+	// Added, Changed and Removed are the names of the environment variables
+	// affected by an env_changed event.
+	Added   []string `json:"added,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// eventHub fans events out to event stream subscribers, and retains recent
+// events for replay.
+type eventHub struct {
+	mu      sync.Mutex
+	lastID  uint64
+	history []Event
+	subs    map[chan Event]struct{}
+	closed  bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan Event]struct{})}
+}
+
+// publish assigns the event an ID (and a time, if it has none), and sends it
+// to all subscribers. Subscribers that have fallen too far behind are
+// disconnected; they can resume from the last event they saw.
+func (h *eventHub) publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+
+	h.lastID++
+	ev.ID = h.lastID
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	h.history = append(h.history, ev)
+	if len(h.history) > eventHistorySize {
+		h.history = h.history[len(h.history)-eventHistorySize:]
+	}
+
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns the retained events with IDs after afterID, and a channel
+// that receives subsequent events. The channel is nil if the hub is closed.
+func (h *eventHub) subscribe(afterID uint64) ([]Event, chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil
+	}
+
+	var replay []Event
+	for _, ev := range h.history {
+		if ev.ID > afterID {
+			replay = append(replay, ev)
+		}
+	}
+
+	ch := make(chan Event, eventBufferSize)
+	h.subs[ch] = struct{}{}
+	return replay, ch
+}
+
+// unsubscribe stops sending events to ch, and closes it.
+func (h *eventHub) unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// close disconnects all subscribers and discards further events.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Publish sends an event to clients of the GET /events endpoint.
+// It is safe to call concurrently, and on a nil Server (which does nothing).
+func (s *Server) Publish(ev Event) {
+	if s == nil {
+		return
+	}
+	s.events.publish(ev)
+}
+
+// streamEvents serves job lifecycle events as server-sent events. Clients can
+// resume a stream by passing the ID of the last event they received in the
+// Last-Event-ID header.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		if err := socket.WriteError(w, "streaming is not supported by this connection", http.StatusInternalServerError); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	var afterID uint64
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		id, err := strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			if err := socket.WriteError(w, fmt.Errorf("invalid Last-Event-ID header: %w", err), http.StatusBadRequest); err != nil {
+				s.Logger.Errorf("Job API: couldn't write error: %v", err)
+			}
+			return
+		}
+		afterID = id
+	}
+
+	replay, ch := s.events.subscribe(afterID)
+	if ch == nil {
+		if err := socket.WriteError(w, "the Job API server is shutting down", http.StatusServiceUnavailable); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+	defer s.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, ev := range replay {
+		if err := writeEvent(w, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes an event in the server-sent events format.
+func writeEvent(w io.Writer, ev Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return err
+}
@@ -0,0 +1,124 @@
+package jobapi_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/buildkite/agent/v3/jobapi"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+var errStopStreaming = errors.New("stop streaming")
+
+// collectEvents streams events from the client until n have been received.
+func collectEvents(ctx context.Context, t *testing.T, cli *jobapi.Client, afterID uint64, n int) []jobapi.Event {
+	t.Helper()
+	var got []jobapi.Event
+	err := cli.Events(ctx, afterID, func(ev jobapi.Event) error {
+		got = append(got, ev)
+		if len(got) == n {
+			return errStopStreaming
+		}
+		return nil
+	})
+	if !errors.Is(err, errStopStreaming) {
+		t.Fatalf("cli.Events(ctx, %d, f) = %v, want %v", afterID, err, errStopStreaming)
+	}
+	return got
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv, token, err := testServer(t, testEnviron(), replacer.NewMux())
+	if err != nil {
+		t.Fatalf("testServer(t, env, mux) error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	cli, err := jobapi.NewClient(ctx, srv.SocketPath, token)
+	if err != nil {
+		t.Fatalf("jobapi.NewClient(ctx, %q, token) error = %v", srv.SocketPath, err)
+	}
+
+	srv.Publish(jobapi.Event{Type: jobapi.EventPhaseStart, Phase: "command"})
+	srv.Publish(jobapi.Event{Type: jobapi.EventHookStart, Hook: "pre-command", Source: "global", Path: "/hooks/pre-command"})
+
+	// Events published before the client connected are replayed, and events
+	// from the Job API itself are published too.
+	done := make(chan []jobapi.Event)
+	go func() { done <- collectEvents(ctx, t, cli, 0, 5) }()
+
+	if _, err := cli.EnvUpdate(ctx, &jobapi.EnvUpdateRequest{Env: map[string]string{"ZEBRA": "1", "MOUNTAIN": "chimborazo"}}); err != nil {
+		t.Fatalf("cli.EnvUpdate(ctx, req) error = %v", err)
+	}
+	if _, err := cli.EnvDelete(ctx, []string{"CAPITAL"}); err != nil {
+		t.Fatalf("cli.EnvDelete(ctx, [CAPITAL]) error = %v", err)
+	}
+	if _, err := cli.RedactionCreate(ctx, "hunter2"); err != nil {
+		t.Fatalf("cli.RedactionCreate(ctx, hunter2) error = %v", err)
+	}
+
+	want := []jobapi.Event{
+		{ID: 1, Type: jobapi.EventPhaseStart, Phase: "command"},
+		{ID: 2, Type: jobapi.EventHookStart, Hook: "pre-command", Source: "global", Path: "/hooks/pre-command"},
+		{ID: 3, Type: jobapi.EventEnvChanged, Added: []string{"ZEBRA"}, Changed: []string{"MOUNTAIN"}},
+		{ID: 4, Type: jobapi.EventEnvChanged, Removed: []string{"CAPITAL"}},
+		{ID: 5, Type: jobapi.EventRedactionAdded},
+	}
+	got := <-done
+	if diff := cmp.Diff(got, want, cmpopts.IgnoreFields(jobapi.Event{}, "Time")); diff != "" {
+		t.Errorf("streamed events diff (-got +want):\n%s", diff)
+	}
+
+	// Resuming from an event ID skips the events already seen.
+	got = collectEvents(ctx, t, cli, 4, 1)
+	if diff := cmp.Diff(got, want[4:], cmpopts.IgnoreFields(jobapi.Event{}, "Time")); diff != "" {
+		t.Errorf("resumed events diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestEventsEndOnStop(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv, token, err := testServer(t, testEnviron(), replacer.NewMux())
+	if err != nil {
+		t.Fatalf("testServer(t, env, mux) error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+
+	cli, err := jobapi.NewClient(ctx, srv.SocketPath, token)
+	if err != nil {
+		t.Fatalf("jobapi.NewClient(ctx, %q, token) error = %v", srv.SocketPath, err)
+	}
+
+	srv.Publish(jobapi.Event{Type: jobapi.EventCancelled})
+
+	done := make(chan error)
+	go func() {
+		done <- cli.Events(ctx, 0, func(jobapi.Event) error {
+			// Stop the server once the stream is running.
+			go srv.Stop()
+			return nil
+		})
+	}()
+
+	if err := <-done; err != nil {
+		t.Errorf("cli.Events(ctx, 0, f) = %v, want nil", err)
+	}
+}
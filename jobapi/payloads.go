@@ -58,3 +58,16 @@ type RedactionCreateRequest struct {
 type RedactionCreateResponse struct {
 	Redacted string `json:"redacted"`
 }
+
+// CleanupCreateRequest is the request body for the POST /cleanups endpoint
+type CleanupCreateRequest struct {
+	Command []string `json:"command"`
+	Dir     string   `json:"dir,omitempty"`
+	Timeout string   `json:"timeout,omitempty"` // e.g. "5m"; defaults to DefaultCleanupTimeout
+}
+
+// CleanupCreateResponse is the response body for the POST /cleanups endpoint
+type CleanupCreateResponse struct {
+	ID      int    `json:"id"`
+	Timeout string `json:"timeout"`
+}
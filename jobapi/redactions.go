@@ -21,6 +21,9 @@ func (s *Server) createRedaction(w http.ResponseWriter, r *http.Request) {
 	s.redactors.Add(payload.Redact)
 	s.mtx.Unlock()
 
+	// The event deliberately doesn't include the redacted value.
+	s.Publish(Event{Type: EventRedactionAdded})
+
 	respBody := &RedactionCreateResponse{Redacted: payload.Redact}
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(respBody); err != nil {
@@ -0,0 +1,68 @@
+package jobapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/internal/socket"
+)
+
+// JobResult is the request body for the PUT /result endpoint, and the
+// response body for the GET and PUT /result endpoints.
+type JobResult = api.JobResult
+
+// Result returns the result set by the job, or nil if none has been set. It
+// returns nil on a nil Server.
+func (s *Server) Result() *JobResult {
+	if s == nil {
+		return nil
+	}
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if s.result == nil {
+		return nil
+	}
+	res := *s.result
+	return &res
+}
+
+func (s *Server) getResult(w http.ResponseWriter, _ *http.Request) {
+	res := s.Result()
+	if res == nil {
+		res = &JobResult{}
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		s.Logger.Errorf("Job API: couldn't encode or write response: %v", err)
+	}
+}
+
+func (s *Server) putResult(w http.ResponseWriter, r *http.Request) {
+	var req JobResult
+	err := json.NewDecoder(r.Body).Decode(&req)
+	defer r.Body.Close()
+	if err != nil {
+		if err := socket.WriteError(w, fmt.Errorf("failed to decode request body: %w", err), http.StatusBadRequest); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		if err := socket.WriteError(w, err, http.StatusUnprocessableEntity); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	s.mtx.Lock()
+	s.result = &req
+	s.mtx.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(req); err != nil {
+		s.Logger.Errorf("Job API: couldn't encode or write response: %v", err)
+	}
+}
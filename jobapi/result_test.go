@@ -0,0 +1,103 @@
+package jobapi_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/buildkite/agent/v3/jobapi"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResult(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv, token, err := testServer(t, testEnviron(), replacer.NewMux())
+	if err != nil {
+		t.Fatalf("testServer(t, env, mux) error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	cli, err := jobapi.NewClient(ctx, srv.SocketPath, token)
+	if err != nil {
+		t.Fatalf("jobapi.NewClient(ctx, %q, token) error = %v", srv.SocketPath, err)
+	}
+
+	if got := srv.Result(); got != nil {
+		t.Errorf("srv.Result() before setting = %v, want nil", got)
+	}
+
+	first := &jobapi.JobResult{Category: "test", Reason: "3 tests failed"}
+	if _, err := cli.ResultSet(ctx, first); err != nil {
+		t.Fatalf("cli.ResultSet(ctx, %v) error = %v", first, err)
+	}
+
+	// The last result set wins.
+	want := &jobapi.JobResult{Category: "infra", Reason: "couldn't pull image", SoftFail: true}
+	if _, err := cli.ResultSet(ctx, want); err != nil {
+		t.Fatalf("cli.ResultSet(ctx, %v) error = %v", want, err)
+	}
+	if diff := cmp.Diff(srv.Result(), want); diff != "" {
+		t.Errorf("srv.Result() diff (-got +want):\n%s", diff)
+	}
+
+	readCli, err := jobapi.NewClient(ctx, srv.SocketPath, srv.ReadOnlyToken())
+	if err != nil {
+		t.Fatalf("jobapi.NewClient(ctx, %q, readToken) error = %v", srv.SocketPath, err)
+	}
+	got, err := readCli.ResultGet(ctx)
+	if err != nil {
+		t.Fatalf("readCli.ResultGet(ctx) error = %v", err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("readCli.ResultGet(ctx) diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestResultInvalid(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv, token, err := testServer(t, testEnviron(), replacer.NewMux())
+	if err != nil {
+		t.Fatalf("testServer(t, env, mux) error = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("srv.Start() = %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	cli, err := jobapi.NewClient(ctx, srv.SocketPath, token)
+	if err != nil {
+		t.Fatalf("jobapi.NewClient(ctx, %q, token) error = %v", srv.SocketPath, err)
+	}
+
+	for _, res := range []*jobapi.JobResult{
+		{Category: "llamas"},
+		{Category: "test", Reason: "first line\nsecond line"},
+		{Reason: strings.Repeat("x", 1025)},
+	} {
+		_, err := cli.ResultSet(ctx, res)
+		var apiErr socket.APIErr
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("cli.ResultSet(ctx, %v) error = %v, want status %d", res, err, http.StatusUnprocessableEntity)
+		}
+	}
+
+	if got := srv.Result(); got != nil {
+		t.Errorf("srv.Result() = %v, want nil", got)
+	}
+}
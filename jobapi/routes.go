@@ -19,7 +19,7 @@ func (s *Server) router() chi.Router {
 
 		// All responses are in JSON.
 		socket.HeadersMiddleware(http.Header{"Content-Type": []string{"application/json"}}),
-		socket.AuthMiddleware(s.token, s.Logger.Errorf),
+		s.authenticate,
 	)
 
 	r := chi.NewRouter()
@@ -27,10 +27,19 @@ func (s *Server) router() chi.Router {
 
 	r.Route("/api/current-job/v0", func(r chi.Router) {
 		r.Get("/env", s.getEnv)
-		r.Patch("/env", s.patchEnv)
-		r.Delete("/env", s.deleteEnv)
+		r.With(s.requireMutate).Patch("/env", s.patchEnv)
+		r.With(s.requireMutate).Delete("/env", s.deleteEnv)
 
-		r.Post("/redactions", s.createRedaction)
+		r.With(s.requireMutate).Post("/redactions", s.createRedaction)
+
+		r.With(s.requireMutate).Post("/cleanups", s.createCleanup)
+
+		r.Get("/result", s.getResult)
+		r.With(s.requireMutate).Put("/result", s.putResult)
+
+		r.Get("/events", s.streamEvents)
+
+		r.Route("/buildkite", s.buildkiteRoutes)
 	})
 
 	return r
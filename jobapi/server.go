@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"sync"
 	"time"
 
@@ -13,6 +15,12 @@ import (
 	"github.com/buildkite/agent/v3/internal/socket"
 )
 
+// Timeouts for reading requests from the TCP listener.
+const (
+	tcpReadHeaderTimeout = 10 * time.Second
+	tcpReadTimeout       = time.Minute
+)
+
 // ServerOpts provides a way to configure a Server
 type ServerOpts func(*Server)
 
@@ -22,6 +30,16 @@ func WithDebug() ServerOpts {
 	}
 }
 
+// WithTCPListener makes the server also listen on a TCP address, such as
+// "127.0.0.1:0" or the address of a container network bridge, so that the
+// Job API can be reached from places the socket can't easily be mounted.
+// The port may be 0, to choose any free port; see URL.
+func WithTCPListener(addr string) ServerOpts {
+	return func(s *Server) {
+		s.tcpAddr = addr
+	}
+}
+
 // Server is a Job API server. It provides an HTTP API with which to interact with the job currently running in the buildkite agent
 // and allows jobs to introspect and mutate their own state
 type Server struct {
@@ -34,8 +52,31 @@ type Server struct {
 	environ   *env.Environment
 	redactors *replacer.Mux
 
-	token   string
+	// If not nil, requests to the /buildkite routes are proxied using
+	// this client.
+	buildkite BuildkiteAPI
+
+	// cleanups are commands to run when the job ends, in the order they
+	// were registered.
+	cleanups []Cleanup
+
+	// result is the structured result set by the job, if any.
+	result *JobResult
+
+	// events fans job lifecycle events out to clients of GET /events.
+	events *eventHub
+
+	// token permits all requests, readToken permits only those that don't
+	// change the job (see TokenScope).
+	token     string
+	readToken string
+
 	sockSvr *socket.Server
+
+	// If tcpAddr is not empty, the server also listens on it, using tcpSvr.
+	tcpAddr string
+	tcpSvr  *http.Server
+	tcpURL  string
 }
 
 // NewServer creates a new Job API server
@@ -52,24 +93,53 @@ func NewServer(
 	if err != nil {
 		return nil, "", fmt.Errorf("generating token: %w", err)
 	}
+	readToken, err := socket.GenerateToken(32)
+	if err != nil {
+		return nil, "", fmt.Errorf("generating read-only token: %w", err)
+	}
 
 	s := &Server{
 		SocketPath: socketPath,
 		Logger:     logger,
 		environ:    environ,
 		redactors:  redactors,
+		events:     newEventHub(),
 		token:      token,
+		readToken:  readToken,
 	}
 
 	for _, o := range opts {
 		o(s)
 	}
 
-	svr, err := socket.NewServer(socketPath, s.router())
+	if s.tcpAddr != "" {
+		host, _, err := net.SplitHostPort(s.tcpAddr)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid TCP listen address %q: %w", s.tcpAddr, err)
+		}
+		// Listening on all interfaces would expose the job to the network.
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			return nil, "", fmt.Errorf("invalid TCP listen address %q: the Job API must listen on a specific address, such as 127.0.0.1", s.tcpAddr)
+		}
+	}
+
+	router := s.router()
+	svr, err := socket.NewServer(socketPath, router)
 	if err != nil {
 		return nil, "", fmt.Errorf("creating socket server: %w", err)
 	}
 	s.sockSvr = svr
+	if s.tcpAddr != "" {
+		// Unlike the socket, the TCP listener can be reached by other
+		// processes on the network it listens on, so slow clients shouldn't
+		// be able to hold connections open. There's no write timeout, since
+		// the events stream is long-lived.
+		s.tcpSvr = &http.Server{
+			Handler:           router,
+			ReadHeaderTimeout: tcpReadHeaderTimeout,
+			ReadTimeout:       tcpReadTimeout,
+		}
+	}
 
 	return s, token, err
 }
@@ -80,14 +150,41 @@ func (s *Server) Start() error {
 		return fmt.Errorf("starting socket server: %w", err)
 	}
 
+	if s.tcpSvr != nil {
+		ln, err := net.Listen("tcp", s.tcpAddr)
+		if err != nil {
+			s.sockSvr.Close()
+			return fmt.Errorf("starting TCP listener: %w", err)
+		}
+		s.tcpURL = "http://" + ln.Addr().String()
+		go s.tcpSvr.Serve(ln)
+	}
+
 	if s.debug {
 		s.Logger.Printf("~~~ Job API")
 		s.Logger.Printf("Server listening on %s", s.SocketPath)
+		if s.tcpURL != "" {
+			s.Logger.Printf("Server listening on %s", s.tcpURL)
+		}
 	}
 
 	return nil
 }
 
+// URL returns the base URL of the server's TCP listener, e.g.
+// "http://127.0.0.1:39821", or "" if it isn't listening on TCP. It is only
+// valid after Start.
+func (s *Server) URL() string {
+	return s.tcpURL
+}
+
+// ReadOnlyToken returns a token that permits only requests that don't change
+// the job (see ScopeRead). The token returned by NewServer permits all
+// requests.
+func (s *Server) ReadOnlyToken() string {
+	return s.readToken
+}
+
 // Stop gracefully shuts the server down, blocking until all requests have been served or the grace period has expired
 // It returns an error if the server has not been started
 func (s *Server) Stop() error {
@@ -95,8 +192,15 @@ func (s *Server) Stop() error {
 	shutdownCtx, serverStopCtx := context.WithTimeout(context.Background(), 10*time.Second)
 	defer serverStopCtx()
 
+	// Event streams would otherwise hold the shutdown open until the grace
+	// period expires.
+	s.events.close()
+
 	// Trigger graceful shutdown
 	err := s.sockSvr.Shutdown(shutdownCtx)
+	if s.tcpSvr != nil {
+		err = errors.Join(err, s.tcpSvr.Shutdown(shutdownCtx))
+	}
 	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
 			s.Logger.Warningf("Job API server shutdown timed out, server shutdown forced")
@@ -0,0 +1,143 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ExitAggregation is how the exit statuses of the clients are combined into
+// the exit status of the job.
+type ExitAggregation string
+
+const (
+	// ExitAggregationFirstFailure finishes the job as soon as any client
+	// exits non-zero (or is lost), with that client's exit status.
+	ExitAggregationFirstFailure ExitAggregation = "first-failure"
+
+	// ExitAggregationAllMustSucceed waits for every client to exit, and
+	// uses the exit status of the first to fail.
+	ExitAggregationAllMustSucceed ExitAggregation = "all-must-succeed"
+
+	// ExitAggregationPrimaryOnly finishes the job when the primary client
+	// exits, with its exit status. Sidecar failures are only logged.
+	ExitAggregationPrimaryOnly ExitAggregation = "primary-only"
+)
+
+// ExitAggregations are all the valid values of ExitAggregation.
+var ExitAggregations = []ExitAggregation{
+	ExitAggregationFirstFailure,
+	ExitAggregationAllMustSucceed,
+	ExitAggregationPrimaryOnly,
+}
+
+// lostExitStatus is used as the exit status of clients that are lost.
+const lostExitStatus = -1
+
+// MinHeartbeatTimeout is the shortest heartbeat timeout the runner uses.
+const MinHeartbeatTimeout = time.Second
+
+// heartbeatInterval is how often clients are asked to send heartbeats, so
+// that a couple can go missing before the client is considered lost.
+func (r *Runner) heartbeatInterval() time.Duration {
+	return r.conf.HeartbeatTimeout / 3
+}
+
+func (r *Runner) heartbeat(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client, found := r.clients[id]
+	if !found {
+		return fmt.Errorf("unrecognized client id: %d", id)
+	}
+	if client.State == stateLost {
+		return fmt.Errorf("client id %d was lost", id)
+	}
+	client.LastHeartbeat = time.Now()
+	return nil
+}
+
+// monitorHeartbeats periodically checks for lost clients until the runner is
+// done.
+func (r *Runner) monitorHeartbeats(ctx context.Context) {
+	ticker := time.NewTicker(r.heartbeatInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			r.checkHeartbeats(now)
+		}
+	}
+}
+
+// checkHeartbeats marks connected clients that haven't sent a heartbeat
+// within the timeout as lost, and treats them as having failed.
+func (r *Runner) checkHeartbeats(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id := range r.conf.ClientCount {
+		client := r.clients[id]
+		if client.State != stateConnected || client.LastHeartbeat.IsZero() {
+			continue
+		}
+		since := now.Sub(client.LastHeartbeat)
+		if since <= r.conf.HeartbeatTimeout {
+			continue
+		}
+		r.logger.Warn("client %d lost: no heartbeat for %v", id, since.Round(time.Second))
+		kind := "Container"
+		if id != r.primaryID {
+			kind = "Sidecar container"
+		}
+		r.jobLogf("%s %s was lost: no heartbeat received for %v", kind, r.describeClient(id), since.Round(time.Second))
+		client.ExitStatus = lostExitStatus
+		client.State = stateLost
+		r.clientFinished(id)
+	}
+}
+
+// clientFinished records the first failure, and closes done if the job is
+// finished according to the exit aggregation. r.mu must be held.
+func (r *Runner) clientFinished(id int) {
+	if r.clients[id].ExitStatus != 0 && r.firstFailure < 0 {
+		r.firstFailure = id
+	}
+
+	done := true
+	for _, client := range r.clients {
+		done = client.finished() && done
+	}
+	switch r.conf.ExitAggregation {
+	case ExitAggregationFirstFailure:
+		done = done || r.firstFailure >= 0
+	case ExitAggregationPrimaryOnly:
+		done = done || id == r.primaryID
+	}
+	if done {
+		r.closedOnce.Do(func() {
+			close(r.done)
+		})
+	}
+}
+
+// describeClient names a client for log messages. r.mu must be held.
+func (r *Runner) describeClient(id int) string {
+	if client, found := r.clients[id]; found && client.Name != "" {
+		return fmt.Sprintf("%q (ID %d)", client.Name, id)
+	}
+	return fmt.Sprint(id)
+}
+
+// jobLogf writes a line to the job log.
+func (r *Runner) jobLogf(format string, v ...any) {
+	if r.conf.Stderr == nil {
+		return
+	}
+	fmt.Fprintf(r.conf.Stderr, format+"\n", v...)
+}
@@ -30,19 +30,31 @@ func New(l logger.Logger, c Config) *Runner {
 	if c.SocketPath == "" {
 		c.SocketPath = defaultSocketPath
 	}
+	if c.ExitAggregation == "" {
+		c.ExitAggregation = ExitAggregationFirstFailure
+	}
+	if c.HeartbeatTimeout > 0 {
+		c.HeartbeatTimeout = max(c.HeartbeatTimeout, MinHeartbeatTimeout)
+	}
+	primaryID := c.ClientCount - 1
+	if c.PrimaryClientID != nil {
+		primaryID = *c.PrimaryClientID
+	}
 	clients := make(map[int]*clientResult, c.ClientCount)
 	for i := range c.ClientCount {
 		clients[i] = &clientResult{}
 	}
 	return &Runner{
-		logger:    l,
-		conf:      c,
-		clients:   clients,
-		server:    rpc.NewServer(),
-		mux:       http.NewServeMux(),
-		done:      make(chan struct{}),
-		started:   make(chan struct{}),
-		interrupt: make(chan struct{}),
+		logger:       l,
+		conf:         c,
+		clients:      clients,
+		firstFailure: -1,
+		primaryID:    primaryID,
+		server:       rpc.NewServer(),
+		mux:          http.NewServeMux(),
+		done:         make(chan struct{}),
+		started:      make(chan struct{}),
+		interrupt:    make(chan struct{}),
 	}
 }
 
@@ -58,11 +70,37 @@ type Runner struct {
 	server  *rpc.Server
 	mux     *http.ServeMux
 	clients map[int]*clientResult
+
+	// primaryID is the ID of the primary client.
+	primaryID int
+
+	// firstFailure is the ID of the first client to exit non-zero (or be
+	// lost), or -1 if none have.
+	firstFailure int
+
+	// logMu serialises writes to Stdout. lastLogClient is the client that
+	// last wrote logs, and midLine is whether its last line is unfinished.
+	logMu         sync.Mutex
+	lastLogClient int
+	midLine       bool
 }
 
 type clientResult struct {
 	ExitStatus int
 	State      clientState
+
+	// Name is the container name, if the client sent one.
+	Name string
+
+	// LastHeartbeat is when the client last sent a heartbeat. Clients that
+	// have never sent one (such as those from older agents) are never
+	// considered lost.
+	LastHeartbeat time.Time
+}
+
+// finished reports whether the client has exited or been lost.
+func (c *clientResult) finished() bool {
+	return c.State == stateExited || c.State == stateLost
 }
 
 type clientState int
@@ -71,6 +109,7 @@ const (
 	stateUnknown clientState = iota
 	stateConnected
 	stateExited
+	stateLost
 )
 
 type Config struct {
@@ -78,11 +117,26 @@ type Config struct {
 	ClientCount    int
 	Stdout, Stderr io.Writer
 	Env            []string
+
+	// HeartbeatTimeout is how long a client can go without sending a
+	// heartbeat before it is considered lost. Zero disables heartbeats.
+	// Shorter timeouts than MinHeartbeatTimeout are raised to it.
+	HeartbeatTimeout time.Duration
+
+	// ExitAggregation is how the exit statuses of the clients are combined.
+	// It defaults to ExitAggregationFirstFailure.
+	ExitAggregation ExitAggregation
+
+	// PrimaryClientID is the ID of the primary client, which runs the job's
+	// command. The others are sidecars. If it is nil, the client with the
+	// highest ID is the primary.
+	PrimaryClientID *int
 }
 
 func (r *Runner) Run(ctx context.Context) error {
 	r.server.Register(r)
 	r.mux.Handle(rpc.DefaultRPCPath, r.server)
+	r.registerRoutes()
 
 	oldUmask, err := Umask(0) // set umask of socket file to 0777 (world read-write-executable)
 	if err != nil {
@@ -99,6 +153,10 @@ func (r *Runner) Run(ctx context.Context) error {
 	r.listener = l
 	go http.Serve(l, r.mux)
 
+	if r.conf.HeartbeatTimeout > 0 {
+		go r.monitorHeartbeats(ctx)
+	}
+
 	<-r.done
 	return nil
 }
@@ -158,12 +216,25 @@ func (w waitStatus) Signaled() bool {
 }
 
 func (r *Runner) WaitStatus() process.WaitStatus {
-	ws := waitStatus{}
-	for _, client := range r.clients {
-		if client.ExitStatus != 0 {
-			return waitStatus{Code: client.ExitStatus}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conf.ExitAggregation == ExitAggregationPrimaryOnly {
+		primary, found := r.clients[r.primaryID]
+		switch {
+		case !found:
+			return waitStatus{Code: lostExitStatus}
+		case primary.State == stateUnknown:
+			return waitStatus{Code: -10}
 		}
+		return waitStatus{Code: primary.ExitStatus}
+	}
 
+	if r.firstFailure >= 0 {
+		return waitStatus{Code: r.clients[r.firstFailure].ExitStatus}
+	}
+	ws := waitStatus{}
+	for _, client := range r.clients {
 		// use an unusual status code to distinguish this unusual state
 		if client.State == stateUnknown {
 			ws.Code -= 10
@@ -198,47 +269,128 @@ type Status struct {
 }
 
 type RegisterResponse struct {
-	Env []string
+	Env []string `json:"env"`
+
+	// ProtocolVersion is the protocol version chosen by the runner. It is
+	// always 0 (the original protocol) over net/rpc.
+	ProtocolVersion int `json:"protocol_version"`
+
+	// HeartbeatInterval is how often the client should send heartbeats. If
+	// it is zero, the client shouldn't send any.
+	HeartbeatInterval time.Duration `json:"heartbeat_interval"`
 }
 
 func (r *Runner) WriteLogs(args Logs, reply *Empty) error {
+	// The original protocol doesn't say which client the logs are from.
+	return r.writeLogs(-1, bytes.NewReader(args.Data))
+}
+
+func (r *Runner) Exit(args ExitCode, reply *Empty) error {
+	return r.exit(args.ID, args.ExitStatus)
+}
+
+func (r *Runner) Register(id int, reply *RegisterResponse) error {
+	env, err := r.register(id, "")
+	if err != nil {
+		return err
+	}
+	reply.Env = env
+	reply.HeartbeatInterval = r.heartbeatInterval()
+	return nil
+}
+
+func (r *Runner) Heartbeat(id int, reply *Empty) error {
+	return r.heartbeat(id)
+}
+
+func (r *Runner) Status(id int, reply *RunState) error {
+	state, err := r.status(id)
+	if err != nil {
+		return err
+	}
+	*reply = state
+	return nil
+}
+
+// The methods below implement the sidecar API for both protocols.
+
+// writeLogs writes logs from a client to Stdout. When there's more than one
+// client, each line is prefixed with the container it came from (unless the
+// client ID is unknown, which it is with the original protocol). If another
+// client's unfinished line is interrupted, it's ended first, so lines from
+// different containers aren't mixed together.
+func (r *Runner) writeLogs(id int, logs io.Reader) error {
 	r.startedOnce.Do(func() {
 		close(r.started)
 	})
-	_, err := io.Copy(r.conf.Stdout, bytes.NewReader(args.Data))
+	data, err := io.ReadAll(logs)
+	if err != nil {
+		return err
+	}
+
+	r.logMu.Lock()
+	defer r.logMu.Unlock()
+
+	var out bytes.Buffer
+	if r.midLine && r.lastLogClient != id {
+		out.WriteByte('\n')
+		r.midLine = false
+	}
+	prefix := r.logPrefix(id)
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		if !r.midLine {
+			out.WriteString(prefix)
+		}
+		out.Write(line)
+		r.midLine = line[len(line)-1] != '\n'
+		data = data[len(line):]
+	}
+	r.lastLogClient = id
+
+	_, err = out.WriteTo(r.conf.Stdout)
 	return err
 }
 
-func (r *Runner) Exit(args ExitCode, reply *Empty) error {
+// logPrefix returns the prefix for lines logged by a client.
+func (r *Runner) logPrefix(id int) string {
+	if id < 0 || r.conf.ClientCount <= 1 {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, found := r.clients[id]; found && c.Name != "" {
+		return fmt.Sprintf("[%s] ", c.Name)
+	}
+	return fmt.Sprintf("[container %d] ", id)
+}
+
+func (r *Runner) exit(id, exitStatus int) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	client, found := r.clients[args.ID]
+	client, found := r.clients[id]
 	if !found {
-		return fmt.Errorf("unrecognized client id: %d", args.ID)
+		return fmt.Errorf("unrecognized client id: %d", id)
 	}
-	r.logger.Info("client %d exited with code %d", args.ID, args.ExitStatus)
-	client.ExitStatus = args.ExitStatus
-	client.State = stateExited
-	if client.ExitStatus != 0 {
-		r.closedOnce.Do(func() {
-			close(r.done)
-		})
+	if client.State == stateLost {
+		// It was given up on, and its replacement status already counted.
+		return fmt.Errorf("client id %d was lost", id)
 	}
-
-	allExited := true
-	for _, client := range r.clients {
-		allExited = client.State == stateExited && allExited
-	}
-	if allExited {
-		r.closedOnce.Do(func() {
-			close(r.done)
-		})
+	r.logger.Info("client %d exited with code %d", id, exitStatus)
+	client.ExitStatus = exitStatus
+	client.State = stateExited
+	if exitStatus != 0 && id != r.primaryID {
+		r.jobLogf("Sidecar container %s exited with status %d", r.describeClient(id), exitStatus)
 	}
+	r.clientFinished(id)
 	return nil
 }
 
-func (r *Runner) Register(id int, reply *RegisterResponse) error {
+func (r *Runner) register(id int, name string) ([]string, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.startedOnce.Do(func() {
@@ -246,46 +398,62 @@ func (r *Runner) Register(id int, reply *RegisterResponse) error {
 	})
 	client, found := r.clients[id]
 	if !found {
-		return fmt.Errorf("client id %d not found", id)
+		return nil, fmt.Errorf("client id %d not found", id)
 	}
 	if client.State != stateUnknown {
-		return fmt.Errorf("client id %d already registered", id)
+		return nil, fmt.Errorf("client id %d already registered", id)
 	}
 	r.logger.Info("client %d connected", id)
 	client.State = stateConnected
+	client.Name = name
 
-	reply.Env = r.conf.Env
-	return nil
+	return r.conf.Env, nil
 }
 
-func (r *Runner) Status(id int, reply *RunState) error {
+func (r *Runner) status(id int) (RunState, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	select {
 	case <-r.done:
-		return rpc.ErrShutdown
+		return RunStateWait, rpc.ErrShutdown
 	case <-r.interrupt:
-		*reply = RunStateInterrupt
-		return nil
+		return RunStateInterrupt, nil
 	default:
 		if id == 0 {
-			*reply = RunStateStart
-		} else if client, found := r.clients[id-1]; found && client.State == stateExited {
-			*reply = RunStateStart
+			return RunStateStart, nil
+		} else if client, found := r.clients[id-1]; found && client.finished() {
+			return RunStateStart, nil
 		}
-		return nil
+		return RunStateWait, nil
 	}
 }
 
 type Client struct {
 	ID         int
 	SocketPath string
-	client     *rpc.Client
+
+	// Name is the container name, used by the runner in log messages. It is
+	// only sent with the JSON protocol.
+	Name string
+
+	// protocol is the negotiated protocol version. If it is 0, the original
+	// net/rpc protocol is used with client, otherwise JSON with http.
+	protocol int
+	client   *rpc.Client
+	http     *http.Client
+
+	// useGob skips trying the JSON protocol.
+	useGob bool
+
+	stopHeartbeats context.CancelFunc
 }
 
 var errNotConnected = errors.New("client not connected")
 
+// Connect connects and registers with the runner. It uses the newest protocol
+// version both support, falling back to the original net/rpc protocol for
+// older agents.
 func (c *Client) Connect(ctx context.Context) (*RegisterResponse, error) {
 	if c.SocketPath == "" {
 		c.SocketPath = defaultSocketPath
@@ -297,24 +465,94 @@ func (c *Client) Connect(ctx context.Context) (*RegisterResponse, error) {
 		roko.WithMaxAttempts(30),
 		roko.WithStrategy(roko.Constant(time.Second)),
 	)
-	client, err := roko.DoFunc(ctx, r, func(*roko.Retrier) (*rpc.Client, error) {
-		return rpc.DialHTTP("unix", c.SocketPath)
+	err := r.DoWithContext(ctx, func(*roko.Retrier) error {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "unix", c.SocketPath)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
 	})
 	if err != nil {
 		return nil, err
 	}
+
+	if !c.useGob {
+		c.http = newHTTPClient(c.SocketPath)
+		resp, err := c.registerJSON(ctx)
+		if err == nil {
+			c.protocol = resp.ProtocolVersion
+			c.startHeartbeats(resp.HeartbeatInterval)
+			return resp, nil
+		}
+		if !errors.Is(err, errProtocolUnsupported) {
+			return nil, err
+		}
+		c.http.CloseIdleConnections()
+		c.http = nil
+	}
+
+	client, err := rpc.DialHTTP("unix", c.SocketPath)
+	if err != nil {
+		return nil, err
+	}
 	c.client = client
 	var resp RegisterResponse
 	if err := c.client.Call("Runner.Register", c.ID, &resp); err != nil {
 		return nil, err
 	}
+	c.startHeartbeats(resp.HeartbeatInterval)
 	return &resp, nil
 }
 
+// startHeartbeats sends heartbeats in the background until Close is called.
+// Errors are ignored: if heartbeats fail for long enough, the runner
+// considers the client lost, which is the point.
+func (c *Client) startHeartbeats(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopHeartbeats = cancel
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			_ = c.heartbeat(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *Client) heartbeat(ctx context.Context) error {
+	if c.protocol != 0 {
+		_, err := c.doJSON(ctx, http.MethodPost, c.clientPath("heartbeat"), nil, nil)
+		return err
+	}
+	return c.client.Call("Runner.Heartbeat", c.ID, nil)
+}
+
+// ProtocolVersion returns the protocol version negotiated by Connect, where 0
+// is the original net/rpc protocol.
+func (c *Client) ProtocolVersion() int {
+	return c.protocol
+}
+
+func (c *Client) connected() bool {
+	return c.client != nil || c.http != nil
+}
+
 func (c *Client) Exit(exitStatus int) error {
-	if c.client == nil {
+	if !c.connected() {
 		return errNotConnected
 	}
+	if c.protocol != 0 {
+		_, err := c.doJSON(context.Background(), http.MethodPost, c.clientPath("exit"), ExitRequest{ExitStatus: exitStatus}, nil)
+		return err
+	}
 	return c.client.Call("Runner.Exit", ExitCode{
 		ID:         c.ID,
 		ExitStatus: exitStatus,
@@ -323,10 +561,14 @@ func (c *Client) Exit(exitStatus int) error {
 
 // Write implements io.Writer
 func (c *Client) Write(p []byte) (int, error) {
-	if c.client == nil {
+	if !c.connected() {
 		return 0, errNotConnected
 	}
 	n := len(p)
+	if c.protocol != 0 {
+		_, err := c.doJSON(context.Background(), http.MethodPost, c.clientPath("logs"), bytes.NewReader(p), nil)
+		return n, err
+	}
 	err := c.client.Call("Runner.WriteLogs", Logs{
 		Data: p,
 	}, nil)
@@ -354,8 +596,8 @@ func (c *Client) Await(ctx context.Context, desiredState RunState) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			var current RunState
-			if err := c.client.Call("Runner.Status", c.ID, &current); err != nil {
+			current, err := c.status(ctx)
+			if err != nil {
 				return err
 			}
 			if current == desiredState {
@@ -373,6 +615,28 @@ func (c *Client) Await(ctx context.Context, desiredState RunState) error {
 	}
 }
 
+// status gets the run state for this client from the runner.
+func (c *Client) status(ctx context.Context) (RunState, error) {
+	if c.protocol != 0 {
+		var resp StatusResponse
+		if _, err := c.doJSON(ctx, http.MethodGet, c.clientPath("status"), nil, &resp); err != nil {
+			return RunStateWait, err
+		}
+		return parseRunState(resp.State)
+	}
+	var current RunState
+	err := c.client.Call("Runner.Status", c.ID, &current)
+	return current, err
+}
+
 func (c *Client) Close() {
-	c.client.Close()
+	if c.stopHeartbeats != nil {
+		c.stopHeartbeats()
+	}
+	if c.client != nil {
+		c.client.Close()
+	}
+	if c.http != nil {
+		c.http.CloseIdleConnections()
+	}
 }
@@ -3,8 +3,12 @@
 package kubernetes
 
 import (
+	"bytes"
 	"context"
 	"encoding/gob"
+	"fmt"
+	"net"
+	"net/http"
 	"net/rpc"
 	"os"
 	"path/filepath"
@@ -128,17 +132,120 @@ func TestTerminate(t *testing.T) {
 	require.ErrorContains(t, client0.Await(ctx, RunStateInterrupt), rpc.ErrShutdown.Error())
 }
 
+func TestProtocolNegotiation(t *testing.T) {
+	runner := newRunner(t, 2)
+	ctx := context.Background()
+
+	// A current client and a client that only speaks the original protocol
+	// can share a runner.
+	client0 := &Client{ID: 0, SocketPath: runner.conf.SocketPath}
+	client1 := &Client{ID: 1, SocketPath: runner.conf.SocketPath, useGob: true}
+
+	require.NoError(t, connect(client0))
+	t.Cleanup(client0.Close)
+	require.NoError(t, connect(client1))
+	t.Cleanup(client1.Close)
+	require.Equal(t, ProtocolVersion1, client0.ProtocolVersion())
+	require.Equal(t, 0, client1.ProtocolVersion())
+
+	require.NoError(t, client0.Await(ctx, RunStateStart))
+	require.NoError(t, client0.Exit(0))
+	require.NoError(t, client1.Await(ctx, RunStateStart))
+	require.NoError(t, client1.Exit(3))
+	require.Equal(t, 3, runner.WaitStatus().ExitStatus())
+}
+
+func TestProtocolFallback(t *testing.T) {
+	// Serve only the original protocol, like older agents do.
+	socketPath := filepath.Join(t.TempDir(), "bk.sock")
+	runner := New(logger.Discard, Config{SocketPath: socketPath, ClientCount: 1})
+	require.NoError(t, runner.server.Register(runner))
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, runner.server)
+	l, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go http.Serve(l, mux)
+
+	client := &Client{ID: 0, SocketPath: socketPath}
+	require.NoError(t, connect(client))
+	t.Cleanup(client.Close)
+	require.Equal(t, 0, client.ProtocolVersion())
+
+	require.NoError(t, client.Await(context.Background(), RunStateStart))
+	require.NoError(t, client.Exit(0))
+	require.Equal(t, 0, runner.WaitStatus().ExitStatus())
+}
+
+func TestWriteLogs(t *testing.T) {
+	for _, useGob := range []bool{false, true} {
+		var stdout bytes.Buffer
+		runner := newRunnerWithConfig(t, Config{ClientCount: 1, Stdout: &stdout})
+		client := &Client{ID: 0, SocketPath: runner.conf.SocketPath, useGob: useGob}
+
+		require.NoError(t, connect(client))
+		t.Cleanup(client.Close)
+		_, err := client.Write([]byte("hello "))
+		require.NoError(t, err)
+		_, err = client.Write([]byte("world\n"))
+		require.NoError(t, err)
+		require.NoError(t, client.Exit(0))
+		require.Equal(t, "hello world\n", stdout.String(), "useGob = %t", useGob)
+	}
+}
+
+func TestWriteLogsTagsContainers(t *testing.T) {
+	var stdout bytes.Buffer
+	runner := newRunnerWithConfig(t, Config{ClientCount: 3, Stdout: &stdout})
+	checkout := &Client{ID: 0, Name: "checkout", SocketPath: runner.conf.SocketPath}
+	command := &Client{ID: 1, SocketPath: runner.conf.SocketPath}
+	legacy := &Client{ID: 2, SocketPath: runner.conf.SocketPath, useGob: true}
+	for _, client := range []*Client{checkout, command, legacy} {
+		require.NoError(t, connect(client))
+		t.Cleanup(client.Close)
+	}
+
+	for _, w := range []struct {
+		client *Client
+		data   string
+	}{
+		{checkout, "cloning...\nfetch"},
+		{command, "running\n"},
+		{checkout, "ed\ndone\n"},
+		{legacy, "from an old agent\n"},
+	} {
+		_, err := w.client.Write([]byte(w.data))
+		require.NoError(t, err)
+	}
+
+	want := "[checkout] cloning...\n" +
+		"[checkout] fetch\n" +
+		"[container 1] running\n" +
+		"[checkout] ed\n" +
+		"[checkout] done\n" +
+		"from an old agent\n"
+	require.Equal(t, want, stdout.String())
+}
+
+func TestPrimaryClientID(t *testing.T) {
+	// By default, the client with the highest ID is the primary.
+	require.Equal(t, 2, New(logger.Discard, Config{ClientCount: 3}).primaryID)
+	require.Equal(t, 0, New(logger.Discard, Config{ClientCount: 3, PrimaryClientID: intptr(0)}).primaryID)
+}
+
 func newRunner(t *testing.T, clientCount int) *Runner {
-	tempDir, err := os.MkdirTemp("", t.Name())
+	return newRunnerWithConfig(t, Config{ClientCount: clientCount})
+}
+
+func newRunnerWithConfig(t *testing.T, conf Config) *Runner {
+	tempDir, err := os.MkdirTemp("", "kubernetes-test")
 	require.NoError(t, err)
 	socketPath := filepath.Join(tempDir, "bk.sock")
 	t.Cleanup(func() {
 		os.RemoveAll(tempDir)
 	})
-	runner := New(logger.Discard, Config{
-		SocketPath:  socketPath,
-		ClientCount: clientCount,
-	})
+	conf.SocketPath = socketPath
+	runner := New(logger.Discard, conf)
 	runnerCtx, cancelRunner := context.WithCancel(context.Background())
 	go runner.Run(runnerCtx)
 	t.Cleanup(func() {
@@ -174,3 +281,125 @@ func connect(c *Client) error {
 	_, err := c.Connect(context.Background())
 	return err
 }
+
+func TestExitAggregation(t *testing.T) {
+	tests := []struct {
+		aggregation ExitAggregation
+		exits       []int // exit statuses, in client ID order
+		wantDone    []bool
+		wantStatus  int
+	}{
+		{
+			aggregation: ExitAggregationFirstFailure,
+			exits:       []int{2, 3},
+			wantDone:    []bool{true, true},
+			wantStatus:  2,
+		},
+		{
+			aggregation: ExitAggregationAllMustSucceed,
+			exits:       []int{2, 3},
+			wantDone:    []bool{false, true},
+			wantStatus:  2,
+		},
+		{
+			aggregation: ExitAggregationAllMustSucceed,
+			exits:       []int{0, 0},
+			wantDone:    []bool{false, true},
+			wantStatus:  0,
+		},
+		{
+			aggregation: ExitAggregationPrimaryOnly,
+			exits:       []int{2, 0},
+			wantDone:    []bool{false, true},
+			wantStatus:  0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.aggregation), func(t *testing.T) {
+			var stderr bytes.Buffer
+			runner := newRunnerWithConfig(t, Config{
+				ClientCount:     2,
+				ExitAggregation: test.aggregation,
+				Stderr:          &stderr,
+			})
+			for id, status := range test.exits {
+				client := &Client{ID: id, SocketPath: runner.conf.SocketPath, Name: fmt.Sprintf("container-%d", id)}
+				require.NoError(t, connect(client))
+				t.Cleanup(client.Close)
+				require.NoError(t, client.Exit(status))
+
+				select {
+				case <-runner.Done():
+					require.True(t, test.wantDone[id], "runner done after client %d exited", id)
+				default:
+					require.False(t, test.wantDone[id], "runner not done after client %d exited", id)
+				}
+				if test.wantDone[id] {
+					break
+				}
+			}
+			require.Equal(t, test.wantStatus, runner.WaitStatus().ExitStatus())
+			if test.exits[0] != 0 {
+				require.Contains(t, stderr.String(), `Sidecar container "container-0" (ID 0) exited with status 2`)
+			}
+		})
+	}
+}
+
+func TestHeartbeatTimeout(t *testing.T) {
+	var stderr bytes.Buffer
+	runner := newRunnerWithConfig(t, Config{
+		ClientCount:      2,
+		HeartbeatTimeout: time.Minute,
+		Stderr:           &stderr,
+	})
+
+	client0 := &Client{ID: 0, SocketPath: runner.conf.SocketPath, Name: "checkout"}
+	client1 := &Client{ID: 1, SocketPath: runner.conf.SocketPath, useGob: true}
+	for _, client := range []*Client{client0, client1} {
+		resp, err := client.Connect(context.Background())
+		require.NoError(t, err)
+		t.Cleanup(client.Close)
+		require.Equal(t, 20*time.Second, resp.HeartbeatInterval)
+	}
+
+	// Both clients send a heartbeat straight away.
+	require.Eventually(t, func() bool {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
+		return !runner.clients[0].LastHeartbeat.IsZero() && !runner.clients[1].LastHeartbeat.IsZero()
+	}, 10*time.Second, time.Millisecond)
+
+	runner.checkHeartbeats(time.Now())
+	select {
+	case <-runner.Done():
+		require.FailNow(t, "runner shouldn't be done before the heartbeat timeout")
+	default:
+	}
+
+	runner.checkHeartbeats(time.Now().Add(2 * time.Minute))
+	select {
+	case <-runner.Done():
+	default:
+		require.FailNow(t, "runner should be done when a client is lost")
+	}
+	require.Equal(t, lostExitStatus, runner.WaitStatus().ExitStatus())
+	require.Contains(t, stderr.String(), `Sidecar container "checkout" (ID 0) was lost`)
+	require.Error(t, client0.Exit(0), "expected an error when a lost client exits")
+}
+
+func TestHeartbeatTimeout_TooShortIsRaisedToMinimum(t *testing.T) {
+	runner := newRunnerWithConfig(t, Config{
+		ClientCount:      1,
+		HeartbeatTimeout: 2 * time.Nanosecond,
+	})
+	require.Equal(t, MinHeartbeatTimeout, runner.conf.HeartbeatTimeout)
+	require.Positive(t, runner.heartbeatInterval())
+
+	client := &Client{ID: 0, SocketPath: runner.conf.SocketPath}
+	resp, err := client.Connect(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	require.Equal(t, MinHeartbeatTimeout/3, resp.HeartbeatInterval)
+}
@@ -0,0 +1,291 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"slices"
+	"strconv"
+)
+
+// Versions of the protocol between the Runner and its Clients. The original
+// protocol, net/rpc with gob encoding, has no version number and is used as
+// a fallback when the other side doesn't support any of these.
+const (
+	// ProtocolVersion1 is HTTP with JSON bodies, served on the same socket
+	// as the original protocol.
+	ProtocolVersion1 = 1
+)
+
+// supportedProtocolVersions are the JSON protocol versions this package
+// supports, from most to least preferred.
+var supportedProtocolVersions = []int{ProtocolVersion1}
+
+// RegisterRequest is the request body for POST /v1/register.
+type RegisterRequest struct {
+	ID               int    `json:"id"`
+	Name             string `json:"name,omitempty"`
+	ProtocolVersions []int  `json:"protocol_versions"`
+}
+
+// ExitRequest is the request body for POST /v1/clients/{id}/exit.
+type ExitRequest struct {
+	ExitStatus int `json:"exit_status"`
+}
+
+// StatusResponse is the response body for GET /v1/clients/{id}/status.
+// State is one of "wait", "start" or "interrupt".
+type StatusResponse struct {
+	State string `json:"state"`
+}
+
+// errorResponse is the response body for any errors.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+var runStateNames = map[RunState]string{
+	RunStateWait:      "wait",
+	RunStateStart:     "start",
+	RunStateInterrupt: "interrupt",
+}
+
+func parseRunState(name string) (RunState, error) {
+	for state, n := range runStateNames {
+		if n == name {
+			return state, nil
+		}
+	}
+	return RunStateWait, fmt.Errorf("unknown run state %q", name)
+}
+
+// negotiateProtocol returns the most preferred protocol version that both
+// sides support, or 0 if there isn't one.
+func negotiateProtocol(theirs []int) int {
+	for _, v := range supportedProtocolVersions {
+		if slices.Contains(theirs, v) {
+			return v
+		}
+	}
+	return 0
+}
+
+// ==== runner (server) side ====
+
+func (r *Runner) registerRoutes() {
+	r.mux.HandleFunc("POST /v1/register", r.handleRegister)
+	r.mux.HandleFunc("POST /v1/clients/{id}/logs", r.handleLogs)
+	r.mux.HandleFunc("POST /v1/clients/{id}/exit", r.handleExit)
+	r.mux.HandleFunc("POST /v1/clients/{id}/heartbeat", r.handleHeartbeat)
+	r.mux.HandleFunc("GET /v1/clients/{id}/status", r.handleStatus)
+}
+
+func (r *Runner) handleRegister(w http.ResponseWriter, req *http.Request) {
+	var body RegisterRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("decoding request body: %w", err))
+		return
+	}
+	version := negotiateProtocol(body.ProtocolVersions)
+	if version == 0 {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("no supported protocol version in %v (this agent supports %v)", body.ProtocolVersions, supportedProtocolVersions))
+		return
+	}
+	env, err := r.register(body.ID, body.Name)
+	if err != nil {
+		writeJSONError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, RegisterResponse{
+		Env:               env,
+		ProtocolVersion:   version,
+		HeartbeatInterval: r.heartbeatInterval(),
+	})
+}
+
+func (r *Runner) handleLogs(w http.ResponseWriter, req *http.Request) {
+	id, ok := r.clientID(w, req)
+	if !ok {
+		return
+	}
+	if err := r.writeLogs(id, req.Body); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, Empty{})
+}
+
+func (r *Runner) handleExit(w http.ResponseWriter, req *http.Request) {
+	id, ok := r.clientID(w, req)
+	if !ok {
+		return
+	}
+	var body ExitRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("decoding request body: %w", err))
+		return
+	}
+	if err := r.exit(id, body.ExitStatus); err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, Empty{})
+}
+
+func (r *Runner) handleHeartbeat(w http.ResponseWriter, req *http.Request) {
+	id, ok := r.clientID(w, req)
+	if !ok {
+		return
+	}
+	if err := r.heartbeat(id); err != nil {
+		writeJSONError(w, http.StatusGone, err)
+		return
+	}
+	writeJSON(w, Empty{})
+}
+
+func (r *Runner) handleStatus(w http.ResponseWriter, req *http.Request) {
+	id, ok := r.clientID(w, req)
+	if !ok {
+		return
+	}
+	state, err := r.status(id)
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, StatusResponse{State: runStateNames[state]})
+}
+
+// clientID parses the client ID from the request path. If it is invalid, it
+// writes an error response and returns false.
+func (r *Runner) clientID(w http.ResponseWriter, req *http.Request) (int, bool) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid client id: %w", err))
+		return 0, false
+	}
+	r.mu.Lock()
+	_, found := r.clients[id]
+	r.mu.Unlock()
+	if !found {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unrecognized client id: %d", id))
+		return 0, false
+	}
+	return id, true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
+
+// ==== client side ====
+
+// jsonBaseURL is the base of request URLs. The host is ignored, because
+// requests are always made over the socket.
+const jsonBaseURL = "http://kubernetes/v1"
+
+// errProtocolUnsupported is returned by registerJSON when the runner doesn't
+// support any of the client's JSON protocol versions (including when it is an
+// older agent that only supports net/rpc).
+var errProtocolUnsupported = errors.New("runner doesn't support the JSON protocol")
+
+// newHTTPClient returns an HTTP client that makes requests over the socket.
+func newHTTPClient(socketPath string) *http.Client {
+	var dialer net.Dialer
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+}
+
+// registerJSON registers with the runner using the JSON protocol.
+func (c *Client) registerJSON(ctx context.Context) (*RegisterResponse, error) {
+	var resp RegisterResponse
+	code, err := c.doJSON(ctx, http.MethodPost, "/register", RegisterRequest{
+		ID:               c.ID,
+		Name:             c.Name,
+		ProtocolVersions: supportedProtocolVersions,
+	}, &resp)
+	switch {
+	case code == http.StatusNotFound:
+		// An older agent, which only serves net/rpc.
+		return nil, errProtocolUnsupported
+	case code == http.StatusBadRequest && err != nil:
+		return nil, fmt.Errorf("%w: %w", errProtocolUnsupported, err)
+	case err != nil:
+		return nil, err
+	}
+	if negotiateProtocol([]int{resp.ProtocolVersion}) == 0 {
+		return nil, fmt.Errorf("runner chose unsupported protocol version %d", resp.ProtocolVersion)
+	}
+	return &resp, nil
+}
+
+// doJSON makes a request using the JSON protocol. If body is an io.Reader,
+// it is sent as-is, otherwise it is encoded as JSON. It returns the response
+// status code (or 0 if there was no response), and an error if the request
+// failed.
+func (c *Client) doJSON(ctx context.Context, method, path string, body, resp any) (int, error) {
+	var reqBody io.Reader
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reqBody = b
+	default:
+		buf, err := json.Marshal(b)
+		if err != nil {
+			return 0, fmt.Errorf("encoding request body: %w", err)
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, jsonBaseURL+path, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	hresp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer hresp.Body.Close()
+
+	if hresp.StatusCode != http.StatusOK {
+		var er errorResponse
+		if err := json.NewDecoder(hresp.Body).Decode(&er); err != nil || er.Error == "" {
+			return hresp.StatusCode, fmt.Errorf("runner responded with status %s", hresp.Status)
+		}
+		if hresp.StatusCode == http.StatusServiceUnavailable && er.Error == rpc.ErrShutdown.Error() {
+			return hresp.StatusCode, rpc.ErrShutdown
+		}
+		return hresp.StatusCode, errors.New(er.Error)
+	}
+
+	if resp == nil {
+		return hresp.StatusCode, nil
+	}
+	if err := json.NewDecoder(hresp.Body).Decode(resp); err != nil {
+		return hresp.StatusCode, fmt.Errorf("decoding response body: %w", err)
+	}
+	return hresp.StatusCode, nil
+}
+
+func (c *Client) clientPath(suffix string) string {
+	return fmt.Sprintf("/clients/%d/%s", c.ID, suffix)
+}
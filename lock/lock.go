@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/buildkite/agent/v3/internal/agentapi"
+	"github.com/buildkite/agent/v3/internal/system"
 )
 
 // For local sockets, we can afford to be fairly chatty. 100ms is an arbitrary
@@ -20,18 +21,67 @@ import (
 // "the blink of an eye" (for a human).
 const localSocketSleepDuration = 100 * time.Millisecond
 
+// Cluster-scoped locks involve a round trip to a shared backend on another
+// host, so poll less often.
+const clusterSleepDuration = 500 * time.Millisecond
+
+// Lock scopes.
+const (
+	// MachineScope locks are shared by agents on the same host.
+	MachineScope = "machine"
+
+	// ClusterScope locks are shared by agents on all hosts using the same
+	// cluster lock backend (see the --lock-cluster-backend agent flag).
+	ClusterScope = "cluster"
+)
+
+// ErrUnsupportedInCluster is returned by operations that are only available in
+// the machine scope.
+var ErrUnsupportedInCluster = errors.New("not supported for cluster-scope locks")
+
 // Client implements a client library for the Agent API locking service.
 type Client struct {
 	client *agentapi.Client
+	scope  string
 }
 
 // NewClient creates a new machine-scope lock service client.
 func NewClient(ctx context.Context, socketsDir string) (*Client, error) {
+	return NewScopedClient(ctx, socketsDir, MachineScope)
+}
+
+// NewScopedClient creates a new lock service client for the given scope
+// (MachineScope or ClusterScope). Either way, the client talks to the leader
+// agent on this host, which forwards cluster-scope operations to the cluster
+// lock backend.
+func NewScopedClient(ctx context.Context, socketsDir, scope string) (*Client, error) {
+	if scope != MachineScope && scope != ClusterScope {
+		return nil, fmt.Errorf("unknown lock scope %q (expected %q or %q)", scope, MachineScope, ClusterScope)
+	}
 	cli, err := agentapi.NewClient(ctx, agentapi.LeaderPath(socketsDir))
 	if err != nil {
 		return nil, err
 	}
-	return &Client{client: cli}, nil
+	if scope == ClusterScope {
+		cli = cli.ClusterScope()
+	}
+	return &Client{client: cli, scope: scope}, nil
+}
+
+// Scope returns the scope of the client's locks.
+func (c *Client) Scope() string {
+	if c.scope == "" {
+		return MachineScope
+	}
+	return c.scope
+}
+
+// pollInterval returns how long to wait between attempts to acquire a lock.
+func (c *Client) pollInterval() time.Duration {
+	if c.scope == ClusterScope {
+		return clusterSleepDuration
+	}
+	return localSocketSleepDuration
 }
 
 // Get retrieves the current state of a lock.
@@ -51,22 +101,54 @@ func (c *Client) Locker(key string) sync.Locker {
 	}
 }
 
+// LockOption configures a call to Lock.
+type LockOption func(*lockOptions)
+
+type lockOptions struct {
+	ttl           time.Duration
+	pid           int
+	releaseOnExit bool
+}
+
+// WithLease acquires the lock under a lease with the given TTL. Unless the
+// lease is renewed (see Renew and KeepAlive) before it expires, the agent
+// releases the lock.
+func WithLease(ttl time.Duration) LockOption {
+	return func(o *lockOptions) { o.ttl = ttl }
+}
+
+// WithHolderPID records pid, rather than the pid of the current process, as
+// the holder of the lock. This is useful when the lock is acquired on behalf
+// of another process, such as the shell running `buildkite-agent lock acquire`.
+func WithHolderPID(pid int) LockOption {
+	return func(o *lockOptions) { o.pid = pid }
+}
+
+// WithReleaseOnExit asks the agent to release the lock as soon as the process
+// holding it (see WithHolderPID) is no longer running, whether or not there is
+// a lease. If the holder is in a different PID namespace to the agent (for
+// example, in a container), the agent can't tell, and only the lease applies.
+// Without it, the holder's pid is only recorded for information.
+func WithReleaseOnExit() LockOption {
+	return func(o *lockOptions) { o.releaseOnExit = true }
+}
+
 // Lock blocks until the lock for the given key is acquired. It returns a
 // token or an error. The token must be passed to Unlock in order to unlock the
 // lock later on.
-func (c *Client) Lock(ctx context.Context, key string) (string, error) {
-	// The token generation only has to avoid making the same token twice to
-	// prevent separate processes unlocking each other.
-	// Using crypto/rand to generate 16 bytes is possibly overkill - it's not a
-	// goal to be cryptographically secure - but ensures the result.
-	otp := make([]byte, 16)
-	if _, err := rand.Read(otp); err != nil {
+func (c *Client) Lock(ctx context.Context, key string, opts ...LockOption) (string, error) {
+	o := lockOptions{pid: os.Getpid()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	token, err := newToken("acquired", o.pid, o.releaseOnExit)
+	if err != nil {
 		return "", err
 	}
-	token := fmt.Sprintf("acquired(pid=%d,otp=%x)", os.Getpid(), otp)
 
 	for {
-		_, done, err := c.client.LockCompareAndSwap(ctx, key, "", token)
+		_, done, err := c.client.LockCompareAndSwapLease(ctx, key, "", token, o.ttl)
 		if err != nil {
 			return "", fmt.Errorf("cas: %w", err)
 		}
@@ -76,15 +158,117 @@ func (c *Client) Lock(ctx context.Context, key string) (string, error) {
 		}
 
 		// Not done.
-		if err := sleep(ctx, localSocketSleepDuration); err != nil {
+		if err := sleep(ctx, c.pollInterval()); err != nil {
 			return "", err
 		}
 	}
 }
 
+// ErrLeaseLost is returned by Renew and KeepAlive when the lock is no longer
+// held with the given token, for example because the lease expired.
+var ErrLeaseLost = errors.New("lease lost")
+
+// Renew extends the lease on the lock for the given key to ttl from now. The
+// token must match the current lock value. It returns the new expiry time.
+// If token is a semaphore permit (from Semaphore.Acquire), the lease on the
+// permit is renewed instead.
+func (c *Client) Renew(ctx context.Context, key, token string, ttl time.Duration) (time.Time, error) {
+	if isPermit(token) {
+		return c.renewPermit(ctx, key, token, ttl)
+	}
+	val, exp, done, err := c.client.LockRenew(ctx, key, token, ttl)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("renew: %w", err)
+	}
+	if !done {
+		if val == "" {
+			return time.Time{}, fmt.Errorf("%w: lock is not held", ErrLeaseLost)
+		}
+		return time.Time{}, fmt.Errorf("%w: lock held by %q", ErrLeaseLost, val)
+	}
+	return exp, nil
+}
+
+// KeepAlive renews the lease on the lock for the given key every third of the
+// ttl, until ctx is done (in which case it returns nil) or a renewal fails.
+func (c *Client) KeepAlive(ctx context.Context, key, token string, ttl time.Duration) error {
+	for {
+		if err := sleep(ctx, ttl/3); err != nil {
+			return nil
+		}
+		if _, err := c.Renew(ctx, key, token, ttl); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Inspect describes the current state of the lock for the given key. It is
+// only supported in the machine scope.
+func (c *Client) Inspect(ctx context.Context, key string) (*agentapi.LockInfo, error) {
+	if c.scope == ClusterScope {
+		return nil, ErrUnsupportedInCluster
+	}
+	return c.client.LockInfo(ctx, key)
+}
+
+// InspectSemaphore describes the current state of the semaphore for the given
+// key. It is only supported in the machine scope.
+func (c *Client) InspectSemaphore(ctx context.Context, key string) (*agentapi.SemaphoreInfo, error) {
+	if c.scope == ClusterScope {
+		return nil, ErrUnsupportedInCluster
+	}
+	return c.client.SemaphoreInfo(ctx, key)
+}
+
+// List describes the current state of all locks and semaphores in use. It is
+// only supported in the machine scope.
+func (c *Client) List(ctx context.Context) ([]agentapi.LockInfo, []agentapi.SemaphoreInfo, error) {
+	if c.scope == ClusterScope {
+		return nil, nil, ErrUnsupportedInCluster
+	}
+	return c.client.LockList(ctx)
+}
+
+// newToken creates a token identifying one acquisition of a lock or
+// semaphore permit by the process pid. If track is set, and where they're
+// available, the token also records when the process started and its PID
+// namespace, so that the agent can tell whether the holder is still running,
+// even if the pid is reused or means something else to the agent. The agent
+// only releases locks and permits with dead holders if the token records when
+// the holder started.
+func newToken(kind string, pid int, track bool) (string, error) {
+	// The token generation only has to avoid making the same token twice to
+	// prevent separate processes unlocking each other.
+	// Using crypto/rand to generate 16 bytes is possibly overkill - it's not a
+	// goal to be cryptographically secure - but ensures the result.
+	otp := make([]byte, 16)
+	if _, err := rand.Read(otp); err != nil {
+		return "", err
+	}
+
+	holder := fmt.Sprintf("pid=%d", pid)
+	if track {
+		if start, err := system.GetProcessStartTime(pid); err == nil {
+			holder += fmt.Sprintf(",start=%d", start)
+		}
+		if ns, err := system.GetPIDNamespace(); err == nil {
+			holder += fmt.Sprintf(",pidns=%d", ns)
+		}
+	}
+	return fmt.Sprintf("%s(%s,otp=%x)", kind, holder, otp), nil
+}
+
 // Unlock unlocks the lock for the given key. To prevent different processes
 // accidentally unlocking the same lock, token must match the current lock value.
+// If token is a semaphore permit (from Semaphore.Acquire), the permit is
+// released instead.
 func (c *Client) Unlock(ctx context.Context, key, token string) error {
+	if isPermit(token) {
+		return c.releasePermit(ctx, key, token)
+	}
 	val, done, err := c.client.LockCompareAndSwap(ctx, key, token, "")
 	if err != nil {
 		return fmt.Errorf("cas: %w", err)
@@ -151,7 +335,7 @@ func (c *Client) DoOnceStart(ctx context.Context, key string) (bool, error) {
 
 		case "doing":
 			// Work in progress - wait until state "done".
-			if err := sleep(ctx, localSocketSleepDuration); err != nil {
+			if err := sleep(ctx, c.pollInterval()); err != nil {
 				return false, err
 			}
 			st, err := c.client.LockGet(ctx, key)
@@ -2,15 +2,19 @@ package lock
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/buildkite/agent/v3/internal/agentapi"
+	"github.com/buildkite/agent/v3/internal/clusterlock"
 	"github.com/buildkite/agent/v3/logger"
 )
 
@@ -142,3 +146,171 @@ func TestDoOnce(t *testing.T) {
 		t.Errorf("calls.Load() = %d, want %d", got, want)
 	}
 }
+
+func TestLockLease(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	const key = "llama"
+	const ttl = 300 * time.Millisecond
+
+	token, err := cli.Lock(ctx, key, WithLease(ttl))
+	if err != nil {
+		t.Fatalf("cli.Lock(ctx, %q, WithLease(%v)) = error %v", key, ttl, err)
+	}
+
+	// Keep the lease alive for several TTLs.
+	kctx, kcanc := context.WithTimeout(ctx, 3*ttl)
+	defer kcanc()
+	if err := cli.KeepAlive(kctx, key, token, ttl); err != nil {
+		t.Errorf("cli.KeepAlive(ctx, %q, %q, %v) = %v", key, token, ttl, err)
+	}
+	if got, err := cli.Get(ctx, key); err != nil || got != token {
+		t.Errorf("cli.Get(ctx, %q) after KeepAlive = (%q, %v), want (%q, nil)", key, got, err, token)
+	}
+
+	// Without renewals, the lease expires and the lock is released.
+	time.Sleep(ttl + 100*time.Millisecond)
+	if got, err := cli.Get(ctx, key); err != nil || got != "" {
+		t.Errorf("cli.Get(ctx, %q) after expiry = (%q, %v), want (%q, nil)", key, got, err, "")
+	}
+	if _, err := cli.Renew(ctx, key, token, ttl); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("cli.Renew(ctx, %q, %q, %v) = %v, want ErrLeaseLost", key, token, ttl, err)
+	}
+}
+
+func TestInspectList(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	const key = "alpaca"
+	pid := os.Getppid()
+	token, err := cli.Lock(ctx, key, WithHolderPID(pid))
+	if err != nil {
+		t.Fatalf("cli.Lock(ctx, %q, WithHolderPID(%d)) = error %v", key, pid, err)
+	}
+
+	info, err := cli.Inspect(ctx, key)
+	if err != nil {
+		t.Fatalf("cli.Inspect(ctx, %q) = error %v", key, err)
+	}
+	if info.Value != token || info.HolderPID != pid {
+		t.Errorf("cli.Inspect(ctx, %q) = %+v, want Value %q and HolderPID %d", key, info, token, pid)
+	}
+
+	locks, _, err := cli.List(ctx)
+	if err != nil {
+		t.Fatalf("cli.List(ctx) = error %v", err)
+	}
+	if len(locks) != 1 || locks[0].Key != key {
+		t.Errorf("cli.List(ctx) = %+v, want one lock %q", locks, key)
+	}
+}
+
+func TestReleaseOnExit(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	// A holder that exits before the locks are checked, like the subshell in
+	// token=$(buildkite-agent lock acquire ...).
+	holder := exec.Command("sleep", "60")
+	if err := holder.Start(); err != nil {
+		t.Skipf("exec.Command(sleep, 60).Start() = %v", err)
+	}
+	pid := holder.Process.Pid
+
+	tracked, err := cli.Lock(ctx, "tracked", WithHolderPID(pid), WithReleaseOnExit())
+	if err != nil {
+		t.Fatalf("cli.Lock(ctx, tracked, WithHolderPID(%d), WithReleaseOnExit()) = error %v", pid, err)
+	}
+	if !strings.Contains(tracked, ",start=") {
+		t.Skipf("cli.Lock(ctx, tracked, ...) = %q, can't get the holder's start time on this platform", tracked)
+	}
+	untracked, err := cli.Lock(ctx, "untracked", WithHolderPID(pid))
+	if err != nil {
+		t.Fatalf("cli.Lock(ctx, untracked, WithHolderPID(%d)) = error %v", pid, err)
+	}
+
+	holder.Process.Kill()
+	holder.Wait()
+
+	if got, err := cli.Get(ctx, "tracked"); err != nil || got != "" {
+		t.Errorf("cli.Get(ctx, tracked) after holder exited = (%q, %v), want (%q, nil)", got, err, "")
+	}
+	if got, err := cli.Get(ctx, "untracked"); err != nil || got != untracked {
+		t.Errorf("cli.Get(ctx, untracked) after holder exited = (%q, %v), want (%q, nil)", got, err, untracked)
+	}
+}
+
+func TestClusterScope(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	// Two servers (as if on different hosts) sharing one backend directory.
+	dir := t.TempDir()
+	var clients [2]*Client
+	for i := range clients {
+		backend, err := clusterlock.NewFileBackend(dir)
+		if err != nil {
+			t.Fatalf("clusterlock.NewFileBackend(%q) error = %v", dir, err)
+		}
+		sockPath := testSocketPath()
+		svr, err := agentapi.NewServer(sockPath, testLogger(t), agentapi.WithClusterLockBackend(backend))
+		if err != nil {
+			t.Fatalf("NewServer(%q, logger, WithClusterLockBackend) = error %v", sockPath, err)
+		}
+		if err := svr.Start(); err != nil {
+			t.Fatalf("svr.Start() = %v", err)
+		}
+		t.Cleanup(func() { svr.Close() })
+
+		cli, err := agentapi.NewClient(ctx, sockPath)
+		if err != nil {
+			t.Fatalf("NewClient(ctx, %q) = error %v", sockPath, err)
+		}
+		clients[i] = &Client{client: cli.ClusterScope(), scope: ClusterScope}
+	}
+
+	token, err := clients[0].Lock(ctx, "llama")
+	if err != nil {
+		t.Fatalf("clients[0].Lock(ctx, llama) error = %v", err)
+	}
+
+	// The lock is held as seen from the other host.
+	if got, err := clients[1].Get(ctx, "llama"); err != nil || got != token {
+		t.Errorf("clients[1].Get(ctx, llama) = (%q, %v), want (%q, nil)", got, err, token)
+	}
+	shortCtx, shortCanc := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCanc()
+	if _, err := clients[1].Lock(shortCtx, "llama"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("clients[1].Lock(shortCtx, llama) error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err := clients[0].Unlock(ctx, "llama", token); err != nil {
+		t.Errorf("clients[0].Unlock(ctx, llama, %q) = %v", token, err)
+	}
+	if _, err := clients[1].Lock(ctx, "llama"); err != nil {
+		t.Errorf("clients[1].Lock(ctx, llama) error = %v", err)
+	}
+
+	// Machine-only operations are refused.
+	if _, err := clients[0].Semaphore("alpaca", 2).Acquire(ctx); !errors.Is(err, ErrUnsupportedInCluster) {
+		t.Errorf("Semaphore(alpaca, 2).Acquire(ctx) error = %v, want %v", err, ErrUnsupportedInCluster)
+	}
+	if _, _, err := clients[0].List(ctx); !errors.Is(err, ErrUnsupportedInCluster) {
+		t.Errorf("List(ctx) error = %v, want %v", err, ErrUnsupportedInCluster)
+	}
+}
@@ -0,0 +1,127 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Semaphore is a counting semaphore provided by the Agent API locking
+// service. At most the given number of permits can be held at once. Processes
+// waiting for a permit are granted one in the order they started waiting.
+//
+// Semaphores use a separate key space to locks, so a semaphore and a lock
+// with the same key are unrelated.
+type Semaphore struct {
+	client  *Client
+	key     string
+	permits int
+}
+
+// Semaphore returns a Semaphore for the given key, with the given number of
+// permits. All users of the semaphore must agree on the number of permits;
+// acquiring a permit fails if the semaphore is currently in use with a
+// different number.
+func (c *Client) Semaphore(key string, permits int) *Semaphore {
+	return &Semaphore{
+		client:  c,
+		key:     key,
+		permits: permits,
+	}
+}
+
+// Acquire blocks until a permit is acquired. It returns a token or an error.
+// The token must be passed to Release in order to release the permit later on.
+// WithLease, WithHolderPID and WithReleaseOnExit apply to permits as they do
+// to locks.
+//
+// If ctx is done while waiting, Acquire gives up its place in the queue.
+//
+// Semaphores are only supported in the machine scope.
+func (s *Semaphore) Acquire(ctx context.Context, opts ...LockOption) (string, error) {
+	if s.client.scope == ClusterScope {
+		return "", ErrUnsupportedInCluster
+	}
+
+	o := lockOptions{pid: os.Getpid()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	token, err := newToken("permit", o.pid, o.releaseOnExit)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		done, _, err := s.client.client.SemaphoreAcquire(ctx, s.key, token, s.permits, o.ttl)
+		if err != nil {
+			s.abandon(token)
+			return "", fmt.Errorf("acquire permit: %w", err)
+		}
+
+		if done {
+			return token, nil
+		}
+
+		// Not done. Polling again also keeps our place in the queue.
+		if err := sleep(ctx, s.client.pollInterval()); err != nil {
+			s.abandon(token)
+			return "", err
+		}
+	}
+}
+
+// abandon leaves the queue after Acquire fails. If the request fails, the
+// agent drops the token from the queue anyway once it stops polling.
+func (s *Semaphore) abandon(token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, _ = s.client.client.SemaphoreRelease(ctx, s.key, token)
+}
+
+// Release releases the permit identified by token.
+func (s *Semaphore) Release(ctx context.Context, token string) error {
+	return s.client.releasePermit(ctx, s.key, token)
+}
+
+// Renew extends the lease on the permit identified by token to ttl from now.
+// It returns the new expiry time.
+func (s *Semaphore) Renew(ctx context.Context, token string, ttl time.Duration) (time.Time, error) {
+	return s.client.renewPermit(ctx, s.key, token, ttl)
+}
+
+// isPermit reports whether token was created by Semaphore.Acquire.
+func isPermit(token string) bool {
+	return strings.HasPrefix(token, "permit(")
+}
+
+func (c *Client) releasePermit(ctx context.Context, key, token string) error {
+	if c.scope == ClusterScope {
+		return ErrUnsupportedInCluster
+	}
+	done, err := c.client.SemaphoreRelease(ctx, key, token)
+	if err != nil {
+		return fmt.Errorf("release permit: %w", err)
+	}
+	if !done {
+		return fmt.Errorf("permit %q is not held", token)
+	}
+	return nil
+}
+
+func (c *Client) renewPermit(ctx context.Context, key, token string, ttl time.Duration) (time.Time, error) {
+	if c.scope == ClusterScope {
+		return time.Time{}, ErrUnsupportedInCluster
+	}
+	exp, done, err := c.client.SemaphoreRenew(ctx, key, token, ttl)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("renew: %w", err)
+	}
+	if !done {
+		return time.Time{}, fmt.Errorf("%w: permit is not held", ErrLeaseLost)
+	}
+	return exp, nil
+}
@@ -0,0 +1,116 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSemaphoreLimitsConcurrency(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	const permits, workers = 3, 10
+	sem := cli.Semaphore("emulators", permits)
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := sem.Acquire(ctx)
+			if err != nil {
+				t.Errorf("sem.Acquire(ctx) = error %v", err)
+				return
+			}
+
+			mu.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+
+			if err := sem.Release(ctx, token); err != nil {
+				t.Errorf("sem.Release(ctx, %q) = %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning > permits {
+		t.Errorf("max concurrent holders = %d, want at most %d", maxRunning, permits)
+	}
+}
+
+func TestSemaphoreFIFO(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	sem := cli.Semaphore("docker", 1)
+	first, err := sem.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("sem.Acquire(ctx) = error %v", err)
+	}
+
+	// Start waiters one at a time, so that their order in the queue is known.
+	const waiters = 4
+	order := make(chan int, waiters)
+	var wg sync.WaitGroup
+	for i := range waiters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := sem.Acquire(ctx)
+			if err != nil {
+				t.Errorf("sem.Acquire(ctx) = error %v", err)
+				return
+			}
+			order <- i
+			if err := sem.Release(ctx, token); err != nil {
+				t.Errorf("sem.Release(ctx, %q) = %v", token, err)
+			}
+		}()
+		for {
+			info, err := cli.InspectSemaphore(ctx, "docker")
+			if err != nil {
+				t.Fatalf("cli.InspectSemaphore(ctx, docker) = error %v", err)
+			}
+			if info.Waiting == i+1 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Releasing via Unlock works for permits too.
+	if err := cli.Unlock(ctx, "docker", first); err != nil {
+		t.Fatalf("cli.Unlock(ctx, docker, %q) = %v", first, err)
+	}
+
+	wg.Wait()
+	close(order)
+	want := 0
+	for got := range order {
+		if got != want {
+			t.Errorf("waiter %d acquired a permit in position %d", got, want)
+		}
+		want++
+	}
+}
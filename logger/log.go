@@ -12,6 +12,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/buildkite/agent/v3/version"
@@ -52,15 +53,19 @@ type Logger interface {
 }
 
 type ConsoleLogger struct {
-	level   Level
+	// level is shared with the copies made by WithFields, so that changing
+	// the level of a logger changes it for all of them
+	level   *atomic.Int32
 	exitFn  func(int)
 	fields  Fields
 	printer Printer
 }
 
 func NewConsoleLogger(printer Printer, exitFn func(int)) Logger {
+	level := &atomic.Int32{}
+	level.Store(int32(DEBUG))
 	return &ConsoleLogger{
-		level:   DEBUG,
+		level:   level,
 		fields:  Fields{},
 		printer: printer,
 		exitFn:  exitFn,
@@ -74,13 +79,14 @@ func (l *ConsoleLogger) WithFields(fields ...Field) Logger {
 	return &clone
 }
 
-// SetLevel sets the level in the logger
+// SetLevel sets the level in the logger, and the loggers derived from it with
+// WithFields
 func (l *ConsoleLogger) SetLevel(level Level) {
-	l.level = level
+	l.level.Store(int32(level))
 }
 
 func (l *ConsoleLogger) Debug(format string, v ...any) {
-	if l.level == DEBUG {
+	if l.Level() == DEBUG {
 		debugFields := make(Fields, len(l.fields))
 		copy(debugFields, l.fields)
 		debugFields.Add(StringField("agent_version", version.FullVersion()))
@@ -98,25 +104,25 @@ func (l *ConsoleLogger) Fatal(format string, v ...any) {
 }
 
 func (l *ConsoleLogger) Notice(format string, v ...any) {
-	if l.level <= NOTICE {
+	if l.Level() <= NOTICE {
 		l.printer.Print(NOTICE, fmt.Sprintf(format, v...), l.fields)
 	}
 }
 
 func (l *ConsoleLogger) Info(format string, v ...any) {
-	if l.level <= INFO {
+	if l.Level() <= INFO {
 		l.printer.Print(INFO, fmt.Sprintf(format, v...), l.fields)
 	}
 }
 
 func (l *ConsoleLogger) Warn(format string, v ...any) {
-	if l.level <= WARN {
+	if l.Level() <= WARN {
 		l.printer.Print(WARN, fmt.Sprintf(format, v...), l.fields)
 	}
 }
 
 func (l *ConsoleLogger) Level() Level {
-	return l.level
+	return Level(l.level.Load())
 }
 
 type Printer interface {
@@ -262,6 +268,7 @@ func (p *JSONPrinter) Print(level Level, msg string, fields Fields) {
 }
 
 var Discard = &ConsoleLogger{
+	level: &atomic.Int32{},
 	printer: &TextPrinter{
 		Writer: io.Discard,
 	},
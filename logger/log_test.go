@@ -54,6 +54,22 @@ func TestConsoleLogger(t *testing.T) {
 	}
 }
 
+func TestConsoleLoggerSetLevelWithFields(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := logger.NewConsoleLogger(logger.NewTextPrinter(b), func(int) {})
+	l.SetLevel(logger.INFO)
+	child := l.WithFields(logger.StringField("agent", "llamas"))
+
+	l.SetLevel(logger.WARN)
+	child.Info("Info %q", "llamas")
+	if got := child.Level(); got != logger.WARN {
+		t.Fatalf("child.Level() = %v, want %v", got, logger.WARN)
+	}
+	if b.Len() != 0 {
+		t.Fatalf("child logged %q after the parent's level was raised", b.String())
+	}
+}
+
 func TestTextPrinter(t *testing.T) {
 	b := &bytes.Buffer{}
 
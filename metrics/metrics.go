@@ -1,9 +1,12 @@
-// Package metrics provides a wrapper around Datadog metrics collection.
+// Package metrics provides a wrapper around Datadog and OpenTelemetry (OTLP)
+// metrics collection.
 //
 // It is intended for internal use by buildkite-agent only.
 package metrics
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"sort"
@@ -21,18 +24,30 @@ const (
 
 	// The default port for dogstatsd
 	defaultDogStatsdPort = 8125
+
+	// The namespace of all metric names
+	namespace = "buildkite."
 )
 
 type Collector struct {
 	config CollectorConfig
 	logger logger.Logger
 	client *statsd.Client
+	otlp   *otlpMetrics
 }
 
 type CollectorConfig struct {
 	Datadog              bool
 	DatadogHost          string
 	DatadogDistributions bool
+
+	// OTLP enables exporting metrics with OTLP. The exporter is configured
+	// with the standard OTEL_EXPORTER_OTLP_* environment variables.
+	OTLP bool
+
+	// OTLPResourceAttributes are added to the resource metrics are exported
+	// with over OTLP, such as the agent's tags.
+	OTLPResourceAttributes map[string]string
 }
 
 func NewCollector(l logger.Logger, c CollectorConfig) *Collector {
@@ -44,6 +59,9 @@ func NewCollector(l logger.Logger, c CollectorConfig) *Collector {
 
 var portSuffixRegexp = regexp.MustCompile(`:\d+$`)
 
+// Start starts sending metrics to the configured backends. A collector can be
+// shared, but it should only be started once, and stopped once it's no longer
+// used.
 func (c *Collector) Start() error {
 	if c.config.Datadog {
 		if !portSuffixRegexp.MatchString(c.config.DatadogHost) {
@@ -55,21 +73,35 @@ func (c *Collector) Start() error {
 		var err error
 		c.client, err = statsd.New(c.config.DatadogHost,
 			statsd.WithMaxMessagesPerPayload(statsdBufferLen),
-			statsd.WithNamespace("buildkite."),
+			statsd.WithNamespace(namespace),
 		)
 		if err != nil {
 			return err
 		}
 	}
+	if c.config.OTLP {
+		c.logger.Info("Starting OTLP metrics collection")
+
+		var err error
+		c.otlp, err = newOTLPMetrics(context.Background(), c.config.OTLPResourceAttributes)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (c *Collector) Stop() error {
+	var errs []error
 	if c.config.Datadog && c.client != nil {
 		c.logger.Info("Stopping metrics collection")
-		return c.client.Close()
+		errs = append(errs, c.client.Close())
 	}
-	return nil
+	if c.config.OTLP && c.otlp != nil {
+		c.logger.Info("Stopping OTLP metrics collection")
+		errs = append(errs, c.otlp.shutdown(context.Background()))
+	}
+	return errors.Join(errs...)
 }
 
 func (c *Collector) Scope(tags Tags) *Scope {
@@ -86,13 +118,23 @@ type Scope struct {
 
 // Timing sends timing information in milliseconds.
 func (s *Scope) Timing(name string, value time.Duration, tags ...Tags) {
-	if s.c.client == nil {
+	if s.c.client == nil && s.c.otlp == nil {
 		return
 	}
 
-	mergedTags := s.mergeTags(tags...).StringSlice()
+	merged := s.mergeTags(tags...)
+	mergedTags := merged.StringSlice()
 	s.c.logger.Debug("Metrics timing %s=%v %v", name, value, mergedTags)
 
+	if s.c.otlp != nil {
+		if err := s.c.otlp.timing(namespace+name, value, merged); err != nil {
+			s.c.logger.Error("OTLP metrics timing failed: %v", err)
+		}
+	}
+	if s.c.client == nil {
+		return
+	}
+
 	var err error
 	if s.c.config.DatadogDistributions {
 		// Datadog recommends that, as distributions are a new distinct metric,
@@ -120,13 +162,23 @@ func (s *Scope) With(tags Tags) *Scope {
 
 // Count tracks how many times something happened per second.
 func (s *Scope) Count(name string, value int64, tags ...Tags) {
-	if s.c.client == nil {
+	if s.c.client == nil && s.c.otlp == nil {
 		return
 	}
 
-	mergedTags := s.mergeTags(tags...).StringSlice()
+	merged := s.mergeTags(tags...)
+	mergedTags := merged.StringSlice()
 	s.c.logger.Debug("Metrics count %s=%v %v", name, value, mergedTags)
 
+	if s.c.otlp != nil {
+		if err := s.c.otlp.count(namespace+name, value, merged); err != nil {
+			s.c.logger.Error("OTLP metrics count failed: %v", err)
+		}
+	}
+	if s.c.client == nil {
+		return
+	}
+
 	if err := s.c.client.Count(name, value, mergedTags, 1); err != nil {
 		s.c.logger.Error("Metrics count failed: %v", err)
 	}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent/v3/version"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// The values of OTEL_EXPORTER_OTLP_PROTOCOL that are supported.
+const (
+	otlpProtocolGRPC = "grpc"
+	otlpProtocolHTTP = "http/protobuf"
+)
+
+// otlpMetrics exports metrics with OTLP, using instruments named the same as
+// the Datadog metrics (including the "buildkite." namespace).
+type otlpMetrics struct {
+	provider *sdkmetric.MeterProvider
+	meter    metric.Meter
+
+	mu         sync.Mutex
+	counters   map[string]metric.Int64Counter
+	histograms map[string]metric.Float64Histogram
+}
+
+// otlpProtocol returns the OTLP protocol to export metrics with, from the
+// standard environment variables.
+func otlpProtocol() (string, error) {
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	switch protocol {
+	case "", otlpProtocolGRPC:
+		return otlpProtocolGRPC, nil
+	case otlpProtocolHTTP:
+		return otlpProtocolHTTP, nil
+	default:
+		return "", fmt.Errorf("unsupported OTLP protocol %q, must be %q or %q", protocol, otlpProtocolGRPC, otlpProtocolHTTP)
+	}
+}
+
+// newOTLPMetrics creates an OTLP exporter configured by the standard
+// OTEL_EXPORTER_OTLP_* environment variables, and a meter provider that
+// exports through it periodically.
+func newOTLPMetrics(ctx context.Context, resourceAttributes map[string]string) (*otlpMetrics, error) {
+	protocol, err := otlpProtocol()
+	if err != nil {
+		return nil, err
+	}
+
+	var exporter sdkmetric.Exporter
+	switch protocol {
+	case otlpProtocolGRPC:
+		exporter, err = otlpmetricgrpc.New(ctx)
+	case otlpProtocolHTTP:
+		exporter, err = otlpmetrichttp.New(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP metrics exporter: %w", err)
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.ServiceNameKey.String("buildkite-agent"),
+		semconv.ServiceVersionKey.String(version.Version()),
+	}
+	for k, v := range resourceAttributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(resource.NewWithAttributes(semconv.SchemaURL, attrs...)),
+	)
+	return &otlpMetrics{
+		provider:   provider,
+		meter:      provider.Meter("github.com/buildkite/agent/v3/metrics", metric.WithInstrumentationVersion(version.Version())),
+		counters:   make(map[string]metric.Int64Counter),
+		histograms: make(map[string]metric.Float64Histogram),
+	}, nil
+}
+
+func (o *otlpMetrics) count(name string, value int64, tags Tags) error {
+	o.mu.Lock()
+	counter, ok := o.counters[name]
+	if !ok {
+		var err error
+		counter, err = o.meter.Int64Counter(name)
+		if err != nil {
+			o.mu.Unlock()
+			return err
+		}
+		o.counters[name] = counter
+	}
+	o.mu.Unlock()
+
+	counter.Add(context.Background(), value, metric.WithAttributes(tags.attributes()...))
+	return nil
+}
+
+func (o *otlpMetrics) timing(name string, value time.Duration, tags Tags) error {
+	o.mu.Lock()
+	histogram, ok := o.histograms[name]
+	if !ok {
+		var err error
+		histogram, err = o.meter.Float64Histogram(name, metric.WithUnit("ms"))
+		if err != nil {
+			o.mu.Unlock()
+			return err
+		}
+		o.histograms[name] = histogram
+	}
+	o.mu.Unlock()
+
+	histogram.Record(context.Background(), float64(value.Milliseconds()), metric.WithAttributes(tags.attributes()...))
+	return nil
+}
+
+// shutdown exports any remaining metrics, and stops the exporter.
+func (o *otlpMetrics) shutdown(ctx context.Context) error {
+	return o.provider.Shutdown(ctx)
+}
+
+// attributes returns the tags as OpenTelemetry attributes.
+func (tags Tags) attributes() []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(tags))
+	for k, v := range tags {
+		if k != "" && v != "" {
+			attrs = append(attrs, attribute.String(k, v))
+		}
+	}
+	return attrs
+}
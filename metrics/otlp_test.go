@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is an in-process OTLP/HTTP metrics collector.
+type collector struct {
+	mu       sync.Mutex
+	requests []*colmetricpb.ExportMetricsServiceRequest
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &colmetricpb.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	c.mu.Unlock()
+
+	resp, _ := proto.Marshal(&colmetricpb.ExportMetricsServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+func stringAttrs(kvs []*commonpb.KeyValue) map[string]string {
+	attrs := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		attrs[kv.Key] = kv.Value.GetStringValue()
+	}
+	return attrs
+}
+
+func TestCollectorOTLP(t *testing.T) {
+	coll := &collector{}
+	svr := httptest.NewServer(coll)
+	t.Cleanup(svr.Close)
+
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf")
+	t.Setenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", svr.URL+"/v1/metrics")
+
+	c := NewCollector(logger.Discard, CollectorConfig{
+		OTLP:                   true,
+		OTLPResourceAttributes: map[string]string{"queue": "default"},
+	})
+	if err := c.Start(); err != nil {
+		t.Fatalf("c.Start() error = %v", err)
+	}
+
+	scope := c.Scope(Tags{"agent_name": "llama_1"}).With(Tags{"pipeline": "my-pipeline"})
+	scope.Count("jobs.success", 2)
+	scope.Timing("jobs.duration.success", 3*time.Second, Tags{"exit_code": "0"})
+
+	// Stopping exports the metrics recorded so far.
+	if err := c.Stop(); err != nil {
+		t.Fatalf("c.Stop() error = %v", err)
+	}
+
+	coll.mu.Lock()
+	defer coll.mu.Unlock()
+	if len(coll.requests) == 0 {
+		t.Fatalf("collector received no requests")
+	}
+
+	got := make(map[string]map[string]string)
+	var resourceAttrs map[string]string
+	for _, req := range coll.requests {
+		for _, rm := range req.ResourceMetrics {
+			resourceAttrs = stringAttrs(rm.Resource.Attributes)
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					if sum := m.GetSum(); sum != nil {
+						if v := sum.DataPoints[0].GetAsInt(); v != 2 {
+							t.Errorf("%s value = %d, want 2", m.Name, v)
+						}
+						got[m.Name] = stringAttrs(sum.DataPoints[0].Attributes)
+					}
+					if hist := m.GetHistogram(); hist != nil {
+						if v := hist.DataPoints[0].GetSum(); v != 3000 {
+							t.Errorf("%s sum = %v, want 3000", m.Name, v)
+						}
+						got[m.Name] = stringAttrs(hist.DataPoints[0].Attributes)
+					}
+				}
+			}
+		}
+	}
+
+	// The tags are formatted the same way as for Datadog.
+	want := map[string]map[string]string{
+		"buildkite.jobs.success": {
+			"agent_name": "llama_1",
+			"pipeline":   "my_pipeline",
+		},
+		"buildkite.jobs.duration.success": {
+			"agent_name": "llama_1",
+			"pipeline":   "my_pipeline",
+			"exit_code":  "0",
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("exported metrics attributes diff (-got +want):\n%s", diff)
+	}
+	if got, want := resourceAttrs["queue"], "default"; got != want {
+		t.Errorf("resource attribute queue = %q, want %q", got, want)
+	}
+}
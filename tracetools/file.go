@@ -0,0 +1,319 @@
+package tracetools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// The formats that the file backend can write traces in.
+const (
+	// FileFormatChrome is the Chrome trace event format, which can be opened
+	// in Perfetto (https://ui.perfetto.dev) or chrome://tracing.
+	FileFormatChrome = "chrome"
+
+	// FileFormatOTLPJSON is the JSON encoding of OTLP, which can be sent to an
+	// OpenTelemetry collector later.
+	FileFormatOTLPJSON = "otlp-json"
+)
+
+// FileFormats are the valid values for the file backend's format.
+var FileFormats = []string{FileFormatChrome, FileFormatOTLPJSON}
+
+// FileExporter is an OpenTelemetry span exporter that collects spans in
+// memory, and writes them all to a file when it's shut down. It's meant for
+// traces of a single job, which are small enough to keep in memory.
+type FileExporter struct {
+	path   string
+	format string
+
+	mu       sync.Mutex
+	spans    []sdktrace.ReadOnlySpan
+	shutdown bool
+}
+
+// NewFileExporter returns a FileExporter that writes to path in the given
+// format.
+func NewFileExporter(path, format string) (*FileExporter, error) {
+	if !slices.Contains(FileFormats, format) {
+		return nil, fmt.Errorf("invalid trace file format %q. Must be one of: %v", format, FileFormats)
+	}
+	return &FileExporter{path: path, format: format}, nil
+}
+
+// ExportSpans collects the spans, to be written when the exporter is shut
+// down.
+func (e *FileExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.shutdown {
+		return nil
+	}
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// Shutdown writes the collected spans to the file. Spans exported after
+// Shutdown are dropped.
+func (e *FileExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.shutdown {
+		return nil
+	}
+	e.shutdown = true
+
+	// Spans are exported as they end, so sort them into the order they
+	// started in, which is easier to read.
+	slices.SortStableFunc(e.spans, func(a, b sdktrace.ReadOnlySpan) int {
+		return a.StartTime().Compare(b.StartTime())
+	})
+
+	var doc any
+	switch e.format {
+	case FileFormatChrome:
+		doc = chromeTrace(e.spans)
+	case FileFormatOTLPJSON:
+		doc = otlpJSONTrace(e.spans)
+	}
+
+	f, err := os.Create(e.path)
+	if err != nil {
+		return fmt.Errorf("creating trace file: %w", err)
+	}
+	if err := json.NewEncoder(f).Encode(doc); err != nil {
+		f.Close()
+		return fmt.Errorf("writing trace file: %w", err)
+	}
+	return f.Close()
+}
+
+// chromeEvent is an event in the Chrome trace event format. See
+// https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type chromeEvent struct {
+	Name      string         `json:"name"`
+	Category  string         `json:"cat,omitempty"`
+	Phase     string         `json:"ph"`
+	Timestamp float64        `json:"ts"` // microseconds
+	Duration  float64        `json:"dur,omitempty"`
+	PID       int            `json:"pid"`
+	TID       int            `json:"tid"`
+	Args      map[string]any `json:"args,omitempty"`
+}
+
+type chromeTraceFile struct {
+	TraceEvents     []chromeEvent `json:"traceEvents"`
+	DisplayTimeUnit string        `json:"displayTimeUnit"`
+}
+
+// chromeTrace converts the spans to Chrome trace events. Each span becomes a
+// complete ("X") event; viewers nest them by their times.
+func chromeTrace(spans []sdktrace.ReadOnlySpan) chromeTraceFile {
+	events := make([]chromeEvent, 0, len(spans)+1)
+
+	if len(spans) > 0 {
+		if name, ok := spans[0].Resource().Set().Value(semconv.ServiceNameKey); ok {
+			events = append(events, chromeEvent{
+				Name:  "process_name",
+				Phase: "M",
+				PID:   1,
+				TID:   1,
+				Args:  map[string]any{"name": name.AsString()},
+			})
+		}
+	}
+
+	for _, span := range spans {
+		args := make(map[string]any, len(span.Attributes())+1)
+		for _, kv := range span.Attributes() {
+			args[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		if status := span.Status(); status.Code == codes.Error {
+			args["error"] = status.Description
+			for _, event := range span.Events() {
+				for _, kv := range event.Attributes {
+					if kv.Key == semconv.ExceptionMessageKey {
+						args["error"] = kv.Value.AsString()
+					}
+				}
+			}
+		}
+
+		events = append(events, chromeEvent{
+			Name:      span.Name(),
+			Category:  span.InstrumentationScope().Name,
+			Phase:     "X",
+			Timestamp: float64(span.StartTime().UnixNano()) / 1e3,
+			Duration:  float64(span.EndTime().Sub(span.StartTime()).Nanoseconds()) / 1e3,
+			PID:       1,
+			TID:       1,
+			Args:      args,
+		})
+	}
+
+	return chromeTraceFile{TraceEvents: events, DisplayTimeUnit: "ms"}
+}
+
+// The OTLP JSON encoding, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding. It's
+// written out here rather than using protojson, because OTLP encodes IDs as
+// hex rather than base64.
+type (
+	otlpTraceFile struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+
+	otlpEvent struct {
+		TimeUnixNano string         `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// otlpJSONTrace converts the spans to OTLP, grouped by instrumentation scope.
+func otlpJSONTrace(spans []sdktrace.ReadOnlySpan) otlpTraceFile {
+	if len(spans) == 0 {
+		return otlpTraceFile{ResourceSpans: []otlpResourceSpans{}}
+	}
+
+	var scopes []instrumentation.Scope
+	byScope := make(map[instrumentation.Scope][]otlpSpan)
+	for _, span := range spans {
+		scope := span.InstrumentationScope()
+		if _, ok := byScope[scope]; !ok {
+			scopes = append(scopes, scope)
+		}
+		byScope[scope] = append(byScope[scope], otlpSpanFrom(span))
+	}
+
+	scopeSpans := make([]otlpScopeSpans, 0, len(scopes))
+	for _, scope := range scopes {
+		scopeSpans = append(scopeSpans, otlpScopeSpans{
+			Scope: otlpScope{Name: scope.Name, Version: scope.Version},
+			Spans: byScope[scope],
+		})
+	}
+
+	// All the spans come from the same tracer provider, so share a resource.
+	return otlpTraceFile{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   otlpResource{Attributes: otlpAttributes(spans[0].Resource().Attributes())},
+			ScopeSpans: scopeSpans,
+		}},
+	}
+}
+
+func otlpSpanFrom(span sdktrace.ReadOnlySpan) otlpSpan {
+	s := otlpSpan{
+		TraceID:           span.SpanContext().TraceID().String(),
+		SpanID:            span.SpanContext().SpanID().String(),
+		Name:              span.Name(),
+		Kind:              int(span.SpanKind()), // The OTLP enum has the same values
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime().UnixNano(), 10),
+		Attributes:        otlpAttributes(span.Attributes()),
+	}
+	if span.Parent().IsValid() {
+		s.ParentSpanID = span.Parent().SpanID().String()
+	}
+	for _, event := range span.Events() {
+		s.Events = append(s.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(event.Time.UnixNano(), 10),
+			Name:         event.Name,
+			Attributes:   otlpAttributes(event.Attributes),
+		})
+	}
+
+	// OpenTelemetry's codes are Unset, Error, Ok, and OTLP's are Unset, Ok,
+	// Error.
+	switch span.Status().Code {
+	case codes.Ok:
+		s.Status.Code = 1
+	case codes.Error:
+		s.Status.Code = 2
+		s.Status.Message = span.Status().Description
+	}
+	return s
+}
+
+func otlpAttributes(kvs []attribute.KeyValue) []otlpKeyValue {
+	attrs := make([]otlpKeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		var value otlpAnyValue
+		switch kv.Value.Type() {
+		case attribute.BOOL:
+			b := kv.Value.AsBool()
+			value.BoolValue = &b
+		case attribute.INT64:
+			i := strconv.FormatInt(kv.Value.AsInt64(), 10)
+			value.IntValue = &i
+		case attribute.FLOAT64:
+			f := kv.Value.AsFloat64()
+			value.DoubleValue = &f
+		default:
+			s := kv.Value.Emit()
+			value.StringValue = &s
+		}
+		attrs = append(attrs, otlpKeyValue{Key: string(kv.Key), Value: value})
+	}
+	return attrs
+}
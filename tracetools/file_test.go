@@ -0,0 +1,111 @@
+package tracetools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// writeTraceFile records a job span with a failed command span inside it, and
+// returns the trace file written in the given format.
+func writeTraceFile(t *testing.T, format string) []byte {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "trace.json")
+	exporter, err := NewFileExporter(path, format)
+	if err != nil {
+		t.Fatalf("NewFileExporter(%q, %q) error = %v", path, format, err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String("buildkite-agent"),
+		)),
+	)
+
+	ctx, job := provider.Tracer("buildkite-agent").Start(context.Background(), "job")
+	job.SetAttributes(attribute.Int("buildkite.retry", 2))
+	span := &OpenTelemetrySpan{}
+	_, span.Span = provider.Tracer("buildkite-agent").Start(ctx, "command")
+	span.AddAttributes(map[string]string{"hook.name": "command"})
+	span.FinishWithError(errors.New("exit status 1"))
+	job.End()
+
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatalf("provider.Shutdown() error = %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error = %v", path, err)
+	}
+	return b
+}
+
+func TestFileExporterChrome(t *testing.T) {
+	var trace chromeTraceFile
+	if err := json.Unmarshal(writeTraceFile(t, FileFormatChrome), &trace); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	if assert.Len(t, trace.TraceEvents, 3) {
+		assert.Equal(t, "process_name", trace.TraceEvents[0].Name)
+		assert.Equal(t, map[string]any{"name": "buildkite-agent"}, trace.TraceEvents[0].Args)
+
+		// Spans are in the order they started, not the order they ended.
+		job, command := trace.TraceEvents[1], trace.TraceEvents[2]
+		assert.Equal(t, "job", job.Name)
+		assert.Equal(t, "X", job.Phase)
+		assert.Equal(t, map[string]any{"buildkite.retry": float64(2)}, job.Args)
+		assert.Equal(t, "command", command.Name)
+		assert.Equal(t, map[string]any{"hook.name": "command", "error": "exit status 1"}, command.Args)
+		assert.LessOrEqual(t, job.Timestamp, command.Timestamp)
+		assert.GreaterOrEqual(t, job.Timestamp+job.Duration, command.Timestamp+command.Duration)
+	}
+}
+
+func TestFileExporterOTLPJSON(t *testing.T) {
+	var trace otlpTraceFile
+	if err := json.Unmarshal(writeTraceFile(t, FileFormatOTLPJSON), &trace); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	if !assert.Len(t, trace.ResourceSpans, 1) || !assert.Len(t, trace.ResourceSpans[0].ScopeSpans, 1) {
+		return
+	}
+	rs := trace.ResourceSpans[0]
+	assert.Contains(t, rs.Resource.Attributes, otlpKeyValue{Key: "service.name", Value: otlpAnyValue{StringValue: ptr("buildkite-agent")}})
+
+	spans := rs.ScopeSpans[0].Spans
+	if assert.Len(t, spans, 2) {
+		job, command := spans[0], spans[1]
+		assert.Equal(t, "job", job.Name)
+		assert.Equal(t, []otlpKeyValue{{Key: "buildkite.retry", Value: otlpAnyValue{IntValue: ptr("2")}}}, job.Attributes)
+		assert.Len(t, job.TraceID, 32, "trace ID should be hex")
+		assert.Empty(t, job.ParentSpanID)
+
+		assert.Equal(t, "command", command.Name)
+		assert.Equal(t, job.TraceID, command.TraceID)
+		assert.Equal(t, job.SpanID, command.ParentSpanID)
+		assert.Equal(t, otlpStatus{Code: 2, Message: "failed"}, command.Status)
+		if assert.Len(t, command.Events, 1) {
+			assert.Equal(t, "exception", command.Events[0].Name)
+		}
+	}
+}
+
+func TestNewFileExporterInvalidFormat(t *testing.T) {
+	if _, err := NewFileExporter("trace.json", "zipkin"); err == nil {
+		t.Errorf(`NewFileExporter("trace.json", "zipkin") error = nil, want an error`)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
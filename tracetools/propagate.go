@@ -2,10 +2,18 @@ package tracetools
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/gob"
 
 	"github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/contrib/propagators/ot"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
@@ -13,6 +21,28 @@ import (
 // encoded trace context information into env var maps.
 const EnvVarTraceContextKey = "BUILDKITE_TRACE_CONTEXT"
 
+// EnvVarTraceParentKey and EnvVarTraceStateKey are the env var keys used for
+// the W3C trace context (https://www.w3.org/TR/trace-context/), for tools that
+// follow the OpenTelemetry conventions for propagating it through the
+// environment.
+const (
+	EnvVarTraceParentKey = "TRACEPARENT"
+	EnvVarTraceStateKey  = "TRACESTATE"
+)
+
+// OpenTelemetryPropagator returns the propagator used for the OpenTelemetry
+// backend, which understands all the common trace context formats.
+func OpenTelemetryPropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+		b3.New(),
+		&jaeger.Jaeger{},
+		&ot.OT{},
+		&xray.Propagator{},
+	)
+}
+
 // EncodeTraceContext will serialize and encode tracing data into a string and place
 // it into the given env vars map.
 func EncodeTraceContext(span opentracing.Span, env map[string]string) error {
@@ -20,7 +50,63 @@ func EncodeTraceContext(span opentracing.Span, env map[string]string) error {
 	if err := span.Tracer().Inject(span.Context(), opentracing.TextMap, &textmap); err != nil {
 		return err
 	}
+	return encodeTextMap(textmap, env)
+}
+
+// EncodeOpenTelemetryTraceContext is the OpenTelemetry equivalent of
+// EncodeTraceContext. It encodes the trace context of the span in ctx, using
+// the global propagator.
+func EncodeOpenTelemetryTraceContext(ctx context.Context, env map[string]string) error {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return encodeTextMap(carrier, env)
+}
+
+// EncodeW3CTraceContext sets the TRACEPARENT and TRACESTATE env vars from the
+// OpenTelemetry span in ctx.
+func EncodeW3CTraceContext(ctx context.Context, env map[string]string) {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	traceparent := carrier.Get("traceparent")
+	if traceparent == "" {
+		return
+	}
+	env[EnvVarTraceParentKey] = traceparent
+	if tracestate := carrier.Get("tracestate"); tracestate != "" {
+		env[EnvVarTraceStateKey] = tracestate
+	} else {
+		// Don't leave behind the state of some other trace.
+		delete(env, EnvVarTraceStateKey)
+	}
+}
+
+// InjectTraceContext encodes the trace context of the span in ctx into env
+// for the given tracing backend, so that a child process can continue the
+// trace. For the OpenTelemetry backend, it also sets TRACEPARENT and
+// TRACESTATE. It does nothing if ctx has no span.
+func InjectTraceContext(ctx context.Context, tracingBackend string, env map[string]string) error {
+	switch tracingBackend {
+	case BackendDatadog:
+		span := opentracing.SpanFromContext(ctx)
+		if span == nil {
+			return nil
+		}
+		return EncodeTraceContext(span, env)
+
+	case BackendOpenTelemetry:
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			return nil
+		}
+		EncodeW3CTraceContext(ctx, env)
+		return EncodeOpenTelemetryTraceContext(ctx, env)
+
+	default:
+		return nil
+	}
+}
 
+// encodeTextMap gob-encodes the text map into env.
+func encodeTextMap(textmap map[string]string, env map[string]string) error {
 	buf := bytes.NewBuffer([]byte{})
 	enc := gob.NewEncoder(buf)
 	if err := enc.Encode(textmap); err != nil {
@@ -34,6 +120,51 @@ func EncodeTraceContext(span opentracing.Span, env map[string]string) error {
 // DecodeTraceContext will decode, deserialize, and extract the tracing data from the
 // given env var map.
 func DecodeTraceContext(env map[string]string) (opentracing.SpanContext, error) {
+	textmap, err := decodeTextMap(env)
+	if err != nil {
+		return nil, err
+	}
+	return opentracing.GlobalTracer().Extract(opentracing.TextMap, opentracing.TextMapCarrier(textmap))
+}
+
+// DecodeOpenTelemetryTraceContext is the OpenTelemetry equivalent of
+// DecodeTraceContext. It returns ctx with the remote trace context from env,
+// extracted using the global propagator, so spans started from it continue
+// that trace. If there is no valid trace context encoded by
+// EncodeOpenTelemetryTraceContext, it falls back to TRACEPARENT and
+// TRACESTATE. If there is neither, it returns ctx.
+func DecodeOpenTelemetryTraceContext(ctx context.Context, env map[string]string) context.Context {
+	if textmap, err := decodeTextMap(env); err == nil {
+		extracted := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(textmap))
+		if trace.SpanContextFromContext(extracted).IsValid() {
+			return extracted
+		}
+	}
+	return DecodeW3CTraceContext(ctx, env)
+}
+
+// DecodeW3CTraceContext returns ctx with the remote trace context from the
+// TRACEPARENT and TRACESTATE env vars. If TRACEPARENT is missing or invalid,
+// it returns ctx.
+func DecodeW3CTraceContext(ctx context.Context, env map[string]string) context.Context {
+	traceparent := env[EnvVarTraceParentKey]
+	if traceparent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{
+		"traceparent": traceparent,
+		"tracestate":  env[EnvVarTraceStateKey],
+	})
+}
+
+// HasTraceContext reports whether env contains a trace context for any
+// backend.
+func HasTraceContext(env map[string]string) bool {
+	return env[EnvVarTraceContextKey] != "" || env[EnvVarTraceParentKey] != ""
+}
+
+// decodeTextMap decodes the text map encoded in env by encodeTextMap.
+func decodeTextMap(env map[string]string) (map[string]string, error) {
 	s, has := env[EnvVarTraceContextKey]
 	if !has {
 		return nil, opentracing.ErrSpanContextNotFound
@@ -46,10 +177,9 @@ func DecodeTraceContext(env map[string]string) (opentracing.SpanContext, error)
 
 	buf := bytes.NewBuffer(contextBytes)
 	dec := gob.NewDecoder(buf)
-	textmap := opentracing.TextMapCarrier{}
+	textmap := map[string]string{}
 	if err := dec.Decode(&textmap); err != nil {
 		return nil, err
 	}
-
-	return opentracing.GlobalTracer().Extract(opentracing.TextMap, textmap)
+	return textmap, nil
 }
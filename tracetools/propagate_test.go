@@ -2,12 +2,17 @@ package tracetools
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/gob"
 	"testing"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // nullLogger is meant to make Datadog tracing logs go nowhere during tests.
@@ -44,3 +49,68 @@ func TestDecodeTraceContext(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestOpenTelemetryTraceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	oldProvider, oldPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(OpenTelemetryPropagator())
+	t.Cleanup(func() {
+		otel.SetTracerProvider(oldProvider)
+		otel.SetTextMapPropagator(oldPropagator)
+	})
+
+	// Without a span, nothing is injected.
+	env := map[string]string{}
+	if err := InjectTraceContext(context.Background(), BackendOpenTelemetry, env); err != nil {
+		t.Fatalf("InjectTraceContext(ctx, %q, env) error = %v", BackendOpenTelemetry, err)
+	}
+	assert.Empty(t, env)
+
+	// Pretend this is the agent.
+	parent, ctx := StartSpanFromContext(context.Background(), "job", BackendOpenTelemetry)
+	if err := InjectTraceContext(ctx, BackendOpenTelemetry, env); err != nil {
+		t.Fatalf("InjectTraceContext(ctx, %q, env) error = %v", BackendOpenTelemetry, err)
+	}
+	parent.FinishWithError(nil)
+
+	// Pretend this is the bootstrap.
+	child, _ := StartSpanFromTraceContext(context.Background(), "job.run", BackendOpenTelemetry, env)
+	child.FinishWithError(nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("len(recorder.Ended()) = %d, want 2", len(spans))
+	}
+	assert.Equal(t, spans[0].SpanContext().TraceID(), spans[1].SpanContext().TraceID())
+	assert.Equal(t, spans[0].SpanContext().SpanID(), spans[1].Parent().SpanID())
+
+	// The W3C trace context is set too, for other tools.
+	wantTraceParent := "00-" + spans[0].SpanContext().TraceID().String() + "-" + spans[0].SpanContext().SpanID().String() + "-01"
+	assert.Equal(t, wantTraceParent, env[EnvVarTraceParentKey])
+}
+
+func TestDecodeW3CTraceContext(t *testing.T) {
+	oldPropagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(OpenTelemetryPropagator())
+	t.Cleanup(func() { otel.SetTextMapPropagator(oldPropagator) })
+
+	// An incoming trace context, for example from a triggering build.
+	env := map[string]string{
+		EnvVarTraceParentKey: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		EnvVarTraceStateKey:  "congo=t61rcWkgMzE",
+	}
+	assert.True(t, HasTraceContext(env))
+
+	sc := trace.SpanContextFromContext(DecodeOpenTelemetryTraceContext(context.Background(), env))
+	assert.True(t, sc.IsRemote())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID().String())
+	assert.Equal(t, "congo=t61rcWkgMzE", sc.TraceState().String())
+
+	// An invalid trace context is ignored.
+	env[EnvVarTraceParentKey] = "llamas"
+	sc = trace.SpanContextFromContext(DecodeOpenTelemetryTraceContext(context.Background(), env))
+	assert.False(t, sc.IsValid())
+}
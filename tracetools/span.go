@@ -15,12 +15,14 @@ import (
 const (
 	BackendDatadog       = "datadog"
 	BackendOpenTelemetry = "opentelemetry"
+	BackendFile          = "file"
 	BackendNone          = ""
 )
 
 var ValidTracingBackends = map[string]struct{}{
 	BackendDatadog:       {},
 	BackendOpenTelemetry: {},
+	BackendFile:          {},
 	BackendNone:          {},
 }
 
@@ -34,7 +36,9 @@ func StartSpanFromContext(ctx context.Context, operation string, tracingBackend
 		span.SetTag(ddext.AnalyticsEvent, true) // Make the span available for analytics in Datadog
 		return NewOpenTracingSpan(span), ctx
 
-	case BackendOpenTelemetry:
+	case BackendOpenTelemetry, BackendFile:
+		// The file backend records spans with OpenTelemetry, and exports them
+		// to a file rather than a collector.
 		ctx, span := otel.Tracer("buildkite-agent").Start(ctx, operation)
 		span.SetAttributes(attribute.String("analytics.event", "true"))
 		return &OpenTelemetrySpan{Span: span}, ctx
@@ -47,6 +51,29 @@ func StartSpanFromContext(ctx context.Context, operation string, tracingBackend
 	}
 }
 
+// StartSpanFromTraceContext is like StartSpanFromContext, but if env contains
+// a trace context encoded by EncodeTraceContext or
+// EncodeOpenTelemetryTraceContext, the span continues that trace.
+func StartSpanFromTraceContext(ctx context.Context, operation string, tracingBackend string, env map[string]string) (Span, context.Context) {
+	switch tracingBackend {
+	case BackendDatadog:
+		sctx, err := DecodeTraceContext(env)
+		if err != nil {
+			return StartSpanFromContext(ctx, operation, tracingBackend)
+		}
+		span := opentracing.StartSpan(operation, opentracing.ChildOf(sctx))
+		span.SetTag(ddext.AnalyticsEvent, true)
+		return NewOpenTracingSpan(span), opentracing.ContextWithSpan(ctx, span)
+
+	case BackendOpenTelemetry:
+		ctx = DecodeOpenTelemetryTraceContext(ctx, env)
+		return StartSpanFromContext(ctx, operation, tracingBackend)
+
+	default:
+		return StartSpanFromContext(ctx, operation, tracingBackend)
+	}
+}
+
 type Span interface {
 	AddAttributes(map[string]string)
 	FinishWithError(error)